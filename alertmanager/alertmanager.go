@@ -0,0 +1,82 @@
+// Package alertmanager pushes alerts to the Alertmanager v2 API
+package alertmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Alert is an alert as the Alertmanager v2 API takes it. An alert whose
+// EndsAt has passed is resolved.
+type Alert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// Client posts alerts to one Alertmanager
+type Client struct {
+	URL        *url.URL
+	HTTPClient *http.Client
+}
+
+// ParseURLs returns a Client for each of a comma-separated list of
+// Alertmanager URLs
+func ParseURLs(urls string, client *http.Client) ([]*Client, error) {
+	clients := []*Client{}
+	for _, u := range strings.Split(urls, ",") {
+		u = strings.TrimSpace(u)
+		if u == "" {
+			continue
+		}
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse alertmanager url %s: %s", u, err)
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return nil, fmt.Errorf("alertmanager url %s must be http or https", u)
+		}
+		clients = append(clients, &Client{URL: parsed, HTTPClient: client})
+	}
+	return clients, nil
+}
+
+// Post sends alerts to the Alertmanager, which takes both firing and
+// resolved alerts this way
+func (c *Client) Post(alerts []Alert) error {
+	b, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+
+	relativeURL, err := url.Parse("api/v2/alerts")
+	if err != nil {
+		return err
+	}
+	base := *c.URL
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	endpoint := base.ResolveReference(relativeURL).String()
+
+	resp, err := c.HTTPClient.Post(endpoint, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("couldn't post alerts to %s: %s", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("alertmanager %s answered %s: %s", endpoint, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package alertmanager_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/alertmanager"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPost(t *testing.T) {
+	var got []alertmanager.Alert
+	fail := false
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/am/api/v2/alerts", r.URL.Path)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		if fail {
+			http.Error(w, "maintenance", http.StatusServiceUnavailable)
+		}
+	}))
+	defer s.Close()
+
+	clients, err := alertmanager.ParseURLs(s.URL+"/am, ", http.DefaultClient)
+	require.NoError(t, err)
+	require.Len(t, clients, 1)
+
+	now := time.Now()
+	alerts := []alertmanager.Alert{{Labels: map[string]string{"alertname": "Test"}, StartsAt: now, EndsAt: now.Add(time.Hour)}}
+	require.NoError(t, clients[0].Post(alerts))
+	require.Len(t, got, 1)
+	require.Equal(t, "Test", got[0].Labels["alertname"])
+
+	fail = true
+	err = clients[0].Post(alerts)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "maintenance")
+
+	_, err = alertmanager.ParseURLs("alertmanager:9093", http.DefaultClient)
+	require.Error(t, err)
+}
@@ -0,0 +1,456 @@
+// Package api implements Bomb Squad's HTTP API, and a client for it
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/patrol"
+	"github.com/open-fresh/bomb-squad/prom"
+	"github.com/prometheus/common/model"
+)
+
+// Prefix is the path under which version 1 of the API is served
+const Prefix = "/api/v1"
+
+// Server serves the API for a running patrol
+type Server struct {
+	Patrol *patrol.Patrol
+}
+
+// Error is the body of every unsuccessful API response
+type Error struct {
+	Error string `json:"error"`
+}
+
+// Handler returns the handler for every API endpoint, to be mounted at Prefix
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(Prefix+"/silences", s.silences)
+	mux.HandleFunc(Prefix+"/silences/", s.silence)
+	mux.HandleFunc(Prefix+"/pending", s.pendingSilences)
+	mux.HandleFunc(Prefix+"/pending/", s.pendingSilence)
+	mux.HandleFunc(Prefix+"/quarantines", s.quarantines)
+	mux.HandleFunc(Prefix+"/quarantines/", s.quarantine)
+	mux.HandleFunc(Prefix+"/detections", s.detections)
+	mux.HandleFunc(Prefix+"/cardinality/", s.cardinality)
+	mux.HandleFunc(Prefix+"/history", s.history)
+	mux.HandleFunc(Prefix+"/status", s.status)
+	mux.HandleFunc(Prefix+"/breaker", s.breaker)
+	mux.HandleFunc(Prefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusNotFound, Error{Error: fmt.Sprintf("no such endpoint %s", r.URL.Path)})
+	})
+	return mux
+}
+
+func (s *Server) silences(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		silences, err := config.ListSilences(s.Patrol.BSConfigurator)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, silences)
+	case http.MethodPost:
+		req := config.SilenceRequest{}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		silence, err := s.Patrol.CreateSilence(req)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Printf("Silenced %s through the API\n", silence.ID)
+		writeJSON(w, http.StatusCreated, silence)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// purgeSuffix ends the path a silence's series are purged through
+const purgeSuffix = "/purge"
+
+// baselineSuffix ends the path a silence's baseline is learned again through
+const baselineSuffix = "/baseline"
+
+func (s *Server) silence(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, Prefix+"/silences/")
+	// Silence IDs hold no slash, so whatever follows one is the action
+	action := ""
+	if i := strings.Index(id, "/"); i >= 0 {
+		id, action = id[:i], id[i:]
+	}
+	if action != "" && action != purgeSuffix && action != baselineSuffix {
+		writeJSON(w, http.StatusNotFound, Error{Error: fmt.Sprintf("no such endpoint %s", r.URL.Path)})
+		return
+	}
+	purge := action == purgeSuffix
+	baseline := action == baselineSuffix
+	if _, _, err := config.ParseSilenceID(id); err != nil {
+		writeJSON(w, http.StatusBadRequest, Error{Error: err.Error()})
+		return
+	}
+
+	if purge {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		req := config.PurgeRequest{}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		silence, err := s.Patrol.PurgeSilence(id, req)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Printf("Purged the series of %s through the API\n", silence.ID)
+		writeJSON(w, http.StatusOK, silence)
+		return
+	}
+	if baseline {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		req := config.BaselineRequest{}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		silence, err := s.Patrol.RefreshBaseline(id, req)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Printf("Learned the baseline of %s again through the API\n", silence.ID)
+		writeJSON(w, http.StatusOK, silence)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		silence, err := config.GetSilence(id, s.Patrol.BSConfigurator)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, silence)
+	case http.MethodDelete:
+		silence, err := s.Patrol.RemoveSilence(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Printf("Unsilenced %s through the API\n", silence.ID)
+		writeJSON(w, http.StatusOK, silence)
+	case http.MethodPatch:
+		upd := config.SilenceUpdate{}
+		if !decodeJSON(w, r, &upd) {
+			return
+		}
+
+		silence, err := s.Patrol.UpdateSilence(id, upd)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Printf("Updated %s through the API\n", silence.ID)
+		writeJSON(w, http.StatusOK, silence)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPatch, http.MethodDelete)
+	}
+}
+
+func (s *Server) pendingSilences(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	pending, err := config.ListPending(s.Patrol.BSConfigurator)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, pending)
+}
+
+// approveSuffix ends the path a pending silence is approved through
+const approveSuffix = "/approve"
+
+func (s *Server) pendingSilence(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, Prefix+"/pending/")
+	approve := strings.HasSuffix(id, approveSuffix)
+	id = strings.TrimSuffix(id, approveSuffix)
+	if _, _, err := config.ParseSilenceID(id); err != nil {
+		writeJSON(w, http.StatusBadRequest, Error{Error: err.Error()})
+		return
+	}
+
+	if approve {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		silence, err := s.Patrol.ApproveSilence(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Printf("Approved the silence of %s through the API\n", silence.ID)
+		writeJSON(w, http.StatusCreated, silence)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		pending, err := config.GetPending(id, s.Patrol.BSConfigurator)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, pending)
+	case http.MethodDelete:
+		pending, err := s.Patrol.RejectSilence(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Printf("Rejected the silence of %s through the API\n", pending.ID)
+		writeJSON(w, http.StatusOK, pending)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+func (s *Server) quarantines(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		quarantines, err := config.ListQuarantines(s.Patrol.BSConfigurator)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, quarantines)
+	case http.MethodPost:
+		req := config.QuarantineRequest{}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		q, err := s.Patrol.CreateQuarantine(req)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Printf("Quarantined %s through the API\n", q.ID)
+		writeJSON(w, http.StatusCreated, q)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (s *Server) quarantine(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, Prefix+"/quarantines/")
+	if _, _, err := config.ParseQuarantineID(id); err != nil {
+		writeJSON(w, http.StatusBadRequest, Error{Error: err.Error()})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		q, err := config.GetQuarantine(id, s.Patrol.BSConfigurator)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, q)
+	case http.MethodDelete:
+		q, err := s.Patrol.RemoveQuarantine(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Printf("Lifted quarantine of %s through the API\n", q.ID)
+		writeJSON(w, http.StatusOK, q)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+// maxCardinalityWindow limits how far back the cardinality endpoint looks
+const maxCardinalityWindow = 24 * time.Hour
+
+// cardinalitySamples is roughly how many samples the cardinality endpoint
+// returns, whatever the window
+const cardinalitySamples = 60
+
+func (s *Server) cardinality(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	metricName := strings.TrimPrefix(r.URL.Path, Prefix+"/cardinality/")
+	if !model.IsValidMetricName(model.LabelValue(metricName)) {
+		writeJSON(w, http.StatusBadRequest, Error{Error: fmt.Sprintf("invalid metric name '%s'", metricName)})
+		return
+	}
+
+	window := time.Hour
+	if wp := r.URL.Query().Get("window"); wp != "" {
+		d, err := model.ParseDuration(wp)
+		if err != nil || d <= 0 || time.Duration(d) > maxCardinalityWindow {
+			writeJSON(w, http.StatusBadRequest, Error{Error: fmt.Sprintf("invalid window '%s', expected a duration up to %s", wp, model.Duration(maxCardinalityWindow))})
+			return
+		}
+		window = time.Duration(d)
+	}
+
+	step := window / cardinalitySamples
+	if step < time.Second {
+		step = time.Second
+	}
+	samples, err := prom.CardinalityHistory(metricName, window, step, s.Patrol.PromURL, s.Patrol.HTTPClient)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, Error{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, samples)
+}
+
+func (s *Server) detections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Patrol.Detections())
+}
+
+func (s *Server) history(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	limit := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 0 {
+			writeJSON(w, http.StatusBadRequest, Error{Error: fmt.Sprintf("invalid limit '%s'", l)})
+			return
+		}
+	}
+
+	events, err := config.History(limit, s.Patrol.BSConfigurator)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Patrol.Status())
+}
+
+func (s *Server) breaker(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		st, err := s.Patrol.BreakerState()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, st)
+	case http.MethodDelete:
+		st, err := s.Patrol.ResetBreaker()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Println("Reset the circuit breaker through the API")
+		writeJSON(w, http.StatusOK, st)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+// maxBodyBytes limits the size of request bodies
+const maxBodyBytes = 1 << 20
+
+// decodeJSON decodes the request body into v, rejecting anything but a
+// single JSON object with no unknown fields. If it fails, it responds with
+// the reason and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		writeJSON(w, http.StatusUnsupportedMediaType, Error{Error: "request body must be application/json"})
+		return false
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil && dec.More() {
+		err = fmt.Errorf("unexpected data after JSON object")
+	}
+	if err != nil {
+		code := http.StatusBadRequest
+		if strings.Contains(err.Error(), "request body too large") {
+			code = http.StatusRequestEntityTooLarge
+		}
+		writeJSON(w, code, Error{Error: fmt.Sprintf("invalid request body: %s", err)})
+		return false
+	}
+	return true
+}
+
+// writeError responds with the status code matching err
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch err.(type) {
+	case config.SilenceNotFoundError, config.PendingNotFoundError, config.QuarantineNotFoundError:
+		code = http.StatusNotFound
+	case config.SilenceExistsError, config.PendingExistsError, config.QuarantineExistsError, config.RulesAffectedError:
+		code = http.StatusConflict
+	case config.InvalidSilenceError:
+		code = http.StatusBadRequest
+	case config.PurgeNotAllowedError:
+		code = http.StatusForbidden
+	case config.HistoryUnavailableError:
+		code = http.StatusNotImplemented
+	}
+	if code == http.StatusInternalServerError {
+		log.Printf("API request failed: %s\n", err)
+	}
+	writeJSON(w, code, Error{Error: err.Error()})
+}
+
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeJSON(w, http.StatusMethodNotAllowed, Error{Error: "method not allowed"})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("Couldn't write API response: %s\n", err)
+	}
+}
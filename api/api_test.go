@@ -0,0 +1,345 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/api"
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/patrol"
+	"github.com/open-fresh/bomb-squad/prom"
+	"github.com/open-fresh/bomb-squad/util"
+	"github.com/stretchr/testify/require"
+)
+
+// newPatrol returns a patrol backed by in-memory configs and a fake
+// Prometheus that has loaded the recording rules. Callers should call the
+// returned func when done.
+func newPatrol(t *testing.T) (*patrol.Patrol, func()) {
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/query_range":
+			w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"metric_name":"foo"},"values":[[1500000000,"10"],[1500000060,"25"]]}]}}`))
+		case "/api/v1/status/buildinfo":
+			w.Write([]byte(`{"status":"success","data":{"version":"2.4.3"}}`))
+		default:
+			w.Write([]byte(`{"status":"success","data":{"groups":[{"name":"bomb_squad_card_counter","rules":[{"name":"card_count","health":"ok"}]}]}}`))
+		}
+	}))
+	promurl, _ := url.Parse(prometheus.URL)
+	client, _ := util.HttpClient()
+
+	p := &patrol.Patrol{
+		PromURL:          promurl,
+		HTTPClient:       client,
+		PromConfigurator: bstesting.NewPromMemoryConfigurator(t),
+		BSConfigurator:   bstesting.NewMemoryConfigurator(t, []byte{}),
+	}
+	return p, prometheus.Close
+}
+
+// newServer starts an API server for a patrol from newPatrol. Callers should
+// call the returned func when done.
+func newServer(t *testing.T) (*httptest.Server, func()) {
+	p, done := newPatrol(t)
+	s := httptest.NewServer((&api.Server{Patrol: p}).Handler())
+	return s, func() {
+		s.Close()
+		done()
+	}
+}
+
+func request(t *testing.T, method, u, contentType, body string) (*http.Response, string) {
+	req, err := http.NewRequest(method, u, strings.NewReader(body))
+	require.NoError(t, err)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(b)
+}
+
+func TestSilencesEndpoints(t *testing.T) {
+	s, done := newServer(t)
+	defer done()
+	silences := s.URL + "/api/v1/silences"
+
+	resp, body := request(t, http.MethodPost, silences, "application/json", `{"metric":"foo","label":"bar","ttl":"1h","jobs":["prometheus"]}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode, body)
+	created := config.SilenceSummary{}
+	require.NoError(t, json.Unmarshal([]byte(body), &created))
+	require.Equal(t, "foo.bar", created.ID)
+	require.Equal(t, []string{"prometheus"}, created.Jobs)
+
+	resp, _ = request(t, http.MethodPost, silences, "application/json", `{"metric":"foo","label":"bar"}`)
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp, body = request(t, http.MethodGet, silences, "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	list := []config.SilenceSummary{}
+	require.NoError(t, json.Unmarshal([]byte(body), &list))
+	require.Len(t, list, 1)
+
+	resp, _ = request(t, http.MethodGet, silences+"/foo.bar", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body = request(t, http.MethodPatch, silences+"/foo.bar", "application/json", `{"ttl":"2h"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	updated := config.SilenceSummary{}
+	require.NoError(t, json.Unmarshal([]byte(body), &updated))
+	require.Equal(t, "2h", updated.TTL)
+	require.True(t, updated.ExpiresAt.After(*created.ExpiresAt))
+
+	resp, _ = request(t, http.MethodPatch, silences+"/foo.bar", "application/json", `{}`)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = request(t, http.MethodDelete, silences+"/foo.bar", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = request(t, http.MethodPatch, silences+"/foo.bar", "application/json", `{"ttl":"2h"}`)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, body = request(t, http.MethodDelete, silences+"/foo.bar", "", "")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.Contains(t, body, `"error":"No silence found for foo.bar"`)
+
+	resp, body = request(t, http.MethodGet, s.URL+"/api/v1/history?limit=1", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	events := []config.Event{}
+	require.NoError(t, json.Unmarshal([]byte(body), &events))
+	require.Len(t, events, 1)
+	require.Equal(t, config.EventUnsilenced, events[0].Type)
+}
+
+func TestQuarantinesEndpoints(t *testing.T) {
+	s, done := newServer(t)
+	defer done()
+	quarantines := s.URL + "/api/v1/quarantines"
+
+	resp, body := request(t, http.MethodPost, quarantines, "application/json", `{"target":"instance:localhost:9090","jobs":["prometheus"],"reason":"testing"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode, body)
+	created := config.QuarantineSummary{}
+	require.NoError(t, json.Unmarshal([]byte(body), &created))
+	require.Equal(t, "instance:localhost:9090", created.ID)
+	require.Equal(t, config.QuarantineDrop, created.Mode)
+	require.Equal(t, []string{"prometheus"}, created.AppliedJobs)
+	require.Equal(t, "1h", created.TTL)
+
+	resp, _ = request(t, http.MethodPost, quarantines, "application/json", `{"target":"instance:localhost:9090"}`)
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp, _ = request(t, http.MethodPost, quarantines, "application/json", `{"target":"instance:localhost:8080","jobs":["nope"]}`)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = request(t, http.MethodPost, quarantines, "application/json", `{"target":"localhost:8080"}`)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, body = request(t, http.MethodGet, quarantines, "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	list := []config.QuarantineSummary{}
+	require.NoError(t, json.Unmarshal([]byte(body), &list))
+	require.Len(t, list, 1)
+
+	resp, _ = request(t, http.MethodGet, quarantines+"/instance:localhost:9090", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = request(t, http.MethodDelete, quarantines+"/instance:localhost:9090", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, body = request(t, http.MethodDelete, quarantines+"/instance:localhost:9090", "", "")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.Contains(t, body, `"error":"No quarantine found for instance:localhost:9090"`)
+	resp, _ = request(t, http.MethodGet, quarantines+"/nocolon", "", "")
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestBreakerEndpoint(t *testing.T) {
+	p, done := newPatrol(t)
+	defer done()
+	s := httptest.NewServer((&api.Server{Patrol: p}).Handler())
+	defer s.Close()
+	breaker := s.URL + "/api/v1/breaker"
+
+	require.NoError(t, config.TripBreaker("testing", p.BSConfigurator))
+	resp, body := request(t, http.MethodGet, breaker, "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	st := config.BreakerState{}
+	require.NoError(t, json.Unmarshal([]byte(body), &st))
+	require.True(t, st.Tripped)
+	require.Equal(t, "testing", st.Reason)
+
+	resp, body = request(t, http.MethodDelete, breaker, "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.Unmarshal([]byte(body), &st))
+	require.True(t, st.Tripped)
+
+	resp, body = request(t, http.MethodGet, breaker, "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.JSONEq(t, `{"tripped":false}`, body)
+	resp, _ = request(t, http.MethodPost, breaker, "", "")
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestPendingEndpoints(t *testing.T) {
+	p, done := newPatrol(t)
+	defer done()
+	s := httptest.NewServer((&api.Server{Patrol: p}).Handler())
+	defer s.Close()
+	pending := s.URL + "/api/v1/pending"
+
+	for _, label := range []string{"bar", "baz"} {
+		require.NoError(t, config.ProposeSilence("foo", label, config.PendingSilence{Action: "replace", Evidence: config.Evidence{Growth: 500}}, p.BSConfigurator))
+	}
+	resp, body := request(t, http.MethodGet, pending, "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	all := []config.PendingSummary{}
+	require.NoError(t, json.Unmarshal([]byte(body), &all))
+	require.Len(t, all, 2)
+	require.Equal(t, float64(500), all[0].Evidence.Growth)
+
+	resp, body = request(t, http.MethodPost, pending+"/foo.bar/approve", "", "")
+	require.Equal(t, http.StatusCreated, resp.StatusCode, body)
+	silence := config.SilenceSummary{}
+	require.NoError(t, json.Unmarshal([]byte(body), &silence))
+	require.Equal(t, "foo.bar", silence.ID)
+	require.False(t, silence.Manual)
+	resp, _ = request(t, http.MethodPost, pending+"/foo.bar/approve", "", "")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = request(t, http.MethodDelete, pending+"/foo.baz", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, body = request(t, http.MethodGet, pending+"/foo.baz", "", "")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.Contains(t, body, `"error":"No pending silence found for foo.baz"`)
+	resp, _ = request(t, http.MethodGet, pending+"/foo.baz/approve", "", "")
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	resp, _ = request(t, http.MethodGet, pending+"/nodot", "", "")
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	silences, err := config.ListSilences(p.BSConfigurator)
+	require.NoError(t, err)
+	require.Len(t, silences, 1)
+}
+
+func TestSilenceRequestValidation(t *testing.T) {
+	s, done := newServer(t)
+	defer done()
+	silences := s.URL + "/api/v1/silences"
+
+	for _, c := range []struct {
+		contentType, body string
+		code              int
+	}{
+		{"text/plain", `{"metric":"foo","label":"bar"}`, http.StatusUnsupportedMediaType},
+		{"application/json", `{"metric":"foo","label":"bar"`, http.StatusBadRequest},
+		{"application/json", `{"metric":"foo","label":"bar","colour":"red"}`, http.StatusBadRequest},
+		{"application/json", `{"metric":"foo","label":"bar"}{}`, http.StatusBadRequest},
+		{"application/json", `{"metric":"foo-bar","label":"bar"}`, http.StatusBadRequest},
+		{"application/json", `{"metric":"foo","label":"__name__"}`, http.StatusBadRequest},
+		{"application/json", `{"metric":"foo","label":"bar","action":"keep"}`, http.StatusBadRequest},
+		{"application/json", `{"metric":"foo","label":"bar","ttl":"soon"}`, http.StatusBadRequest},
+		{"application/json", `{"metric":"foo","label":"bar","jobs":["nope"]}`, http.StatusBadRequest},
+		{"application/json", `{"metric":"foo","label":"` + strings.Repeat("a", 2<<20) + `"}`, http.StatusRequestEntityTooLarge},
+	} {
+		resp, body := request(t, http.MethodPost, silences, c.contentType, c.body)
+		require.Equal(t, c.code, resp.StatusCode, body)
+		require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	}
+
+	resp, _ := request(t, http.MethodGet, silences+"/nodot", "", "")
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = request(t, http.MethodPost, silences+"/foo.bar/baseline/purge", "", `{}`)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = request(t, http.MethodGet, silences+"/foo.bar/other", "", "")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = request(t, http.MethodPut, silences, "", "")
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	require.Equal(t, "GET, POST", resp.Header.Get("Allow"))
+
+	resp, _ = request(t, http.MethodGet, s.URL+"/api/v1/nothing", "", "")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = request(t, http.MethodGet, s.URL+"/api/v1/history?limit=-1", "", "")
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = request(t, http.MethodGet, s.URL+"/api/v1/cardinality/foo?window=48h", "", "")
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = request(t, http.MethodGet, s.URL+"/api/v1/cardinality/foo-bar", "", "")
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestCardinality(t *testing.T) {
+	s, done := newServer(t)
+	defer done()
+
+	resp, body := request(t, http.MethodGet, s.URL+"/api/v1/cardinality/foo?window=30m", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	samples := []prom.Sample{}
+	require.NoError(t, json.Unmarshal([]byte(body), &samples))
+	require.Len(t, samples, 2)
+	require.Equal(t, 25.0, samples[1].Value)
+}
+
+func TestStatusAndDetections(t *testing.T) {
+	s, done := newServer(t)
+	defer done()
+	u, _ := url.Parse(s.URL)
+	client := &api.Client{URL: u, HTTPClient: http.DefaultClient}
+
+	st, err := client.Status()
+	require.NoError(t, err)
+	require.True(t, st.Healthy, "%v", st.Problems)
+	require.True(t, st.RulesLoaded)
+
+	_, err = client.CreateSilence(config.SilenceRequest{Metric: "foo", Label: "bar"})
+	require.NoError(t, err)
+	st, err = client.Status()
+	require.NoError(t, err)
+	require.Equal(t, 1, st.Silences)
+
+	detections, err := client.Detections()
+	require.NoError(t, err)
+	require.Len(t, detections, 0)
+}
+
+func TestProbes(t *testing.T) {
+	p, done := newPatrol(t)
+	defer done()
+	s := &api.Server{Patrol: p}
+
+	w := httptest.NewRecorder()
+	s.HealthHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, api.HealthPath, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	s.ReadyHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, api.ReadyPath, nil))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	ready := patrol.Readiness{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &ready))
+	require.Equal(t, []string{"patrol"}, ready.Failed)
+	require.Len(t, ready.Checks, 5)
+
+	// An hour-long interval starts the patrol without running a cycle
+	p.Interval = time.Hour
+	go p.Run()
+	for i := 0; i < 100; i++ {
+		w = httptest.NewRecorder()
+		s.ReadyHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, api.ReadyPath, nil))
+		if w.Code == http.StatusOK {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
@@ -0,0 +1,250 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/patrol"
+)
+
+// Client talks to the API of a running Bomb Squad
+type Client struct {
+	// URL is the base URL of the Bomb Squad instance, such as http://bomb-squad:8080
+	URL        *url.URL
+	HTTPClient *http.Client
+	// Token, if set, is sent as a bearer token
+	Token string
+}
+
+// ListSilences returns every silence, sorted by ID
+func (c *Client) ListSilences() ([]config.SilenceSummary, error) {
+	silences := []config.SilenceSummary{}
+	err := c.do(http.MethodGet, "/silences", nil, &silences)
+	return silences, err
+}
+
+// GetSilence returns the silence with the given ID, or a
+// config.SilenceNotFoundError
+func (c *Client) GetSilence(id string) (config.SilenceSummary, error) {
+	silence := config.SilenceSummary{}
+	err := c.do(http.MethodGet, "/silences/"+id, nil, &silence)
+	if isNotFound(err) {
+		return silence, config.SilenceNotFoundError{ID: id}
+	}
+	return silence, err
+}
+
+// CreateSilence asks Bomb Squad to apply a silence and returns it
+func (c *Client) CreateSilence(req config.SilenceRequest) (config.SilenceSummary, error) {
+	silence := config.SilenceSummary{}
+	err := c.do(http.MethodPost, "/silences", req, &silence)
+	return silence, err
+}
+
+// RemoveSilence asks Bomb Squad to remove the silence with the given ID and
+// returns it
+func (c *Client) RemoveSilence(id string) (config.SilenceSummary, error) {
+	silence := config.SilenceSummary{}
+	err := c.do(http.MethodDelete, "/silences/"+id, nil, &silence)
+	if isNotFound(err) {
+		return silence, config.SilenceNotFoundError{ID: id}
+	}
+	return silence, err
+}
+
+// PurgeSilence asks Bomb Squad to delete the series the label of the silence
+// with the given ID exploded into, and returns the silence
+func (c *Client) PurgeSilence(id string, req config.PurgeRequest) (config.SilenceSummary, error) {
+	silence := config.SilenceSummary{}
+	err := c.do(http.MethodPost, "/silences/"+id+"/purge", req, &silence)
+	if isNotFound(err) {
+		return silence, config.SilenceNotFoundError{ID: id}
+	}
+	return silence, err
+}
+
+// RefreshBaseline asks Bomb Squad to learn the values the silence with the
+// given ID keeps again, and returns the silence
+func (c *Client) RefreshBaseline(id string, req config.BaselineRequest) (config.SilenceSummary, error) {
+	silence := config.SilenceSummary{}
+	err := c.do(http.MethodPost, "/silences/"+id+"/baseline", req, &silence)
+	if isNotFound(err) {
+		return silence, config.SilenceNotFoundError{ID: id}
+	}
+	return silence, err
+}
+
+// ListPending returns every silence awaiting approval, sorted by ID
+func (c *Client) ListPending() ([]config.PendingSummary, error) {
+	pending := []config.PendingSummary{}
+	err := c.do(http.MethodGet, "/pending", nil, &pending)
+	return pending, err
+}
+
+// GetPending returns the pending silence with the given ID, or a
+// config.PendingNotFoundError
+func (c *Client) GetPending(id string) (config.PendingSummary, error) {
+	pending := config.PendingSummary{}
+	err := c.do(http.MethodGet, "/pending/"+id, nil, &pending)
+	if isNotFound(err) {
+		return pending, config.PendingNotFoundError{ID: id}
+	}
+	return pending, err
+}
+
+// ApproveSilence asks Bomb Squad to apply the pending silence with the given
+// ID and returns the applied silence
+func (c *Client) ApproveSilence(id string) (config.SilenceSummary, error) {
+	silence := config.SilenceSummary{}
+	err := c.do(http.MethodPost, "/pending/"+id+"/approve", nil, &silence)
+	if isNotFound(err) {
+		return silence, config.PendingNotFoundError{ID: id}
+	}
+	return silence, err
+}
+
+// RejectSilence asks Bomb Squad to dismiss the pending silence with the given
+// ID and returns it
+func (c *Client) RejectSilence(id string) (config.PendingSummary, error) {
+	pending := config.PendingSummary{}
+	err := c.do(http.MethodDelete, "/pending/"+id, nil, &pending)
+	if isNotFound(err) {
+		return pending, config.PendingNotFoundError{ID: id}
+	}
+	return pending, err
+}
+
+// ListQuarantines returns every quarantine, sorted by ID
+func (c *Client) ListQuarantines() ([]config.QuarantineSummary, error) {
+	quarantines := []config.QuarantineSummary{}
+	err := c.do(http.MethodGet, "/quarantines", nil, &quarantines)
+	return quarantines, err
+}
+
+// CreateQuarantine asks Bomb Squad to quarantine a target and returns the
+// quarantine
+func (c *Client) CreateQuarantine(req config.QuarantineRequest) (config.QuarantineSummary, error) {
+	q := config.QuarantineSummary{}
+	err := c.do(http.MethodPost, "/quarantines", req, &q)
+	return q, err
+}
+
+// RemoveQuarantine asks Bomb Squad to lift the quarantine with the given ID
+// and returns it
+func (c *Client) RemoveQuarantine(id string) (config.QuarantineSummary, error) {
+	q := config.QuarantineSummary{}
+	err := c.do(http.MethodDelete, "/quarantines/"+id, nil, &q)
+	if isNotFound(err) {
+		return q, config.QuarantineNotFoundError{ID: id}
+	}
+	return q, err
+}
+
+// Detections returns the exploding labels found by the last patrol cycle
+func (c *Client) Detections() ([]patrol.Detection, error) {
+	detections := []patrol.Detection{}
+	err := c.do(http.MethodGet, "/detections", nil, &detections)
+	return detections, err
+}
+
+// History returns the most recent limit events, or every event if limit is 0
+func (c *Client) History(limit int) ([]config.Event, error) {
+	events := []config.Event{}
+	err := c.do(http.MethodGet, fmt.Sprintf("/history?limit=%d", limit), nil, &events)
+	return events, err
+}
+
+// Status returns the result of Bomb Squad's self checks
+func (c *Client) Status() (patrol.Status, error) {
+	st := patrol.Status{}
+	err := c.do(http.MethodGet, "/status", nil, &st)
+	return st, err
+}
+
+// BreakerState returns the state of the circuit breaker
+func (c *Client) BreakerState() (config.BreakerState, error) {
+	st := config.BreakerState{}
+	err := c.do(http.MethodGet, "/breaker", nil, &st)
+	return st, err
+}
+
+// ResetBreaker asks Bomb Squad to reset the circuit breaker and returns its
+// state from before the reset
+func (c *Client) ResetBreaker() (config.BreakerState, error) {
+	st := config.BreakerState{}
+	err := c.do(http.MethodDelete, "/breaker", nil, &st)
+	return st, err
+}
+
+// StatusError is returned for API responses with an unsuccessful status code
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("%s (HTTP %d)", e.Message, e.Code)
+}
+
+func isNotFound(err error) bool {
+	se, ok := err.(StatusError)
+	return ok && se.Code == http.StatusNotFound
+}
+
+func (c *Client) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	ref, err := url.Parse(strings.TrimSuffix(c.URL.Path, "/") + Prefix + path)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, c.URL.ResolveReference(ref).String(), body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to Bomb Squad failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("couldn't read response from Bomb Squad: %s", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		e := Error{}
+		if json.Unmarshal(b, &e) != nil || e.Error == "" {
+			e.Error = strings.TrimSpace(string(b))
+		}
+		return StatusError{Code: resp.StatusCode, Message: e.Error}
+	}
+
+	err = json.Unmarshal(b, out)
+	if err != nil {
+		return fmt.Errorf("couldn't unmarshal response from Bomb Squad: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+)
+
+// Liveness and readiness probes live outside Prefix, where Kubernetes expects
+// them, and are served without auth
+const (
+	HealthPath = "/healthz"
+	ReadyPath  = "/readyz"
+)
+
+// HealthHandler answers as long as Bomb Squad can serve HTTP at all
+func (s *Server) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			methodNotAllowed(w, http.MethodGet, http.MethodHead)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+}
+
+// ReadyHandler answers 200 when every dependency of Bomb Squad is working,
+// and 503 otherwise. Either way the body lists the checks made.
+func (s *Server) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			methodNotAllowed(w, http.MethodGet, http.MethodHead)
+			return
+		}
+		ready := s.Patrol.Ready()
+		code := http.StatusOK
+		if !ready.Ready {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, ready)
+	})
+}
@@ -0,0 +1,210 @@
+// Package auth decides who may call Bomb Squad's HTTP endpoints. Callers are
+// identified by a bearer token or by the common name of a verified TLS client
+// certificate, and each identity is mapped to a role.
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Role is what a caller is allowed to do
+type Role int
+
+const (
+	// RoleNone may call nothing but the endpoints that are always open
+	RoleNone Role = iota
+	// RoleRead may call read-only endpoints
+	RoleRead
+	// RoleWrite may call every endpoint, including those that change silences
+	RoleWrite
+)
+
+var roleNames = map[string]Role{
+	"none":  RoleNone,
+	"read":  RoleRead,
+	"write": RoleWrite,
+}
+
+func (r Role) String() string {
+	for name, role := range roleNames {
+		if role == r {
+			return name
+		}
+	}
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+func parseRole(s string, def Role) (Role, error) {
+	if s == "" {
+		return def, nil
+	}
+	r, ok := roleNames[s]
+	if !ok {
+		return RoleNone, fmt.Errorf("unknown role '%s', expected none, read or write", s)
+	}
+	return r, nil
+}
+
+// Config is the contents of the auth config file
+type Config struct {
+	// Tokens are accepted as "Authorization: Bearer <token>"
+	Tokens []Identity `yaml:"tokens"`
+	// ClientCerts match the common name of verified TLS client certificates
+	ClientCerts []Identity `yaml:"client_certs"`
+	// Anonymous is the role of callers that present no credentials. Defaults
+	// to none.
+	Anonymous string `yaml:"anonymous"`
+}
+
+// Identity maps a token or certificate common name to a role
+type Identity struct {
+	Token      string `yaml:"token,omitempty"`
+	CommonName string `yaml:"common_name,omitempty"`
+	// Role defaults to write
+	Role string `yaml:"role,omitempty"`
+}
+
+// Authorizer maps requests to roles and guards handlers with them
+type Authorizer struct {
+	tokens    map[string]Role
+	certs     map[string]Role
+	anonymous Role
+}
+
+// Open returns an Authorizer that lets every caller do everything, which is
+// how Bomb Squad behaves unless an auth config is given
+func Open() *Authorizer {
+	return &Authorizer{anonymous: RoleWrite}
+}
+
+// New returns the Authorizer for cfg
+func New(cfg Config) (*Authorizer, error) {
+	a := &Authorizer{tokens: map[string]Role{}, certs: map[string]Role{}}
+
+	var err error
+	a.anonymous, err = parseRole(cfg.Anonymous, RoleNone)
+	if err != nil {
+		return nil, fmt.Errorf("anonymous: %s", err)
+	}
+
+	for i, id := range cfg.Tokens {
+		if id.Token == "" {
+			return nil, fmt.Errorf("tokens[%d]: token is empty", i)
+		}
+		a.tokens[id.Token], err = parseRole(id.Role, RoleWrite)
+		if err != nil {
+			return nil, fmt.Errorf("tokens[%d]: %s", i, err)
+		}
+	}
+
+	for i, id := range cfg.ClientCerts {
+		if id.CommonName == "" {
+			return nil, fmt.Errorf("client_certs[%d]: common_name is empty", i)
+		}
+		a.certs[id.CommonName], err = parseRole(id.Role, RoleWrite)
+		if err != nil {
+			return nil, fmt.Errorf("client_certs[%d]: %s", i, err)
+		}
+	}
+	return a, nil
+}
+
+// Load reads the auth config file at path and returns its Authorizer
+func Load(path string) (*Authorizer, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read auth config: %s", err)
+	}
+
+	cfg := Config{}
+	err = yaml.UnmarshalStrict(b, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse auth config %s: %s", path, err)
+	}
+
+	a, err := New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth config %s: %s", path, err)
+	}
+	return a, nil
+}
+
+// errUnauthenticated is returned for credentials that match no identity
+var errUnauthenticated = fmt.Errorf("invalid credentials")
+
+// Role returns the role of the caller making r. Credentials that are
+// presented but not recognised are an error, rather than falling back to the
+// anonymous role.
+func (a *Authorizer) Role(r *http.Request) (Role, error) {
+	if h := r.Header.Get("Authorization"); h != "" {
+		if !strings.HasPrefix(h, "Bearer ") {
+			return RoleNone, errUnauthenticated
+		}
+		token := strings.TrimPrefix(h, "Bearer ")
+		for t, role := range a.tokens {
+			if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+				return role, nil
+			}
+		}
+		return RoleNone, errUnauthenticated
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		if role, ok := a.certs[cn]; ok {
+			return role, nil
+		}
+		return RoleNone, errUnauthenticated
+	}
+
+	return a.anonymous, nil
+}
+
+// Require only lets callers with at least the given role through to h
+func (a *Authorizer) Require(role Role, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, err := a.Role(r)
+		if err != nil {
+			deny(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if got < role {
+			if got == RoleNone {
+				deny(w, http.StatusUnauthorized, "authentication required")
+				return
+			}
+			deny(w, http.StatusForbidden, fmt.Sprintf("%s access required", role))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ByMethod requires the read role for GET and HEAD requests, and the write
+// role for every other method
+func (a *Authorizer) ByMethod(h http.Handler) http.Handler {
+	read, write := a.Require(RoleRead, h), a.Require(RoleWrite, h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			read.ServeHTTP(w, r)
+			return
+		}
+		write.ServeHTTP(w, r)
+	})
+}
+
+func deny(w http.ResponseWriter, code int, msg string) {
+	if code == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="bomb-squad"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
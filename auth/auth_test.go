@@ -0,0 +1,94 @@
+package auth_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/open-fresh/bomb-squad/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(h http.Handler, method, token, commonName string) int {
+	r := httptest.NewRequest(method, "/api/v1/silences", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	if commonName != "" {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestByMethod(t *testing.T) {
+	a, err := auth.New(auth.Config{
+		Tokens: []auth.Identity{
+			{Token: "reader", Role: "read"},
+			{Token: "writer"},
+		},
+		ClientCerts: []auth.Identity{
+			{CommonName: "chatops", Role: "write"},
+			{CommonName: "dashboard", Role: "read"},
+		},
+	})
+	require.NoError(t, err)
+	h := a.ByMethod(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, c := range []struct {
+		method, token, cn string
+		code              int
+	}{
+		{http.MethodGet, "", "", http.StatusUnauthorized},
+		{http.MethodGet, "wrong", "", http.StatusUnauthorized},
+		{http.MethodGet, "reader", "", http.StatusOK},
+		{http.MethodPost, "reader", "", http.StatusForbidden},
+		{http.MethodDelete, "writer", "", http.StatusOK},
+		{http.MethodGet, "", "dashboard", http.StatusOK},
+		{http.MethodDelete, "", "dashboard", http.StatusForbidden},
+		{http.MethodPost, "", "chatops", http.StatusOK},
+		{http.MethodGet, "", "stranger", http.StatusUnauthorized},
+	} {
+		require.Equal(t, c.code, serve(h, c.method, c.token, c.cn), "%s with token %q and cert %q", c.method, c.token, c.cn)
+	}
+}
+
+func TestAnonymousRole(t *testing.T) {
+	a, err := auth.New(auth.Config{Anonymous: "read", Tokens: []auth.Identity{{Token: "writer"}}})
+	require.NoError(t, err)
+	h := a.ByMethod(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	require.Equal(t, http.StatusOK, serve(h, http.MethodGet, "", ""))
+	require.Equal(t, http.StatusForbidden, serve(h, http.MethodPost, "", ""))
+	require.Equal(t, http.StatusOK, serve(h, http.MethodPost, "writer", ""))
+
+	open := auth.Open().ByMethod(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	require.Equal(t, http.StatusOK, serve(open, http.MethodPost, "", ""))
+}
+
+func TestLoad(t *testing.T) {
+	f, err := ioutil.TempFile("", "bomb-squad-auth")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString("tokens:\n- token: abc\n  role: admin\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = auth.Load(f.Name())
+	require.Error(t, err)
+
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte("tokens:\n- token: abc\n  role: read\nanonymous: none\n"), 0600))
+	_, err = auth.Load(f.Name())
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte("tokenz: []\n"), 0600))
+	_, err = auth.Load(f.Name())
+	require.Error(t, err)
+}
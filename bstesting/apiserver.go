@@ -0,0 +1,190 @@
+package bstesting
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+// FakeAPIServer is a minimal stand-in for the Kubernetes apiserver that keeps
+// arbitrary JSON objects per collection path. It understands just enough of
+// the REST conventions for the custom resource clients in this repo.
+type FakeAPIServer struct {
+	*httptest.Server
+	T *testing.T
+
+	mu      sync.Mutex
+	objects map[string]map[string]map[string]interface{}
+}
+
+// NewFakeAPIServer starts a FakeAPIServer. Callers should Close it when done.
+func NewFakeAPIServer(t *testing.T) *FakeAPIServer {
+	f := &FakeAPIServer{
+		T:       t,
+		objects: map[string]map[string]map[string]interface{}{},
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	return f
+}
+
+// RESTClient returns a client-go REST client pointed at the fake apiserver
+func (f *FakeAPIServer) RESTClient() rest.Interface {
+	client, err := rest.RESTClientFor(&rest.Config{
+		Host: f.URL,
+		ContentConfig: rest.ContentConfig{
+			GroupVersion:         &schema.GroupVersion{Version: "v1"},
+			NegotiatedSerializer: scheme.Codecs,
+		},
+	})
+	if err != nil {
+		f.T.Fatalf("couldn't create REST client for fake apiserver: %s", err)
+	}
+	return client
+}
+
+// Put stores obj under collection, keyed by its metadata.name
+func (f *FakeAPIServer) Put(collection string, obj map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.objects[collection] == nil {
+		f.objects[collection] = map[string]map[string]interface{}{}
+	}
+	f.objects[collection][objectName(obj)] = obj
+}
+
+// Objects returns the objects stored under collection, sorted by name
+func (f *FakeAPIServer) Objects(collection string) []map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := []string{}
+	for name := range f.objects[collection] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := []map[string]interface{}{}
+	for _, name := range names {
+		out = append(out, f.objects[collection][name])
+	}
+	return out
+}
+
+func (f *FakeAPIServer) handle(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	body, _ := ioutil.ReadAll(r.Body)
+
+	obj := map[string]interface{}{}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &obj); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Namespaced paths look like /apis/<group>/<version>/namespaces/<ns>/<plural>
+	// or /api/v1/namespaces/<ns>/<plural>, optionally followed by a name
+	collectionDepth := 6
+	if strings.HasPrefix(path, "/api/") {
+		collectionDepth = 5
+	}
+	collection, name := path, ""
+	if segments := strings.Split(strings.TrimPrefix(path, "/"), "/"); len(segments) > collectionDepth {
+		collection = "/" + strings.Join(segments[:collectionDepth], "/")
+		name = segments[collectionDepth]
+	}
+
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"items": f.Objects(collection)})
+	case r.Method == http.MethodGet:
+		if o, ok := f.get(collection, name); ok {
+			writeJSON(w, http.StatusOK, o)
+			return
+		}
+		notFound(w, name)
+	case r.Method == http.MethodPost:
+		if _, ok := f.get(collection, objectName(obj)); ok {
+			writeJSON(w, http.StatusConflict, map[string]interface{}{"kind": "Status", "reason": "AlreadyExists", "code": http.StatusConflict})
+			return
+		}
+		f.Put(collection, obj)
+		writeJSON(w, http.StatusCreated, obj)
+	case r.Method == http.MethodPut:
+		if _, ok := f.get(collection, name); !ok {
+			notFound(w, name)
+			return
+		}
+		f.Put(collection, obj)
+		writeJSON(w, http.StatusOK, obj)
+	case r.Method == http.MethodPatch:
+		o, ok := f.get(collection, name)
+		if !ok {
+			notFound(w, name)
+			return
+		}
+		mergePatch(o, obj)
+		f.Put(collection, o)
+		writeJSON(w, http.StatusOK, o)
+	case r.Method == http.MethodDelete:
+		f.mu.Lock()
+		_, ok := f.objects[collection][name]
+		delete(f.objects[collection], name)
+		f.mu.Unlock()
+		if !ok {
+			notFound(w, name)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"kind": "Status", "status": "Success"})
+	default:
+		http.Error(w, "unsupported", http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *FakeAPIServer) get(collection, name string) (map[string]interface{}, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	o, ok := f.objects[collection][name]
+	return o, ok
+}
+
+func objectName(obj map[string]interface{}) string {
+	meta, _ := obj["metadata"].(map[string]interface{})
+	name, _ := meta["name"].(string)
+	return name
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386) to dst
+func mergePatch(dst, patch map[string]interface{}) {
+	for k, v := range patch {
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+		pm, pok := v.(map[string]interface{})
+		dm, dok := dst[k].(map[string]interface{})
+		if pok && dok {
+			mergePatch(dm, pm)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+func notFound(w http.ResponseWriter, name string) {
+	writeJSON(w, http.StatusNotFound, map[string]interface{}{"kind": "Status", "reason": "NotFound", "code": http.StatusNotFound, "message": name + " not found"})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -33,3 +33,38 @@ func (c *TestConfigurator) Write([]byte) error {
 func (c *TestConfigurator) GetLocation() string {
 	return "testLocal"
 }
+
+// NewMemoryConfigurator returns a Configurator that starts out holding data
+// and keeps whatever is written to it
+func NewMemoryConfigurator(t *testing.T, data []byte) *MemoryConfigurator {
+	return &MemoryConfigurator{
+		T:    t,
+		Data: data,
+	}
+}
+
+// NewPromMemoryConfigurator returns a MemoryConfigurator holding the test
+// Prometheus config
+func NewPromMemoryConfigurator(t *testing.T) *MemoryConfigurator {
+	return NewMemoryConfigurator(t, promConfigBytes)
+}
+
+type MemoryConfigurator struct {
+	T      *testing.T
+	Data   []byte
+	Writes int
+}
+
+func (c *MemoryConfigurator) Read() ([]byte, error) {
+	return c.Data, nil
+}
+
+func (c *MemoryConfigurator) Write(b []byte) error {
+	c.Data = b
+	c.Writes++
+	return nil
+}
+
+func (c *MemoryConfigurator) GetLocation() string {
+	return "testMemory"
+}
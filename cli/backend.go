@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/open-fresh/bomb-squad/api"
+	"github.com/open-fresh/bomb-squad/auth"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/patrol"
+	"github.com/open-fresh/bomb-squad/util"
+)
+
+// backend is what the silence commands act on: Bomb Squad's state directly,
+// or a running Bomb Squad through its API
+type backend interface {
+	ListSilences() ([]config.SilenceSummary, error)
+	GetSilence(id string) (config.SilenceSummary, error)
+	CreateSilence(req config.SilenceRequest) (config.SilenceSummary, error)
+	RemoveSilence(id string) (config.SilenceSummary, error)
+	PurgeSilence(id string, req config.PurgeRequest) (config.SilenceSummary, error)
+	RefreshBaseline(id string, req config.BaselineRequest) (config.SilenceSummary, error)
+	ListPending() ([]config.PendingSummary, error)
+	GetPending(id string) (config.PendingSummary, error)
+	ApproveSilence(id string) (config.SilenceSummary, error)
+	RejectSilence(id string) (config.PendingSummary, error)
+	ListQuarantines() ([]config.QuarantineSummary, error)
+	CreateQuarantine(req config.QuarantineRequest) (config.QuarantineSummary, error)
+	RemoveQuarantine(id string) (config.QuarantineSummary, error)
+	// History returns the most recent limit events, or every event if limit is 0
+	History(limit int) ([]config.Event, error)
+	Status() (patrol.Status, error)
+	BreakerState() (config.BreakerState, error)
+	// ResetBreaker returns the state of the circuit breaker from before the reset
+	ResetBreaker() (config.BreakerState, error)
+}
+
+// localBackend changes Bomb Squad's state directly, the same way the daemon
+// does. A running daemon picks the changes up when it next reconciles.
+type localBackend struct {
+	*patrol.Patrol
+}
+
+func newLocalBackend(env *Env) backend {
+	return localBackend{&patrol.Patrol{
+		PromURL:          env.PromURL,
+		HTTPClient:       env.HTTPClient,
+		PromConfigurator: env.PromConfigurator,
+		BSConfigurator:   env.BSConfigurator,
+		Impact:           env.Impact,
+		Purge:            env.Purge,
+		Baseline:         env.Baseline,
+	}}
+}
+
+func (b localBackend) ListSilences() ([]config.SilenceSummary, error) {
+	return config.ListSilences(b.BSConfigurator)
+}
+
+func (b localBackend) GetSilence(id string) (config.SilenceSummary, error) {
+	return config.GetSilence(id, b.BSConfigurator)
+}
+
+func (b localBackend) ListPending() ([]config.PendingSummary, error) {
+	return config.ListPending(b.BSConfigurator)
+}
+
+func (b localBackend) GetPending(id string) (config.PendingSummary, error) {
+	return config.GetPending(id, b.BSConfigurator)
+}
+
+func (b localBackend) ListQuarantines() ([]config.QuarantineSummary, error) {
+	return config.ListQuarantines(b.BSConfigurator)
+}
+
+func (b localBackend) History(limit int) ([]config.Event, error) {
+	return config.History(limit, b.BSConfigurator)
+}
+
+func (b localBackend) Status() (patrol.Status, error) {
+	return b.Patrol.Status(), nil
+}
+
+// remoteOptions are the flags for reaching a Bomb Squad given with -server
+type remoteOptions struct {
+	token      string
+	caCert     string
+	clientCert string
+	clientKey  string
+}
+
+func newRemoteBackend(server string, opts remoteOptions) (backend, error) {
+	u, err := url.Parse(server)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid server URL '%s'", server)
+	}
+
+	httpClient, err := util.HttpClient()
+	if err != nil {
+		return nil, fmt.Errorf("could not create http client: %s", err)
+	}
+	if u.Scheme == "https" {
+		tlsConfig, err := auth.ClientTLSConfig(opts.caCert, opts.clientCert, opts.clientKey)
+		if err != nil {
+			return nil, err
+		}
+		httpClient.Transport.(*http.Transport).TLSClientConfig = tlsConfig
+	}
+	return &api.Client{URL: u, HTTPClient: httpClient, Token: opts.token}, nil
+}
@@ -0,0 +1,298 @@
+// Package cli implements the bs command line: the subcommands used to inspect
+// and change the silences Bomb Squad manages.
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/open-fresh/bomb-squad/api"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/patrol"
+)
+
+// Exit codes returned by App.Run
+const (
+	ExitOK = 0
+	// ExitError means the command failed
+	ExitError = 1
+	// ExitUsage means the command line was invalid
+	ExitUsage = 2
+	// ExitNotFound means the silence or quarantine named on the command line
+	// doesn't exist
+	ExitNotFound = 3
+	// ExitUnhealthy means status found Bomb Squad unhealthy
+	ExitUnhealthy = 4
+)
+
+// TokenEnv is the environment variable holding the default bearer token for
+// -server
+const TokenEnv = "BOMB_SQUAD_TOKEN"
+
+// Env holds what subcommands need to act on Bomb Squad's state
+type Env struct {
+	PromConfigurator config.Configurator
+	BSConfigurator   config.Configurator
+	PromURL          *url.URL
+	HTTPClient       *http.Client
+	// Impact says what happens to silences that may break rules
+	Impact patrol.ImpactPolicy
+	// Purge says whether silenced series may be deleted from the TSDB
+	Purge patrol.PurgePolicy
+	// Baseline says how the baselines of silences are learned
+	Baseline patrol.BaselinePolicy
+}
+
+// VersionInfo holds the versions reported by the version subcommand
+type VersionInfo struct {
+	Version         string `json:"version"`
+	Prometheus      string `json:"prometheus"`
+	PrometheusRules string `json:"prometheusRules"`
+}
+
+// App is the bs command line
+type App struct {
+	// Flags are the daemon's flags. Every subcommand accepts them too, so
+	// they can be given before or after the subcommand name.
+	Flags   *flag.FlagSet
+	Version VersionInfo
+	// Setup is called once the command line has been parsed, to build the
+	// Env the subcommand runs against
+	Setup func() (*Env, error)
+	// Stdin is read for confirmations. Nil confirms nothing.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// command is a bs subcommand
+type command struct {
+	name    string
+	args    string
+	summary string
+	// noEnv is set for commands that don't touch Bomb Squad's state
+	noEnv bool
+	// remote is set for commands that can run against a Bomb Squad instance
+	// given with -server
+	remote bool
+	// flags registers the command's own flags, if it has any
+	flags func(fs *flag.FlagSet)
+	run   func(ctx *context) int
+}
+
+var commands = []command{
+	{name: "list", remote: true, summary: "List silences", run: runList},
+	{name: "describe", remote: true, args: "<metric>.<label>", summary: "Show a silence in detail", run: runDescribe},
+	{name: "silence", remote: true, args: "<metric>.<label>", summary: "Silence a label of a metric", run: runSilence, flags: func(fs *flag.FlagSet) {
+		fs.String("action", "replace", "Relabel action of the silence: "+strings.Join(config.SilenceActions, ", "))
+		fs.String("ttl", "", "Remove the silence after this long, such as 6h. Empty keeps it until it is removed by hand")
+		fs.String("jobs", "", "Comma-separated scrape jobs to silence the label in. Empty means every job")
+		fs.Bool("force", false, "Silence the label even if the impact policy blocks it, as it may break recording or alerting rules")
+		fs.String("remote-write", "", "Comma-separated URLs of remote_write endpoints to silence the label in as well, through their write_relabel_configs, or * for every endpoint")
+		fs.Bool("remote-write-only", false, "Silence the label in the -remote-write endpoints alone, still storing its series locally")
+	}},
+	{name: "unsilence", remote: true, args: "<metric>.<label>", summary: "Remove a silence", run: runUnsilence},
+	{name: "purge", remote: true, args: "<metric>.<label>", summary: "Delete the series a silenced label exploded into from Prometheus", run: runPurge, flags: func(fs *flag.FlagSet) {
+		fs.Bool("yes", false, "Purge without asking for confirmation")
+	}},
+	{name: "baseline", remote: true, args: "<metric>.<label>", summary: "Learn the label values a replace silence keeps again", run: runBaseline, flags: func(fs *flag.FlagSet) {
+		fs.String("window", "", "How long a window to learn the values over, such as 2h. Empty means -baseline-window, or an hour if that is 0")
+		fs.String("until", "", "When the window ends, as an RFC 3339 time. Empty means where the current baseline ends, or when the explosion that got the label silenced started")
+	}},
+	{name: "pending", remote: true, args: "[<metric>.<label>]", summary: "List silences the patrol proposed, which await approval, or show one with its evidence", run: runPending},
+	{name: "approve", remote: true, args: "<metric>.<label>", summary: "Apply a silence the patrol proposed", run: runApprove},
+	{name: "reject", remote: true, args: "<metric>.<label>", summary: "Dismiss a silence the patrol proposed", run: runReject},
+	{name: "quarantines", remote: true, summary: "List quarantined scrape targets", run: runQuarantines},
+	{name: "quarantine", remote: true, args: "instance:<address>|pod:<namespace>/<name>", summary: "Cut a scrape target off", run: runQuarantine, flags: func(fs *flag.FlagSet) {
+		fs.String("mode", config.QuarantineDrop, "How to quarantine the target: "+strings.Join(config.QuarantineModes, " or "))
+		fs.Uint("sample-limit", 0, "Samples per scrape allowed in sample_limit mode. The limit applies to every target of the jobs")
+		fs.String("ttl", config.DefaultQuarantineTTL.String(), "Lift the quarantine after this long. 0s keeps it until it is removed by hand")
+		fs.String("jobs", "", "Comma-separated scrape jobs to quarantine the target in. Empty means every job")
+		fs.String("reason", "", "Why the target is being quarantined")
+	}},
+	{name: "unquarantine", remote: true, args: "instance:<address>|pod:<namespace>/<name>", summary: "Lift a quarantine", run: runUnquarantine},
+	{name: "history", remote: true, summary: "Show recent changes made by Bomb Squad", run: runHistory, flags: func(fs *flag.FlagSet) {
+		fs.Int("limit", 0, "Show only the most recent events. 0 shows every event kept")
+	}},
+	{name: "breaker", remote: true, summary: "Show whether the circuit breaker has tripped", run: runBreaker},
+	{name: "reset-breaker", remote: true, summary: "Reset the circuit breaker, letting the patrol act by itself again", run: runResetBreaker},
+	{name: "status", remote: true, summary: "Check that Bomb Squad is working, exiting non-zero if not", run: runStatus},
+	{name: "version", summary: "Show version information", noEnv: true, run: runVersion},
+}
+
+// context is what a running subcommand works with
+type context struct {
+	app     *App
+	env     *Env
+	backend backend
+	flags   *flag.FlagSet
+	args    []string
+	output  string
+	server  string
+	remote  remoteOptions
+}
+
+// Run runs the subcommand named by args[0] with the rest of args, and returns
+// the process exit code
+func (a *App) Run(args []string) int {
+	if len(args) == 0 {
+		a.usage()
+		return ExitUsage
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == args[0] {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(a.Stderr, "bs: unknown command '%s'\n\n", args[0])
+		a.usage()
+		return ExitUsage
+	}
+
+	ctx := &context{app: a}
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(a.Stderr)
+	fs.StringVar(&ctx.output, "output", "table", "Output format: table, json or yaml")
+	if cmd.remote {
+		fs.StringVar(&ctx.server, "server", "", "URL of a running Bomb Squad, such as http://bomb-squad:8080, to act through instead of changing its state directly")
+		fs.StringVar(&ctx.remote.token, "token", os.Getenv(TokenEnv), "Bearer token for -server. Defaults to $"+TokenEnv)
+		fs.StringVar(&ctx.remote.caCert, "ca-cert", "", "CA certificates to verify -server with, instead of the system's")
+		fs.StringVar(&ctx.remote.clientCert, "client-cert", "", "Client certificate to present to -server")
+		fs.StringVar(&ctx.remote.clientKey, "client-key", "", "Key of -client-cert")
+	}
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	if a.Flags != nil {
+		a.Flags.VisitAll(func(f *flag.Flag) {
+			fs.Var(f.Value, f.Name, f.Usage)
+		})
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(a.Stderr, "Usage: bs %s [flags] %s\n\n%s\n\nFlags:\n", cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+
+	// Flags may come after the arguments as well as before them
+	var err error
+	rest := args[1:]
+	for {
+		err = fs.Parse(rest)
+		if err == flag.ErrHelp {
+			return ExitOK
+		}
+		if err != nil {
+			return ExitUsage
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		ctx.args = append(ctx.args, fs.Arg(0))
+		rest = fs.Args()[1:]
+	}
+	ctx.flags = fs
+
+	switch ctx.output {
+	case "table", "json", "yaml":
+	default:
+		fmt.Fprintf(a.Stderr, "bs: unknown output format '%s'\n", ctx.output)
+		return ExitUsage
+	}
+
+	switch {
+	case ctx.server != "":
+		ctx.backend, err = newRemoteBackend(ctx.server, ctx.remote)
+		if err != nil {
+			return a.fail(err)
+		}
+	case !cmd.noEnv:
+		ctx.env, err = a.Setup()
+		if err != nil {
+			return a.fail(err)
+		}
+		ctx.backend = newLocalBackend(ctx.env)
+	}
+	return cmd.run(ctx)
+}
+
+func (a *App) usage() {
+	fmt.Fprintf(a.Stderr, "Usage: bs <command> [flags]\n\nCommands:\n")
+	names := []string{}
+	summaries := map[string]string{}
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+		summaries[cmd.name] = cmd.summary
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(a.Stderr, "  %-14s %s\n", name, summaries[name])
+	}
+	fmt.Fprintf(a.Stderr, "\nRun 'bs <command> -h' for the flags of a command.\n")
+}
+
+// fail reports err and returns the matching exit code
+func (a *App) fail(err error) int {
+	fmt.Fprintf(a.Stderr, "bs: %s\n", err)
+	switch e := err.(type) {
+	case config.SilenceNotFoundError, config.PendingNotFoundError, config.QuarantineNotFoundError:
+		return ExitNotFound
+	case config.InvalidSilenceError:
+		return ExitUsage
+	case api.StatusError:
+		switch e.Code {
+		case http.StatusNotFound:
+			return ExitNotFound
+		case http.StatusBadRequest:
+			return ExitUsage
+		}
+	}
+	return ExitError
+}
+
+// flag returns the value of one of the command's own flags
+func (ctx *context) flag(name string) string {
+	return ctx.flags.Lookup(name).Value.String()
+}
+
+// silenceArg returns the single silence ID a command was given
+func (ctx *context) silenceArg() (string, string, int) {
+	if len(ctx.args) != 1 {
+		ctx.flags.Usage()
+		return "", "", ExitUsage
+	}
+	metricName, labelName, err := config.ParseSilenceID(ctx.args[0])
+	if err != nil {
+		fmt.Fprintf(ctx.app.Stderr, "bs: %s\n", err)
+		return "", "", ExitUsage
+	}
+	return metricName, labelName, ExitOK
+}
+
+// quarantineArg returns the single quarantine ID a command was given
+func (ctx *context) quarantineArg() (string, int) {
+	if len(ctx.args) != 1 {
+		ctx.flags.Usage()
+		return "", ExitUsage
+	}
+	if _, _, err := config.ParseQuarantineID(ctx.args[0]); err != nil {
+		fmt.Fprintf(ctx.app.Stderr, "bs: %s\n", err)
+		return "", ExitUsage
+	}
+	return ctx.args[0], ExitOK
+}
+
+func joinOrDash(s []string, all string) string {
+	if len(s) == 0 {
+		return all
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,153 @@
+package cli_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/open-fresh/bomb-squad/api"
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/cli"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/patrol"
+	"github.com/stretchr/testify/require"
+)
+
+func newApp(t *testing.T) (*cli.App, *bytes.Buffer, *bytes.Buffer) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	env := &cli.Env{
+		PromConfigurator: bstesting.NewPromMemoryConfigurator(t),
+		BSConfigurator:   bstesting.NewMemoryConfigurator(t, []byte{}),
+	}
+	flags := flag.NewFlagSet("bs", flag.ContinueOnError)
+	flags.String("prom-url", "http://localhost:9090", "")
+	return &cli.App{
+		Flags:  flags,
+		Setup:  func() (*cli.Env, error) { return env, nil },
+		Stdout: stdout,
+		Stderr: stderr,
+	}, stdout, stderr
+}
+
+func TestSilenceListUnsilence(t *testing.T) {
+	app, stdout, _ := newApp(t)
+
+	require.Equal(t, cli.ExitOK, app.Run([]string{"silence", "foo.bar"}))
+	require.Equal(t, cli.ExitError, app.Run([]string{"silence", "foo.bar"}))
+
+	stdout.Reset()
+	require.Equal(t, cli.ExitOK, app.Run([]string{"list", "-output", "json"}))
+	silences := []config.SilenceSummary{}
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &silences))
+	require.Len(t, silences, 1)
+	require.Equal(t, "foo.bar", silences[0].ID)
+	require.Len(t, silences[0].AppliedJobs, 0)
+	require.NotNil(t, silences[0].AppliedAt)
+
+	require.Equal(t, cli.ExitOK, app.Run([]string{"unsilence", "foo.bar", "-prom-url", "http://prometheus:9090"}))
+	require.Equal(t, cli.ExitNotFound, app.Run([]string{"describe", "foo.bar"}))
+
+	stdout.Reset()
+	require.Equal(t, cli.ExitOK, app.Run([]string{"history", "-output", "json"}))
+	events := []config.Event{}
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &events))
+	require.Len(t, events, 2)
+	require.Equal(t, config.EventSilenced, events[0].Type)
+	require.Equal(t, config.EventUnsilenced, events[1].Type)
+}
+
+func TestUsageErrors(t *testing.T) {
+	app, _, stderr := newApp(t)
+
+	require.Equal(t, cli.ExitUsage, app.Run(nil))
+	require.Equal(t, cli.ExitUsage, app.Run([]string{"explode"}))
+	require.Equal(t, cli.ExitUsage, app.Run([]string{"unsilence"}))
+	require.Equal(t, cli.ExitUsage, app.Run([]string{"describe", "nodot"}))
+	require.Equal(t, cli.ExitUsage, app.Run([]string{"list", "-output", "xml"}))
+	require.Contains(t, stderr.String(), "unknown output format")
+}
+
+func TestVersionYAML(t *testing.T) {
+	app, stdout, _ := newApp(t)
+	app.Version = cli.VersionInfo{Version: "1.2.3", Prometheus: "2.3.0", PrometheusRules: "0.1"}
+
+	require.Equal(t, cli.ExitOK, app.Run([]string{"version", "-output", "yaml"}))
+	require.Equal(t, "prometheus: 2.3.0\nprometheusRules: \"0.1\"\nversion: 1.2.3\n", stdout.String())
+}
+
+func TestManualSilence(t *testing.T) {
+	app, stdout, stderr := newApp(t)
+
+	require.Equal(t, cli.ExitUsage, app.Run([]string{"silence", "foo.bar", "-action", "keep"}))
+	require.Equal(t, cli.ExitUsage, app.Run([]string{"silence", "foo.bar", "-ttl", "soon"}))
+	require.Equal(t, cli.ExitUsage, app.Run([]string{"silence", "foo.bar", "-jobs", "nope"}))
+	require.Contains(t, stderr.String(), "no scrape config for job 'nope'")
+
+	require.Equal(t, cli.ExitOK, app.Run([]string{"silence", "foo.bar", "-action", "drop", "-ttl", "6h", "-jobs", "prometheus,bomb-squad"}))
+
+	stdout.Reset()
+	require.Equal(t, cli.ExitOK, app.Run([]string{"describe", "foo.bar", "-output", "json"}))
+	s := config.SilenceSummary{}
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &s))
+	require.True(t, s.Manual)
+	require.Equal(t, "drop", s.Action)
+	require.Equal(t, "6h", s.TTL)
+	require.Equal(t, []string{"prometheus", "bomb-squad"}, s.Jobs)
+	require.NotNil(t, s.ExpiresAt)
+
+	stdout.Reset()
+	require.Equal(t, cli.ExitOK, app.Run([]string{"list"}))
+	require.Contains(t, stdout.String(), "manual")
+}
+
+func TestRemoteSilence(t *testing.T) {
+	p := &patrol.Patrol{
+		PromConfigurator: bstesting.NewPromMemoryConfigurator(t),
+		BSConfigurator:   bstesting.NewMemoryConfigurator(t, []byte{}),
+	}
+	server := httptest.NewServer((&api.Server{Patrol: p}).Handler())
+	defer server.Close()
+
+	app, stdout, _ := newApp(t)
+	app.Setup = func() (*cli.Env, error) {
+		t.Fatal("remote commands shouldn't touch local state")
+		return nil, nil
+	}
+
+	require.Equal(t, cli.ExitOK, app.Run([]string{"silence", "-server", server.URL, "foo.bar", "-ttl", "1h"}))
+	require.Equal(t, cli.ExitUsage, app.Run([]string{"silence", "-server", server.URL, "foo.baz", "-action", "keep"}))
+
+	silences, err := config.ListSilences(p.BSConfigurator)
+	require.NoError(t, err)
+	require.Len(t, silences, 1)
+	require.True(t, silences[0].Manual)
+
+	stdout.Reset()
+	require.Equal(t, cli.ExitOK, app.Run([]string{"list", "-server", server.URL, "-output", "json"}))
+	remote := []config.SilenceSummary{}
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &remote))
+	require.Len(t, remote, 1)
+	require.Equal(t, "foo.bar", remote[0].ID)
+
+	require.Equal(t, cli.ExitOK, app.Run([]string{"unsilence", "-server", server.URL, "foo.bar"}))
+	require.Equal(t, cli.ExitNotFound, app.Run([]string{"unsilence", "-server", server.URL, "foo.bar"}))
+	require.Equal(t, cli.ExitNotFound, app.Run([]string{"describe", "-server", server.URL, "foo.bar"}))
+}
+
+func TestPurgeNeedsConfirmation(t *testing.T) {
+	app, _, stderr := newApp(t)
+	require.Equal(t, cli.ExitOK, app.Run([]string{"silence", "foo.bar"}))
+
+	app.Stdin = strings.NewReader("foo.baz\n")
+	require.Equal(t, cli.ExitError, app.Run([]string{"purge", "foo.bar"}))
+	require.Contains(t, stderr.String(), "not confirmed")
+
+	// Purging is off unless the daemon's policy allows it
+	stderr.Reset()
+	app.Stdin = strings.NewReader("foo.bar\n")
+	require.Equal(t, cli.ExitError, app.Run([]string{"purge", "foo.bar"}))
+	require.Contains(t, stderr.String(), "-allow-purge")
+}
@@ -0,0 +1,450 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/config"
+)
+
+func runList(ctx *context) int {
+	if len(ctx.args) != 0 {
+		ctx.flags.Usage()
+		return ExitUsage
+	}
+
+	silences, err := ctx.backend.ListSilences()
+	if err != nil {
+		return ctx.app.fail(err)
+	}
+
+	return ctx.write(silences, func(w io.Writer) {
+		row(w, "SILENCE", "SOURCE", "ACTION", "JOBS", "TTL", "APPLIED", "EXPIRES")
+		for _, s := range silences {
+			row(w, s.ID, source(s), s.Action, joinOrDash(s.Jobs, "*"), orDash(s.TTL), formatTime(s.AppliedAt), formatTime(s.ExpiresAt))
+		}
+	})
+}
+
+func runDescribe(ctx *context) int {
+	metricName, labelName, code := ctx.silenceArg()
+	if code != ExitOK {
+		return code
+	}
+
+	s, err := ctx.backend.GetSilence(metricName + "." + labelName)
+	if err != nil {
+		return ctx.app.fail(err)
+	}
+	return ctx.write(s, func(w io.Writer) { describeSilence(w, s) })
+}
+
+func describeSilence(w io.Writer, s config.SilenceSummary) {
+	row(w, "Silence:", s.ID)
+	row(w, "Source:", source(s))
+	row(w, "Action:", s.Action)
+	row(w, "Jobs:", joinOrDash(s.Jobs, "all"))
+	row(w, "Applied to:", joinOrDash(s.AppliedJobs, "-"))
+	if len(s.RemoteWrite) > 0 {
+		row(w, "Remote write:", strings.Join(s.RemoteWrite, ","))
+		row(w, "Remote write only:", strconv.FormatBool(s.RemoteWriteOnly))
+		row(w, "Applied to remote write:", joinOrDash(s.AppliedRemoteWrite, "-"))
+	}
+	row(w, "Applied at:", formatTime(s.AppliedAt))
+	row(w, "TTL:", orDash(s.TTL))
+	row(w, "Expires at:", formatTime(s.ExpiresAt))
+	if s.Culprit != nil {
+		row(w, "Likely culprit:", s.Culprit.String())
+	}
+	row(w, "Verification:", orDash(s.Verification))
+	for _, e := range s.Escalations {
+		row(w, "Escalated:", formatTime(&e.Time)+" "+e.String())
+	}
+	for _, r := range s.AffectedRules {
+		row(w, "Affected rule:", r.String())
+	}
+	for _, p := range s.Purges {
+		row(w, "Purged:", formatTime(&p.Time)+" "+p.String())
+	}
+	if s.Baseline != nil {
+		row(w, "Baseline:", formatTime(&s.Baseline.LearnedAt)+" "+s.Baseline.String())
+		row(w, "Known values:", strings.Join(s.Baseline.Values, ","))
+	}
+	row(w, "Rule:")
+	for _, line := range strings.Split(strings.TrimRight(s.RelabelConfig, "\n"), "\n") {
+		row(w, "  "+line)
+	}
+}
+
+// source says who asked for a silence
+func source(s config.SilenceSummary) string {
+	if s.Manual {
+		return "manual"
+	}
+	return "patrol"
+}
+
+func runSilence(ctx *context) int {
+	metricName, labelName, code := ctx.silenceArg()
+	if code != ExitOK {
+		return code
+	}
+
+	req := config.SilenceRequest{
+		Metric: metricName,
+		Label:  labelName,
+		Action: ctx.flag("action"),
+		TTL:    ctx.flag("ttl"),
+		Force:  ctx.flag("force") == "true",
+
+		RemoteWriteOnly: ctx.flag("remote-write-only") == "true",
+	}
+	if jobs := ctx.flag("jobs"); jobs != "" {
+		req.Jobs = strings.Split(jobs, ",")
+	}
+	if urls := ctx.flag("remote-write"); urls != "" {
+		req.RemoteWrite = strings.Split(urls, ",")
+	}
+
+	s, err := ctx.backend.CreateSilence(req)
+	if err != nil {
+		return ctx.app.fail(err)
+	}
+	return ctx.write(s, func(w io.Writer) { describeSilence(w, s) })
+}
+
+func runUnsilence(ctx *context) int {
+	metricName, labelName, code := ctx.silenceArg()
+	if code != ExitOK {
+		return code
+	}
+
+	s, err := ctx.backend.RemoveSilence(metricName + "." + labelName)
+	if err != nil {
+		return ctx.app.fail(err)
+	}
+	return ctx.write(s, func(w io.Writer) { row(w, "Removed silence", s.ID) })
+}
+
+func runPurge(ctx *context) int {
+	metricName, labelName, code := ctx.silenceArg()
+	if code != ExitOK {
+		return code
+	}
+
+	id := metricName + "." + labelName
+	req := config.PurgeRequest{Confirm: id}
+	if ctx.flag("yes") != "true" {
+		fmt.Fprintf(ctx.app.Stderr, "This deletes the series label %s of %s exploded into from Prometheus for good.\nType %s to confirm: ", labelName, metricName, id)
+		req.Confirm = ""
+		if ctx.app.Stdin != nil {
+			line, _ := bufio.NewReader(ctx.app.Stdin).ReadString('\n')
+			req.Confirm = strings.TrimSpace(line)
+		}
+		if req.Confirm != id {
+			fmt.Fprintf(ctx.app.Stderr, "bs: purge of %s not confirmed\n", id)
+			return ExitError
+		}
+	}
+
+	s, err := ctx.backend.PurgeSilence(id, req)
+	if err != nil {
+		return ctx.app.fail(err)
+	}
+	return ctx.write(s, func(w io.Writer) { describeSilence(w, s) })
+}
+
+func runBaseline(ctx *context) int {
+	metricName, labelName, code := ctx.silenceArg()
+	if code != ExitOK {
+		return code
+	}
+
+	req := config.BaselineRequest{Window: ctx.flag("window")}
+	if until := ctx.flag("until"); until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return ctx.app.fail(fmt.Errorf("invalid -until '%s', expected an RFC 3339 time such as 2006-01-02T15:04:05Z", until))
+		}
+		req.Until = &t
+	}
+
+	s, err := ctx.backend.RefreshBaseline(metricName+"."+labelName, req)
+	if err != nil {
+		return ctx.app.fail(err)
+	}
+	return ctx.write(s, func(w io.Writer) { describeSilence(w, s) })
+}
+
+func runPending(ctx *context) int {
+	if len(ctx.args) == 1 {
+		metricName, labelName, code := ctx.silenceArg()
+		if code != ExitOK {
+			return code
+		}
+		p, err := ctx.backend.GetPending(metricName + "." + labelName)
+		if err != nil {
+			return ctx.app.fail(err)
+		}
+		return ctx.write(p, func(w io.Writer) { describePending(w, p) })
+	}
+	if len(ctx.args) != 0 {
+		ctx.flags.Usage()
+		return ExitUsage
+	}
+
+	pending, err := ctx.backend.ListPending()
+	if err != nil {
+		return ctx.app.fail(err)
+	}
+
+	return ctx.write(pending, func(w io.Writer) {
+		row(w, "SILENCE", "ACTION", "JOBS", "GROWTH", "SERIES", "VALUES", "PROPOSED", "AUTO-APPLY")
+		for _, p := range pending {
+			e := p.Evidence
+			row(w, p.ID, p.Action, joinOrDash(p.Jobs, "*"), strconv.FormatFloat(e.Growth, 'g', -1, 64), strconv.Itoa(e.Series), strconv.Itoa(e.DistinctValues), formatTime(&p.ProposedAt), formatTime(p.AutoApplyAt))
+		}
+	})
+}
+
+func describePending(w io.Writer, p config.PendingSummary) {
+	e := p.Evidence
+	row(w, "Silence:", p.ID)
+	row(w, "Action:", p.Action)
+	row(w, "Jobs:", joinOrDash(p.Jobs, "all"))
+	row(w, "Proposed at:", formatTime(&p.ProposedAt))
+	row(w, "Auto-apply at:", formatTime(p.AutoApplyAt))
+	row(w, "Detected at:", formatTime(&e.DetectedAt))
+	row(w, "Growth:", strconv.FormatFloat(e.Growth, 'g', -1, 64))
+	row(w, "Series:", strconv.Itoa(e.Series))
+	row(w, "Distinct values:", strconv.Itoa(e.DistinctValues))
+	row(w, "Sample values:", joinOrDash(e.SampleValues, "-"))
+	row(w, "Instances:", joinOrDash(e.Instances, "-"))
+	if p.Culprit != nil {
+		row(w, "Likely culprit:", p.Culprit.String())
+	}
+	for _, r := range p.AffectedRules {
+		row(w, "Affected rule:", r.String())
+	}
+	row(w, "Approve with:", "bs approve "+p.ID)
+}
+
+func runApprove(ctx *context) int {
+	metricName, labelName, code := ctx.silenceArg()
+	if code != ExitOK {
+		return code
+	}
+
+	s, err := ctx.backend.ApproveSilence(metricName + "." + labelName)
+	if err != nil {
+		return ctx.app.fail(err)
+	}
+	return ctx.write(s, func(w io.Writer) { describeSilence(w, s) })
+}
+
+func runReject(ctx *context) int {
+	metricName, labelName, code := ctx.silenceArg()
+	if code != ExitOK {
+		return code
+	}
+
+	p, err := ctx.backend.RejectSilence(metricName + "." + labelName)
+	if err != nil {
+		return ctx.app.fail(err)
+	}
+	return ctx.write(p, func(w io.Writer) { row(w, "Rejected silence", p.ID) })
+}
+
+func runQuarantines(ctx *context) int {
+	if len(ctx.args) != 0 {
+		ctx.flags.Usage()
+		return ExitUsage
+	}
+
+	quarantines, err := ctx.backend.ListQuarantines()
+	if err != nil {
+		return ctx.app.fail(err)
+	}
+
+	return ctx.write(quarantines, func(w io.Writer) {
+		row(w, "QUARANTINE", "SOURCE", "MODE", "JOBS", "TTL", "APPLIED", "EXPIRES")
+		for _, q := range quarantines {
+			src := "patrol"
+			if q.Manual {
+				src = "manual"
+			}
+			row(w, q.ID, src, q.Mode, joinOrDash(q.AppliedJobs, "-"), orDash(q.TTL), formatTime(q.AppliedAt), formatTime(q.ExpiresAt))
+		}
+	})
+}
+
+func runQuarantine(ctx *context) int {
+	id, code := ctx.quarantineArg()
+	if code != ExitOK {
+		return code
+	}
+
+	limit, _ := strconv.ParseUint(ctx.flag("sample-limit"), 10, 0)
+	req := config.QuarantineRequest{
+		Target:      id,
+		Mode:        ctx.flag("mode"),
+		SampleLimit: uint(limit),
+		TTL:         ctx.flag("ttl"),
+		Reason:      ctx.flag("reason"),
+	}
+	if jobs := ctx.flag("jobs"); jobs != "" {
+		req.Jobs = strings.Split(jobs, ",")
+	}
+
+	q, err := ctx.backend.CreateQuarantine(req)
+	if err != nil {
+		return ctx.app.fail(err)
+	}
+	return ctx.write(q, func(w io.Writer) { describeQuarantine(w, q) })
+}
+
+func describeQuarantine(w io.Writer, q config.QuarantineSummary) {
+	row(w, "Quarantine:", q.ID)
+	row(w, "Mode:", q.Mode)
+	if q.SampleLimit != 0 {
+		row(w, "Sample limit:", strconv.FormatUint(uint64(q.SampleLimit), 10))
+	}
+	row(w, "Applied to:", joinOrDash(q.AppliedJobs, "-"))
+	row(w, "Applied at:", formatTime(q.AppliedAt))
+	row(w, "TTL:", orDash(q.TTL))
+	row(w, "Expires at:", formatTime(q.ExpiresAt))
+	row(w, "Reason:", orDash(q.Reason))
+	if q.RelabelConfig != "" {
+		row(w, "Rule:")
+		for _, line := range strings.Split(strings.TrimRight(q.RelabelConfig, "\n"), "\n") {
+			row(w, "  "+line)
+		}
+	}
+}
+
+func runUnquarantine(ctx *context) int {
+	id, code := ctx.quarantineArg()
+	if code != ExitOK {
+		return code
+	}
+
+	q, err := ctx.backend.RemoveQuarantine(id)
+	if err != nil {
+		return ctx.app.fail(err)
+	}
+	return ctx.write(q, func(w io.Writer) { row(w, "Lifted quarantine", q.ID) })
+}
+
+func runHistory(ctx *context) int {
+	limit, _ := strconv.Atoi(ctx.flag("limit"))
+	if limit < 0 {
+		fmt.Fprintf(ctx.app.Stderr, "bs: invalid limit %d\n", limit)
+		return ExitUsage
+	}
+
+	events, err := ctx.backend.History(limit)
+	if err != nil {
+		return ctx.app.fail(err)
+	}
+
+	return ctx.write(events, func(w io.Writer) {
+		row(w, "TIME", "EVENT", "SILENCE", "DETAIL")
+		for _, e := range events {
+			id := "-"
+			switch {
+			case e.Metric != "":
+				id = e.Metric + "." + e.Label
+			case e.Target != "":
+				id = e.Target
+			}
+			row(w, formatTime(&e.Time), e.Type, id, orDash(e.Detail))
+		}
+	})
+}
+
+func runStatus(ctx *context) int {
+	if len(ctx.args) != 0 {
+		ctx.flags.Usage()
+		return ExitUsage
+	}
+
+	st, err := ctx.backend.Status()
+	if err != nil {
+		return ctx.app.fail(err)
+	}
+
+	code := ctx.write(st, func(w io.Writer) {
+		health := "healthy"
+		if !st.Healthy {
+			health = "unhealthy"
+		}
+		row(w, "Status:", health)
+		row(w, "Recording rules loaded:", strconv.FormatBool(st.RulesLoaded))
+		row(w, "Silences:", strconv.Itoa(st.Silences))
+		row(w, "Quarantines:", strconv.Itoa(st.Quarantines))
+		row(w, "Pending silences:", strconv.Itoa(st.Pending))
+		row(w, "Circuit breaker tripped:", strconv.FormatBool(st.BreakerTripped))
+		row(w, "Missing rules:", strconv.Itoa(st.MissingRules))
+		row(w, "Orphaned rules:", strconv.Itoa(st.OrphanedRules))
+		for _, p := range st.Problems {
+			row(w, "Problem:", p)
+		}
+	})
+	if code == ExitOK && !st.Healthy {
+		return ExitUnhealthy
+	}
+	return code
+}
+
+func runBreaker(ctx *context) int {
+	if len(ctx.args) != 0 {
+		ctx.flags.Usage()
+		return ExitUsage
+	}
+
+	st, err := ctx.backend.BreakerState()
+	if err != nil {
+		return ctx.app.fail(err)
+	}
+	return ctx.write(st, func(w io.Writer) { describeBreaker(w, st) })
+}
+
+func describeBreaker(w io.Writer, st config.BreakerState) {
+	row(w, "Tripped:", strconv.FormatBool(st.Tripped))
+	if st.Tripped {
+		row(w, "Tripped at:", formatTime(st.TrippedAt))
+		row(w, "Reason:", orDash(st.Reason))
+	}
+}
+
+func runResetBreaker(ctx *context) int {
+	if len(ctx.args) != 0 {
+		ctx.flags.Usage()
+		return ExitUsage
+	}
+
+	st, err := ctx.backend.ResetBreaker()
+	if err != nil {
+		return ctx.app.fail(err)
+	}
+	return ctx.write(st, func(w io.Writer) {
+		if !st.Tripped {
+			row(w, "The circuit breaker wasn't tripped")
+			return
+		}
+		row(w, "Reset the circuit breaker, tripped at", formatTime(st.TrippedAt))
+	})
+}
+
+func runVersion(ctx *context) int {
+	v := ctx.app.Version
+	return ctx.write(v, func(w io.Writer) {
+		row(w, "version:", v.Version)
+		row(w, "prometheus:", v.Prometheus)
+		row(w, "prometheus-rules:", v.PrometheusRules)
+	})
+}
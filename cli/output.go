@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// write prints v in the context's output format. table writes the table form
+// of v; its rows are tab separated and aligned once it returns.
+func (ctx *context) write(v interface{}, table func(w io.Writer)) int {
+	out := ctx.app.Stdout
+	switch ctx.output {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			return ctx.app.fail(err)
+		}
+	case "yaml":
+		// ghodss/yaml goes through encoding/json, so the json tags apply to
+		// both formats
+		b, err := yaml.Marshal(v)
+		if err != nil {
+			return ctx.app.fail(err)
+		}
+		if _, err := out.Write(b); err != nil {
+			return ctx.app.fail(err)
+		}
+	default:
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		table(tw)
+		if err := tw.Flush(); err != nil {
+			return ctx.app.fail(err)
+		}
+	}
+	return ExitOK
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func row(w io.Writer, cols ...string) {
+	for i, c := range cols {
+		if i > 0 {
+			fmt.Fprint(w, "\t")
+		}
+		fmt.Fprint(w, c)
+	}
+	fmt.Fprintln(w)
+}
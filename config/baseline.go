@@ -0,0 +1,177 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	promcfg "github.com/prometheus/prometheus/config"
+)
+
+// Baseline holds the values a label had before it exploded. A replace silence
+// with a baseline keeps those values, and only rewrites the others.
+type Baseline struct {
+	Values []string `yaml:"values" json:"values"`
+	// From and Until bound the window the values were learned over
+	From  time.Time `yaml:"from" json:"from"`
+	Until time.Time `yaml:"until" json:"until"`
+	// LearnedAt is when the values were learned
+	LearnedAt time.Time `yaml:"learned_at" json:"learnedAt"`
+}
+
+func (b Baseline) String() string {
+	return fmt.Sprintf("%d values seen from %s to %s", len(b.Values), b.From.Format(time.RFC3339), b.Until.Format(time.RFC3339))
+}
+
+// BaselineRequest asks for the baseline of a silence to be learned again
+type BaselineRequest struct {
+	// Window is a Prometheus duration, such as 1h, that the values are
+	// learned over. Empty means the patrol's default.
+	Window string `json:"window,omitempty"`
+	// Until is when that window ends. Empty means where the silence's
+	// current baseline ends, or when the explosion that got the label
+	// silenced started.
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// GenerateBaselineRelabelConfig returns a replace rule silencing s that
+// leaves the values in known alone. Series without the label are left alone
+// as well.
+func GenerateBaselineRelabelConfig(s HighCardSeries, known []string) (promcfg.RelabelConfig, error) {
+	regexpOriginal := fmt.Sprintf("^%s;(?:%s)$", s.MetricName, exceptRegexp(append([]string{""}, known...)))
+	promRegex, err := promcfg.NewRegexp(regexpOriginal)
+	if err != nil {
+		return promcfg.RelabelConfig{}, fmt.Errorf("Couldn't create promcfg.Regexp for the baseline of %s.%s: %s", s.MetricName, s.HighCardLabelName, err)
+	}
+	return promcfg.RelabelConfig{
+		SourceLabels: model.LabelNames{"__name__", s.HighCardLabelName},
+		Regex:        promRegex,
+		Replacement:  SilenceReplacement,
+		TargetLabel:  string(s.HighCardLabelName),
+		Action:       promcfg.RelabelReplace,
+	}, nil
+}
+
+// valueTrie holds label values by their runes, to build a regexp matching
+// every other value
+type valueTrie struct {
+	end      bool
+	children map[rune]*valueTrie
+}
+
+func (t *valueTrie) insert(value string) {
+	for _, r := range value {
+		child, ok := t.children[r]
+		if !ok {
+			child = &valueTrie{children: map[rune]*valueTrie{}}
+			t.children[r] = child
+		}
+		t = child
+	}
+	t.end = true
+}
+
+// except returns a regexp matching every string that, following the prefix
+// that led to t, doesn't make one of the values. RE2 has no negative
+// lookahead, so it spells out where each other string parts ways with them.
+func (t *valueTrie) except() string {
+	runes := []rune{}
+	for r := range t.children {
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+
+	alternatives := []string{}
+	if !t.end {
+		alternatives = append(alternatives, "")
+	}
+	if len(runes) == 0 {
+		alternatives = append(alternatives, ".+")
+	} else {
+		class := ""
+		for _, r := range runes {
+			if r == '-' {
+				class += `\-`
+			} else {
+				class += regexp.QuoteMeta(string(r))
+			}
+		}
+		alternatives = append(alternatives, "[^"+class+"].*")
+	}
+	for _, r := range runes {
+		alternatives = append(alternatives, regexp.QuoteMeta(string(r))+"(?:"+t.children[r].except()+")")
+	}
+	return strings.Join(alternatives, "|")
+}
+
+// exceptRegexp returns a regexp matching every string but values
+func exceptRegexp(values []string) string {
+	t := &valueTrie{children: map[rune]*valueTrie{}}
+	for _, v := range values {
+		t.insert(v)
+	}
+	return t.except()
+}
+
+// SetBaseline records b as the baseline of the replace silence with the given
+// ID, and swaps its rule for one keeping b's values in every scrape config
+// and remote_write endpoint the silence applies to. The Bomb Squad config is
+// written first, and restored if the Prometheus config can't be written.
+func SetBaseline(id string, b Baseline, pc, bc Configurator) error {
+	metricName, labelName, err := ParseSilenceID(id)
+	if err != nil {
+		return err
+	}
+
+	bsCfg, err := ReadBombSquadConfig(bc)
+	if err != nil {
+		return err
+	}
+	silence, ok := bsCfg.SuppressedMetrics[metricName][labelName]
+	if !ok {
+		return SilenceNotFoundError{ID: id}
+	}
+	if action := silence.Summarize(metricName, labelName).Action; action != string(promcfg.RelabelReplace) {
+		return InvalidSilenceError{Reason: fmt.Sprintf("the silence of %s uses %s, and only replace silences keep a baseline's values", id, action)}
+	}
+	previous := silence
+
+	pcfg, err := ReadPromConfig(pc)
+	if err != nil {
+		return err
+	}
+	old, err := silence.Rule(metricName, labelName)
+	if err != nil {
+		return err
+	}
+	rc, err := GenerateBaselineRelabelConfig(HighCardSeries{MetricName: metricName, HighCardLabelName: model.LabelName(labelName)}, b.Values)
+	if err != nil {
+		return err
+	}
+	if silence.RelabelConfig != "" {
+		swapRule(silence, old, rc, ruleSharers(bsCfg, metricName, labelName, old), &pcfg)
+		silence.RelabelConfig = encode(rc)
+	}
+	silence.Baseline = &b
+	bsCfg.SuppressedMetrics[metricName][labelName] = silence
+	bsCfg.RecordEvent(Event{Type: EventBaselined, Metric: metricName, Label: labelName, Detail: b.String()})
+
+	err = WriteBombSquadConfig(bsCfg, bc)
+	if err != nil {
+		return err
+	}
+	err = WritePromConfig(pcfg, pc)
+	if err != nil {
+		bsCfg.SuppressedMetrics[metricName][labelName] = previous
+		bsCfg.History = bsCfg.History[:len(bsCfg.History)-1]
+		if werr := WriteBombSquadConfig(bsCfg, bc); werr != nil {
+			log.Printf("Couldn't undo the baseline of %s, which wasn't applied: %s\n", id, werr)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateBaselineRelabelConfig(t *testing.T) {
+	s := config.HighCardSeries{MetricName: "requests_total", HighCardLabelName: "path"}
+	rc, err := config.GenerateBaselineRelabelConfig(s, []string{"/", "/login", "/log-out", "/a.b"})
+	require.NoError(t, err)
+	require.Equal(t, "path", rc.TargetLabel)
+	require.Equal(t, config.SilenceReplacement, rc.Replacement)
+
+	for _, kept := range []string{"/", "/login", "/log-out", "/a.b", ""} {
+		require.False(t, rc.Regex.MatchString("requests_total;"+kept), "%q should be kept", kept)
+	}
+	for _, rewritten := range []string{"/log", "/logins", "/logXout", "/aXb", "/users/1234", "x", config.SilenceReplacement} {
+		require.True(t, rc.Regex.MatchString("requests_total;"+rewritten), "%q should be rewritten", rewritten)
+	}
+	require.False(t, rc.Regex.MatchString("other_total;/users/1234"))
+}
@@ -0,0 +1,69 @@
+package config
+
+import "time"
+
+// Breaker records that Bomb Squad's circuit breaker has tripped. While it's
+// tripped, the patrol only reports explosions, and changes nothing by itself.
+type Breaker struct {
+	TrippedAt time.Time `yaml:"tripped_at"`
+	// Reason says which limit was reached
+	Reason string `yaml:"reason"`
+}
+
+// BreakerState describes the circuit breaker, suitable for showing to people
+// and scripts
+type BreakerState struct {
+	Tripped   bool       `json:"tripped"`
+	TrippedAt *time.Time `json:"trippedAt,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+}
+
+func (b *Breaker) state() BreakerState {
+	if b == nil {
+		return BreakerState{}
+	}
+	trippedAt := b.TrippedAt
+	return BreakerState{Tripped: true, TrippedAt: &trippedAt, Reason: b.Reason}
+}
+
+// GetBreaker returns the state of the circuit breaker
+func GetBreaker(c Configurator) (BreakerState, error) {
+	bsCfg, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return BreakerState{}, err
+	}
+	return bsCfg.Breaker.state(), nil
+}
+
+// TripBreaker trips the circuit breaker for the given reason. A breaker that
+// has already tripped keeps its original reason.
+func TripBreaker(reason string, c Configurator) error {
+	bsCfg, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return err
+	}
+	if bsCfg.Breaker != nil {
+		return nil
+	}
+
+	bsCfg.Breaker = &Breaker{TrippedAt: time.Now().UTC(), Reason: reason}
+	bsCfg.RecordEvent(Event{Type: EventBreakerTripped, Detail: reason})
+	return WriteBombSquadConfig(bsCfg, c)
+}
+
+// ResetBreaker resets the circuit breaker, letting the patrol act by itself
+// again. The state from before the reset is returned.
+func ResetBreaker(c Configurator) (BreakerState, error) {
+	bsCfg, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return BreakerState{}, err
+	}
+	previous := bsCfg.Breaker.state()
+	if bsCfg.Breaker == nil {
+		return previous, nil
+	}
+
+	bsCfg.Breaker = nil
+	bsCfg.RecordEvent(Event{Type: EventBreakerReset, Detail: "tripped as " + previous.Reason})
+	return previous, WriteBombSquadConfig(bsCfg, c)
+}
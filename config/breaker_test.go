@@ -0,0 +1,39 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreaker(t *testing.T) {
+	bc := bstesting.NewMemoryConfigurator(t, []byte{})
+
+	st, err := config.GetBreaker(bc)
+	require.NoError(t, err)
+	require.False(t, st.Tripped)
+
+	require.NoError(t, config.TripBreaker("too many silences", bc))
+	// Tripping it again keeps the first reason
+	require.NoError(t, config.TripBreaker("too many metrics", bc))
+	st, err = config.GetBreaker(bc)
+	require.NoError(t, err)
+	require.True(t, st.Tripped)
+	require.NotNil(t, st.TrippedAt)
+	require.Equal(t, "too many silences", st.Reason)
+
+	previous, err := config.ResetBreaker(bc)
+	require.NoError(t, err)
+	require.Equal(t, st, previous)
+	previous, err = config.ResetBreaker(bc)
+	require.NoError(t, err)
+	require.False(t, previous.Tripped)
+
+	events, err := config.History(0, bc)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, config.EventBreakerTripped, events[0].Type)
+	require.Equal(t, config.EventBreakerReset, events[1].Type)
+}
@@ -4,25 +4,41 @@ import (
 	"encoding/base64"
 	"fmt"
 	"log"
-	"net/http"
 	"strings"
+	"time"
 
-	"github.com/open-fresh/bomb-squad/util"
 	"github.com/prometheus/common/model"
 	promcfg "github.com/prometheus/prometheus/config"
 	yaml "gopkg.in/yaml.v2"
 )
 
+// SilenceReplacement is the value Bomb Squad writes over exploding label
+// values. It doubles as the marker that identifies Bomb Squad's own rules.
+const SilenceReplacement = "bs_silence"
+
 type Configurator interface {
 	Read() ([]byte, error)
 	Write([]byte) error
 	GetLocation() string
 }
 
-type BombSquadLabelConfig map[string]string
+type BombSquadLabelConfig map[string]Silence
 
 type BombSquadConfig struct {
 	SuppressedMetrics map[string]BombSquadLabelConfig
+	// Quarantines holds the scrape targets Bomb Squad has cut off, by ID
+	Quarantines map[string]Quarantine `yaml:"quarantines,omitempty"`
+	// Pending holds the silences the patrol has proposed and that await
+	// approval, by silence ID
+	Pending map[string]PendingSilence `yaml:"pending,omitempty"`
+	// Rejected holds when each rejected silence was rejected, by silence ID.
+	// The patrol doesn't propose them again while their label keeps
+	// exploding.
+	Rejected map[string]time.Time `yaml:"rejected,omitempty"`
+	// Breaker is set while the circuit breaker is tripped
+	Breaker *Breaker `yaml:"breaker,omitempty"`
+	// History holds the most recent changes Bomb Squad made, oldest first
+	History []Event `yaml:"history,omitempty"`
 }
 
 func ReadBombSquadConfig(c Configurator) (BombSquadConfig, error) {
@@ -39,6 +55,15 @@ func ReadBombSquadConfig(c Configurator) (BombSquadConfig, error) {
 	if bscfg.SuppressedMetrics == nil {
 		bscfg.SuppressedMetrics = map[string]BombSquadLabelConfig{}
 	}
+	if bscfg.Quarantines == nil {
+		bscfg.Quarantines = map[string]Quarantine{}
+	}
+	if bscfg.Pending == nil {
+		bscfg.Pending = map[string]PendingSilence{}
+	}
+	if bscfg.Rejected == nil {
+		bscfg.Rejected = map[string]time.Time{}
+	}
 
 	return bscfg, nil
 }
@@ -75,48 +100,29 @@ func WritePromConfig(pcfg promcfg.Config, c Configurator) error {
 	return c.Write(b)
 }
 
-func ListSuppressedMetrics(c Configurator) {
-	b, err := ReadBombSquadConfig(c)
-	if err != nil {
-		log.Fatalf("Couldn't list suppressed metrics: %s\n", err)
-	}
-
-	for metric, labels := range b.SuppressedMetrics {
-		for label := range labels {
-			fmt.Printf("%s.%s\n", metric, label)
-		}
-	}
-}
-
+// RemoveSilence removes the silence for label, given in metricName.labelName
+// form, from the Bomb Squad config and its rule from the Prometheus config
 func RemoveSilence(label string, pc, bc Configurator) error {
 	promConfig, err := ReadPromConfig(pc)
 	if err != nil {
 		return err
 	}
 
-	ml := strings.Split(label, ".")
-	metricName, labelName := ml[0], ml[1]
-
-	bsCfg, err := ReadBombSquadConfig(bc)
+	metricName, labelName, err := ParseSilenceID(label)
 	if err != nil {
 		return err
 	}
 
-	bsRelabelConfigEncoded := bsCfg.SuppressedMetrics[metricName][labelName]
-
-	for _, scrapeConfig := range promConfig.ScrapeConfigs {
-		i := FindRelabelConfigInScrapeConfig(bsRelabelConfigEncoded, *scrapeConfig)
-		if i >= 0 {
-			scrapeConfig.MetricRelabelConfigs = DeleteRelabelConfigFromArray(scrapeConfig.MetricRelabelConfigs, i)
-			fmt.Printf("Deleted silence rule from ScrapeConfig %s\n", scrapeConfig.JobName)
-		}
+	bsCfg, err := ReadBombSquadConfig(bc)
+	if err != nil {
+		return err
 	}
 
-	if len(bsCfg.SuppressedMetrics[metricName]) == 1 {
-		delete(bsCfg.SuppressedMetrics, metricName)
-	} else {
-		delete(bsCfg.SuppressedMetrics[metricName], labelName)
+	err = removeSilence(metricName, labelName, &promConfig, &bsCfg)
+	if err != nil {
+		return err
 	}
+	bsCfg.RecordEvent(Event{Type: EventUnsilenced, Metric: metricName, Label: labelName})
 
 	err = WriteBombSquadConfig(bsCfg, bc)
 	if err != nil {
@@ -128,31 +134,41 @@ func RemoveSilence(label string, pc, bc Configurator) error {
 		return err
 	}
 
-	resetMetric(metricName, labelName)
+	logOrphanedRelabelConfigs(FindOrphanedRelabelConfigs(promConfig, bsCfg))
 
 	return nil
 }
 
 func StoreMetricRelabelConfigBombSquad(s HighCardSeries, mrc promcfg.RelabelConfig, c Configurator) error {
+	return StoreSilence(s, mrc, Silence{}, c)
+}
+
+// StoreSilence records in the Bomb Squad config that the given series has
+// been silenced with mrc. Fields set on silence, such as its TTL, are kept;
+// the rule, action, jobs and applied time are filled in from s and mrc.
+func StoreSilence(s HighCardSeries, mrc promcfg.RelabelConfig, silence Silence, c Configurator) error {
 	b, err := ReadBombSquadConfig(c)
 	if err != nil {
 		return err
 	}
 
-	if lc, ok := b.SuppressedMetrics[s.MetricName]; ok {
-		lc[string(s.HighCardLabelName)] = encode(mrc)
-	} else {
-		b.SuppressedMetrics[s.MetricName] = lc
+	lc, ok := b.SuppressedMetrics[s.MetricName]
+	if !ok {
 		lc = BombSquadLabelConfig{}
-		lc[string(s.HighCardLabelName)] = encode(mrc)
+		b.SuppressedMetrics[s.MetricName] = lc
 	}
-
-	err = WriteBombSquadConfig(b, c)
-	if err != nil {
-		log.Fatalf("Failed to write BombSquadConfig: %s\n", err)
+	silence.RelabelConfig = encode(mrc)
+	silence.Action = string(mrc.Action)
+	silence.Jobs = s.Jobs
+	silence.AppliedAt = time.Now().UTC()
+	lc[string(s.HighCardLabelName)] = silence
+	e := Event{Type: EventSilenced, Metric: s.MetricName, Label: string(s.HighCardLabelName)}
+	if silence.Manual {
+		e.Detail = "requested by hand"
 	}
+	b.RecordEvent(e)
 
-	return nil
+	return WriteBombSquadConfig(b, c)
 }
 
 func DeleteRelabelConfigFromArray(arr []*promcfg.RelabelConfig, index int) []*promcfg.RelabelConfig {
@@ -165,9 +181,11 @@ func DeleteRelabelConfigFromArray(arr []*promcfg.RelabelConfig, index int) []*pr
 	return res
 }
 
-func FindRelabelConfigInScrapeConfig(encodedRule string, scrapeConfig promcfg.ScrapeConfig) int {
+// FindRelabelConfigInScrapeConfig returns the index of the first metric relabel
+// config in scrapeConfig that is semantically equal to rc, or -1 if there is none
+func FindRelabelConfigInScrapeConfig(rc promcfg.RelabelConfig, scrapeConfig promcfg.ScrapeConfig) int {
 	for i, relabelConfig := range scrapeConfig.MetricRelabelConfigs {
-		if encode(*relabelConfig) == encodedRule {
+		if RelabelConfigsEqual(*relabelConfig, rc) {
 			return i
 		}
 	}
@@ -175,22 +193,184 @@ func FindRelabelConfigInScrapeConfig(encodedRule string, scrapeConfig promcfg.Sc
 	return -1
 }
 
+// RelabelConfigsEqual compares two relabel configs by what they do rather than
+// by how they happen to be serialized. Defaults that Prometheus fills in upon
+// unmarshalling are applied to both sides, and regexes are compared by their
+// original (unanchored) form, so a rule survives a round trip through the
+// Prometheus config or a prom.ReUnmarshal unchanged.
+func RelabelConfigsEqual(a, b promcfg.RelabelConfig) bool {
+	a, b = normalizeRelabelConfig(a), normalizeRelabelConfig(b)
+
+	if len(a.SourceLabels) != len(b.SourceLabels) {
+		return false
+	}
+	for i := range a.SourceLabels {
+		if a.SourceLabels[i] != b.SourceLabels[i] {
+			return false
+		}
+	}
+
+	return a.Separator == b.Separator &&
+		regexString(a.Regex) == regexString(b.Regex) &&
+		a.Modulus == b.Modulus &&
+		a.TargetLabel == b.TargetLabel &&
+		a.Replacement == b.Replacement &&
+		a.Action == b.Action
+}
+
+func normalizeRelabelConfig(rc promcfg.RelabelConfig) promcfg.RelabelConfig {
+	if rc.Separator == "" {
+		rc.Separator = promcfg.DefaultRelabelConfig.Separator
+	}
+	if rc.Replacement == "" {
+		rc.Replacement = promcfg.DefaultRelabelConfig.Replacement
+	}
+	if rc.Action == "" {
+		rc.Action = promcfg.DefaultRelabelConfig.Action
+	}
+	rc.Action = promcfg.RelabelAction(strings.ToLower(string(rc.Action)))
+	return rc
+}
+
+// regexString returns the regex as it was originally written, falling back to
+// the compiled form if the original has been lost along the way
+func regexString(re promcfg.Regexp) string {
+	if re.Regexp == nil {
+		return ""
+	}
+	if original, err := re.MarshalYAML(); err == nil && original != nil {
+		return original.(string)
+	}
+	return re.String()
+}
+
+// IsBombSquadRelabelConfig reports whether a relabel config carries the marker
+// that Bomb Squad puts in every silencing rule it generates
+func IsBombSquadRelabelConfig(rc promcfg.RelabelConfig) bool {
+	return rc.Replacement == SilenceReplacement
+}
+
+// OrphanedRelabelConfig is a Bomb Squad silencing rule that was found in a
+// scrape config, or in the remote_write endpoint with the URL RemoteWrite, but
+// is not tracked in the Bomb Squad config
+type OrphanedRelabelConfig struct {
+	JobName       string
+	RemoteWrite   string
+	RelabelConfig promcfg.RelabelConfig
+}
+
+// FindOrphanedRelabelConfigs returns every Bomb Squad silencing rule present in
+// the scrape configs or remote_write endpoints of promConfig that doesn't
+// correspond to a silence stored in bsCfg
+func FindOrphanedRelabelConfigs(promConfig promcfg.Config, bsCfg BombSquadConfig) []OrphanedRelabelConfig {
+	known := []promcfg.RelabelConfig{}
+	for metricName, labels := range bsCfg.SuppressedMetrics {
+		for labelName, silence := range labels {
+			rc, err := silence.Rule(metricName, labelName)
+			if err != nil {
+				log.Printf("Couldn't get silence rule for %s.%s: %s\n", metricName, labelName, err)
+				continue
+			}
+			known = append(known, rc)
+		}
+	}
+
+	isOrphan := func(relabelConfig promcfg.RelabelConfig) bool {
+		if !IsBombSquadRelabelConfig(relabelConfig) {
+			return false
+		}
+		for _, rc := range known {
+			if RelabelConfigsEqual(relabelConfig, rc) {
+				return false
+			}
+		}
+		return true
+	}
+
+	orphans := []OrphanedRelabelConfig{}
+	for _, scrapeConfig := range promConfig.ScrapeConfigs {
+		for _, relabelConfig := range scrapeConfig.MetricRelabelConfigs {
+			if isOrphan(*relabelConfig) {
+				orphans = append(orphans, OrphanedRelabelConfig{JobName: scrapeConfig.JobName, RelabelConfig: *relabelConfig})
+			}
+		}
+	}
+	for _, rw := range promConfig.RemoteWriteConfigs {
+		for _, relabelConfig := range rw.WriteRelabelConfigs {
+			if isOrphan(*relabelConfig) {
+				orphans = append(orphans, OrphanedRelabelConfig{RemoteWrite: RemoteWriteURL(*rw), RelabelConfig: *relabelConfig})
+			}
+		}
+	}
+	return orphans
+}
+
+// ReportOrphanedRelabelConfigs logs any Bomb Squad silencing rules left in the
+// Prometheus config that Bomb Squad no longer knows about
+func ReportOrphanedRelabelConfigs(pc, bc Configurator) error {
+	promConfig, err := ReadPromConfig(pc)
+	if err != nil {
+		return err
+	}
+
+	bsCfg, err := ReadBombSquadConfig(bc)
+	if err != nil {
+		return err
+	}
+
+	logOrphanedRelabelConfigs(FindOrphanedRelabelConfigs(promConfig, bsCfg))
+	return nil
+}
+
+func logOrphanedRelabelConfigs(orphans []OrphanedRelabelConfig) {
+	for _, o := range orphans {
+		where := "ScrapeConfig " + o.JobName
+		if o.RemoteWrite != "" {
+			where = "remote_write " + o.RemoteWrite
+		}
+		log.Printf("Found orphaned silence rule in %s: source_labels=%v regex=%q target_label=%s\n",
+			where, o.RelabelConfig.SourceLabels, regexString(o.RelabelConfig.Regex), o.RelabelConfig.TargetLabel)
+	}
+}
+
 func InsertMetricRelabelConfigToPromConfig(rc promcfg.RelabelConfig, c Configurator) (promcfg.Config, error) {
+	return InsertMetricRelabelConfigToJobs(rc, nil, c)
+}
+
+// InsertMetricRelabelConfigToJobs works like InsertMetricRelabelConfigToPromConfig,
+// but only touches the scrape configs of the given jobs. No jobs means all of them.
+func InsertMetricRelabelConfigToJobs(rc promcfg.RelabelConfig, jobs []string, c Configurator) (promcfg.Config, error) {
 	promConfig, err := ReadPromConfig(c)
 	if err != nil {
 		return promcfg.Config{}, err
 	}
 
-	rcEncoded := encode(rc)
 	for _, scrapeConfig := range promConfig.ScrapeConfigs {
-		if FindRelabelConfigInScrapeConfig(rcEncoded, *scrapeConfig) == -1 {
-			fmt.Printf("Did not find necessary silence rule in ScrapeConfig %s, adding now\n", scrapeConfig.JobName)
+		if !jobSelected(jobs, scrapeConfig.JobName) {
+			continue
+		}
+		if FindRelabelConfigInScrapeConfig(rc, *scrapeConfig) == -1 {
+			log.Printf("Did not find necessary silence rule in ScrapeConfig %s, adding now\n", scrapeConfig.JobName)
 			scrapeConfig.MetricRelabelConfigs = append(scrapeConfig.MetricRelabelConfigs, &rc)
 		}
 	}
 	return promConfig, nil
 }
 
+// jobSelected reports whether jobName is one of jobs, treating no jobs at all
+// as every job
+func jobSelected(jobs []string, jobName string) bool {
+	if len(jobs) == 0 {
+		return true
+	}
+	for _, j := range jobs {
+		if j == jobName {
+			return true
+		}
+	}
+	return false
+}
+
 func encode(rc promcfg.RelabelConfig) string {
 	b, err := yaml.Marshal(rc)
 	if err != nil {
@@ -202,6 +382,20 @@ func encode(rc promcfg.RelabelConfig) string {
 	return base64.StdEncoding.EncodeToString([]byte(s))
 }
 
+func decode(encoded string) (promcfg.RelabelConfig, error) {
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return promcfg.RelabelConfig{}, fmt.Errorf("Failed to decode relabel config: %s", err)
+	}
+
+	rc := promcfg.RelabelConfig{}
+	err = yaml.Unmarshal(b, &rc)
+	if err != nil {
+		return promcfg.RelabelConfig{}, fmt.Errorf("Couldn't unmarshal into prometheus.RelabelConfig: %s", err)
+	}
+	return rc, nil
+}
+
 func ConfigGetRuleFiles() []string {
 	return []string{"nope", "not yet"}
 }
@@ -211,40 +405,60 @@ func ConfigGetRuleFiles() []string {
 type HighCardSeries struct {
 	MetricName        string
 	HighCardLabelName model.LabelName
+	// Jobs are the scrape jobs exposing the series. Empty means every job.
+	Jobs []string
 }
 
-// TODO: Only generate the relabel config for the appropriate job that is spitting out
-// the high-cardinality metric
 // TODO: Within a job, some series may never be exploding on this label. Consider including
 // all relevant labels in source_labels...?
 func GenerateMetricRelabelConfig(s HighCardSeries) (promcfg.RelabelConfig, error) {
-	valueReplace := "bs_silence"
-	regexpOriginal := fmt.Sprintf("^%s;.*$", s.MetricName)
+	return GenerateSilenceRelabelConfig(s, string(promcfg.RelabelReplace))
+}
+
+// SilenceActions are the relabel actions a silence can use, from the mildest
+// to the harshest. replace overwrites the exploding label's value with a
+// constant, keeping the series; labeldrop removes the label altogether, from
+// every metric of the job; drop discards every series of the metric that
+// carries the label.
+var SilenceActions = []string{string(promcfg.RelabelReplace), string(promcfg.RelabelLabelDrop), string(promcfg.RelabelDrop)}
+
+// GenerateSilenceRelabelConfig returns the rule silencing s with the given
+// action, which must be one of SilenceActions
+func GenerateSilenceRelabelConfig(s HighCardSeries, action string) (promcfg.RelabelConfig, error) {
+	var regexpOriginal string
+	switch action {
+	case string(promcfg.RelabelReplace):
+		regexpOriginal = fmt.Sprintf("^%s;.*$", s.MetricName)
+	case string(promcfg.RelabelDrop):
+		regexpOriginal = fmt.Sprintf("^%s;.+$", s.MetricName)
+	case string(promcfg.RelabelLabelDrop):
+		regexpOriginal = fmt.Sprintf("^%s$", s.HighCardLabelName)
+	default:
+		return promcfg.RelabelConfig{}, fmt.Errorf("Unsupported silence action '%s', expected one of %s", action, strings.Join(SilenceActions, ", "))
+	}
+
 	promRegex, err := promcfg.NewRegexp(regexpOriginal)
 	if err != nil {
 		return promcfg.RelabelConfig{}, fmt.Errorf("Couldn't create promcfg.Regexp from '%s': %s", regexpOriginal, err)
 	}
 
+	if action == string(promcfg.RelabelLabelDrop) {
+		// Prometheus rejects labeldrop rules with source labels or a
+		// replacement, so these can't carry the marker. They're only known
+		// to be Bomb Squad's through the silence that made them.
+		return promcfg.RelabelConfig{Regex: promRegex, Action: promcfg.RelabelLabelDrop}, nil
+	}
+
 	newMetricRelabelConfig := promcfg.RelabelConfig{
 		SourceLabels: model.LabelNames{"__name__", s.HighCardLabelName},
 		Regex:        promRegex,
-		TargetLabel:  string(s.HighCardLabelName),
-		Replacement:  valueReplace,
-		Action:       "replace",
+		// Drop rules ignore the replacement, but carrying the marker lets
+		// them be recognised as Bomb Squad's all the same
+		Replacement: SilenceReplacement,
+		Action:      promcfg.RelabelAction(action),
 	}
-	return newMetricRelabelConfig, nil
-}
-
-func resetMetric(metricName, labelName string) {
-	client, _ := util.HttpClient()
-	// TODO This is a hack currently, to allow the CLI invocation of `unsilence` to actually get to the metric
-	// that needs reset. Should not assume that CLI will be invoked from the running instance, and make this
-	// configurable
-	endpt := fmt.Sprintf("http://localhost:8080/metrics/reset?metric=%s&label=%s", metricName, labelName)
-	req, _ := http.NewRequest("GET", endpt, nil)
-
-	_, err := client.Do(req)
-	if err != nil {
-		log.Printf("Failed to reset metric for %s.%s: %s. Not urgent - continuing.", metricName, labelName, err)
+	if action == string(promcfg.RelabelReplace) {
+		newMetricRelabelConfig.TargetLabel = string(s.HighCardLabelName)
 	}
+	return newMetricRelabelConfig, nil
 }
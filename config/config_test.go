@@ -5,7 +5,9 @@ import (
 
 	"github.com/open-fresh/bomb-squad/bstesting"
 	"github.com/open-fresh/bomb-squad/config"
+	promcfg "github.com/prometheus/prometheus/config"
 	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v2"
 )
 
 func TestCanReadPromConfig(t *testing.T) {
@@ -47,3 +49,103 @@ func TestCanInsertMetricRelabelConfigToPromConfig(t *testing.T) {
 	require.Equal(t, "bar", insertedMRC.TargetLabel)
 	require.Equal(t, "^(?:^foo;.*$)$", insertedMRC.Regex.String())
 }
+
+func TestFindRelabelConfigIgnoresSerialization(t *testing.T) {
+	c := bstesting.NewConfigurator(t)
+	hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar"}
+	mrc, err := config.GenerateMetricRelabelConfig(hcs)
+	require.NoError(t, err)
+
+	reordered := promcfg.RelabelConfig{}
+	err = yaml.Unmarshal([]byte(`
+action: replace
+target_label: bar
+replacement: bs_silence
+regex: ^foo;.*$
+source_labels: [__name__, bar]
+`), &reordered)
+	require.NoError(t, err)
+
+	pcfg, err := config.ReadPromConfig(c)
+	require.NoError(t, err)
+	pcfg.ScrapeConfigs[0].MetricRelabelConfigs = append(pcfg.ScrapeConfigs[0].MetricRelabelConfigs, &reordered)
+
+	require.Equal(t, 0, config.FindRelabelConfigInScrapeConfig(mrc, *pcfg.ScrapeConfigs[0]))
+	require.Equal(t, -1, config.FindRelabelConfigInScrapeConfig(mrc, *pcfg.ScrapeConfigs[1]))
+}
+
+func TestFindOrphanedRelabelConfigs(t *testing.T) {
+	c := bstesting.NewConfigurator(t)
+	hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar"}
+	mrc, err := config.GenerateMetricRelabelConfig(hcs)
+	require.NoError(t, err)
+	pcfg, err := config.InsertMetricRelabelConfigToPromConfig(mrc, c)
+	require.NoError(t, err)
+
+	bscfg := config.BombSquadConfig{SuppressedMetrics: map[string]config.BombSquadLabelConfig{}}
+	orphans := config.FindOrphanedRelabelConfigs(pcfg, bscfg)
+	require.Len(t, orphans, len(pcfg.ScrapeConfigs))
+	require.Equal(t, "prometheus", orphans[0].JobName)
+}
+
+func TestGenerateDropSilence(t *testing.T) {
+	hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar"}
+	rc, err := config.GenerateSilenceRelabelConfig(hcs, "drop")
+	require.NoError(t, err)
+	require.Equal(t, promcfg.RelabelDrop, rc.Action)
+	require.Empty(t, rc.TargetLabel)
+	require.True(t, config.IsBombSquadRelabelConfig(rc))
+	require.True(t, rc.Regex.MatchString("foo;x"))
+	require.False(t, rc.Regex.MatchString("foo;"))
+
+	_, err = config.GenerateSilenceRelabelConfig(hcs, "keep")
+	require.Error(t, err)
+}
+
+func TestGenerateLabelDropSilence(t *testing.T) {
+	hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar"}
+	rc, err := config.GenerateSilenceRelabelConfig(hcs, "labeldrop")
+	require.NoError(t, err)
+	require.Equal(t, promcfg.RelabelLabelDrop, rc.Action)
+	require.Empty(t, rc.SourceLabels)
+	require.True(t, rc.Regex.MatchString("bar"))
+	require.False(t, rc.Regex.MatchString("barn"))
+
+	// Prometheus must accept the rule as it's written to its config
+	b, err := yaml.Marshal(rc)
+	require.NoError(t, err)
+	require.NoError(t, yaml.Unmarshal(b, &promcfg.RelabelConfig{}))
+}
+
+func TestSharedLabelDropRule(t *testing.T) {
+	pc := bstesting.NewPromMemoryConfigurator(t)
+	bc := bstesting.NewMemoryConfigurator(t, []byte{})
+	for _, metric := range []string{"foo", "baz"} {
+		s := config.HighCardSeries{MetricName: metric, HighCardLabelName: "bar", Jobs: []string{"prometheus"}}
+		rc, err := config.GenerateSilenceRelabelConfig(s, "labeldrop")
+		require.NoError(t, err)
+		pcfg, err := config.InsertMetricRelabelConfigToJobs(rc, s.Jobs, pc)
+		require.NoError(t, err)
+		require.NoError(t, config.WritePromConfig(pcfg, pc))
+		require.NoError(t, config.StoreSilence(s, rc, config.Silence{}, bc))
+	}
+	rc, err := config.GenerateSilenceRelabelConfig(config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar"}, "labeldrop")
+	require.NoError(t, err)
+	found := func() bool {
+		pcfg, err := config.ReadPromConfig(pc)
+		require.NoError(t, err)
+		for _, sc := range pcfg.ScrapeConfigs {
+			if sc.JobName == "prometheus" {
+				return config.FindRelabelConfigInScrapeConfig(rc, *sc) >= 0
+			}
+		}
+		return false
+	}
+	require.True(t, found())
+
+	// Both silences share the rule, so it stays until the last one goes
+	require.NoError(t, config.RemoveSilence("foo.bar", pc, bc))
+	require.True(t, found())
+	require.NoError(t, config.RemoveSilence("baz.bar", pc, bc))
+	require.False(t, found())
+}
@@ -0,0 +1,346 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"time"
+
+	promcfg "github.com/prometheus/prometheus/config"
+)
+
+// Drift describes how the Prometheus config has diverged from the silences
+// and quarantines recorded in the Bomb Squad config
+type Drift struct {
+	// Missing holds silences that Bomb Squad has recorded but whose rule is
+	// absent from a scrape config
+	Missing []MissingRelabelConfig
+	// Orphaned holds Bomb Squad rules present in a scrape config that no
+	// recorded silence accounts for
+	Orphaned []OrphanedRelabelConfig
+	// MissingQuarantines holds quarantines that Bomb Squad has recorded as
+	// applied to a scrape config that lacks their rule or sample_limit
+	MissingQuarantines []MissingQuarantine
+	// OrphanedQuarantines holds quarantine rules present in a scrape config
+	// that no recorded quarantine accounts for
+	OrphanedQuarantines []OrphanedRelabelConfig
+}
+
+// MissingQuarantine is a recorded quarantine whose drop rule, or sample_limit,
+// was not found in the scrape config for JobName
+type MissingQuarantine struct {
+	ID      string
+	JobName string
+	Mode    string
+	// RelabelConfig is the drop rule in drop mode
+	RelabelConfig promcfg.RelabelConfig
+	// SampleLimit is the limit in sample_limit mode
+	SampleLimit uint
+}
+
+// MissingRelabelConfig is a recorded silence whose rule was not found in the
+// scrape config for JobName, or in the remote_write endpoint with the URL
+// RemoteWrite
+type MissingRelabelConfig struct {
+	JobName       string
+	RemoteWrite   string
+	MetricName    string
+	LabelName     string
+	RelabelConfig promcfg.RelabelConfig
+}
+
+// Empty reports whether the Prometheus config and the Bomb Squad config agree
+func (d Drift) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Orphaned) == 0 && len(d.MissingQuarantines) == 0 && len(d.OrphanedQuarantines) == 0
+}
+
+// DetectDrift compares the silences and quarantines stored in bsCfg with the
+// Bomb Squad rules actually present in each of promConfig's scrape configs
+func DetectDrift(promConfig promcfg.Config, bsCfg BombSquadConfig) Drift {
+	d := Drift{
+		Missing:             []MissingRelabelConfig{},
+		Orphaned:            FindOrphanedRelabelConfigs(promConfig, bsCfg),
+		MissingQuarantines:  findMissingQuarantines(promConfig, bsCfg),
+		OrphanedQuarantines: findOrphanedQuarantineRules(promConfig, bsCfg),
+	}
+
+	for metricName, labels := range bsCfg.SuppressedMetrics {
+		for labelName, silence := range labels {
+			rc, err := silence.Rule(metricName, labelName)
+			if err != nil {
+				log.Printf("Couldn't get silence rule for %s.%s: %s\n", metricName, labelName, err)
+				continue
+			}
+
+			for _, scrapeConfig := range promConfig.ScrapeConfigs {
+				if silence.RemoteWriteOnly || !jobSelected(silence.Jobs, scrapeConfig.JobName) {
+					continue
+				}
+				if FindRelabelConfigInScrapeConfig(rc, *scrapeConfig) == -1 {
+					d.Missing = append(d.Missing, MissingRelabelConfig{
+						JobName:       scrapeConfig.JobName,
+						MetricName:    metricName,
+						LabelName:     labelName,
+						RelabelConfig: rc,
+					})
+				}
+			}
+			for _, rw := range promConfig.RemoteWriteConfigs {
+				u := RemoteWriteURL(*rw)
+				if remoteWriteSelected(silence.RemoteWrite, u) && FindWriteRelabelConfig(rc, *rw) == -1 {
+					d.Missing = append(d.Missing, MissingRelabelConfig{
+						RemoteWrite:   u,
+						MetricName:    metricName,
+						LabelName:     labelName,
+						RelabelConfig: rc,
+					})
+				}
+			}
+		}
+	}
+	return d
+}
+
+// quarantineRules returns the drop rules of the quarantine with the given ID:
+// the one recorded with it, and the one it would get now, if they differ
+func quarantineRules(id string, q Quarantine) []promcfg.RelabelConfig {
+	rules := []promcfg.RelabelConfig{}
+	if q.RelabelConfig != "" {
+		if stored, err := decode(q.RelabelConfig); err == nil {
+			rules = append(rules, stored)
+		}
+	}
+	if rc, err := QuarantineRule(id); err == nil && (len(rules) == 0 || !RelabelConfigsEqual(rules[0], rc)) {
+		rules = append(rules, rc)
+	}
+	return rules
+}
+
+// findMissingQuarantines returns the quarantines in bsCfg whose drop rule is
+// absent from, or whose sample_limit isn't in force in, a scrape config they
+// were applied to
+func findMissingQuarantines(promConfig promcfg.Config, bsCfg BombSquadConfig) []MissingQuarantine {
+	missing := []MissingQuarantine{}
+	for id, q := range bsCfg.Quarantines {
+		for _, scrapeConfig := range promConfig.ScrapeConfigs {
+			switch q.Mode {
+			case QuarantineDrop:
+				if len(q.AppliedJobs) == 0 || !jobSelected(q.AppliedJobs, scrapeConfig.JobName) {
+					continue
+				}
+				rules := quarantineRules(id, q)
+				if len(rules) == 0 {
+					log.Printf("Couldn't get the rule of quarantine %s\n", id)
+					continue
+				}
+				found := false
+				for _, rc := range rules {
+					found = found || findTargetRelabelConfig(rc, *scrapeConfig) >= 0
+				}
+				if !found {
+					missing = append(missing, MissingQuarantine{ID: id, JobName: scrapeConfig.JobName, Mode: q.Mode, RelabelConfig: rules[0]})
+				}
+
+			case QuarantineSampleLimit:
+				if _, ok := q.PreviousSampleLimits[scrapeConfig.JobName]; !ok {
+					continue
+				}
+				if scrapeConfig.SampleLimit == 0 || scrapeConfig.SampleLimit > q.SampleLimit {
+					missing = append(missing, MissingQuarantine{ID: id, JobName: scrapeConfig.JobName, Mode: q.Mode, SampleLimit: q.SampleLimit})
+				}
+			}
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		if missing[i].ID != missing[j].ID {
+			return missing[i].ID < missing[j].ID
+		}
+		return missing[i].JobName < missing[j].JobName
+	})
+	return missing
+}
+
+// findOrphanedQuarantineRules returns every quarantine rule present in the
+// scrape configs of promConfig that no quarantine in bsCfg accounts for
+func findOrphanedQuarantineRules(promConfig promcfg.Config, bsCfg BombSquadConfig) []OrphanedRelabelConfig {
+	known := []promcfg.RelabelConfig{}
+	for id, q := range bsCfg.Quarantines {
+		if q.Mode == QuarantineDrop {
+			known = append(known, quarantineRules(id, q)...)
+		}
+	}
+
+	orphans := []OrphanedRelabelConfig{}
+	for _, scrapeConfig := range promConfig.ScrapeConfigs {
+		for _, relabelConfig := range scrapeConfig.RelabelConfigs {
+			if relabelConfig.Replacement != QuarantineReplacement {
+				continue
+			}
+			orphaned := true
+			for _, rc := range known {
+				if RelabelConfigsEqual(*relabelConfig, rc) {
+					orphaned = false
+				}
+			}
+			if orphaned {
+				orphans = append(orphans, OrphanedRelabelConfig{JobName: scrapeConfig.JobName, RelabelConfig: *relabelConfig})
+			}
+		}
+	}
+	return orphans
+}
+
+// Reconcile brings the Prometheus config back in line with the Bomb Squad
+// config, which is treated as the source of truth: missing silence and
+// quarantine rules, and quarantines' sample_limits, are re-applied and
+// orphaned rules are removed. Silences that were recorded
+// without a rule, such as those created by hand, get theirs generated and
+// applied here. The Prometheus config is only written if there was drift to
+// correct, and the Bomb Squad config only if drift was corrected or a
+// silence's applied status changed. The drift that was found is returned.
+func Reconcile(pc, bc Configurator) (Drift, error) {
+	promConfig, err := ReadPromConfig(pc)
+	if err != nil {
+		return Drift{}, err
+	}
+
+	bsCfg, err := ReadBombSquadConfig(bc)
+	if err != nil {
+		return Drift{}, err
+	}
+
+	d := DetectDrift(promConfig, bsCfg)
+	changed := false
+	if !d.Empty() {
+		correctDrift(d, &promConfig)
+
+		err = WritePromConfig(promConfig, pc)
+		if err != nil {
+			return d, err
+		}
+		bsCfg.RecordEvent(Event{
+			Type:   EventDriftCorrected,
+			Detail: fmt.Sprintf("re-applied %d missing and removed %d orphaned silence rules, and re-applied %d missing and removed %d orphaned quarantine rules", len(d.Missing), len(d.Orphaned), len(d.MissingQuarantines), len(d.OrphanedQuarantines)),
+		})
+		changed = true
+	}
+
+	if updateAppliedStatus(promConfig, &bsCfg, time.Now().UTC()) || changed {
+		err = WriteBombSquadConfig(bsCfg, bc)
+		if err != nil {
+			return d, err
+		}
+	}
+	return d, nil
+}
+
+func correctDrift(d Drift, promConfig *promcfg.Config) {
+	scrapeConfigs := map[string]*promcfg.ScrapeConfig{}
+	for _, scrapeConfig := range promConfig.ScrapeConfigs {
+		scrapeConfigs[scrapeConfig.JobName] = scrapeConfig
+	}
+
+	remoteWrites := map[string]*promcfg.RemoteWriteConfig{}
+	for _, rw := range promConfig.RemoteWriteConfigs {
+		remoteWrites[RemoteWriteURL(*rw)] = rw
+	}
+
+	for _, m := range d.Missing {
+		rc := m.RelabelConfig
+		if m.RemoteWrite != "" {
+			rw := remoteWrites[m.RemoteWrite]
+			rw.WriteRelabelConfigs = append(rw.WriteRelabelConfigs, &rc)
+			fmt.Printf("Re-applied missing silence rule for %s.%s to remote_write %s\n", m.MetricName, m.LabelName, m.RemoteWrite)
+			continue
+		}
+		scrapeConfig := scrapeConfigs[m.JobName]
+		scrapeConfig.MetricRelabelConfigs = append(scrapeConfig.MetricRelabelConfigs, &rc)
+		fmt.Printf("Re-applied missing silence rule for %s.%s to ScrapeConfig %s\n", m.MetricName, m.LabelName, m.JobName)
+	}
+
+	for _, o := range d.Orphaned {
+		if o.RemoteWrite != "" {
+			rw := remoteWrites[o.RemoteWrite]
+			if i := FindWriteRelabelConfig(o.RelabelConfig, *rw); i >= 0 {
+				rw.WriteRelabelConfigs = DeleteRelabelConfigFromArray(rw.WriteRelabelConfigs, i)
+				fmt.Printf("Removed orphaned silence rule from remote_write %s\n", o.RemoteWrite)
+			}
+			continue
+		}
+		scrapeConfig := scrapeConfigs[o.JobName]
+		i := FindRelabelConfigInScrapeConfig(o.RelabelConfig, *scrapeConfig)
+		if i >= 0 {
+			scrapeConfig.MetricRelabelConfigs = DeleteRelabelConfigFromArray(scrapeConfig.MetricRelabelConfigs, i)
+			fmt.Printf("Removed orphaned silence rule from ScrapeConfig %s\n", o.JobName)
+		}
+	}
+
+	for _, m := range d.MissingQuarantines {
+		scrapeConfig := scrapeConfigs[m.JobName]
+		switch m.Mode {
+		case QuarantineDrop:
+			rc := m.RelabelConfig
+			scrapeConfig.RelabelConfigs = append(scrapeConfig.RelabelConfigs, &rc)
+			fmt.Printf("Re-applied missing rule of quarantine %s to ScrapeConfig %s\n", m.ID, m.JobName)
+		case QuarantineSampleLimit:
+			if scrapeConfig.SampleLimit == 0 || scrapeConfig.SampleLimit > m.SampleLimit {
+				scrapeConfig.SampleLimit = m.SampleLimit
+			}
+			fmt.Printf("Re-applied missing sample_limit of quarantine %s to ScrapeConfig %s\n", m.ID, m.JobName)
+		}
+	}
+
+	for _, o := range d.OrphanedQuarantines {
+		scrapeConfig := scrapeConfigs[o.JobName]
+		if i := findTargetRelabelConfig(o.RelabelConfig, *scrapeConfig); i >= 0 {
+			scrapeConfig.RelabelConfigs = DeleteRelabelConfigFromArray(scrapeConfig.RelabelConfigs, i)
+			fmt.Printf("Removed orphaned quarantine rule from ScrapeConfig %s\n", o.JobName)
+		}
+	}
+}
+
+// updateAppliedStatus records, for every silence in bsCfg, which scrape jobs
+// and remote_write endpoints in promConfig carry its rule and when it was
+// first applied. It reports
+// whether anything changed.
+func updateAppliedStatus(promConfig promcfg.Config, bsCfg *BombSquadConfig, now time.Time) bool {
+	changed := false
+	for metricName, labels := range bsCfg.SuppressedMetrics {
+		for labelName, silence := range labels {
+			rc, err := silence.Rule(metricName, labelName)
+			if err != nil {
+				continue
+			}
+
+			var jobs []string
+			for _, scrapeConfig := range promConfig.ScrapeConfigs {
+				if FindRelabelConfigInScrapeConfig(rc, *scrapeConfig) >= 0 {
+					jobs = append(jobs, scrapeConfig.JobName)
+				}
+			}
+			var remoteWrites []string
+			for _, rw := range promConfig.RemoteWriteConfigs {
+				if FindWriteRelabelConfig(rc, *rw) >= 0 {
+					remoteWrites = append(remoteWrites, RemoteWriteURL(*rw))
+				}
+			}
+
+			updated := silence
+			updated.AppliedJobs = jobs
+			updated.AppliedRemoteWrite = remoteWrites
+			if updated.RelabelConfig == "" {
+				updated.RelabelConfig = encode(rc)
+			}
+			if updated.AppliedAt.IsZero() && len(jobs)+len(remoteWrites) > 0 {
+				updated.AppliedAt = now
+			}
+
+			if !reflect.DeepEqual(updated, silence) {
+				labels[labelName] = updated
+				changed = true
+			}
+		}
+	}
+	return changed
+}
@@ -0,0 +1,95 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcileReappliesMissingRules(t *testing.T) {
+	pc := bstesting.NewPromMemoryConfigurator(t)
+	bc := bstesting.NewMemoryConfigurator(t, []byte{})
+
+	hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar"}
+	mrc, err := config.GenerateMetricRelabelConfig(hcs)
+	require.NoError(t, err)
+	require.NoError(t, config.StoreMetricRelabelConfigBombSquad(hcs, mrc, bc))
+
+	d, err := config.Reconcile(pc, bc)
+	require.NoError(t, err)
+	require.Len(t, d.Orphaned, 0)
+	require.Len(t, d.Missing, 4)
+	require.Equal(t, 1, pc.Writes)
+
+	pcfg, err := config.ReadPromConfig(pc)
+	require.NoError(t, err)
+	for _, sc := range pcfg.ScrapeConfigs {
+		require.NotEqual(t, -1, config.FindRelabelConfigInScrapeConfig(mrc, *sc))
+	}
+
+	d, err = config.Reconcile(pc, bc)
+	require.NoError(t, err)
+	require.True(t, d.Empty())
+	require.Equal(t, 1, pc.Writes)
+}
+
+func TestReconcileRemovesOrphanedRules(t *testing.T) {
+	pc := bstesting.NewPromMemoryConfigurator(t)
+	bc := bstesting.NewMemoryConfigurator(t, []byte{})
+
+	hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar"}
+	mrc, err := config.GenerateMetricRelabelConfig(hcs)
+	require.NoError(t, err)
+	pcfg, err := config.InsertMetricRelabelConfigToPromConfig(mrc, pc)
+	require.NoError(t, err)
+	require.NoError(t, config.WritePromConfig(pcfg, pc))
+
+	d, err := config.Reconcile(pc, bc)
+	require.NoError(t, err)
+	require.Len(t, d.Missing, 0)
+	require.Len(t, d.Orphaned, 4)
+
+	pcfg, err = config.ReadPromConfig(pc)
+	require.NoError(t, err)
+	for _, sc := range pcfg.ScrapeConfigs {
+		require.Len(t, sc.MetricRelabelConfigs, 0)
+	}
+}
+
+func TestReconcileRestoresQuarantines(t *testing.T) {
+	pc := bstesting.NewPromMemoryConfigurator(t)
+	bc := bstesting.NewMemoryConfigurator(t, []byte{})
+
+	require.NoError(t, config.ApplyQuarantine("instance:10.0.0.1:8080", config.Quarantine{Mode: config.QuarantineDrop, Jobs: []string{"prometheus"}}, pc, bc))
+	require.NoError(t, config.ApplyQuarantine("instance:10.0.0.2:8080", config.Quarantine{Mode: config.QuarantineSampleLimit, SampleLimit: 1000, Jobs: []string{"prometheus"}}, pc, bc))
+	applied, err := config.ReadPromConfig(pc)
+	require.NoError(t, err)
+
+	// The ConfigMap is redeployed without the quarantines, but with the rule
+	// of one Bomb Squad no longer knows about
+	redeployed := bstesting.NewPromMemoryConfigurator(t)
+	pcfg, err := config.ReadPromConfig(redeployed)
+	require.NoError(t, err)
+	orphan, err := config.QuarantineRule("pod:shop/api-0")
+	require.NoError(t, err)
+	pcfg.ScrapeConfigs[0].RelabelConfigs = append(pcfg.ScrapeConfigs[0].RelabelConfigs, &orphan)
+	require.NoError(t, config.WritePromConfig(pcfg, redeployed))
+
+	d, err := config.Reconcile(redeployed, bc)
+	require.NoError(t, err)
+	require.Len(t, d.MissingQuarantines, 2)
+	require.Equal(t, "instance:10.0.0.1:8080", d.MissingQuarantines[0].ID)
+	require.Equal(t, config.QuarantineSampleLimit, d.MissingQuarantines[1].Mode)
+	require.Len(t, d.OrphanedQuarantines, 1)
+	require.Equal(t, pcfg.ScrapeConfigs[0].JobName, d.OrphanedQuarantines[0].JobName)
+
+	restored, err := config.ReadPromConfig(redeployed)
+	require.NoError(t, err)
+	require.Equal(t, applied.ScrapeConfigs, restored.ScrapeConfigs)
+
+	d, err = config.Reconcile(redeployed, bc)
+	require.NoError(t, err)
+	require.True(t, d.Empty())
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/prometheus/common/model"
+	promcfg "github.com/prometheus/prometheus/config"
+)
+
+// EscalationQuarantine is the escalation step that quarantines the target
+// behind a silenced metric, rather than changing the silence itself
+const EscalationQuarantine = "quarantine"
+
+// Outcomes of checking that a silence stopped its metric from growing. A
+// silence that hasn't been checked yet has no verification.
+const (
+	// VerificationPassed means the metric stopped growing
+	VerificationPassed = "passed"
+	// VerificationExhausted means the metric kept growing after every step
+	// of the escalation ladder was tried
+	VerificationExhausted = "exhausted"
+)
+
+// Escalation records a silence being made harsher because its metric kept
+// growing after it was applied
+type Escalation struct {
+	Time time.Time `yaml:"time" json:"time"`
+	// From is the silence's action before the escalation
+	From string `yaml:"from" json:"from"`
+	// To is the silence's action after the escalation, or
+	// EscalationQuarantine if a target was quarantined instead
+	To string `yaml:"to" json:"to"`
+	// Growth is how much card_count grew by in the minute before
+	Growth float64 `yaml:"growth" json:"growth"`
+	// Target is the ID of the quarantine made by a quarantine step
+	Target string `yaml:"target,omitempty" json:"target,omitempty"`
+	// Reason says why the silence was escalated, when it wasn't because
+	// card_count kept growing
+	Reason string `yaml:"reason,omitempty" json:"reason,omitempty"`
+}
+
+func (e Escalation) String() string {
+	s := fmt.Sprintf("%s to %s, as card_count still grew by %g", e.From, e.To, e.Growth)
+	if e.Reason != "" {
+		s = fmt.Sprintf("%s to %s, as %s", e.From, e.To, e.Reason)
+	}
+	if e.Target != "" {
+		s += ", quarantining " + e.Target
+	}
+	return s
+}
+
+// EscalateSilence records e against the silence with the given ID. Unless e
+// is a quarantine step, the silence's rule is swapped for one using the
+// action e.To in every scrape config and remote_write endpoint the silence
+// applies to; its jobs, TTL and applied time are kept. The Bomb Squad config
+// is written first, and restored if the Prometheus config can't be written.
+func EscalateSilence(id string, e Escalation, pc, bc Configurator) error {
+	metricName, labelName, err := ParseSilenceID(id)
+	if err != nil {
+		return err
+	}
+
+	bsCfg, err := ReadBombSquadConfig(bc)
+	if err != nil {
+		return err
+	}
+	silence, ok := bsCfg.SuppressedMetrics[metricName][labelName]
+	if !ok {
+		return SilenceNotFoundError{ID: id}
+	}
+	previous := silence
+	if e.To == string(promcfg.RelabelLabelDrop) && len(silence.RemoteWrite) > 0 {
+		return InvalidSilenceError{Reason: labelDropRemoteWrite}
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if e.From == "" {
+		e.From = silence.Summarize(metricName, labelName).Action
+	}
+
+	var pcfg promcfg.Config
+	if e.To != EscalationQuarantine {
+		pcfg, err = ReadPromConfig(pc)
+		if err != nil {
+			return err
+		}
+		old, err := silence.Rule(metricName, labelName)
+		if err != nil {
+			return err
+		}
+		rc, err := GenerateSilenceRelabelConfig(HighCardSeries{MetricName: metricName, HighCardLabelName: model.LabelName(labelName)}, e.To)
+		if err != nil {
+			return InvalidSilenceError{Reason: err.Error()}
+		}
+
+		swapRule(silence, old, rc, ruleSharers(bsCfg, metricName, labelName, old), &pcfg)
+		silence.RelabelConfig = encode(rc)
+		silence.Action = e.To
+	}
+	silence.Escalations = append(silence.Escalations, e)
+	bsCfg.SuppressedMetrics[metricName][labelName] = silence
+	bsCfg.RecordEvent(Event{Type: EventEscalated, Metric: metricName, Label: labelName, Target: e.Target, Detail: e.String()})
+
+	err = WriteBombSquadConfig(bsCfg, bc)
+	if err != nil {
+		return err
+	}
+	if e.To == EscalationQuarantine {
+		return nil
+	}
+	err = WritePromConfig(pcfg, pc)
+	if err != nil {
+		// Put the silence back as it was, so that it doesn't claim a rule
+		// that isn't in place
+		bsCfg.SuppressedMetrics[metricName][labelName] = previous
+		bsCfg.History = bsCfg.History[:len(bsCfg.History)-1]
+		if werr := WriteBombSquadConfig(bsCfg, bc); werr != nil {
+			log.Printf("Couldn't undo the escalation of %s, which wasn't applied: %s\n", id, werr)
+		}
+		return err
+	}
+	return nil
+}
+
+// swapRule replaces the rule old of silence with rc in every scrape config
+// and remote_write endpoint the silence applies to. old is left where one of
+// sharers, the other silences with the same rule, still needs it.
+func swapRule(silence Silence, old, rc promcfg.RelabelConfig, sharers []Silence, pcfg *promcfg.Config) {
+	for _, scrapeConfig := range pcfg.ScrapeConfigs {
+		for i := FindRelabelConfigInScrapeConfig(old, *scrapeConfig); i >= 0 && !neededInJob(sharers, scrapeConfig.JobName); i = FindRelabelConfigInScrapeConfig(old, *scrapeConfig) {
+			scrapeConfig.MetricRelabelConfigs = DeleteRelabelConfigFromArray(scrapeConfig.MetricRelabelConfigs, i)
+		}
+		if !silence.RemoteWriteOnly && jobSelected(silence.Jobs, scrapeConfig.JobName) && FindRelabelConfigInScrapeConfig(rc, *scrapeConfig) == -1 {
+			rc := rc
+			scrapeConfig.MetricRelabelConfigs = append(scrapeConfig.MetricRelabelConfigs, &rc)
+		}
+	}
+	deleteWriteRelabelConfig(old, sharers, pcfg)
+	InsertWriteRelabelConfig(rc, silence.RemoteWrite, pcfg)
+}
+
+// SetVerification records the outcome of checking whether the silence with
+// the given ID stopped its metric from growing. detail says why.
+func SetVerification(id, verification, detail string, c Configurator) error {
+	metricName, labelName, err := ParseSilenceID(id)
+	if err != nil {
+		return err
+	}
+
+	bsCfg, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return err
+	}
+	silence, ok := bsCfg.SuppressedMetrics[metricName][labelName]
+	if !ok {
+		return SilenceNotFoundError{ID: id}
+	}
+
+	silence.Verification = verification
+	bsCfg.SuppressedMetrics[metricName][labelName] = silence
+	eventType := EventVerified
+	if verification == VerificationExhausted {
+		eventType = EventEscalationExhausted
+	}
+	bsCfg.RecordEvent(Event{Type: eventType, Metric: metricName, Label: labelName, Detail: detail})
+	return WriteBombSquadConfig(bsCfg, c)
+}
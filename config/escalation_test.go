@@ -0,0 +1,68 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/config"
+	promcfg "github.com/prometheus/prometheus/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEscalateSilence(t *testing.T) {
+	pc := bstesting.NewPromMemoryConfigurator(t)
+	bc := bstesting.NewMemoryConfigurator(t, []byte{})
+	s := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar", Jobs: []string{"prometheus"}}
+	rc, err := config.GenerateSilenceRelabelConfig(s, "replace")
+	require.NoError(t, err)
+	pcfg, err := config.InsertMetricRelabelConfigToJobs(rc, s.Jobs, pc)
+	require.NoError(t, err)
+	require.NoError(t, config.WritePromConfig(pcfg, pc))
+	require.NoError(t, config.StoreSilence(s, rc, config.Silence{}, bc))
+
+	rules := func(job string) []*promcfg.RelabelConfig {
+		pcfg, err := config.ReadPromConfig(pc)
+		require.NoError(t, err)
+		for _, sc := range pcfg.ScrapeConfigs {
+			if sc.JobName == job {
+				return sc.MetricRelabelConfigs
+			}
+		}
+		t.Fatalf("no job %s", job)
+		return nil
+	}
+	before := len(rules("prometheus"))
+
+	require.NoError(t, config.EscalateSilence("foo.bar", config.Escalation{To: "labeldrop", Growth: 250}, pc, bc))
+	after := rules("prometheus")
+	require.Len(t, after, before)
+	last := after[len(after)-1]
+	require.Equal(t, promcfg.RelabelLabelDrop, last.Action)
+	require.True(t, last.Regex.MatchString("bar"))
+	require.False(t, last.Regex.MatchString("bart"))
+
+	sum, err := config.GetSilence("foo.bar", bc)
+	require.NoError(t, err)
+	require.Equal(t, "labeldrop", sum.Action)
+	require.Len(t, sum.Escalations, 1)
+	require.Equal(t, "replace", sum.Escalations[0].From)
+
+	// A quarantine step leaves the rule alone
+	require.NoError(t, config.EscalateSilence("foo.bar", config.Escalation{To: config.EscalationQuarantine, Target: "instance:localhost:9090"}, pc, bc))
+	require.Len(t, rules("prometheus"), before)
+	require.NoError(t, config.SetVerification("foo.bar", config.VerificationExhausted, "still growing", bc))
+
+	// Removing the silence takes the labeldrop rule with it
+	require.NoError(t, config.RemoveSilence("foo.bar", pc, bc))
+	require.Len(t, rules("prometheus"), before-1)
+
+	events, err := config.History(0, bc)
+	require.NoError(t, err)
+	types := []string{}
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	require.Equal(t, []string{config.EventSilenced, config.EventEscalated, config.EventEscalated, config.EventEscalationExhausted, config.EventUnsilenced}, types)
+
+	require.IsType(t, config.SilenceNotFoundError{}, config.EscalateSilence("foo.bar", config.Escalation{To: "drop"}, pc, bc))
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+)
+
+// FileConfigurator implements Configurator on top of a file on disk
+type FileConfigurator struct {
+	Path string
+}
+
+// NewFileConfigurator returns a FileConfigurator
+func NewFileConfigurator(path string) *FileConfigurator {
+	return &FileConfigurator{Path: path}
+}
+
+// GetLocation implements Configurator
+func (c *FileConfigurator) GetLocation() string {
+	return c.Path
+}
+
+// Read implements Configurator. A missing file reads as empty.
+func (c *FileConfigurator) Read() ([]byte, error) {
+	b, err := ioutil.ReadFile(c.Path)
+	if os.IsNotExist(err) {
+		return []byte{}, nil
+	}
+	return b, err
+}
+
+// Write implements Configurator
+func (c *FileConfigurator) Write(b []byte) error {
+	return ioutil.WriteFile(c.Path, b, 0644)
+}
+
+// WriteIfChanged writes b through c unless c already holds exactly b. It
+// reports whether anything was written.
+func WriteIfChanged(b []byte, c Configurator) (bool, error) {
+	current, err := c.Read()
+	if err == nil && bytes.Equal(current, b) {
+		return false, nil
+	}
+
+	err = c.Write(b)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,34 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteIfChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bomb-squad")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	c := config.NewFileConfigurator(filepath.Join(dir, "rules.yaml"))
+
+	written, err := config.WriteIfChanged([]byte("groups: []\n"), c)
+	require.NoError(t, err)
+	require.True(t, written)
+
+	written, err = config.WriteIfChanged([]byte("groups: []\n"), c)
+	require.NoError(t, err)
+	require.False(t, written)
+
+	written, err = config.WriteIfChanged([]byte("groups: [{}]\n"), c)
+	require.NoError(t, err)
+	require.True(t, written)
+
+	b, err := c.Read()
+	require.NoError(t, err)
+	require.Equal(t, "groups: [{}]\n", string(b))
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// MaxHistory is how many events the Bomb Squad config keeps before dropping
+// the oldest
+const MaxHistory = 200
+
+// Event types recorded in the Bomb Squad config's history
+const (
+	EventSilenced       = "silenced"
+	EventUnsilenced     = "unsilenced"
+	EventUpdated        = "updated"
+	EventExpired        = "expired"
+	EventDriftCorrected = "drift_corrected"
+	EventQuarantined    = "quarantined"
+	EventReleased       = "released"
+	EventEscalated      = "escalated"
+	EventVerified       = "verified"
+	// EventEscalationExhausted is recorded when a silenced metric keeps
+	// growing after every step of the escalation ladder
+	EventEscalationExhausted = "escalation_exhausted"
+	EventBreakerTripped      = "breaker_tripped"
+	EventBreakerReset        = "breaker_reset"
+	// EventProposed is recorded when the patrol proposes a silence that
+	// awaits approval
+	EventProposed = "proposed"
+	EventApproved = "approved"
+	EventRejected = "rejected"
+	// EventPurged is recorded when the series of a silenced label are
+	// deleted from Prometheus' TSDB
+	EventPurged = "purged"
+	// EventBaselined is recorded when the values a silence keeps are learned
+	EventBaselined = "baselined"
+)
+
+// Event is an entry in the history of changes Bomb Squad has made
+type Event struct {
+	Time   time.Time `yaml:"time" json:"time"`
+	Type   string    `yaml:"type" json:"type"`
+	Metric string    `yaml:"metric,omitempty" json:"metric,omitempty"`
+	Label  string    `yaml:"label,omitempty" json:"label,omitempty"`
+	// Target is the ID of the quarantine the event is about, if any
+	Target string `yaml:"target,omitempty" json:"target,omitempty"`
+	Detail string `yaml:"detail,omitempty" json:"detail,omitempty"`
+}
+
+// HistoryKeeper is implemented by Configurators that can say whether the
+// Bomb Squad configs they write keep their history
+type HistoryKeeper interface {
+	KeepsHistory() bool
+}
+
+// HistoryUnavailableError is returned when asked for the history of a Bomb
+// Squad config whose Configurator doesn't keep it
+type HistoryUnavailableError struct {
+	Location string
+}
+
+func (e HistoryUnavailableError) Error() string {
+	return fmt.Sprintf("The state backend at %s doesn't keep history", e.Location)
+}
+
+// RecordEvent appends an event to the history, stamping it with the current
+// time if it has none, and trims the history to MaxHistory entries
+func (b *BombSquadConfig) RecordEvent(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	b.History = append(b.History, e)
+	if len(b.History) > MaxHistory {
+		b.History = b.History[len(b.History)-MaxHistory:]
+	}
+}
+
+// History returns the most recent limit events in the Bomb Squad config,
+// oldest first, or every event if limit is 0. A HistoryKeeper that keeps no
+// history gets a HistoryUnavailableError rather than no events.
+func History(limit int, c Configurator) ([]Event, error) {
+	if k, ok := c.(HistoryKeeper); ok && !k.KeepsHistory() {
+		return nil, HistoryUnavailableError{Location: c.GetLocation()}
+	}
+	bsCfg, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	events := bsCfg.History
+	if events == nil {
+		events = []Event{}
+	}
+	if limit > 0 && len(events) > limit {
+		events = events[len(events)-limit:]
+	}
+	return events, nil
+}
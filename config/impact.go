@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AffectedRule is a recording or alerting rule that a silence may break,
+// because it refers to the silenced metric or label
+type AffectedRule struct {
+	Group string `yaml:"group" json:"group"`
+	Name  string `yaml:"name" json:"name"`
+	// Type is recording or alerting
+	Type string `yaml:"type" json:"type"`
+	// File is the rule file the rule comes from, if known
+	File string `yaml:"file,omitempty" json:"file,omitempty"`
+	// Reason says how the rule refers to the metric or label
+	Reason string `yaml:"reason" json:"reason"`
+}
+
+func (r AffectedRule) String() string {
+	return fmt.Sprintf("%s rule %s in group %s %s", r.Type, r.Name, r.Group, r.Reason)
+}
+
+// maxReportedRules limits how many rules a RulesAffectedError names
+const maxReportedRules = 3
+
+// RulesAffectedError is returned when a silence may break recording or
+// alerting rules, and the impact policy doesn't allow that
+type RulesAffectedError struct {
+	ID    string
+	Rules []AffectedRule
+}
+
+func (e RulesAffectedError) Error() string {
+	names := []string{}
+	for i, r := range e.Rules {
+		if i == maxReportedRules {
+			names = append(names, fmt.Sprintf("and %d more", len(e.Rules)-maxReportedRules))
+			break
+		}
+		names = append(names, r.String())
+	}
+	return fmt.Sprintf("Silencing %s may break %d rules: %s. Force the silence to apply it anyway", e.ID, len(e.Rules), strings.Join(names, "; "))
+}
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Evidence is what the patrol saw when it found a label exploding
+type Evidence struct {
+	// Growth is how much the metric's card_count grew over the minute before
+	Growth float64 `yaml:"growth" json:"growth"`
+	// Series is how many series of the metric Prometheus returned
+	Series int `yaml:"series" json:"series"`
+	// DistinctValues is how many values of the label those series had
+	DistinctValues int `yaml:"distinct_values" json:"distinctValues"`
+	// SampleValues are a few of those values
+	SampleValues []string `yaml:"sample_values,omitempty" json:"sampleValues,omitempty"`
+	// Instances are the scrape targets the series came from
+	Instances  []string  `yaml:"instances,omitempty" json:"instances,omitempty"`
+	DetectedAt time.Time `yaml:"detected_at" json:"detectedAt"`
+}
+
+// PendingSilence records a silence the patrol has proposed, which is only
+// applied once approved
+type PendingSilence struct {
+	// Action is the relabel action the silence will use
+	Action string `yaml:"action,omitempty"`
+	// Jobs limits the silence to the scrape configs of these jobs. Empty
+	// means every job.
+	Jobs []string `yaml:"jobs,omitempty"`
+	// Culprit is the rollout that most likely caused the explosion, if the
+	// patrol could tell
+	Culprit    *Rollout  `yaml:"culprit,omitempty"`
+	Evidence   Evidence  `yaml:"evidence"`
+	ProposedAt time.Time `yaml:"proposed_at"`
+	// AutoApplyAt is when the patrol applies the silence without approval,
+	// if the label is still exploding by then. Nil means never.
+	AutoApplyAt *time.Time `yaml:"auto_apply_at,omitempty"`
+	// AffectedRules are the recording and alerting rules the silence may
+	// break
+	AffectedRules []AffectedRule `yaml:"affected_rules,omitempty"`
+}
+
+// ProposeSilence records a pending silence for metricName.labelName, or
+// returns a PendingExistsError if there already is one
+func ProposeSilence(metricName, labelName string, pending PendingSilence, c Configurator) error {
+	id := metricName + "." + labelName
+	bsCfg, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return err
+	}
+	if _, ok := bsCfg.Pending[id]; ok {
+		return PendingExistsError{ID: id}
+	}
+
+	if pending.ProposedAt.IsZero() {
+		pending.ProposedAt = time.Now().UTC()
+	}
+	bsCfg.Pending[id] = pending
+	bsCfg.RecordEvent(Event{Type: EventProposed, Metric: metricName, Label: labelName, Detail: pending.Action})
+	return WriteBombSquadConfig(bsCfg, c)
+}
+
+// RemovePending removes the pending silence with the given ID, recording an
+// event of the given type, EventApproved or EventRejected. detail says how.
+// Rejected silences are remembered until ForgetRejections drops them.
+func RemovePending(id, eventType, detail string, c Configurator) error {
+	metricName, labelName, err := ParseSilenceID(id)
+	if err != nil {
+		return err
+	}
+
+	bsCfg, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return err
+	}
+	if _, ok := bsCfg.Pending[id]; !ok {
+		return PendingNotFoundError{ID: id}
+	}
+
+	delete(bsCfg.Pending, id)
+	if eventType == EventRejected {
+		bsCfg.Rejected[id] = time.Now().UTC()
+	}
+	bsCfg.RecordEvent(Event{Type: eventType, Metric: metricName, Label: labelName, Detail: detail})
+	return WriteBombSquadConfig(bsCfg, c)
+}
+
+// IsRejected reports whether the silence with the given ID was rejected, and
+// not yet forgotten
+func IsRejected(id string, c Configurator) (bool, error) {
+	bsCfg, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return false, err
+	}
+	_, ok := bsCfg.Rejected[id]
+	return ok, nil
+}
+
+// ForgetRejections forgets every rejected silence whose ID isn't in keep, so
+// that it can be proposed again
+func ForgetRejections(keep map[string]bool, c Configurator) error {
+	bsCfg, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return err
+	}
+
+	forgotten := false
+	for id := range bsCfg.Rejected {
+		if !keep[id] {
+			delete(bsCfg.Rejected, id)
+			forgotten = true
+		}
+	}
+	if !forgotten {
+		return nil
+	}
+	return WriteBombSquadConfig(bsCfg, c)
+}
+
+// PendingSummary is a self-contained description of a pending silence,
+// suitable for showing to people and scripts
+type PendingSummary struct {
+	ID            string         `json:"id"`
+	Metric        string         `json:"metric"`
+	Label         string         `json:"label"`
+	Action        string         `json:"action"`
+	Jobs          []string       `json:"jobs,omitempty"`
+	Culprit       *Rollout       `json:"culprit,omitempty"`
+	Evidence      Evidence       `json:"evidence"`
+	ProposedAt    time.Time      `json:"proposedAt"`
+	AutoApplyAt   *time.Time     `json:"autoApplyAt,omitempty"`
+	AffectedRules []AffectedRule `json:"affectedRules,omitempty"`
+}
+
+// Summarize returns the PendingSummary of the pending silence for
+// metricName.labelName
+func (p PendingSilence) Summarize(metricName, labelName string) PendingSummary {
+	return PendingSummary{
+		ID:            metricName + "." + labelName,
+		Metric:        metricName,
+		Label:         labelName,
+		Action:        p.Action,
+		Jobs:          p.Jobs,
+		Culprit:       p.Culprit,
+		Evidence:      p.Evidence,
+		ProposedAt:    p.ProposedAt,
+		AutoApplyAt:   p.AutoApplyAt,
+		AffectedRules: p.AffectedRules,
+	}
+}
+
+// ListPending returns a summary of every pending silence in the Bomb Squad
+// config, sorted by ID
+func ListPending(c Configurator) ([]PendingSummary, error) {
+	bsCfg, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []PendingSummary{}
+	for id, p := range bsCfg.Pending {
+		metricName, labelName, err := ParseSilenceID(id)
+		if err != nil {
+			continue
+		}
+		out = append(out, p.Summarize(metricName, labelName))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+// GetPending returns the summary of the pending silence with the given ID, or
+// a PendingNotFoundError
+func GetPending(id string, c Configurator) (PendingSummary, error) {
+	metricName, labelName, err := ParseSilenceID(id)
+	if err != nil {
+		return PendingSummary{}, err
+	}
+
+	bsCfg, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return PendingSummary{}, err
+	}
+
+	p, ok := bsCfg.Pending[id]
+	if !ok {
+		return PendingSummary{}, PendingNotFoundError{ID: id}
+	}
+	return p.Summarize(metricName, labelName), nil
+}
+
+// PendingNotFoundError is returned when there is no pending silence with the
+// given ID
+type PendingNotFoundError struct {
+	ID string
+}
+
+func (e PendingNotFoundError) Error() string {
+	return fmt.Sprintf("No pending silence found for %s", e.ID)
+}
+
+// PendingExistsError is returned when proposing a silence that is already
+// pending
+type PendingExistsError struct {
+	ID string
+}
+
+func (e PendingExistsError) Error() string {
+	return fmt.Sprintf("A silence of %s is already pending", e.ID)
+}
@@ -0,0 +1,54 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPendingSilences(t *testing.T) {
+	bc := bstesting.NewMemoryConfigurator(t, []byte{})
+
+	pending := config.PendingSilence{Action: "replace", Evidence: config.Evidence{Growth: 500, Series: 40, DistinctValues: 38, SampleValues: []string{"1", "2"}}}
+	require.NoError(t, config.ProposeSilence("foo", "bar", pending, bc))
+	require.IsType(t, config.PendingExistsError{}, config.ProposeSilence("foo", "bar", pending, bc))
+	require.NoError(t, config.ProposeSilence("foo", "baz", pending, bc))
+
+	all, err := config.ListPending(bc)
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	require.Equal(t, "foo.bar", all[0].ID)
+	require.Equal(t, pending.Evidence, all[0].Evidence)
+	require.False(t, all[0].ProposedAt.IsZero())
+
+	require.NoError(t, config.RemovePending("foo.bar", config.EventApproved, "approved by hand", bc))
+	require.NoError(t, config.RemovePending("foo.baz", config.EventRejected, "rejected by hand", bc))
+	_, err = config.GetPending("foo.bar", bc)
+	require.IsType(t, config.PendingNotFoundError{}, err)
+
+	// Only the rejected silence is remembered, until its label stops exploding
+	rejected, err := config.IsRejected("foo.bar", bc)
+	require.NoError(t, err)
+	require.False(t, rejected)
+	rejected, err = config.IsRejected("foo.baz", bc)
+	require.NoError(t, err)
+	require.True(t, rejected)
+	require.NoError(t, config.ForgetRejections(map[string]bool{"foo.baz": true}, bc))
+	rejected, err = config.IsRejected("foo.baz", bc)
+	require.NoError(t, err)
+	require.True(t, rejected)
+	require.NoError(t, config.ForgetRejections(map[string]bool{}, bc))
+	rejected, err = config.IsRejected("foo.baz", bc)
+	require.NoError(t, err)
+	require.False(t, rejected)
+
+	events, err := config.History(0, bc)
+	require.NoError(t, err)
+	types := []string{}
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	require.Equal(t, []string{config.EventProposed, config.EventProposed, config.EventApproved, config.EventRejected}, types)
+}
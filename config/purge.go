@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Purge records the series of a silenced label being deleted from
+// Prometheus' TSDB
+type Purge struct {
+	Time time.Time `yaml:"time" json:"time"`
+	// Start and End bound the samples that were deleted
+	Start time.Time `yaml:"start" json:"start"`
+	End   time.Time `yaml:"end" json:"end"`
+	// Matcher is the series selector that was deleted
+	Matcher string `yaml:"matcher" json:"matcher"`
+	// Snapshot is the name of the TSDB snapshot taken first, if any
+	Snapshot string `yaml:"snapshot,omitempty" json:"snapshot,omitempty"`
+}
+
+func (p Purge) String() string {
+	s := fmt.Sprintf("%s from %s to %s", p.Matcher, p.Start.Format(time.RFC3339), p.End.Format(time.RFC3339))
+	if p.Snapshot != "" {
+		s += ", after snapshot " + p.Snapshot
+	}
+	return s
+}
+
+// PurgeRequest asks for the series of a silenced label to be purged
+type PurgeRequest struct {
+	// Confirm must be the ID of the silence, to show that the caller knows
+	// which series are about to be deleted
+	Confirm string `json:"confirm"`
+}
+
+// PurgeNotAllowedError is returned when asked to purge series while the purge
+// policy doesn't allow it
+type PurgeNotAllowedError struct {
+	Reason string
+}
+
+func (e PurgeNotAllowedError) Error() string {
+	return e.Reason
+}
+
+// RecordPurge records p against the silence with the given ID
+func RecordPurge(id string, p Purge, c Configurator) error {
+	metricName, labelName, err := ParseSilenceID(id)
+	if err != nil {
+		return err
+	}
+
+	bsCfg, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return err
+	}
+	silence, ok := bsCfg.SuppressedMetrics[metricName][labelName]
+	if !ok {
+		return SilenceNotFoundError{ID: id}
+	}
+
+	if p.Time.IsZero() {
+		p.Time = time.Now().UTC()
+	}
+	silence.Purges = append(silence.Purges, p)
+	bsCfg.SuppressedMetrics[metricName][labelName] = silence
+	bsCfg.RecordEvent(Event{Type: EventPurged, Metric: metricName, Label: labelName, Detail: p.String()})
+	return WriteBombSquadConfig(bsCfg, c)
+}
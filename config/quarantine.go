@@ -0,0 +1,505 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	promcfg "github.com/prometheus/prometheus/config"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// QuarantineReplacement is the marker carried by the target relabelling rules
+// Bomb Squad generates to quarantine targets
+const QuarantineReplacement = "bs_quarantine"
+
+// DefaultQuarantineTTL is how long a quarantine lasts when no TTL is given.
+// Dropping a whole target is drastic, so quarantines expire unless asked not
+// to.
+const DefaultQuarantineTTL = model.Duration(time.Hour)
+
+// Quarantine modes. drop removes the target from its scrape jobs with a
+// relabel_configs rule. sample_limit caps the number of samples per scrape
+// of its jobs instead; Prometheus applies it to every target of a job, not
+// just the quarantined one.
+const (
+	QuarantineDrop        = "drop"
+	QuarantineSampleLimit = "sample_limit"
+)
+
+// QuarantineModes are the ways a target can be quarantined
+var QuarantineModes = []string{QuarantineDrop, QuarantineSampleLimit}
+
+// Kinds of target a quarantine can name. A quarantine's ID is its kind and
+// target joined by a colon, such as instance:10.0.0.1:8080 or pod:shop/api-0.
+const (
+	TargetInstance = "instance"
+	TargetPod      = "pod"
+)
+
+// Quarantine records a scrape target that Bomb Squad has cut off, because it
+// was producing series faster than silencing its labels could keep up with
+type Quarantine struct {
+	// Mode is one of QuarantineModes
+	Mode string `yaml:"mode"`
+	// SampleLimit is the limit set on the target's jobs in sample_limit mode
+	SampleLimit uint `yaml:"sample_limit,omitempty"`
+	// RelabelConfig is the base64 encoded drop rule in drop mode
+	RelabelConfig string `yaml:"relabel_config,omitempty"`
+	// TTL is how long the quarantine lasts once applied. Zero means until it
+	// is removed by hand.
+	TTL model.Duration `yaml:"ttl,omitempty"`
+	// Jobs limits the quarantine to the scrape configs of these jobs. Empty
+	// means every job.
+	Jobs []string `yaml:"jobs,omitempty"`
+	// AppliedJobs are the scrape jobs the quarantine changed
+	AppliedJobs []string `yaml:"applied_jobs,omitempty"`
+	// PreviousSampleLimits holds the sample_limit of each job before a
+	// sample_limit quarantine changed it, to be restored on removal
+	PreviousSampleLimits map[string]uint `yaml:"previous_sample_limits,omitempty"`
+	AppliedAt            time.Time       `yaml:"applied_at"`
+	// Manual is set for quarantines requested by hand rather than by the patrol
+	Manual bool `yaml:"manual,omitempty"`
+	// Reason says why the target was quarantined
+	Reason string `yaml:"reason,omitempty"`
+}
+
+// Expired reports whether the quarantine has outlived its TTL at time now
+func (q Quarantine) Expired(now time.Time) bool {
+	if q.TTL == 0 || q.AppliedAt.IsZero() {
+		return false
+	}
+	return now.After(q.AppliedAt.Add(time.Duration(q.TTL)))
+}
+
+// ParseQuarantineID splits a quarantine ID into the kind of target it names
+// and the target itself
+func ParseQuarantineID(id string) (string, string, error) {
+	i := strings.Index(id, ":")
+	if i < 0 {
+		return "", "", fmt.Errorf("Invalid quarantine '%s', expected instance:<address> or pod:<namespace>/<name>", id)
+	}
+	kind, target := id[:i], id[i+1:]
+	switch kind {
+	case TargetInstance:
+		if target != "" {
+			return kind, target, nil
+		}
+	case TargetPod:
+		parts := strings.Split(target, "/")
+		if len(parts) == 2 && parts[0] != "" && parts[1] != "" {
+			return kind, target, nil
+		}
+	}
+	return "", "", fmt.Errorf("Invalid quarantine '%s', expected instance:<address> or pod:<namespace>/<name>", id)
+}
+
+// QuarantineRule returns the target relabelling rule that drops the target
+// with the given ID. Instances are matched on the instance label, as the rule
+// goes after every other target relabelling rule, and on __address__ for
+// targets without one, as Prometheus sets the instance label from it after
+// relabelling. Pods are matched on the Kubernetes service discovery meta
+// labels, so pod quarantines only affect jobs using it.
+func QuarantineRule(id string) (promcfg.RelabelConfig, error) {
+	kind, target, err := ParseQuarantineID(id)
+	if err != nil {
+		return promcfg.RelabelConfig{}, err
+	}
+
+	rc := promcfg.RelabelConfig{
+		Replacement: QuarantineReplacement,
+		Action:      promcfg.RelabelDrop,
+	}
+	var original string
+	switch kind {
+	case TargetInstance:
+		rc.SourceLabels = model.LabelNames{"__address__", "instance"}
+		original = fmt.Sprintf("%s;|.*;%s", regexp.QuoteMeta(target), regexp.QuoteMeta(target))
+	case TargetPod:
+		rc.SourceLabels = model.LabelNames{"__meta_kubernetes_namespace", "__meta_kubernetes_pod_name"}
+		original = regexp.QuoteMeta(strings.Replace(target, "/", ";", 1))
+	}
+	rc.Regex, err = promcfg.NewRegexp(original)
+	if err != nil {
+		return promcfg.RelabelConfig{}, fmt.Errorf("Couldn't create promcfg.Regexp from '%s': %s", original, err)
+	}
+	return rc, nil
+}
+
+// findTargetRelabelConfig returns the index of the first target relabel config
+// in scrapeConfig that is semantically equal to rc, or -1 if there is none
+func findTargetRelabelConfig(rc promcfg.RelabelConfig, scrapeConfig promcfg.ScrapeConfig) int {
+	for i, relabelConfig := range scrapeConfig.RelabelConfigs {
+		if RelabelConfigsEqual(*relabelConfig, rc) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ApplyQuarantine quarantines the target with the given ID in the scrape
+// configs of q.Jobs, or of every job if there are none, and records it in the
+// Bomb Squad config. The Bomb Squad config is written first, so that a
+// quarantine that can't be recorded is never applied, and the record is
+// removed again if the Prometheus config can't be written.
+func ApplyQuarantine(id string, q Quarantine, pc, bc Configurator) error {
+	bsCfg, err := ReadBombSquadConfig(bc)
+	if err != nil {
+		return err
+	}
+	if _, ok := bsCfg.Quarantines[id]; ok {
+		return QuarantineExistsError{ID: id}
+	}
+
+	promConfig, err := ReadPromConfig(pc)
+	if err != nil {
+		return err
+	}
+
+	q.AppliedJobs = nil
+	switch q.Mode {
+	case QuarantineDrop:
+		rc, err := QuarantineRule(id)
+		if err != nil {
+			return err
+		}
+		q.RelabelConfig = encode(rc)
+		for _, scrapeConfig := range promConfig.ScrapeConfigs {
+			if !jobSelected(q.Jobs, scrapeConfig.JobName) {
+				continue
+			}
+			if findTargetRelabelConfig(rc, *scrapeConfig) == -1 {
+				rc := rc
+				scrapeConfig.RelabelConfigs = append(scrapeConfig.RelabelConfigs, &rc)
+			}
+			q.AppliedJobs = append(q.AppliedJobs, scrapeConfig.JobName)
+		}
+
+	case QuarantineSampleLimit:
+		q.PreviousSampleLimits = map[string]uint{}
+		for _, scrapeConfig := range promConfig.ScrapeConfigs {
+			if !jobSelected(q.Jobs, scrapeConfig.JobName) {
+				continue
+			}
+			// Another quarantine may have lowered the limit already, in which
+			// case the one from before it is what to restore
+			previous, ok := previousSampleLimit(bsCfg, scrapeConfig.JobName)
+			if !ok {
+				previous = scrapeConfig.SampleLimit
+			}
+			q.PreviousSampleLimits[scrapeConfig.JobName] = previous
+			if scrapeConfig.SampleLimit == 0 || scrapeConfig.SampleLimit > q.SampleLimit {
+				scrapeConfig.SampleLimit = q.SampleLimit
+			}
+			q.AppliedJobs = append(q.AppliedJobs, scrapeConfig.JobName)
+		}
+
+	default:
+		return InvalidSilenceError{Reason: fmt.Sprintf("unsupported quarantine mode '%s', expected one of %s", q.Mode, strings.Join(QuarantineModes, ", "))}
+	}
+	if len(q.AppliedJobs) == 0 {
+		return InvalidSilenceError{Reason: fmt.Sprintf("no scrape config to quarantine %s in", id)}
+	}
+
+	q.AppliedAt = time.Now().UTC()
+	bsCfg.Quarantines[id] = q
+	detail := q.Mode
+	if q.Reason != "" {
+		detail += ": " + q.Reason
+	}
+	bsCfg.RecordEvent(Event{Type: EventQuarantined, Target: id, Detail: detail})
+
+	err = WriteBombSquadConfig(bsCfg, bc)
+	if err != nil {
+		return err
+	}
+	err = WritePromConfig(promConfig, pc)
+	if err != nil {
+		// Take the record back out, so that it doesn't claim a quarantine
+		// that isn't in place
+		delete(bsCfg.Quarantines, id)
+		bsCfg.History = bsCfg.History[:len(bsCfg.History)-1]
+		if werr := WriteBombSquadConfig(bsCfg, bc); werr != nil {
+			log.Printf("Couldn't remove the record of quarantine %s, which wasn't applied: %s\n", id, werr)
+		}
+		return err
+	}
+	return nil
+}
+
+// previousSampleLimit returns the sample_limit job had before any of the
+// quarantines in bsCfg changed it
+func previousSampleLimit(bsCfg BombSquadConfig, job string) (uint, bool) {
+	for _, q := range bsCfg.Quarantines {
+		if previous, ok := q.PreviousSampleLimits[job]; ok {
+			return previous, true
+		}
+	}
+	return 0, false
+}
+
+// RemoveQuarantine lifts the quarantine with the given ID, both from the
+// Prometheus config and from the Bomb Squad config
+func RemoveQuarantine(id string, pc, bc Configurator) error {
+	return removeQuarantines([]string{id}, EventReleased, pc, bc)
+}
+
+// ExpireQuarantines lifts every quarantine whose TTL has run out by now. The
+// IDs of the quarantines lifted are returned.
+func ExpireQuarantines(now time.Time, pc, bc Configurator) ([]string, error) {
+	bsCfg, err := ReadBombSquadConfig(bc)
+	if err != nil {
+		return nil, err
+	}
+
+	expired := []string{}
+	for id, q := range bsCfg.Quarantines {
+		if q.Expired(now) {
+			expired = append(expired, id)
+		}
+	}
+	if len(expired) == 0 {
+		return expired, nil
+	}
+	sort.Strings(expired)
+	return expired, removeQuarantines(expired, EventExpired, pc, bc)
+}
+
+func removeQuarantines(ids []string, eventType string, pc, bc Configurator) error {
+	bsCfg, err := ReadBombSquadConfig(bc)
+	if err != nil {
+		return err
+	}
+	promConfig, err := ReadPromConfig(pc)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		q, ok := bsCfg.Quarantines[id]
+		if !ok {
+			return QuarantineNotFoundError{ID: id}
+		}
+		delete(bsCfg.Quarantines, id)
+
+		switch q.Mode {
+		case QuarantineDrop:
+			rc, err := QuarantineRule(id)
+			if err != nil {
+				return err
+			}
+			rules := []promcfg.RelabelConfig{rc}
+			// Quarantines applied by older versions carry a different rule
+			if stored, err := decode(q.RelabelConfig); err == nil && q.RelabelConfig != "" && !RelabelConfigsEqual(stored, rc) {
+				rules = append(rules, stored)
+			}
+			for _, scrapeConfig := range promConfig.ScrapeConfigs {
+				for _, rc := range rules {
+					for i := findTargetRelabelConfig(rc, *scrapeConfig); i >= 0; i = findTargetRelabelConfig(rc, *scrapeConfig) {
+						scrapeConfig.RelabelConfigs = DeleteRelabelConfigFromArray(scrapeConfig.RelabelConfigs, i)
+						log.Printf("Deleted quarantine rule for %s from ScrapeConfig %s\n", id, scrapeConfig.JobName)
+					}
+				}
+			}
+
+		case QuarantineSampleLimit:
+			for _, scrapeConfig := range promConfig.ScrapeConfigs {
+				previous, ok := q.PreviousSampleLimits[scrapeConfig.JobName]
+				if !ok {
+					continue
+				}
+				scrapeConfig.SampleLimit = sampleLimitAfter(bsCfg, scrapeConfig.JobName, previous)
+				log.Printf("Set sample_limit of ScrapeConfig %s back to %d\n", scrapeConfig.JobName, scrapeConfig.SampleLimit)
+			}
+		}
+		bsCfg.RecordEvent(Event{Type: eventType, Target: id})
+	}
+
+	err = WriteBombSquadConfig(bsCfg, bc)
+	if err != nil {
+		return err
+	}
+	return WritePromConfig(promConfig, pc)
+}
+
+// sampleLimitAfter returns the sample_limit job should have given the
+// quarantines left in bsCfg: the lowest of theirs, or previous if none of them
+// cap job
+func sampleLimitAfter(bsCfg BombSquadConfig, job string, previous uint) uint {
+	limit := previous
+	for _, q := range bsCfg.Quarantines {
+		if _, ok := q.PreviousSampleLimits[job]; !ok {
+			continue
+		}
+		if limit == 0 || q.SampleLimit < limit {
+			limit = q.SampleLimit
+		}
+	}
+	return limit
+}
+
+// QuarantineSummary is a self-contained description of a quarantine, suitable
+// for showing to people and scripts
+type QuarantineSummary struct {
+	ID          string     `json:"id"`
+	Kind        string     `json:"kind"`
+	Target      string     `json:"target"`
+	Mode        string     `json:"mode"`
+	SampleLimit uint       `json:"sampleLimit,omitempty"`
+	Manual      bool       `json:"manual"`
+	Reason      string     `json:"reason,omitempty"`
+	TTL         string     `json:"ttl,omitempty"`
+	Jobs        []string   `json:"jobs,omitempty"`
+	AppliedJobs []string   `json:"appliedJobs,omitempty"`
+	AppliedAt   *time.Time `json:"appliedAt,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	// RelabelConfig is the drop rule, as it appears in the Prometheus config
+	RelabelConfig string `json:"relabelConfig,omitempty"`
+}
+
+// Summarize returns the QuarantineSummary of the quarantine with the given ID
+func (q Quarantine) Summarize(id string) QuarantineSummary {
+	kind, target, _ := ParseQuarantineID(id)
+	sum := QuarantineSummary{
+		ID:          id,
+		Kind:        kind,
+		Target:      target,
+		Mode:        q.Mode,
+		SampleLimit: q.SampleLimit,
+		Manual:      q.Manual,
+		Reason:      q.Reason,
+		Jobs:        q.Jobs,
+		AppliedJobs: q.AppliedJobs,
+	}
+	if q.TTL != 0 {
+		sum.TTL = q.TTL.String()
+	}
+	if !q.AppliedAt.IsZero() {
+		appliedAt := q.AppliedAt
+		sum.AppliedAt = &appliedAt
+		if q.TTL != 0 {
+			expiresAt := q.AppliedAt.Add(time.Duration(q.TTL))
+			sum.ExpiresAt = &expiresAt
+		}
+	}
+	if q.RelabelConfig != "" {
+		if rc, err := decode(q.RelabelConfig); err == nil {
+			if b, err := yaml.Marshal(rc); err == nil {
+				sum.RelabelConfig = string(b)
+			}
+		}
+	}
+	return sum
+}
+
+// ListQuarantines returns a summary of every quarantine in the Bomb Squad
+// config, sorted by ID
+func ListQuarantines(c Configurator) ([]QuarantineSummary, error) {
+	bsCfg, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []QuarantineSummary{}
+	for id, q := range bsCfg.Quarantines {
+		out = append(out, q.Summarize(id))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+// GetQuarantine returns the summary of the quarantine with the given ID, or a
+// QuarantineNotFoundError
+func GetQuarantine(id string, c Configurator) (QuarantineSummary, error) {
+	bsCfg, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return QuarantineSummary{}, err
+	}
+
+	q, ok := bsCfg.Quarantines[id]
+	if !ok {
+		return QuarantineSummary{}, QuarantineNotFoundError{ID: id}
+	}
+	return q.Summarize(id), nil
+}
+
+// QuarantineNotFoundError is returned when there is no quarantine with the
+// given ID
+type QuarantineNotFoundError struct {
+	ID string
+}
+
+func (e QuarantineNotFoundError) Error() string {
+	return fmt.Sprintf("No quarantine found for %s", e.ID)
+}
+
+// QuarantineExistsError is returned when quarantining a target that is
+// already quarantined
+type QuarantineExistsError struct {
+	ID string
+}
+
+func (e QuarantineExistsError) Error() string {
+	return fmt.Sprintf("%s is already quarantined", e.ID)
+}
+
+// QuarantineRequest asks for a target to be quarantined by hand
+type QuarantineRequest struct {
+	// Target is the ID of the quarantine, such as instance:10.0.0.1:8080 or
+	// pod:shop/api-0
+	Target string `json:"target"`
+	// Mode is one of QuarantineModes. Empty means drop.
+	Mode string `json:"mode,omitempty"`
+	// SampleLimit is required in sample_limit mode
+	SampleLimit uint `json:"sampleLimit,omitempty"`
+	// TTL is a Prometheus duration, such as 6h. Empty means
+	// DefaultQuarantineTTL, and 0s no expiry.
+	TTL string `json:"ttl,omitempty"`
+	// Jobs limits the quarantine to these scrape jobs. Empty means every job.
+	Jobs   []string `json:"jobs,omitempty"`
+	Reason string   `json:"reason,omitempty"`
+}
+
+// Parse validates the request and returns the quarantine to apply, or an
+// InvalidSilenceError
+func (r QuarantineRequest) Parse() (Quarantine, error) {
+	if _, _, err := ParseQuarantineID(r.Target); err != nil {
+		return Quarantine{}, InvalidSilenceError{Reason: err.Error()}
+	}
+
+	q := Quarantine{Mode: r.Mode, SampleLimit: r.SampleLimit, TTL: DefaultQuarantineTTL, Manual: true, Reason: r.Reason}
+	if q.Mode == "" {
+		q.Mode = QuarantineDrop
+	}
+	switch q.Mode {
+	case QuarantineDrop:
+		if q.SampleLimit != 0 {
+			return Quarantine{}, InvalidSilenceError{Reason: "sampleLimit only applies to the sample_limit mode"}
+		}
+	case QuarantineSampleLimit:
+		if q.SampleLimit == 0 {
+			return Quarantine{}, InvalidSilenceError{Reason: "the sample_limit mode needs a sampleLimit"}
+		}
+	default:
+		return Quarantine{}, InvalidSilenceError{Reason: fmt.Sprintf("unsupported quarantine mode '%s', expected one of %s", q.Mode, strings.Join(QuarantineModes, ", "))}
+	}
+
+	for _, job := range r.Jobs {
+		if job = strings.TrimSpace(job); job != "" {
+			q.Jobs = append(q.Jobs, job)
+		}
+	}
+
+	if r.TTL != "" {
+		ttl, err := model.ParseDuration(r.TTL)
+		if err != nil {
+			return Quarantine{}, InvalidSilenceError{Reason: fmt.Sprintf("invalid ttl '%s'", r.TTL)}
+		}
+		q.TTL = ttl
+	}
+	return q, nil
+}
@@ -0,0 +1,134 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/prometheus/common/model"
+	promcfg "github.com/prometheus/prometheus/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuarantineDrop(t *testing.T) {
+	pc := bstesting.NewPromMemoryConfigurator(t)
+	bc := bstesting.NewMemoryConfigurator(t, []byte{})
+
+	q, err := config.QuarantineRequest{Target: "pod:shop/api-0", Jobs: []string{"ft-kubernetes-pods"}}.Parse()
+	require.NoError(t, err)
+	require.Equal(t, config.DefaultQuarantineTTL, q.TTL)
+	require.NoError(t, config.ApplyQuarantine("pod:shop/api-0", q, pc, bc))
+	require.IsType(t, config.QuarantineExistsError{}, config.ApplyQuarantine("pod:shop/api-0", q, pc, bc))
+
+	pcfg, err := config.ReadPromConfig(pc)
+	require.NoError(t, err)
+	for _, sc := range pcfg.ScrapeConfigs {
+		if sc.JobName != "ft-kubernetes-pods" {
+			for _, rc := range sc.RelabelConfigs {
+				require.NotEqual(t, config.QuarantineReplacement, rc.Replacement)
+			}
+			continue
+		}
+		last := sc.RelabelConfigs[len(sc.RelabelConfigs)-1]
+		require.Equal(t, promcfg.RelabelDrop, last.Action)
+		require.Equal(t, model.LabelNames{"__meta_kubernetes_namespace", "__meta_kubernetes_pod_name"}, last.SourceLabels)
+		require.True(t, last.Regex.MatchString("shop;api-0"))
+		require.False(t, last.Regex.MatchString("shop;api-01"))
+	}
+
+	sum, err := config.GetQuarantine("pod:shop/api-0", bc)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ft-kubernetes-pods"}, sum.AppliedJobs)
+	require.Equal(t, "1h", sum.TTL)
+	require.Contains(t, sum.RelabelConfig, "bs_quarantine")
+
+	expired, err := config.ExpireQuarantines(time.Now(), pc, bc)
+	require.NoError(t, err)
+	require.Len(t, expired, 0)
+	expired, err = config.ExpireQuarantines(time.Now().Add(2*time.Hour), pc, bc)
+	require.NoError(t, err)
+	require.Equal(t, []string{"pod:shop/api-0"}, expired)
+
+	after, err := config.ReadPromConfig(pc)
+	require.NoError(t, err)
+	for _, sc := range after.ScrapeConfigs {
+		for _, rc := range sc.RelabelConfigs {
+			require.NotEqual(t, config.QuarantineReplacement, rc.Replacement)
+		}
+	}
+	quarantines, err := config.ListQuarantines(bc)
+	require.NoError(t, err)
+	require.Len(t, quarantines, 0)
+
+	events, err := config.History(0, bc)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, config.EventQuarantined, events[0].Type)
+	require.Equal(t, config.EventExpired, events[1].Type)
+	require.Equal(t, "pod:shop/api-0", events[1].Target)
+}
+
+func TestQuarantineSampleLimit(t *testing.T) {
+	pc := bstesting.NewPromMemoryConfigurator(t)
+	bc := bstesting.NewMemoryConfigurator(t, []byte{})
+	sampleLimit := func(job string) uint {
+		pcfg, err := config.ReadPromConfig(pc)
+		require.NoError(t, err)
+		for _, sc := range pcfg.ScrapeConfigs {
+			if sc.JobName == job {
+				return sc.SampleLimit
+			}
+		}
+		t.Fatalf("no job %s", job)
+		return 0
+	}
+
+	first := config.Quarantine{Mode: config.QuarantineSampleLimit, SampleLimit: 5000, Jobs: []string{"prometheus"}}
+	require.NoError(t, config.ApplyQuarantine("instance:localhost:9090", first, pc, bc))
+	require.Equal(t, uint(5000), sampleLimit("prometheus"))
+	require.Equal(t, uint(0), sampleLimit("bomb-squad"))
+
+	second := config.Quarantine{Mode: config.QuarantineSampleLimit, SampleLimit: 1000, Jobs: []string{"prometheus"}}
+	require.NoError(t, config.ApplyQuarantine("instance:localhost:9091", second, pc, bc))
+	require.Equal(t, uint(1000), sampleLimit("prometheus"))
+
+	// The first quarantine's limit holds until the second is lifted too, and
+	// then the job has no limit, as before either
+	require.NoError(t, config.RemoveQuarantine("instance:localhost:9091", pc, bc))
+	require.Equal(t, uint(5000), sampleLimit("prometheus"))
+	require.NoError(t, config.RemoveQuarantine("instance:localhost:9090", pc, bc))
+	require.Equal(t, uint(0), sampleLimit("prometheus"))
+
+	require.IsType(t, config.QuarantineNotFoundError{}, config.RemoveQuarantine("instance:localhost:9090", pc, bc))
+}
+
+func TestQuarantineRuleInstance(t *testing.T) {
+	rc, err := config.QuarantineRule("instance:10.0.0.1:8080")
+	require.NoError(t, err)
+	require.Equal(t, model.LabelNames{"__address__", "instance"}, rc.SourceLabels)
+	// Targets are matched on their instance label, or on their address if
+	// they have none
+	require.True(t, rc.Regex.MatchString("10.0.0.1:8080;"))
+	require.True(t, rc.Regex.MatchString("10.0.0.2:8080;10.0.0.1:8080"))
+	require.False(t, rc.Regex.MatchString("10.0.0.1:8080;api-0"))
+	require.False(t, rc.Regex.MatchString("10.0.0.1:80801;"))
+}
+
+func TestQuarantineRequestValidation(t *testing.T) {
+	for _, req := range []config.QuarantineRequest{
+		{Target: "localhost:9090"},
+		{Target: "pod:api-0"},
+		{Target: "instance:localhost:9090", Mode: "keep"},
+		{Target: "instance:localhost:9090", Mode: config.QuarantineSampleLimit},
+		{Target: "instance:localhost:9090", SampleLimit: 10},
+		{Target: "instance:localhost:9090", TTL: "soon"},
+	} {
+		_, err := req.Parse()
+		require.IsType(t, config.InvalidSilenceError{}, err, "%+v", req)
+	}
+
+	q, err := config.QuarantineRequest{Target: "instance:localhost:9090", TTL: "0s"}.Parse()
+	require.NoError(t, err)
+	require.Equal(t, model.Duration(0), q.TTL)
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	promcfg "github.com/prometheus/prometheus/config"
+)
+
+// AllRemoteWrites stands for every remote_write endpoint of the Prometheus
+// config in a silence's RemoteWrite
+const AllRemoteWrites = "*"
+
+// RemoteWriteURL returns the URL identifying the remote_write endpoint rw
+func RemoteWriteURL(rw promcfg.RemoteWriteConfig) string {
+	if rw.URL == nil || rw.URL.URL == nil {
+		return ""
+	}
+	return rw.URL.String()
+}
+
+// remoteWriteSelected reports whether the remote_write endpoint with the given
+// URL is one of urls. Unlike jobs, no URLs at all means no endpoint.
+func remoteWriteSelected(urls []string, u string) bool {
+	for _, s := range urls {
+		if s == AllRemoteWrites || s == u {
+			return true
+		}
+	}
+	return false
+}
+
+// FindWriteRelabelConfig returns the index of the first write relabel config
+// of rw that is semantically equal to rc, or -1 if there is none
+func FindWriteRelabelConfig(rc promcfg.RelabelConfig, rw promcfg.RemoteWriteConfig) int {
+	for i, relabelConfig := range rw.WriteRelabelConfigs {
+		if RelabelConfigsEqual(*relabelConfig, rc) {
+			return i
+		}
+	}
+	return -1
+}
+
+// InsertWriteRelabelConfig adds rc to the write_relabel_configs of the
+// remote_write endpoints of promConfig with the given URLs, unless it's there
+// already
+func InsertWriteRelabelConfig(rc promcfg.RelabelConfig, urls []string, promConfig *promcfg.Config) {
+	for _, rw := range promConfig.RemoteWriteConfigs {
+		if !remoteWriteSelected(urls, RemoteWriteURL(*rw)) || FindWriteRelabelConfig(rc, *rw) >= 0 {
+			continue
+		}
+		rc := rc
+		rw.WriteRelabelConfigs = append(rw.WriteRelabelConfigs, &rc)
+	}
+}
+
+// deleteWriteRelabelConfig removes rc from the write_relabel_configs of every
+// remote_write endpoint of promConfig that none of sharers applies it to, and
+// returns how many copies it removed
+func deleteWriteRelabelConfig(rc promcfg.RelabelConfig, sharers []Silence, promConfig *promcfg.Config) int {
+	deleted := 0
+	for _, rw := range promConfig.RemoteWriteConfigs {
+		if neededInRemoteWrite(sharers, RemoteWriteURL(*rw)) {
+			continue
+		}
+		for i := FindWriteRelabelConfig(rc, *rw); i >= 0; i = FindWriteRelabelConfig(rc, *rw) {
+			rw.WriteRelabelConfigs = DeleteRelabelConfigFromArray(rw.WriteRelabelConfigs, i)
+			deleted++
+		}
+	}
+	return deleted
+}
@@ -0,0 +1,57 @@
+package config_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteWriteSilences(t *testing.T) {
+	pc := bstesting.NewPromMemoryConfigurator(t)
+	pc.Data = bytes.Replace(pc.Data, []byte("remote_write: []"), []byte("remote_write:\n- url: http://a/write\n- url: http://b/write"), 1)
+	bc := bstesting.NewMemoryConfigurator(t, []byte{})
+
+	hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar"}
+	mrc, err := config.GenerateMetricRelabelConfig(hcs)
+	require.NoError(t, err)
+	require.NoError(t, config.StoreSilence(hcs, mrc, config.Silence{RemoteWrite: []string{"http://b/write"}, RemoteWriteOnly: true}, bc))
+
+	// Reconciling applies the rule to the remote_write endpoint alone
+	d, err := config.Reconcile(pc, bc)
+	require.NoError(t, err)
+	require.Len(t, d.Missing, 1)
+	require.Equal(t, "http://b/write", d.Missing[0].RemoteWrite)
+	require.Empty(t, d.Missing[0].JobName)
+	pcfg, err := config.ReadPromConfig(pc)
+	require.NoError(t, err)
+	for _, sc := range pcfg.ScrapeConfigs {
+		require.Equal(t, -1, config.FindRelabelConfigInScrapeConfig(mrc, *sc))
+	}
+	require.Equal(t, -1, config.FindWriteRelabelConfig(mrc, *pcfg.RemoteWriteConfigs[0]))
+	require.Equal(t, 0, config.FindWriteRelabelConfig(mrc, *pcfg.RemoteWriteConfigs[1]))
+
+	s, err := config.GetSilence("foo.bar", bc)
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://b/write"}, s.AppliedRemoteWrite)
+	require.Empty(t, s.AppliedJobs)
+
+	// Removing the silence takes its rule out of every endpoint, including
+	// those it was copied into by hand
+	config.InsertWriteRelabelConfig(mrc, []string{config.AllRemoteWrites}, &pcfg)
+	require.NoError(t, config.WritePromConfig(pcfg, pc))
+	require.NoError(t, config.RemoveSilence("foo.bar", pc, bc))
+	pcfg, err = config.ReadPromConfig(pc)
+	require.NoError(t, err)
+	for _, rw := range pcfg.RemoteWriteConfigs {
+		require.Len(t, rw.WriteRelabelConfigs, 0)
+	}
+	// labeldrop would strip the label from every metric forwarded
+	_, _, err = config.SilenceRequest{Metric: "foo", Label: "bar", Action: "labeldrop", RemoteWrite: []string{config.AllRemoteWrites}}.Parse()
+	require.IsType(t, config.InvalidSilenceError{}, err)
+	require.NoError(t, config.StoreSilence(hcs, mrc, config.Silence{RemoteWrite: []string{config.AllRemoteWrites}}, bc))
+	err = config.EscalateSilence("foo.bar", config.Escalation{To: "labeldrop"}, pc, bc)
+	require.IsType(t, config.InvalidSilenceError{}, err)
+}
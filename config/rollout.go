@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Rollout is a revision of a Deployment or StatefulSet, recorded against a
+// silence as the likely cause of the explosion
+type Rollout struct {
+	Kind      string `yaml:"kind" json:"kind"`
+	Namespace string `yaml:"namespace" json:"namespace"`
+	Name      string `yaml:"name" json:"name"`
+	Revision  int64  `yaml:"revision" json:"revision"`
+	// CreatedAt is when the revision's ReplicaSet or ControllerRevision was
+	// created
+	CreatedAt time.Time `yaml:"created_at" json:"createdAt"`
+	Images    []string  `yaml:"images,omitempty" json:"images,omitempty"`
+	// ChangedImages are the images the revision brought in, compared with the
+	// revision before it
+	ChangedImages []string `yaml:"changed_images,omitempty" json:"changedImages,omitempty"`
+	// ExplosionStartedAt is when the metric's card_count started growing
+	ExplosionStartedAt time.Time `yaml:"explosion_started_at" json:"explosionStartedAt"`
+}
+
+func (r Rollout) String() string {
+	s := fmt.Sprintf("%s %s/%s revision %d, rolled out at %s, %s before the explosion started",
+		r.Kind, r.Namespace, r.Name, r.Revision, r.CreatedAt.Format(time.RFC3339),
+		r.ExplosionStartedAt.Sub(r.CreatedAt).Round(time.Second))
+	if len(r.ChangedImages) > 0 {
+		s += ", changing images to " + strings.Join(r.ChangedImages, ", ")
+	}
+	return s
+}
@@ -0,0 +1,504 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	promcfg "github.com/prometheus/prometheus/config"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Silence records a single label of a metric that Bomb Squad has silenced, or
+// has been asked to silence
+type Silence struct {
+	// RelabelConfig is the base64 encoded silencing rule. It is empty for
+	// silences that have been requested but not yet applied.
+	RelabelConfig string `yaml:"relabel_config,omitempty"`
+	// Action is the relabel action used to silence the label
+	Action string `yaml:"action,omitempty"`
+	// TTL is how long the silence stays in place once applied. Zero means
+	// until it is removed by hand.
+	TTL model.Duration `yaml:"ttl,omitempty"`
+	// Jobs limits the silence to the scrape configs of these jobs. Empty
+	// means every job.
+	Jobs []string `yaml:"jobs,omitempty"`
+	// AppliedJobs are the scrape jobs the silencing rule is present in
+	AppliedJobs []string `yaml:"applied_jobs,omitempty"`
+	// AppliedAt is when the silencing rule was first applied
+	AppliedAt time.Time `yaml:"applied_at"`
+	// Manual is set for silences requested by hand rather than by the patrol
+	Manual bool `yaml:"manual,omitempty"`
+	// Culprit is the rollout that most likely caused the explosion, if the
+	// patrol could tell
+	Culprit *Rollout `yaml:"culprit,omitempty"`
+	// Escalations are the steps taken because the metric kept growing after
+	// the silence was applied, oldest first
+	Escalations []Escalation `yaml:"escalations,omitempty"`
+	// Verification is the outcome of checking that the silence stopped the
+	// metric from growing, or empty until that has been checked
+	Verification string `yaml:"verification,omitempty"`
+	// AffectedRules are the recording and alerting rules the silence may
+	// break, as found when it was applied
+	AffectedRules []AffectedRule `yaml:"affected_rules,omitempty"`
+	// Purges are the deletions of the label's exploded series from
+	// Prometheus' TSDB, oldest first
+	Purges []Purge `yaml:"purges,omitempty"`
+	// RemoteWrite holds the URLs of the remote_write endpoints whose
+	// write_relabel_configs carry the rule as well, or AllRemoteWrites for
+	// every endpoint. The rule applies there to the metric from every job.
+	RemoteWrite []string `yaml:"remote_write,omitempty"`
+	// RemoteWriteOnly leaves the scrape configs alone, so that the series
+	// are still stored locally but not forwarded
+	RemoteWriteOnly bool `yaml:"remote_write_only,omitempty"`
+	// AppliedRemoteWrite holds the URLs of the remote_write endpoints the
+	// rule is present in
+	AppliedRemoteWrite []string `yaml:"applied_remote_write,omitempty"`
+	// Baseline holds the values the label had before it exploded, which a
+	// replace silence leaves alone
+	Baseline *Baseline `yaml:"baseline,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface. Older versions of
+// Bomb Squad stored nothing but the encoded rule, so a plain string is
+// accepted as well.
+func (s *Silence) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var encoded string
+	if err := unmarshal(&encoded); err == nil {
+		*s = Silence{RelabelConfig: encoded, Action: string(promcfg.RelabelReplace)}
+		return nil
+	}
+
+	type plain Silence
+	return unmarshal((*plain)(s))
+}
+
+// Rule returns the silencing rule for metricName.labelName. Silences that
+// haven't been applied yet have their rule generated from their action and
+// baseline.
+func (s Silence) Rule(metricName, labelName string) (promcfg.RelabelConfig, error) {
+	if s.RelabelConfig != "" {
+		return decode(s.RelabelConfig)
+	}
+
+	action := s.Action
+	if action == "" {
+		action = string(promcfg.RelabelReplace)
+	}
+	hcs := HighCardSeries{MetricName: metricName, HighCardLabelName: model.LabelName(labelName)}
+	if s.Baseline != nil && action == string(promcfg.RelabelReplace) {
+		return GenerateBaselineRelabelConfig(hcs, s.Baseline.Values)
+	}
+	return GenerateSilenceRelabelConfig(hcs, action)
+}
+
+// Expired reports whether the silence has outlived its TTL at time now
+func (s Silence) Expired(now time.Time) bool {
+	if s.TTL == 0 || s.AppliedAt.IsZero() {
+		return false
+	}
+	return now.After(s.AppliedAt.Add(time.Duration(s.TTL)))
+}
+
+// ExpireSilences removes every silence whose TTL has run out by now, both
+// from the Bomb Squad config and from the Prometheus config. The series whose
+// silences were removed are returned.
+func ExpireSilences(now time.Time, pc, bc Configurator) ([]HighCardSeries, error) {
+	bsCfg, err := ReadBombSquadConfig(bc)
+	if err != nil {
+		return nil, err
+	}
+
+	expired := []HighCardSeries{}
+	for metricName, labels := range bsCfg.SuppressedMetrics {
+		for labelName, silence := range labels {
+			if silence.Expired(now) {
+				expired = append(expired, HighCardSeries{MetricName: metricName, HighCardLabelName: model.LabelName(labelName)})
+			}
+		}
+	}
+	if len(expired) == 0 {
+		return expired, nil
+	}
+
+	promConfig, err := ReadPromConfig(pc)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []HighCardSeries{}
+	for _, s := range expired {
+		err = removeSilence(s.MetricName, string(s.HighCardLabelName), &promConfig, &bsCfg)
+		if err != nil {
+			log.Printf("Couldn't expire silence for %s.%s: %s\n", s.MetricName, s.HighCardLabelName, err)
+			continue
+		}
+		bsCfg.RecordEvent(Event{Type: EventExpired, Metric: s.MetricName, Label: string(s.HighCardLabelName)})
+		removed = append(removed, s)
+	}
+
+	err = WriteBombSquadConfig(bsCfg, bc)
+	if err != nil {
+		return nil, err
+	}
+
+	err = WritePromConfig(promConfig, pc)
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// removeSilence deletes the silence for metricName.labelName from bsCfg and
+// its rule from every scrape config and remote_write endpoint in promConfig,
+// other than those where another silence with the same rule still needs it
+func removeSilence(metricName, labelName string, promConfig *promcfg.Config, bsCfg *BombSquadConfig) error {
+	silence, ok := bsCfg.SuppressedMetrics[metricName][labelName]
+	if !ok {
+		return SilenceNotFoundError{ID: metricName + "." + labelName}
+	}
+
+	bsRelabelConfig, err := silence.Rule(metricName, labelName)
+	if err != nil {
+		return err
+	}
+	sharers := ruleSharers(*bsCfg, metricName, labelName, bsRelabelConfig)
+
+	deleted := 0
+	for _, scrapeConfig := range promConfig.ScrapeConfigs {
+		if neededInJob(sharers, scrapeConfig.JobName) {
+			log.Printf("Kept silence rule in ScrapeConfig %s, as another silence shares it\n", scrapeConfig.JobName)
+			continue
+		}
+		i := FindRelabelConfigInScrapeConfig(bsRelabelConfig, *scrapeConfig)
+		for i >= 0 {
+			scrapeConfig.MetricRelabelConfigs = DeleteRelabelConfigFromArray(scrapeConfig.MetricRelabelConfigs, i)
+			log.Printf("Deleted silence rule from ScrapeConfig %s\n", scrapeConfig.JobName)
+			deleted++
+			i = FindRelabelConfigInScrapeConfig(bsRelabelConfig, *scrapeConfig)
+		}
+	}
+	if n := deleteWriteRelabelConfig(bsRelabelConfig, sharers, promConfig); n > 0 {
+		log.Printf("Deleted silence rule from %d remote_write endpoints\n", n)
+		deleted += n
+	}
+	if deleted == 0 {
+		log.Printf("No silence rule for %s.%s was found in any ScrapeConfig or remote_write, removing it from Bomb Squad config only\n", metricName, labelName)
+	}
+
+	if len(bsCfg.SuppressedMetrics[metricName]) == 1 {
+		delete(bsCfg.SuppressedMetrics, metricName)
+	} else {
+		delete(bsCfg.SuppressedMetrics[metricName], labelName)
+	}
+	return nil
+}
+
+// ruleSharers returns the silences other than the one for
+// metricName.labelName whose rule is rc. A labeldrop rule names nothing but
+// its label, so every labeldrop silence of the same label shares one rule.
+func ruleSharers(bsCfg BombSquadConfig, metricName, labelName string, rc promcfg.RelabelConfig) []Silence {
+	sharers := []Silence{}
+	for metric, labels := range bsCfg.SuppressedMetrics {
+		for label, silence := range labels {
+			if metric == metricName && label == labelName {
+				continue
+			}
+			other, err := silence.Rule(metric, label)
+			if err == nil && RelabelConfigsEqual(other, rc) {
+				sharers = append(sharers, silence)
+			}
+		}
+	}
+	return sharers
+}
+
+// neededInJob reports whether any of silences applies its rule to the scrape
+// config of the given job
+func neededInJob(silences []Silence, jobName string) bool {
+	for _, s := range silences {
+		if !s.RemoteWriteOnly && jobSelected(s.Jobs, jobName) {
+			return true
+		}
+	}
+	return false
+}
+
+// neededInRemoteWrite reports whether any of silences applies its rule to the
+// remote_write endpoint with the given URL
+func neededInRemoteWrite(silences []Silence, u string) bool {
+	for _, s := range silences {
+		if remoteWriteSelected(s.RemoteWrite, u) {
+			return true
+		}
+	}
+	return false
+}
+
+// SilenceNotFoundError is returned when there is no silence with the given ID
+type SilenceNotFoundError struct {
+	ID string
+}
+
+func (e SilenceNotFoundError) Error() string {
+	return fmt.Sprintf("No silence found for %s", e.ID)
+}
+
+// ParseSilenceID splits a silence ID, in metricName.labelName form, into its
+// metric and label names
+func ParseSilenceID(id string) (string, string, error) {
+	i := strings.LastIndex(id, ".")
+	if i <= 0 || i == len(id)-1 {
+		return "", "", fmt.Errorf("Invalid silence '%s', expected metricName.labelName", id)
+	}
+	return id[:i], id[i+1:], nil
+}
+
+// SilenceSummary is a self-contained description of a silence, suitable for
+// showing to people and scripts
+type SilenceSummary struct {
+	ID          string     `json:"id"`
+	Metric      string     `json:"metric"`
+	Label       string     `json:"label"`
+	Action      string     `json:"action"`
+	Manual      bool       `json:"manual"`
+	TTL         string     `json:"ttl,omitempty"`
+	Jobs        []string   `json:"jobs,omitempty"`
+	AppliedJobs []string   `json:"appliedJobs,omitempty"`
+	AppliedAt   *time.Time `json:"appliedAt,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	// RelabelConfig is the silencing rule, as it appears in the Prometheus config
+	RelabelConfig string         `json:"relabelConfig,omitempty"`
+	Culprit       *Rollout       `json:"culprit,omitempty"`
+	Escalations   []Escalation   `json:"escalations,omitempty"`
+	Verification  string         `json:"verification,omitempty"`
+	AffectedRules []AffectedRule `json:"affectedRules,omitempty"`
+	Purges        []Purge        `json:"purges,omitempty"`
+	// RemoteWrite holds the remote_write endpoints the silence applies to
+	RemoteWrite        []string  `json:"remoteWrite,omitempty"`
+	RemoteWriteOnly    bool      `json:"remoteWriteOnly,omitempty"`
+	AppliedRemoteWrite []string  `json:"appliedRemoteWrite,omitempty"`
+	Baseline           *Baseline `json:"baseline,omitempty"`
+}
+
+// Summarize returns the SilenceSummary of the silence for metricName.labelName
+func (s Silence) Summarize(metricName, labelName string) SilenceSummary {
+	sum := SilenceSummary{
+		ID:            metricName + "." + labelName,
+		Metric:        metricName,
+		Label:         labelName,
+		Action:        s.Action,
+		Manual:        s.Manual,
+		Jobs:          s.Jobs,
+		AppliedJobs:   s.AppliedJobs,
+		Culprit:       s.Culprit,
+		Escalations:   s.Escalations,
+		Verification:  s.Verification,
+		AffectedRules: s.AffectedRules,
+		Purges:        s.Purges,
+
+		RemoteWrite:        s.RemoteWrite,
+		RemoteWriteOnly:    s.RemoteWriteOnly,
+		AppliedRemoteWrite: s.AppliedRemoteWrite,
+		Baseline:           s.Baseline,
+	}
+	if sum.Action == "" {
+		sum.Action = string(promcfg.RelabelReplace)
+	}
+	if s.TTL != 0 {
+		sum.TTL = s.TTL.String()
+	}
+	if !s.AppliedAt.IsZero() {
+		appliedAt := s.AppliedAt
+		sum.AppliedAt = &appliedAt
+		if s.TTL != 0 {
+			expiresAt := s.AppliedAt.Add(time.Duration(s.TTL))
+			sum.ExpiresAt = &expiresAt
+		}
+	}
+	if rc, err := s.Rule(metricName, labelName); err == nil {
+		if b, err := yaml.Marshal(rc); err == nil {
+			sum.RelabelConfig = string(b)
+		}
+	}
+	return sum
+}
+
+// ListSilences returns a summary of every silence in the Bomb Squad config,
+// sorted by ID
+func ListSilences(c Configurator) ([]SilenceSummary, error) {
+	bsCfg, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []SilenceSummary{}
+	for metricName, labels := range bsCfg.SuppressedMetrics {
+		for labelName, silence := range labels {
+			out = append(out, silence.Summarize(metricName, labelName))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+// GetSilence returns the summary of the silence with the given ID, or a
+// SilenceNotFoundError
+func GetSilence(id string, c Configurator) (SilenceSummary, error) {
+	metricName, labelName, err := ParseSilenceID(id)
+	if err != nil {
+		return SilenceSummary{}, err
+	}
+
+	bsCfg, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return SilenceSummary{}, err
+	}
+
+	silence, ok := bsCfg.SuppressedMetrics[metricName][labelName]
+	if !ok {
+		return SilenceSummary{}, SilenceNotFoundError{ID: id}
+	}
+	return silence.Summarize(metricName, labelName), nil
+}
+
+// SilenceExistsError is returned when asked to create a silence that already
+// exists
+type SilenceExistsError struct {
+	ID string
+}
+
+func (e SilenceExistsError) Error() string {
+	return fmt.Sprintf("%s is already silenced", e.ID)
+}
+
+// InvalidSilenceError is returned for a SilenceRequest that can't be applied
+type InvalidSilenceError struct {
+	Reason string
+}
+
+func (e InvalidSilenceError) Error() string {
+	return e.Reason
+}
+
+// labelDropRemoteWrite is why labeldrop silences can't apply to remote_write
+// endpoints
+const labelDropRemoteWrite = "labeldrop can't silence a label in remote_write endpoints, as write_relabel_configs would drop it from every metric forwarded, whatever the job"
+
+// SilenceRequest asks for a label of a metric to be silenced by hand
+type SilenceRequest struct {
+	Metric string `json:"metric"`
+	Label  string `json:"label"`
+	// Action is one of SilenceActions. Empty means replace.
+	Action string `json:"action,omitempty"`
+	// TTL is a Prometheus duration, such as 6h. Empty means no expiry.
+	TTL string `json:"ttl,omitempty"`
+	// Jobs limits the silence to these scrape jobs. Empty means every job.
+	Jobs []string `json:"jobs,omitempty"`
+	// Force applies the silence even if the impact policy blocks silences
+	// that may break rules
+	Force bool `json:"force,omitempty"`
+	// RemoteWrite holds the URLs of the remote_write endpoints to silence the
+	// label in as well, or AllRemoteWrites for every endpoint
+	RemoteWrite []string `json:"remoteWrite,omitempty"`
+	// RemoteWriteOnly silences the label in RemoteWrite alone, leaving the
+	// scrape configs untouched
+	RemoteWriteOnly bool `json:"remoteWriteOnly,omitempty"`
+}
+
+// Parse validates the request and returns the series to silence and the
+// silence to record for it, or an InvalidSilenceError
+func (r SilenceRequest) Parse() (HighCardSeries, Silence, error) {
+	if !model.IsValidMetricName(model.LabelValue(r.Metric)) {
+		return HighCardSeries{}, Silence{}, InvalidSilenceError{Reason: fmt.Sprintf("invalid metric name '%s'", r.Metric)}
+	}
+	if !model.LabelName(r.Label).IsValid() || strings.HasPrefix(r.Label, model.ReservedLabelPrefix) {
+		return HighCardSeries{}, Silence{}, InvalidSilenceError{Reason: fmt.Sprintf("invalid label name '%s'", r.Label)}
+	}
+
+	s := HighCardSeries{MetricName: r.Metric, HighCardLabelName: model.LabelName(r.Label)}
+	for _, job := range r.Jobs {
+		if job = strings.TrimSpace(job); job != "" {
+			s.Jobs = append(s.Jobs, job)
+		}
+	}
+
+	silence := Silence{Action: r.Action, Manual: true, RemoteWriteOnly: r.RemoteWriteOnly}
+	for _, u := range r.RemoteWrite {
+		if u = strings.TrimSpace(u); u != "" {
+			silence.RemoteWrite = append(silence.RemoteWrite, u)
+		}
+	}
+	if silence.RemoteWriteOnly && len(silence.RemoteWrite) == 0 {
+		return HighCardSeries{}, Silence{}, InvalidSilenceError{Reason: "a remote write only silence needs the remote_write endpoints to silence the label in"}
+	}
+	if silence.Action == "" {
+		silence.Action = string(promcfg.RelabelReplace)
+	}
+	if silence.Action == string(promcfg.RelabelLabelDrop) && len(silence.RemoteWrite) > 0 {
+		return HighCardSeries{}, Silence{}, InvalidSilenceError{Reason: labelDropRemoteWrite}
+	}
+	if _, err := GenerateSilenceRelabelConfig(s, silence.Action); err != nil {
+		return HighCardSeries{}, Silence{}, InvalidSilenceError{Reason: err.Error()}
+	}
+
+	if r.TTL != "" {
+		ttl, err := model.ParseDuration(r.TTL)
+		if err != nil || ttl == 0 {
+			return HighCardSeries{}, Silence{}, InvalidSilenceError{Reason: fmt.Sprintf("invalid ttl '%s'", r.TTL)}
+		}
+		silence.TTL = ttl
+	}
+	return s, silence, nil
+}
+
+// SilenceUpdate asks for an existing silence to be changed
+type SilenceUpdate struct {
+	// TTL is a Prometheus duration counted from when the silence was
+	// applied, such as 12h. 0s removes the silence's expiry.
+	TTL string `json:"ttl"`
+}
+
+// UpdateSilence applies upd to the silence with the given ID and returns the
+// updated silence. Invalid updates return an InvalidSilenceError.
+func UpdateSilence(id string, upd SilenceUpdate, c Configurator) (SilenceSummary, error) {
+	metricName, labelName, err := ParseSilenceID(id)
+	if err != nil {
+		return SilenceSummary{}, InvalidSilenceError{Reason: err.Error()}
+	}
+
+	if upd.TTL == "" {
+		return SilenceSummary{}, InvalidSilenceError{Reason: "ttl is required"}
+	}
+	ttl, err := model.ParseDuration(upd.TTL)
+	if err != nil {
+		return SilenceSummary{}, InvalidSilenceError{Reason: fmt.Sprintf("invalid ttl '%s'", upd.TTL)}
+	}
+
+	bsCfg, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return SilenceSummary{}, err
+	}
+
+	silence, ok := bsCfg.SuppressedMetrics[metricName][labelName]
+	if !ok {
+		return SilenceSummary{}, SilenceNotFoundError{ID: id}
+	}
+	silence.TTL = ttl
+	bsCfg.SuppressedMetrics[metricName][labelName] = silence
+
+	detail := "expiry removed"
+	if ttl != 0 {
+		detail = fmt.Sprintf("ttl set to %s", ttl)
+	}
+	bsCfg.RecordEvent(Event{Type: EventUpdated, Metric: metricName, Label: labelName, Detail: detail})
+
+	err = WriteBombSquadConfig(bsCfg, c)
+	if err != nil {
+		return SilenceSummary{}, err
+	}
+	return silence.Summarize(metricName, labelName), nil
+}
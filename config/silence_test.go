@@ -0,0 +1,74 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanReadLegacyBombSquadConfig(t *testing.T) {
+	hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar"}
+	mrc, err := config.GenerateMetricRelabelConfig(hcs)
+	require.NoError(t, err)
+
+	// Older releases stored the encoded rule directly under the label name
+	legacy := bstesting.NewMemoryConfigurator(t, []byte{})
+	require.NoError(t, config.StoreMetricRelabelConfigBombSquad(hcs, mrc, legacy))
+	bscfg, err := config.ReadBombSquadConfig(legacy)
+	require.NoError(t, err)
+	encoded := bscfg.SuppressedMetrics["foo"]["bar"].RelabelConfig
+
+	bc := bstesting.NewMemoryConfigurator(t, []byte("suppressedmetrics:\n  foo:\n    bar: "+encoded+"\n"))
+	bscfg, err = config.ReadBombSquadConfig(bc)
+	require.NoError(t, err)
+
+	rc, err := bscfg.SuppressedMetrics["foo"]["bar"].Rule("foo", "bar")
+	require.NoError(t, err)
+	require.True(t, config.RelabelConfigsEqual(mrc, rc))
+	require.Equal(t, "replace", bscfg.SuppressedMetrics["foo"]["bar"].Action)
+}
+
+func TestReconcileAppliesRequestedSilence(t *testing.T) {
+	pc := bstesting.NewPromMemoryConfigurator(t)
+	bc := bstesting.NewMemoryConfigurator(t, []byte("suppressedmetrics:\n  foo:\n    bar:\n      action: replace\n      ttl: 1h\n"))
+
+	_, err := config.Reconcile(pc, bc)
+	require.NoError(t, err)
+
+	bscfg, err := config.ReadBombSquadConfig(bc)
+	require.NoError(t, err)
+	silence := bscfg.SuppressedMetrics["foo"]["bar"]
+	require.NotEmpty(t, silence.RelabelConfig)
+	require.Len(t, silence.AppliedJobs, 4)
+	require.False(t, silence.AppliedAt.IsZero())
+	require.Equal(t, model.Duration(time.Hour), silence.TTL)
+}
+
+func TestExpireSilences(t *testing.T) {
+	pc := bstesting.NewPromMemoryConfigurator(t)
+	bc := bstesting.NewMemoryConfigurator(t, []byte("suppressedmetrics:\n  foo:\n    bar:\n      ttl: 1h\n"))
+	_, err := config.Reconcile(pc, bc)
+	require.NoError(t, err)
+
+	expired, err := config.ExpireSilences(time.Now(), pc, bc)
+	require.NoError(t, err)
+	require.Len(t, expired, 0)
+
+	expired, err = config.ExpireSilences(time.Now().Add(2*time.Hour), pc, bc)
+	require.NoError(t, err)
+	require.Equal(t, []config.HighCardSeries{{MetricName: "foo", HighCardLabelName: "bar"}}, expired)
+
+	bscfg, err := config.ReadBombSquadConfig(bc)
+	require.NoError(t, err)
+	require.Len(t, bscfg.SuppressedMetrics, 0)
+
+	pcfg, err := config.ReadPromConfig(pc)
+	require.NoError(t, err)
+	for _, sc := range pcfg.ScrapeConfigs {
+		require.Len(t, sc.MetricRelabelConfigs, 0)
+	}
+}
@@ -2,8 +2,11 @@ package configmap
 
 import (
 	"fmt"
+	"log"
+	"time"
 
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	kcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/util/retry"
 )
@@ -57,6 +60,9 @@ func (c *ConfigMapWrapper) Write(data []byte) error {
 			return fmt.Errorf("Failed to get latest version of ConfigMap: %v", err)
 		}
 
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
 		cm.Data[dataKey] = string(data)
 
 		_, updateErr := c.Client.Update(cm)
@@ -73,3 +79,47 @@ func (c *ConfigMapWrapper) Write(data []byte) error {
 
 	return nil
 }
+
+// Watch returns a channel that receives a value whenever the ConfigMap is
+// changed, until stop is closed. Changes that arrive while a previous one is
+// still unconsumed are coalesced. The underlying watch is re-established
+// whenever the apiserver closes it.
+func (c *ConfigMapWrapper) Watch(stop <-chan struct{}) <-chan struct{} {
+	events := make(chan struct{}, 1)
+
+	go func() {
+		for {
+			w, err := c.Client.Watch(v1.ListOptions{
+				FieldSelector: fields.OneTermEqualSelector("metadata.name", c.Name).String(),
+			})
+			if err != nil {
+				log.Printf("Failed to watch ConfigMap %s, retrying: %s\n", c.Name, err)
+				select {
+				case <-stop:
+					return
+				case <-time.After(5 * time.Second):
+				}
+				continue
+			}
+
+		receive:
+			for {
+				select {
+				case <-stop:
+					w.Stop()
+					return
+				case _, ok := <-w.ResultChan():
+					if !ok {
+						break receive
+					}
+					select {
+					case events <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+
+	return events
+}
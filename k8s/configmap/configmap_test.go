@@ -2,6 +2,7 @@ package configmap
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	k8sAPICoreV1 "k8s.io/api/core/v1"
@@ -54,3 +55,23 @@ func newConfigMap() *k8sAPICoreV1.ConfigMap {
 
 	return &k8sAPICoreV1.ConfigMap{TypeMeta: cmType, ObjectMeta: cmMeta, Data: cmData}
 }
+
+func TestWatchSignalsConfigMapChanges(t *testing.T) {
+	cmw := NewConfigMapWrapper(fakeConfigMapClient(), "testNamespace", "testConfigMap", "testDataKey")
+	_, _ = cmw.Client.Create(newConfigMap())
+
+	stop := make(chan struct{})
+	defer close(stop)
+	events := cmw.Watch(stop)
+
+	// The watch is established asynchronously, so keep writing until it's seen
+	for i := 0; i < 50; i++ {
+		require.NoError(t, cmw.Write([]byte("BazBat")))
+		select {
+		case <-events:
+			return
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	t.Fatal("no event received after writing to the ConfigMap")
+}
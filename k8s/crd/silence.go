@@ -0,0 +1,428 @@
+package crd
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/prometheus/common/model"
+	yaml "gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// Group is the API group of Bomb Squad's custom resources
+	Group = "bombsquad.freshtracks.io"
+	// Version is the API version of Bomb Squad's custom resources
+	Version = "v1alpha1"
+	// Kind is the kind of the custom resource holding a silence
+	Kind = "CardinalitySilence"
+	// Plural is the resource name used in CardinalitySilence API paths
+	Plural = "cardinalitysilences"
+)
+
+// CardinalitySilence is a silence of one or more labels of a metric,
+// stored as a Kubernetes custom resource
+type CardinalitySilence struct {
+	v1.TypeMeta   `json:",inline"`
+	v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CardinalitySilenceSpec   `json:"spec"`
+	Status CardinalitySilenceStatus `json:"status,omitempty"`
+}
+
+// CardinalitySilenceSpec is what a human, or Bomb Squad, asks to be silenced
+type CardinalitySilenceSpec struct {
+	Metric string   `json:"metric"`
+	Labels []string `json:"labels"`
+	// Action is the relabel action used for the silence. Defaults to replace.
+	Action string `json:"action,omitempty"`
+	// TTL is a Prometheus duration, such as 6h, after which the silence is
+	// removed. Empty means the silence stays until it is deleted.
+	TTL string `json:"ttl,omitempty"`
+	// Jobs limits the silence to these scrape jobs. Empty means every job.
+	Jobs []string `json:"jobs,omitempty"`
+	// Manual is set for silences requested by hand rather than by the patrol
+	Manual bool `json:"manual,omitempty"`
+	// RemoteWrite holds the URLs of the remote_write endpoints to silence the
+	// labels in as well, or * for every endpoint
+	RemoteWrite []string `json:"remoteWrite,omitempty"`
+	// RemoteWriteOnly silences the labels in RemoteWrite alone
+	RemoteWriteOnly bool `json:"remoteWriteOnly,omitempty"`
+}
+
+// CardinalitySilenceStatus is maintained by Bomb Squad as it applies the silence
+type CardinalitySilenceStatus struct {
+	AppliedJobs []string `json:"appliedJobs,omitempty"`
+	AppliedAt   *v1.Time `json:"appliedAt,omitempty"`
+	// AppliedRemoteWrite holds the URLs of the remote_write endpoints
+	// carrying the silencing rules
+	AppliedRemoteWrite []string `json:"appliedRemoteWrite,omitempty"`
+	// RelabelConfigs holds the encoded silencing rule applied for each label
+	RelabelConfigs map[string]string `json:"relabelConfigs,omitempty"`
+	// Culprits holds the rollout that most likely caused each label to explode
+	Culprits map[string]*config.Rollout `json:"culprits,omitempty"`
+	// Escalations holds the steps each label's silence was escalated through
+	Escalations map[string][]config.Escalation `json:"escalations,omitempty"`
+	// Verifications holds whether each label's silence stopped its metric
+	// from growing
+	Verifications map[string]string `json:"verifications,omitempty"`
+	// AffectedRules holds the rules each label's silence may break
+	AffectedRules map[string][]config.AffectedRule `json:"affectedRules,omitempty"`
+	// Purges holds the deletions of each label's exploded series
+	Purges map[string][]config.Purge `json:"purges,omitempty"`
+	// Baselines holds the values each label's silence keeps
+	Baselines map[string]*config.Baseline `json:"baselines,omitempty"`
+}
+
+// CardinalitySilenceList is a list of CardinalitySilences
+type CardinalitySilenceList struct {
+	v1.TypeMeta `json:",inline"`
+	v1.ListMeta `json:"metadata,omitempty"`
+
+	Items []CardinalitySilence `json:"items"`
+}
+
+// SilenceWrapper is a struct with public fields, which implements github.com/open-fresh/bomb-squad/config.Configurator
+// on top of the CardinalitySilence objects in a namespace. The Bomb Squad config
+// it reads and writes is the collection of those objects.
+type SilenceWrapper struct {
+	// Client is any REST client for the cluster; requests use absolute paths
+	Client    rest.Interface
+	Namespace string
+}
+
+// NewSilenceWrapper returns a SilenceWrapper
+func NewSilenceWrapper(client rest.Interface, namespace string) *SilenceWrapper {
+	return &SilenceWrapper{
+		Client:    client,
+		Namespace: namespace,
+	}
+}
+
+// GetLocation implements github.com/open-fresh/bomb-squad/config.Configurator
+func (c *SilenceWrapper) GetLocation() string {
+	return fmt.Sprintf("/apis/%s/%s/namespaces/%s/%s", Group, Version, c.Namespace, Plural)
+}
+
+// KeepsHistory implements github.com/open-fresh/bomb-squad/config.HistoryKeeper.
+// CardinalitySilences have nowhere to hold the history, which Write discards.
+func (c *SilenceWrapper) KeepsHistory() bool {
+	return false
+}
+
+// Read implements github.com/open-fresh/bomb-squad/config.Configurator
+func (c *SilenceWrapper) Read() ([]byte, error) {
+	silences, err := c.list()
+	if err != nil {
+		return []byte{}, err
+	}
+
+	bscfg := config.BombSquadConfig{SuppressedMetrics: map[string]config.BombSquadLabelConfig{}}
+	for _, cs := range silences {
+		ttl := model.Duration(0)
+		if cs.Spec.TTL != "" {
+			ttl, err = model.ParseDuration(cs.Spec.TTL)
+			if err != nil {
+				log.Printf("Ignoring invalid TTL '%s' of CardinalitySilence %s: %s\n", cs.Spec.TTL, cs.Name, err)
+			}
+		}
+
+		lc, ok := bscfg.SuppressedMetrics[cs.Spec.Metric]
+		if !ok {
+			lc = config.BombSquadLabelConfig{}
+			bscfg.SuppressedMetrics[cs.Spec.Metric] = lc
+		}
+
+		for _, label := range cs.Spec.Labels {
+			if _, ok := lc[label]; ok {
+				log.Printf("Ignoring duplicate silence of %s.%s in CardinalitySilence %s\n", cs.Spec.Metric, label, cs.Name)
+				continue
+			}
+
+			s := config.Silence{
+				RelabelConfig: cs.Status.RelabelConfigs[label],
+				Action:        cs.Spec.Action,
+				TTL:           ttl,
+				Jobs:          cs.Spec.Jobs,
+				AppliedJobs:   cs.Status.AppliedJobs,
+				Manual:        cs.Spec.Manual,
+				Culprit:       cs.Status.Culprits[label],
+				Escalations:   cs.Status.Escalations[label],
+				Verification:  cs.Status.Verifications[label],
+				AffectedRules: cs.Status.AffectedRules[label],
+				Purges:        cs.Status.Purges[label],
+				Baseline:      cs.Status.Baselines[label],
+
+				RemoteWrite:        cs.Spec.RemoteWrite,
+				RemoteWriteOnly:    cs.Spec.RemoteWriteOnly,
+				AppliedRemoteWrite: cs.Status.AppliedRemoteWrite,
+			}
+			if cs.Status.AppliedAt != nil {
+				s.AppliedAt = cs.Status.AppliedAt.Time.UTC()
+			}
+			lc[label] = s
+		}
+	}
+
+	return yaml.Marshal(bscfg)
+}
+
+// Write implements github.com/open-fresh/bomb-squad/config.Configurator. Objects
+// whose labels are no longer silenced are deleted, or have their labels
+// trimmed, the status of the remaining ones is updated, and new silences get
+// an object of their own. So do labels whose action or TTL no longer matches
+// that of the others in their object, as the labels of an object share them. Quarantines, pending silences and the circuit
+// breaker's state can't be stored, and are refused. The history can't be
+// stored either, and is discarded, as every change records an event.
+func (c *SilenceWrapper) Write(data []byte) error {
+	bscfg := config.BombSquadConfig{}
+	err := yaml.Unmarshal(data, &bscfg)
+	if err != nil {
+		return fmt.Errorf("Couldn't unmarshal into config.BombSquadConfig: %s", err)
+	}
+	if len(bscfg.Quarantines) > 0 {
+		return fmt.Errorf("CardinalitySilences can't hold quarantines; use the configmap state backend to quarantine targets")
+	}
+	if len(bscfg.Pending) > 0 || len(bscfg.Rejected) > 0 {
+		return fmt.Errorf("CardinalitySilences can't hold pending silences; use the configmap state backend to require approval")
+	}
+	if bscfg.Breaker != nil {
+		return fmt.Errorf("CardinalitySilences can't hold the circuit breaker's state; use the configmap state backend to keep it")
+	}
+
+	silences, err := c.list()
+	if err != nil {
+		return err
+	}
+
+	covered := map[string]bool{}
+	for _, cs := range silences {
+		labels := []string{}
+		var first config.Silence
+		for _, label := range cs.Spec.Labels {
+			id := cs.Spec.Metric + "." + label
+			s, ok := bscfg.SuppressedMetrics[cs.Spec.Metric][label]
+			if !ok || covered[id] {
+				continue
+			}
+			// The labels of an object share its TTL and action, so the first
+			// one's stand for all, and any other is left for an object of its
+			// own
+			if len(labels) > 0 && (s.Action != first.Action || s.TTL != first.TTL) {
+				continue
+			}
+			if len(labels) == 0 {
+				first = s
+			}
+			labels = append(labels, label)
+			covered[id] = true
+		}
+
+		if len(labels) == 0 {
+			err = c.delete(cs.Name)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		updated := cs
+		updated.Spec.Labels = labels
+		if first.TTL != 0 {
+			updated.Spec.TTL = first.TTL.String()
+		} else if _, err := model.ParseDuration(cs.Spec.TTL); err == nil {
+			// A TTL that doesn't parse was read as none, and is left for
+			// its author to fix
+			updated.Spec.TTL = ""
+		}
+		if first.Action != "" {
+			updated.Spec.Action = first.Action
+		}
+		updated.Status = statusFor(cs.Spec.Metric, labels, bscfg.SuppressedMetrics[cs.Spec.Metric])
+		b, _ := json.Marshal(cs)
+		u, _ := json.Marshal(updated)
+		if string(b) != string(u) {
+			err = c.update(updated)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for metricName, labels := range bscfg.SuppressedMetrics {
+		for label, s := range labels {
+			if covered[metricName+"."+label] {
+				continue
+			}
+
+			cs := CardinalitySilence{
+				TypeMeta:   v1.TypeMeta{APIVersion: Group + "/" + Version, Kind: Kind},
+				ObjectMeta: v1.ObjectMeta{Name: ObjectName(metricName, label), Namespace: c.Namespace},
+				Spec: CardinalitySilenceSpec{
+					Metric: metricName,
+					Labels: []string{label},
+					Action: s.Action,
+					Jobs:   s.Jobs,
+					Manual: s.Manual,
+
+					RemoteWrite:     s.RemoteWrite,
+					RemoteWriteOnly: s.RemoteWriteOnly,
+				},
+				Status: statusFor(metricName, []string{label}, labels),
+			}
+			if s.TTL != 0 {
+				cs.Spec.TTL = s.TTL.String()
+			}
+
+			err = c.create(cs)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// statusFor summarises the applied state of the given labels of a metric
+func statusFor(metricName string, labels []string, lc config.BombSquadLabelConfig) CardinalitySilenceStatus {
+	status := CardinalitySilenceStatus{}
+	jobs := map[string]bool{}
+	remoteWrites := map[string]bool{}
+	for _, label := range labels {
+		s := lc[label]
+		if s.RelabelConfig != "" {
+			if status.RelabelConfigs == nil {
+				status.RelabelConfigs = map[string]string{}
+			}
+			status.RelabelConfigs[label] = s.RelabelConfig
+		}
+		if s.Culprit != nil {
+			if status.Culprits == nil {
+				status.Culprits = map[string]*config.Rollout{}
+			}
+			status.Culprits[label] = s.Culprit
+		}
+		if len(s.Escalations) > 0 {
+			if status.Escalations == nil {
+				status.Escalations = map[string][]config.Escalation{}
+			}
+			status.Escalations[label] = s.Escalations
+		}
+		if s.Verification != "" {
+			if status.Verifications == nil {
+				status.Verifications = map[string]string{}
+			}
+			status.Verifications[label] = s.Verification
+		}
+		if len(s.AffectedRules) > 0 {
+			if status.AffectedRules == nil {
+				status.AffectedRules = map[string][]config.AffectedRule{}
+			}
+			status.AffectedRules[label] = s.AffectedRules
+		}
+		if len(s.Purges) > 0 {
+			if status.Purges == nil {
+				status.Purges = map[string][]config.Purge{}
+			}
+			status.Purges[label] = s.Purges
+		}
+		if s.Baseline != nil {
+			if status.Baselines == nil {
+				status.Baselines = map[string]*config.Baseline{}
+			}
+			status.Baselines[label] = s.Baseline
+		}
+		for _, job := range s.AppliedJobs {
+			jobs[job] = true
+		}
+		for _, u := range s.AppliedRemoteWrite {
+			remoteWrites[u] = true
+		}
+		if !s.AppliedAt.IsZero() && (status.AppliedAt == nil || s.AppliedAt.Before(status.AppliedAt.Time)) {
+			t := v1.NewTime(s.AppliedAt)
+			status.AppliedAt = &t
+		}
+	}
+
+	for job := range jobs {
+		status.AppliedJobs = append(status.AppliedJobs, job)
+	}
+	sort.Strings(status.AppliedJobs)
+	for u := range remoteWrites {
+		status.AppliedRemoteWrite = append(status.AppliedRemoteWrite, u)
+	}
+	sort.Strings(status.AppliedRemoteWrite)
+	return status
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// ObjectName returns the name of the CardinalitySilence Bomb Squad creates for
+// metricName.labelName. Metric and label names allow characters that object
+// names don't, so a hash of the original is appended to keep names unique.
+func ObjectName(metricName, labelName string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(metricName + "." + labelName))
+
+	name := invalidNameChars.ReplaceAllString(strings.ToLower(metricName+"."+labelName), "-")
+	name = strings.Trim(name, ".-")
+	if len(name) > 200 {
+		name = name[:200]
+	}
+	return fmt.Sprintf("%s-%08x", name, h.Sum32())
+}
+
+func (c *SilenceWrapper) list() ([]CardinalitySilence, error) {
+	b, err := c.Client.Get().AbsPath(c.GetLocation()).DoRaw()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list CardinalitySilences: %s", err)
+	}
+
+	l := CardinalitySilenceList{}
+	err = json.Unmarshal(b, &l)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't unmarshal CardinalitySilences: %s", err)
+	}
+	return l.Items, nil
+}
+
+func (c *SilenceWrapper) create(cs CardinalitySilence) error {
+	b, err := json.Marshal(cs)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Client.Post().AbsPath(c.GetLocation()).SetHeader("Content-Type", "application/json").Body(b).DoRaw()
+	if err != nil {
+		return fmt.Errorf("Failed to create CardinalitySilence %s: %s", cs.Name, err)
+	}
+	return nil
+}
+
+func (c *SilenceWrapper) update(cs CardinalitySilence) error {
+	b, err := json.Marshal(cs)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Client.Put().AbsPath(c.GetLocation(), cs.Name).SetHeader("Content-Type", "application/json").Body(b).DoRaw()
+	if err != nil {
+		return fmt.Errorf("Failed to update CardinalitySilence %s: %s", cs.Name, err)
+	}
+	return nil
+}
+
+func (c *SilenceWrapper) delete(name string) error {
+	_, err := c.Client.Delete().AbsPath(c.GetLocation(), name).DoRaw()
+	if err != nil {
+		return fmt.Errorf("Failed to delete CardinalitySilence %s: %s", name, err)
+	}
+	return nil
+}
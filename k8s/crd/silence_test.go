@@ -0,0 +1,139 @@
+package crd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanReadHumanCreatedSilence(t *testing.T) {
+	api := bstesting.NewFakeAPIServer(t)
+	defer api.Close()
+	sw := NewSilenceWrapper(api.RESTClient(), "testNamespace")
+
+	api.Put(sw.GetLocation(), map[string]interface{}{
+		"apiVersion": "bombsquad.freshtracks.io/v1alpha1",
+		"kind":       "CardinalitySilence",
+		"metadata":   map[string]interface{}{"name": "requests-by-user"},
+		"spec": map[string]interface{}{
+			"metric": "http_requests_total",
+			"labels": []string{"user_id", "session"},
+			"ttl":    "6h",
+		},
+	})
+
+	bscfg, err := config.ReadBombSquadConfig(sw)
+	require.NoError(t, err)
+	require.Len(t, bscfg.SuppressedMetrics["http_requests_total"], 2)
+	require.Equal(t, model.Duration(6*time.Hour), bscfg.SuppressedMetrics["http_requests_total"]["user_id"].TTL)
+	require.Empty(t, bscfg.SuppressedMetrics["http_requests_total"]["session"].RelabelConfig)
+}
+
+func TestCanWriteSilences(t *testing.T) {
+	api := bstesting.NewFakeAPIServer(t)
+	defer api.Close()
+	sw := NewSilenceWrapper(api.RESTClient(), "testNamespace")
+
+	api.Put(sw.GetLocation(), map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "requests-by-user"},
+		"spec": map[string]interface{}{
+			"metric": "http_requests_total",
+			"labels": []string{"user_id", "session"},
+		},
+	})
+
+	appliedAt := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	bscfg := config.BombSquadConfig{SuppressedMetrics: map[string]config.BombSquadLabelConfig{
+		"http_requests_total": {
+			"user_id": config.Silence{RelabelConfig: "encoded", AppliedJobs: []string{"b", "a"}, AppliedAt: appliedAt},
+		},
+		"foo": {
+			"bar": config.Silence{Action: "replace", TTL: model.Duration(time.Hour), Culprit: &config.Rollout{Kind: "Deployment", Namespace: "shop", Name: "api", Revision: 7, CreatedAt: appliedAt}},
+		},
+	}}
+	require.NoError(t, config.WriteBombSquadConfig(bscfg, sw))
+
+	objects := api.Objects(sw.GetLocation())
+	require.Len(t, objects, 2)
+
+	read, err := config.ReadBombSquadConfig(sw)
+	require.NoError(t, err)
+	require.Len(t, read.SuppressedMetrics["http_requests_total"], 1)
+	userID := read.SuppressedMetrics["http_requests_total"]["user_id"]
+	require.Equal(t, "encoded", userID.RelabelConfig)
+	require.Equal(t, []string{"a", "b"}, userID.AppliedJobs)
+	require.Equal(t, appliedAt, userID.AppliedAt)
+	require.Equal(t, model.Duration(time.Hour), read.SuppressedMetrics["foo"]["bar"].TTL)
+	require.Equal(t, int64(7), read.SuppressedMetrics["foo"]["bar"].Culprit.Revision)
+
+	bscfg.SuppressedMetrics["foo"]["bar"] = config.Silence{Action: "replace", TTL: model.Duration(2 * time.Hour)}
+	require.NoError(t, config.WriteBombSquadConfig(bscfg, sw))
+	read, err = config.ReadBombSquadConfig(sw)
+	require.NoError(t, err)
+	require.Equal(t, model.Duration(2*time.Hour), read.SuppressedMetrics["foo"]["bar"].TTL)
+
+	escalation := config.Escalation{Time: appliedAt, From: "replace", To: "drop", Growth: 250}
+	bscfg.SuppressedMetrics["foo"]["bar"] = config.Silence{Action: "drop", Escalations: []config.Escalation{escalation}, Verification: config.VerificationPassed}
+	require.NoError(t, config.WriteBombSquadConfig(bscfg, sw))
+	read, err = config.ReadBombSquadConfig(sw)
+	require.NoError(t, err)
+	require.Equal(t, "drop", read.SuppressedMetrics["foo"]["bar"].Action)
+	require.Equal(t, []config.Escalation{escalation}, read.SuppressedMetrics["foo"]["bar"].Escalations)
+	require.Equal(t, config.VerificationPassed, read.SuppressedMetrics["foo"]["bar"].Verification)
+
+	delete(bscfg.SuppressedMetrics, "http_requests_total")
+	require.NoError(t, config.WriteBombSquadConfig(bscfg, sw))
+	require.Len(t, api.Objects(sw.GetLocation()), 1)
+}
+
+func TestObjectNameIsValid(t *testing.T) {
+	require.Regexp(t, `^[a-z0-9]([a-z0-9.-]*[a-z0-9])?$`, ObjectName("HTTP_requests:total", "User_ID"))
+	require.NotEqual(t, ObjectName("foo", "Bar"), ObjectName("foo", "bar"))
+}
+
+func TestHistoryIsUnavailable(t *testing.T) {
+	api := bstesting.NewFakeAPIServer(t)
+	defer api.Close()
+	sw := NewSilenceWrapper(api.RESTClient(), "testNamespace")
+
+	_, err := config.History(0, sw)
+	require.Equal(t, config.HistoryUnavailableError{Location: sw.GetLocation()}, err)
+}
+
+func TestLabelsKeepTheirOwnActionAndTTL(t *testing.T) {
+	api := bstesting.NewFakeAPIServer(t)
+	defer api.Close()
+	sw := NewSilenceWrapper(api.RESTClient(), "testNamespace")
+
+	api.Put(sw.GetLocation(), map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "requests-by-user"},
+		"spec": map[string]interface{}{
+			"metric": "http_requests_total",
+			"labels": []string{"user_id", "session"},
+			"ttl":    "soon",
+		},
+	})
+
+	bscfg, err := config.ReadBombSquadConfig(sw)
+	require.NoError(t, err)
+	require.NoError(t, config.WriteBombSquadConfig(bscfg, sw))
+	objects := api.Objects(sw.GetLocation())
+	require.Len(t, objects, 1)
+	require.Equal(t, "soon", objects[0]["spec"].(map[string]interface{})["ttl"])
+
+	// Escalating one label leaves the other as it was
+	userID := bscfg.SuppressedMetrics["http_requests_total"]["user_id"]
+	userID.Action = "drop"
+	bscfg.SuppressedMetrics["http_requests_total"]["user_id"] = userID
+	require.NoError(t, config.WriteBombSquadConfig(bscfg, sw))
+	require.Len(t, api.Objects(sw.GetLocation()), 2)
+
+	read, err := config.ReadBombSquadConfig(sw)
+	require.NoError(t, err)
+	require.Equal(t, "drop", read.SuppressedMetrics["http_requests_total"]["user_id"].Action)
+	require.Empty(t, read.SuppressedMetrics["http_requests_total"]["session"].Action)
+}
@@ -0,0 +1,121 @@
+// Package events records Kubernetes Events on the workloads behind exploding
+// labels, so that the teams that own them hear about it through kubectl
+// describe and their own event pipelines
+package events
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/patrol"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Component is the source of the Events
+	Component = "bomb-squad"
+	// ReasonSilenced is the reason of the Events recorded when the patrol
+	// silences a label
+	ReasonSilenced = "CardinalityExplosionSilenced"
+)
+
+// Recorder records Events on the pods behind an exploding label, and on the
+// controllers that own them: a Deployment by way of its ReplicaSet, or a
+// StatefulSet, DaemonSet or Job directly
+type Recorder struct {
+	Client kubernetes.Interface
+}
+
+// NewRecorder returns a Recorder that uses client
+func NewRecorder(client kubernetes.Interface) *Recorder {
+	return &Recorder{Client: client}
+}
+
+// RecordSilence records an Event saying how d was silenced on each object
+// behind it. Pods that have gone, and objects Bomb Squad may not read, are
+// logged and skipped.
+func (r *Recorder) RecordSilence(d patrol.Detection, action string) {
+	msg := fmt.Sprintf("Bomb Squad silenced label %s of metric %s with action %s: %d series had %d distinct values of %s, and card_count grew by %g in a minute",
+		d.Label, d.Metric, action, d.Series, d.DistinctValues, d.Label, d.Growth)
+	for _, ref := range r.involvedObjects(d.Pods) {
+		err := r.record(ref, msg)
+		if err != nil {
+			log.Printf("Couldn't record event on %s %s/%s: %s\n", ref.Kind, ref.Namespace, ref.Name, err)
+		}
+	}
+}
+
+// involvedObjects resolves pods to themselves and their owners, without
+// repeating owners shared by several pods
+func (r *Recorder) involvedObjects(pods []patrol.Pod) []coreV1.ObjectReference {
+	refs := []coreV1.ObjectReference{}
+	seen := map[types.UID]bool{}
+	add := func(ref coreV1.ObjectReference) {
+		if !seen[ref.UID] {
+			seen[ref.UID] = true
+			refs = append(refs, ref)
+		}
+	}
+
+	for _, p := range pods {
+		pod, err := r.Client.CoreV1().Pods(p.Namespace).Get(p.Name, metaV1.GetOptions{})
+		if err != nil {
+			log.Printf("Couldn't get pod %s/%s: %s\n", p.Namespace, p.Name, err)
+			continue
+		}
+		add(coreV1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID})
+
+		owner := metaV1.GetControllerOf(pod)
+		if owner == nil {
+			continue
+		}
+		add(ownerReference(pod.Namespace, owner))
+		if owner.Kind != "ReplicaSet" {
+			continue
+		}
+
+		rs, err := r.Client.AppsV1().ReplicaSets(pod.Namespace).Get(owner.Name, metaV1.GetOptions{})
+		if err != nil {
+			log.Printf("Couldn't get ReplicaSet %s/%s: %s\n", pod.Namespace, owner.Name, err)
+			continue
+		}
+		if deployment := metaV1.GetControllerOf(rs); deployment != nil {
+			add(ownerReference(pod.Namespace, deployment))
+		}
+	}
+	return refs
+}
+
+func ownerReference(namespace string, owner *metaV1.OwnerReference) coreV1.ObjectReference {
+	return coreV1.ObjectReference{
+		Kind:       owner.Kind,
+		APIVersion: owner.APIVersion,
+		Namespace:  namespace,
+		Name:       owner.Name,
+		UID:        owner.UID,
+	}
+}
+
+func (r *Recorder) record(ref coreV1.ObjectReference, msg string) error {
+	now := metaV1.NewTime(time.Now())
+	_, err := r.Client.CoreV1().Events(ref.Namespace).Create(&coreV1.Event{
+		ObjectMeta: metaV1.ObjectMeta{
+			// Named the way kubectl and the kubelet name theirs
+			Name:      fmt.Sprintf("%s.%x", ref.Name, now.UnixNano()),
+			Namespace: ref.Namespace,
+		},
+		InvolvedObject: ref,
+		Reason:         ReasonSilenced,
+		Message:        msg,
+		Type:           coreV1.EventTypeWarning,
+		Source:         coreV1.EventSource{Component: Component},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	})
+	return err
+}
@@ -0,0 +1,57 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/open-fresh/bomb-squad/patrol"
+	"github.com/stretchr/testify/require"
+	appsV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func controlledBy(kind, apiVersion, name string, uid types.UID) []metaV1.OwnerReference {
+	controller := true
+	return []metaV1.OwnerReference{{Kind: kind, APIVersion: apiVersion, Name: name, UID: uid, Controller: &controller}}
+}
+
+func TestRecordSilence(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&appsV1.ReplicaSet{ObjectMeta: metaV1.ObjectMeta{
+			Namespace: "shop", Name: "api-5d8f", UID: "rs-uid",
+			OwnerReferences: controlledBy("Deployment", "apps/v1", "api", "deploy-uid"),
+		}},
+		&coreV1.Pod{ObjectMeta: metaV1.ObjectMeta{
+			Namespace: "shop", Name: "api-5d8f-a", UID: "pod-a",
+			OwnerReferences: controlledBy("ReplicaSet", "apps/v1", "api-5d8f", "rs-uid"),
+		}},
+		&coreV1.Pod{ObjectMeta: metaV1.ObjectMeta{
+			Namespace: "shop", Name: "api-5d8f-b", UID: "pod-b",
+			OwnerReferences: controlledBy("ReplicaSet", "apps/v1", "api-5d8f", "rs-uid"),
+		}},
+	)
+
+	NewRecorder(client).RecordSilence(patrol.Detection{
+		Metric: "http_requests_total",
+		Label:  "user_id",
+		Pods: []patrol.Pod{
+			{Namespace: "shop", Name: "api-5d8f-a"},
+			{Namespace: "shop", Name: "api-5d8f-b"},
+			{Namespace: "shop", Name: "gone"},
+		},
+	}, "replace")
+
+	events, err := client.CoreV1().Events("shop").List(metaV1.ListOptions{})
+	require.NoError(t, err)
+
+	kinds := map[string]int{}
+	for _, e := range events.Items {
+		kinds[e.InvolvedObject.Kind]++
+		require.Equal(t, ReasonSilenced, e.Reason)
+		require.Equal(t, coreV1.EventTypeWarning, e.Type)
+		require.Contains(t, e.Message, "label user_id of metric http_requests_total with action replace")
+	}
+	require.Equal(t, map[string]int{"Pod": 2, "ReplicaSet": 1, "Deployment": 1}, kinds)
+}
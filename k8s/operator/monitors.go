@@ -0,0 +1,343 @@
+package operator
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/open-fresh/bomb-squad/config"
+	promcfg "github.com/prometheus/prometheus/config"
+	yaml "gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// Group is the API group of the Prometheus Operator's custom resources
+	Group = "monitoring.coreos.com"
+	// Version is the API version of the Prometheus Operator's custom resources
+	Version = "v1"
+)
+
+// monitorKind describes one of the kinds of object the Prometheus Operator
+// generates scrape configs from
+type monitorKind struct {
+	// Plural is the resource name used in API paths
+	Plural string
+	// JobPrefix is how the Prometheus Operator prefixes the job names it generates
+	JobPrefix string
+	// EndpointsField is the spec field holding the list of scraped endpoints
+	EndpointsField string
+}
+
+var monitorKinds = []monitorKind{
+	{Plural: "servicemonitors", JobPrefix: "serviceMonitor", EndpointsField: "endpoints"},
+	{Plural: "podmonitors", JobPrefix: "podMonitor", EndpointsField: "podMetricsEndpoints"},
+}
+
+// monitor is a ServiceMonitor or PodMonitor, kept as raw JSON so that fields
+// Bomb Squad doesn't know about survive being written back
+type monitor struct {
+	kind monitorKind
+	obj  map[string]interface{}
+}
+
+func (m monitor) name() string {
+	meta, _ := m.obj["metadata"].(map[string]interface{})
+	name, _ := meta["name"].(string)
+	return name
+}
+
+func (m monitor) endpoints() []interface{} {
+	spec, _ := m.obj["spec"].(map[string]interface{})
+	endpoints, _ := spec[m.kind.EndpointsField].([]interface{})
+	return endpoints
+}
+
+// jobName returns the name the Prometheus Operator gives the scrape config it
+// generates for the endpoint at index i
+func (m monitor) jobName(namespace string, i int) string {
+	return fmt.Sprintf("%s/%s/%s/%d", m.kind.JobPrefix, namespace, m.name(), i)
+}
+
+// MonitorWrapper is a struct with public fields, which implements github.com/open-fresh/bomb-squad/config.Configurator
+// on top of the ServiceMonitors and PodMonitors in a namespace. The Prometheus
+// config it reads holds one scrape config per monitor endpoint, named the way the
+// Prometheus Operator names them, with that endpoint's metricRelabelings and
+// relabelings. Writing it back patches those of every endpoint that changed;
+// a sample_limit is refused, and anything else in the written config is
+// ignored.
+type MonitorWrapper struct {
+	// Client is any REST client for the cluster; requests use absolute paths
+	Client    rest.Interface
+	Namespace string
+}
+
+// NewMonitorWrapper returns a MonitorWrapper
+func NewMonitorWrapper(client rest.Interface, namespace string) *MonitorWrapper {
+	return &MonitorWrapper{
+		Client:    client,
+		Namespace: namespace,
+	}
+}
+
+// GetLocation implements github.com/open-fresh/bomb-squad/config.Configurator
+func (c *MonitorWrapper) GetLocation() string {
+	return fmt.Sprintf("/apis/%s/%s/namespaces/%s", Group, Version, c.Namespace)
+}
+
+// Read implements github.com/open-fresh/bomb-squad/config.Configurator
+func (c *MonitorWrapper) Read() ([]byte, error) {
+	monitors, err := c.list()
+	if err != nil {
+		return []byte{}, err
+	}
+
+	cfg := promcfg.Config{}
+	for _, m := range monitors {
+		for i, ep := range m.endpoints() {
+			sc := &promcfg.ScrapeConfig{JobName: m.jobName(c.Namespace, i)}
+			sc.MetricRelabelConfigs = readRelabelings(ep, metricRelabelingsField, sc.JobName)
+			sc.RelabelConfigs = readRelabelings(ep, relabelingsField, sc.JobName)
+			cfg.ScrapeConfigs = append(cfg.ScrapeConfigs, sc)
+		}
+	}
+
+	return yaml.Marshal(cfg)
+}
+
+// Write implements github.com/open-fresh/bomb-squad/config.Configurator
+func (c *MonitorWrapper) Write(data []byte) error {
+	cfg := promcfg.Config{}
+	err := yaml.Unmarshal(data, &cfg)
+	if err != nil {
+		return fmt.Errorf("Couldn't unmarshal into prometheus.Config: %s", err)
+	}
+
+	desired := map[string]*promcfg.ScrapeConfig{}
+	for _, sc := range cfg.ScrapeConfigs {
+		// Read never sets a sample_limit, so one here was meant to be applied
+		if sc.SampleLimit != 0 {
+			return fmt.Errorf("Can't set the sample_limit of %s through its monitor", sc.JobName)
+		}
+		desired[sc.JobName] = sc
+	}
+
+	monitors, err := c.list()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range monitors {
+		changed := false
+		endpoints := m.endpoints()
+		for i, ep := range endpoints {
+			want, ok := desired[m.jobName(c.Namespace, i)]
+			if !ok {
+				continue
+			}
+
+			epMap, ok := ep.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			for field, rcs := range map[string][]*promcfg.RelabelConfig{
+				metricRelabelingsField: want.MetricRelabelConfigs,
+				relabelingsField:       want.RelabelConfigs,
+			} {
+				updated, same := mergeRelabelings(relabelings(ep, field), rcs)
+				if same {
+					continue
+				}
+
+				if len(updated) == 0 {
+					delete(epMap, field)
+				} else {
+					epMap[field] = updated
+				}
+				changed = true
+			}
+		}
+
+		if changed {
+			err = c.patchEndpoints(m, endpoints)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mergeRelabelings returns the operator form of want, reusing the existing raw
+// entries for rules that are already present so they're written back exactly
+// as their authors wrote them. It also reports whether want and current
+// describe the same rules.
+func mergeRelabelings(current []interface{}, want []*promcfg.RelabelConfig) ([]interface{}, bool) {
+	parsed := make([]*promcfg.RelabelConfig, len(current))
+	for i, raw := range current {
+		rc, err := fromOperator(raw)
+		if err == nil {
+			parsed[i] = &rc
+		}
+	}
+
+	same := len(current) == len(want)
+	out := []interface{}{}
+	for i, rc := range want {
+		var match interface{}
+		for j, p := range parsed {
+			if p != nil && config.RelabelConfigsEqual(*p, *rc) {
+				match = current[j]
+				if i != j {
+					same = false
+				}
+				break
+			}
+		}
+		if match == nil {
+			same = false
+			match = toOperator(*rc)
+		}
+		out = append(out, match)
+	}
+	return out, same
+}
+
+// Endpoint fields holding the relabelling rules the Prometheus Operator turns
+// into metric_relabel_configs and relabel_configs
+const (
+	metricRelabelingsField = "metricRelabelings"
+	relabelingsField       = "relabelings"
+)
+
+func relabelings(ep interface{}, field string) []interface{} {
+	epMap, _ := ep.(map[string]interface{})
+	rcs, _ := epMap[field].([]interface{})
+	return rcs
+}
+
+// readRelabelings returns the rules in the given field of ep, skipping any
+// that can't be read
+func readRelabelings(ep interface{}, field, jobName string) []*promcfg.RelabelConfig {
+	var out []*promcfg.RelabelConfig
+	for _, raw := range relabelings(ep, field) {
+		rc, err := fromOperator(raw)
+		if err != nil {
+			log.Printf("Skipping unreadable %s entry in %s: %s\n", field, jobName, err)
+			continue
+		}
+		out = append(out, &rc)
+	}
+	return out
+}
+
+// operatorFields maps Prometheus relabel config keys to the Prometheus
+// Operator's camelCase equivalents
+var operatorFields = map[string]string{
+	"source_labels": "sourceLabels",
+	"separator":     "separator",
+	"regex":         "regex",
+	"modulus":       "modulus",
+	"target_label":  "targetLabel",
+	"replacement":   "replacement",
+	"action":        "action",
+}
+
+func fromOperator(raw interface{}) (promcfg.RelabelConfig, error) {
+	in, ok := raw.(map[string]interface{})
+	if !ok {
+		return promcfg.RelabelConfig{}, fmt.Errorf("expected an object, got %T", raw)
+	}
+
+	out := map[string]interface{}{}
+	for promKey, operatorKey := range operatorFields {
+		if v, ok := in[operatorKey]; ok {
+			out[promKey] = v
+		}
+	}
+
+	b, err := yaml.Marshal(out)
+	if err != nil {
+		return promcfg.RelabelConfig{}, err
+	}
+	rc := promcfg.RelabelConfig{}
+	err = yaml.Unmarshal(b, &rc)
+	return rc, err
+}
+
+func toOperator(rc promcfg.RelabelConfig) map[string]interface{} {
+	// Going through YAML keeps the regex as it was written, rather than in its
+	// anchored, compiled form
+	b, _ := yaml.Marshal(rc)
+	in := map[string]interface{}{}
+	_ = yaml.Unmarshal(b, &in)
+
+	out := map[string]interface{}{}
+	for promKey, operatorKey := range operatorFields {
+		if v, ok := in[promKey]; ok {
+			if l, ok := v.([]interface{}); ok {
+				labels := []string{}
+				for _, label := range l {
+					labels = append(labels, fmt.Sprint(label))
+				}
+				v = labels
+			}
+			out[operatorKey] = v
+		}
+	}
+	return out
+}
+
+func (c *MonitorWrapper) list() ([]monitor, error) {
+	monitors := []monitor{}
+	for _, kind := range monitorKinds {
+		b, err := c.Client.Get().AbsPath(c.GetLocation(), kind.Plural).DoRaw()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list %s: %s", kind.Plural, err)
+		}
+
+		l := struct {
+			Items []map[string]interface{} `json:"items"`
+		}{}
+		err = json.Unmarshal(b, &l)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't unmarshal %s: %s", kind.Plural, err)
+		}
+
+		for _, obj := range l.Items {
+			monitors = append(monitors, monitor{kind: kind, obj: obj})
+		}
+	}
+	return monitors, nil
+}
+
+func (c *MonitorWrapper) patchEndpoints(m monitor, endpoints []interface{}) error {
+	meta := map[string]interface{}{}
+	if objMeta, ok := m.obj["metadata"].(map[string]interface{}); ok {
+		// Including the resourceVersion makes the apiserver reject the patch if
+		// the monitor changed since we read it
+		if rv, ok := objMeta["resourceVersion"]; ok {
+			meta["resourceVersion"] = rv
+		}
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": meta,
+		"spec": map[string]interface{}{
+			m.kind.EndpointsField: endpoints,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Client.Patch(types.MergePatchType).AbsPath(c.GetLocation(), m.kind.Plural, m.name()).Body(patch).DoRaw()
+	if err != nil {
+		return fmt.Errorf("Failed to patch relabelings of %s %s: %s", strings.TrimSuffix(m.kind.Plural, "s"), m.name(), err)
+	}
+	log.Printf("Patched relabelings of %s %s\n", strings.TrimSuffix(m.kind.Plural, "s"), m.name())
+	return nil
+}
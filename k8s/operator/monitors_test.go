@@ -0,0 +1,128 @@
+package operator
+
+import (
+	"testing"
+
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/stretchr/testify/require"
+)
+
+func newServiceMonitor() map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "monitoring.coreos.com/v1",
+		"kind":       "ServiceMonitor",
+		"metadata":   map[string]interface{}{"name": "api", "namespace": "testNamespace", "resourceVersion": "7"},
+		"spec": map[string]interface{}{
+			"endpoints": []interface{}{
+				map[string]interface{}{
+					"port": "web",
+					"metricRelabelings": []interface{}{
+						map[string]interface{}{"sourceLabels": []interface{}{"__name__"}, "regex": "go_.*", "action": "drop"},
+					},
+				},
+				map[string]interface{}{"port": "admin"},
+			},
+		},
+	}
+}
+
+func newPodMonitor() map[string]interface{} {
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "worker", "namespace": "testNamespace"},
+		"spec": map[string]interface{}{
+			"podMetricsEndpoints": []interface{}{map[string]interface{}{"port": "metrics"}},
+		},
+	}
+}
+
+func TestCanReadMonitorsAsPromConfig(t *testing.T) {
+	api := bstesting.NewFakeAPIServer(t)
+	defer api.Close()
+	mw := NewMonitorWrapper(api.RESTClient(), "testNamespace")
+	api.Put(mw.GetLocation()+"/servicemonitors", newServiceMonitor())
+	api.Put(mw.GetLocation()+"/podmonitors", newPodMonitor())
+
+	pcfg, err := config.ReadPromConfig(mw)
+	require.NoError(t, err)
+	require.Len(t, pcfg.ScrapeConfigs, 3)
+	require.Equal(t, "serviceMonitor/testNamespace/api/0", pcfg.ScrapeConfigs[0].JobName)
+	require.Len(t, pcfg.ScrapeConfigs[0].MetricRelabelConfigs, 1)
+	require.Equal(t, "serviceMonitor/testNamespace/api/1", pcfg.ScrapeConfigs[1].JobName)
+	require.Equal(t, "podMonitor/testNamespace/worker/0", pcfg.ScrapeConfigs[2].JobName)
+}
+
+func TestSilenceIsPatchedIntoOwningMonitorAndRemoved(t *testing.T) {
+	api := bstesting.NewFakeAPIServer(t)
+	defer api.Close()
+	mw := NewMonitorWrapper(api.RESTClient(), "testNamespace")
+	api.Put(mw.GetLocation()+"/servicemonitors", newServiceMonitor())
+	api.Put(mw.GetLocation()+"/podmonitors", newPodMonitor())
+	bc := bstesting.NewMemoryConfigurator(t, []byte{})
+
+	hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar", Jobs: []string{"serviceMonitor/testNamespace/api/0"}}
+	mrc, err := config.GenerateMetricRelabelConfig(hcs)
+	require.NoError(t, err)
+	pcfg, err := config.InsertMetricRelabelConfigToJobs(mrc, hcs.Jobs, mw)
+	require.NoError(t, err)
+	require.NoError(t, config.WritePromConfig(pcfg, mw))
+	require.NoError(t, config.StoreMetricRelabelConfigBombSquad(hcs, mrc, bc))
+
+	sm := api.Objects(mw.GetLocation() + "/servicemonitors")[0]
+	endpoints := sm["spec"].(map[string]interface{})["endpoints"].([]interface{})
+	web := endpoints[0].(map[string]interface{})
+	require.Len(t, web["metricRelabelings"], 2)
+	require.Equal(t, map[string]interface{}{"sourceLabels": []interface{}{"__name__"}, "regex": "go_.*", "action": "drop"}, web["metricRelabelings"].([]interface{})[0])
+	require.Equal(t, "bs_silence", web["metricRelabelings"].([]interface{})[1].(map[string]interface{})["replacement"])
+	require.Nil(t, endpoints[1].(map[string]interface{})["metricRelabelings"])
+	require.Nil(t, api.Objects(mw.GetLocation() + "/podmonitors")[0]["spec"].(map[string]interface{})["podMetricsEndpoints"].([]interface{})[0].(map[string]interface{})["metricRelabelings"])
+
+	d, err := config.Reconcile(mw, bc)
+	require.NoError(t, err)
+	require.True(t, d.Empty())
+
+	require.NoError(t, config.RemoveSilence("foo.bar", mw, bc))
+	sm = api.Objects(mw.GetLocation() + "/servicemonitors")[0]
+	web = sm["spec"].(map[string]interface{})["endpoints"].([]interface{})[0].(map[string]interface{})
+	require.Len(t, web["metricRelabelings"], 1)
+}
+
+func TestQuarantineIsPatchedIntoRelabelings(t *testing.T) {
+	api := bstesting.NewFakeAPIServer(t)
+	defer api.Close()
+	mw := NewMonitorWrapper(api.RESTClient(), "testNamespace")
+	api.Put(mw.GetLocation()+"/servicemonitors", newServiceMonitor())
+	api.Put(mw.GetLocation()+"/podmonitors", newPodMonitor())
+	bc := bstesting.NewMemoryConfigurator(t, []byte{})
+
+	q := config.Quarantine{Mode: config.QuarantineDrop, Jobs: []string{"podMonitor/testNamespace/worker/0"}}
+	require.NoError(t, config.ApplyQuarantine("pod:testNamespace/worker-0", q, mw, bc))
+
+	ep := api.Objects(mw.GetLocation() + "/podmonitors")[0]["spec"].(map[string]interface{})["podMetricsEndpoints"].([]interface{})[0].(map[string]interface{})
+	require.Len(t, ep["relabelings"], 1)
+	rule := ep["relabelings"].([]interface{})[0].(map[string]interface{})
+	require.Equal(t, "drop", rule["action"])
+	require.Equal(t, "bs_quarantine", rule["replacement"])
+	require.Nil(t, api.Objects(mw.GetLocation() + "/servicemonitors")[0]["spec"].(map[string]interface{})["endpoints"].([]interface{})[0].(map[string]interface{})["relabelings"])
+
+	require.NoError(t, config.RemoveQuarantine("pod:testNamespace/worker-0", mw, bc))
+	ep = api.Objects(mw.GetLocation() + "/podmonitors")[0]["spec"].(map[string]interface{})["podMetricsEndpoints"].([]interface{})[0].(map[string]interface{})
+	require.Nil(t, ep["relabelings"])
+}
+
+func TestEnsurePrometheusRule(t *testing.T) {
+	api := bstesting.NewFakeAPIServer(t)
+	defer api.Close()
+	client := api.RESTClient()
+	rules := []byte("groups:\n- name: bomb_squad_card_counter\n  rules:\n  - record: card_count\n    expr: count by(__name__) ({__name__!=\"\"})\n")
+	labels := map[string]string{"prometheus": "k8s"}
+
+	require.NoError(t, EnsurePrometheusRule(client, "testNamespace", "bomb-squad", labels, rules))
+	objects := api.Objects("/apis/monitoring.coreos.com/v1/namespaces/testNamespace/prometheusrules")
+	require.Len(t, objects, 1)
+	groups := objects[0]["spec"].(map[string]interface{})["groups"].([]interface{})
+	require.Equal(t, "bomb_squad_card_counter", groups[0].(map[string]interface{})["name"])
+
+	require.NoError(t, EnsurePrometheusRule(client, "testNamespace", "bomb-squad", labels, rules))
+	require.Len(t, api.Objects("/apis/monitoring.coreos.com/v1/namespaces/testNamespace/prometheusrules"), 1)
+}
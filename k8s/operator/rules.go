@@ -0,0 +1,98 @@
+package operator
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+)
+
+// EnsurePrometheusRule makes sure a PrometheusRule called name exists in
+// namespace, carrying the rule groups in rules (a Prometheus rule file) and
+// the given labels, which must match the Prometheus object's ruleSelector. An
+// existing PrometheusRule is only patched if it differs.
+func EnsurePrometheusRule(client rest.Interface, namespace, name string, labels map[string]string, rules []byte) error {
+	j, err := yaml.YAMLToJSON(rules)
+	if err != nil {
+		return fmt.Errorf("Couldn't convert rules to JSON: %s", err)
+	}
+	spec := map[string]interface{}{}
+	err = json.Unmarshal(j, &spec)
+	if err != nil {
+		return fmt.Errorf("Couldn't unmarshal rules: %s", err)
+	}
+
+	collection := fmt.Sprintf("/apis/%s/%s/namespaces/%s/prometheusrules", Group, Version, namespace)
+
+	b, err := client.Get().AbsPath(collection, name).DoRaw()
+	if errors.IsNotFound(err) {
+		obj, err := json.Marshal(map[string]interface{}{
+			"apiVersion": Group + "/" + Version,
+			"kind":       "PrometheusRule",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": namespace,
+				"labels":    labels,
+			},
+			"spec": spec,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = client.Post().AbsPath(collection).SetHeader("Content-Type", "application/json").Body(obj).DoRaw()
+		if err != nil {
+			return fmt.Errorf("Failed to create PrometheusRule %s: %s", name, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to get PrometheusRule %s: %s", name, err)
+	}
+
+	existing := struct {
+		Metadata struct {
+			Labels          map[string]string `json:"labels"`
+			ResourceVersion string            `json:"resourceVersion"`
+		} `json:"metadata"`
+		Spec map[string]interface{} `json:"spec"`
+	}{}
+	err = json.Unmarshal(b, &existing)
+	if err != nil {
+		return fmt.Errorf("Couldn't unmarshal PrometheusRule %s: %s", name, err)
+	}
+
+	if reflect.DeepEqual(existing.Spec, spec) && labelsPresent(existing.Metadata.Labels, labels) {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":          labels,
+			"resourceVersion": existing.Metadata.ResourceVersion,
+		},
+		"spec": spec,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Patch(types.MergePatchType).AbsPath(collection, name).Body(patch).DoRaw()
+	if err != nil {
+		return fmt.Errorf("Failed to patch PrometheusRule %s: %s", name, err)
+	}
+	return nil
+}
+
+func labelsPresent(have, want map[string]string) bool {
+	for k, v := range want {
+		if have[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,192 @@
+// Package rollouts correlates cardinality explosions with the Deployment and
+// StatefulSet rollouts that most likely caused them
+package rollouts
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/patrol"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// revisionAnnotation is where the Deployment controller numbers the
+	// revisions of a Deployment on its ReplicaSets
+	revisionAnnotation = "deployment.kubernetes.io/revision"
+
+	// maxAge is how long before an explosion a rollout can be and still be
+	// a suspect
+	maxAge = 24 * time.Hour
+	// grace allows for rollouts that appear to come just after the explosion
+	// started, as its start is only known to the minute
+	grace = 2 * time.Minute
+)
+
+// Correlator finds the rollout behind an explosion from the pods its series
+// came from
+type Correlator struct {
+	Client kubernetes.Interface
+}
+
+// NewCorrelator returns a Correlator that uses client
+func NewCorrelator(client kubernetes.Interface) *Correlator {
+	return &Correlator{Client: client}
+}
+
+// workload is a Deployment or StatefulSet
+type workload struct {
+	kind, namespace, name string
+	uid                   types.UID
+}
+
+// Culprit implements patrol.RolloutCorrelator. Of the revisions of the
+// workloads behind pods, it picks the one rolled out most recently before
+// start, allowing a couple of minutes either way, and no more than a day
+// before.
+func (c *Correlator) Culprit(pods []patrol.Pod, start time.Time) (*config.Rollout, error) {
+	var culprit *config.Rollout
+	for _, w := range c.workloads(pods) {
+		revisions, err := c.revisions(w)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list revisions of %s %s/%s: %s", w.kind, w.namespace, w.name, err)
+		}
+
+		for i, r := range revisions {
+			if r.CreatedAt.After(start.Add(grace)) || r.CreatedAt.Before(start.Add(-maxAge)) {
+				continue
+			}
+			if culprit != nil && !r.CreatedAt.After(culprit.CreatedAt) {
+				continue
+			}
+			r := r
+			if i > 0 {
+				r.ChangedImages = changedImages(revisions[i-1].Images, r.Images)
+			}
+			r.ExplosionStartedAt = start
+			culprit = &r
+		}
+	}
+	return culprit, nil
+}
+
+// workloads resolves pods to the Deployments and StatefulSets that own them
+func (c *Correlator) workloads(pods []patrol.Pod) []workload {
+	out := []workload{}
+	seen := map[types.UID]bool{}
+	for _, p := range pods {
+		pod, err := c.Client.CoreV1().Pods(p.Namespace).Get(p.Name, metaV1.GetOptions{})
+		if err != nil {
+			log.Printf("Couldn't get pod %s/%s: %s\n", p.Namespace, p.Name, err)
+			continue
+		}
+
+		owner := metaV1.GetControllerOf(pod)
+		if owner != nil && owner.Kind == "ReplicaSet" {
+			rs, err := c.Client.AppsV1().ReplicaSets(pod.Namespace).Get(owner.Name, metaV1.GetOptions{})
+			if err != nil {
+				log.Printf("Couldn't get ReplicaSet %s/%s: %s\n", pod.Namespace, owner.Name, err)
+				continue
+			}
+			owner = metaV1.GetControllerOf(rs)
+		}
+		if owner == nil || (owner.Kind != "Deployment" && owner.Kind != "StatefulSet") || seen[owner.UID] {
+			continue
+		}
+		seen[owner.UID] = true
+		out = append(out, workload{kind: owner.Kind, namespace: pod.Namespace, name: owner.Name, uid: owner.UID})
+	}
+	return out
+}
+
+// revisions returns the revisions of w, oldest first
+func (c *Correlator) revisions(w workload) ([]config.Rollout, error) {
+	out := []config.Rollout{}
+	switch w.kind {
+	case "Deployment":
+		list, err := c.Client.AppsV1().ReplicaSets(w.namespace).List(metaV1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, rs := range list.Items {
+			if !controlledBy(&rs, w.uid) {
+				continue
+			}
+			revision, err := strconv.ParseInt(rs.Annotations[revisionAnnotation], 10, 64)
+			if err != nil {
+				continue
+			}
+			out = append(out, rollout(w, revision, rs.CreationTimestamp.Time, rs.Spec.Template.Spec))
+		}
+
+	case "StatefulSet":
+		list, err := c.Client.AppsV1().ControllerRevisions(w.namespace).List(metaV1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, cr := range list.Items {
+			if !controlledBy(&cr, w.uid) {
+				continue
+			}
+			// The StatefulSet controller stores a patch holding the pod template
+			patch := struct {
+				Spec struct {
+					Template coreV1.PodTemplateSpec `json:"template"`
+				} `json:"spec"`
+			}{}
+			if len(cr.Data.Raw) > 0 {
+				if err := json.Unmarshal(cr.Data.Raw, &patch); err != nil {
+					log.Printf("Couldn't decode ControllerRevision %s/%s: %s\n", cr.Namespace, cr.Name, err)
+				}
+			}
+			out = append(out, rollout(w, cr.Revision, cr.CreationTimestamp.Time, patch.Spec.Template.Spec))
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Revision < out[j].Revision })
+	return out, nil
+}
+
+func controlledBy(obj metaV1.Object, uid types.UID) bool {
+	owner := metaV1.GetControllerOf(obj)
+	return owner != nil && owner.UID == uid
+}
+
+func rollout(w workload, revision int64, created time.Time, spec coreV1.PodSpec) config.Rollout {
+	r := config.Rollout{
+		Kind:      w.kind,
+		Namespace: w.namespace,
+		Name:      w.name,
+		Revision:  revision,
+		CreatedAt: created.UTC(),
+	}
+	for _, container := range spec.Containers {
+		r.Images = append(r.Images, container.Image)
+	}
+	return r
+}
+
+// changedImages returns the images in current that weren't in previous
+func changedImages(previous, current []string) []string {
+	old := map[string]bool{}
+	for _, image := range previous {
+		old[image] = true
+	}
+	changed := []string{}
+	for _, image := range current {
+		if !old[image] {
+			changed = append(changed, image)
+		}
+	}
+	return changed
+}
+
+var _ patrol.RolloutCorrelator = &Correlator{}
@@ -0,0 +1,92 @@
+package rollouts
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/patrol"
+	"github.com/stretchr/testify/require"
+	appsV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func owners(kind, name string, uid types.UID) []metaV1.OwnerReference {
+	controller := true
+	return []metaV1.OwnerReference{{Kind: kind, APIVersion: "apps/v1", Name: name, UID: uid, Controller: &controller}}
+}
+
+func replicaSet(name, revision, image string, created time.Time) *appsV1.ReplicaSet {
+	rs := &appsV1.ReplicaSet{ObjectMeta: metaV1.ObjectMeta{
+		Namespace:         "shop",
+		Name:              name,
+		UID:               types.UID(name),
+		Annotations:       map[string]string{revisionAnnotation: revision},
+		CreationTimestamp: metaV1.NewTime(created),
+		OwnerReferences:   owners("Deployment", "api", "deploy-uid"),
+	}}
+	rs.Spec.Template.Spec.Containers = []coreV1.Container{{Name: "api", Image: image}, {Name: "proxy", Image: "envoy:1.8"}}
+	return rs
+}
+
+func TestCulpritDeployment(t *testing.T) {
+	start := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	client := fake.NewSimpleClientset(
+		replicaSet("api-1", "1", "api:1.0", start.Add(-72*time.Hour)),
+		replicaSet("api-2", "2", "api:1.1", start.Add(-20*time.Minute)),
+		replicaSet("api-3", "3", "api:1.2", start.Add(time.Hour)),
+		&coreV1.Pod{ObjectMeta: metaV1.ObjectMeta{
+			Namespace: "shop", Name: "api-3-a",
+			OwnerReferences: owners("ReplicaSet", "api-3", "api-3"),
+		}},
+	)
+
+	c := NewCorrelator(client)
+	culprit, err := c.Culprit([]patrol.Pod{{Namespace: "shop", Name: "api-3-a"}}, start)
+	require.NoError(t, err)
+	require.NotNil(t, culprit)
+	require.Equal(t, "Deployment", culprit.Kind)
+	require.Equal(t, "api", culprit.Name)
+	require.Equal(t, int64(2), culprit.Revision)
+	require.Equal(t, []string{"api:1.1", "envoy:1.8"}, culprit.Images)
+	require.Equal(t, []string{"api:1.1"}, culprit.ChangedImages)
+	require.Equal(t, start, culprit.ExplosionStartedAt)
+
+	// Nothing was rolled out within a day before
+	culprit, err = c.Culprit([]patrol.Pod{{Namespace: "shop", Name: "api-3-a"}}, start.Add(-25*time.Hour))
+	require.NoError(t, err)
+	require.Nil(t, culprit)
+}
+
+func TestCulpritStatefulSet(t *testing.T) {
+	start := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	revision := func(n int64, data string, created time.Time) *appsV1.ControllerRevision {
+		return &appsV1.ControllerRevision{
+			ObjectMeta: metaV1.ObjectMeta{
+				Namespace: "shop", Name: fmt.Sprintf("db-%d", n),
+				CreationTimestamp: metaV1.NewTime(created),
+				OwnerReferences:   owners("StatefulSet", "db", "sts-uid"),
+			},
+			Revision: n,
+			Data:     runtime.RawExtension{Raw: []byte(data)},
+		}
+	}
+	client := fake.NewSimpleClientset(
+		revision(1, `{"spec":{"template":{"spec":{"containers":[{"name":"db","image":"db:1"}]}}}}`, start.Add(-time.Hour)),
+		revision(2, `{"spec":{"template":{"spec":{"containers":[{"name":"db","image":"db:2"}]}}}}`, start.Add(time.Minute)),
+		&coreV1.Pod{ObjectMeta: metaV1.ObjectMeta{
+			Namespace: "shop", Name: "db-0",
+			OwnerReferences: owners("StatefulSet", "db", "sts-uid"),
+		}},
+	)
+
+	culprit, err := NewCorrelator(client).Culprit([]patrol.Pod{{Namespace: "shop", Name: "db-0"}}, start)
+	require.NoError(t, err)
+	require.NotNil(t, culprit)
+	require.Equal(t, int64(2), culprit.Revision)
+	require.Equal(t, []string{"db:2"}, culprit.ChangedImages)
+}
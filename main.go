@@ -8,15 +8,27 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/open-fresh/bomb-squad/alertmanager"
+	"github.com/open-fresh/bomb-squad/api"
+	"github.com/open-fresh/bomb-squad/auth"
+	"github.com/open-fresh/bomb-squad/cli"
 	"github.com/open-fresh/bomb-squad/config"
 	configmap "github.com/open-fresh/bomb-squad/k8s/configmap"
+	"github.com/open-fresh/bomb-squad/k8s/crd"
+	k8sevents "github.com/open-fresh/bomb-squad/k8s/events"
+	"github.com/open-fresh/bomb-squad/k8s/operator"
+	"github.com/open-fresh/bomb-squad/k8s/rollouts"
+	"github.com/open-fresh/bomb-squad/notify"
 	"github.com/open-fresh/bomb-squad/patrol"
 	"github.com/open-fresh/bomb-squad/prom"
+	"github.com/open-fresh/bomb-squad/ui"
 	"github.com/open-fresh/bomb-squad/util"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/model"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -28,10 +40,47 @@ var (
 	inK8s              = flag.Bool("k8s", true, "Whether bomb-squad is being deployed in a Kubernetes cluster")
 	k8sNamespace       = flag.String("k8s-namespace", "default", "Kubernetes namespace holding Prometheus ConfigMap")
 	k8sConfigMapName   = flag.String("k8s-configmap", "prometheus", "Name of the Kubernetes ConfigMap holding Prometheus configuration")
+	rulesSource        = flag.String("rules-source", "/etc/bomb-squad/rules.yaml", "Path of the recording rules file shipped with Bomb Squad")
+	rulesDest          = flag.String("rules-dest", "/etc/config/bomb-squad/rules.yaml", "Path at which Prometheus finds Bomb Squad's recording rules, added to its rule_files. Bomb Squad writes the rules there itself unless rules-configmap-key is set")
+	rulesConfigMapKey  = flag.String("rules-configmap-key", "", "If set, store the recording rules under this key of the Prometheus ConfigMap instead of writing them to rules-dest, which must then be where Prometheus has that key mounted")
+	rulesCheckInterval = flag.Duration("rules-check-interval", 10*time.Second, "How often to retry bootstrapping and to check that Prometheus has loaded the recording rules")
+	promBackend        = flag.String("prom-backend", "configmap", "How Bomb Squad applies silences to Prometheus: 'configmap' to edit the prom-config-loc key of the Prometheus ConfigMap, or 'operator' to patch the metricRelabelings of Prometheus Operator ServiceMonitors and PodMonitors in k8s-namespace")
+	operatorRuleName   = flag.String("operator-rule-name", "bomb-squad", "Name of the PrometheusRule holding Bomb Squad's recording rules, when prom-backend is 'operator'")
+	operatorRuleLabels = flag.String("operator-rule-labels", "", "Comma-separated key=value labels to put on the PrometheusRule so that the Prometheus ruleSelector picks it up, when prom-backend is 'operator'")
+	stateBackend       = flag.String("state-backend", "configmap", "Where Bomb Squad keeps its silences: 'configmap' to use the bs-config-loc key of the Prometheus ConfigMap, or 'crd' to use CardinalitySilence objects in k8s-namespace")
 	bsConfigLocation   = flag.String("bs-config-loc", "bomb-squad", "Where the Bomb Squad Config lives. For K8s deployments, this should be the ConfigMap.Data key. Otherwise, full path to file.")
 	promConfigLocation = flag.String("prom-config-loc", "prometheus.yml", "Where the Prometheus lives. For K8s deployments, this should be the ConfigMap.Data key. Otherwise, full path to file.")
 	metricsPort        = flag.Int("metrics-port", 8080, "Port on which to listen for metric scrapes")
 	promURL            = flag.String("prom-url", "http://localhost:9090", "Prometheus URL to query")
+	reconcileInterval  = flag.Duration("reconcile-interval", time.Minute, "How often to reconcile the Prometheus config with the silences recorded by Bomb Squad")
+	authConfig         = flag.String("auth-config", "", "Path of a YAML file mapping bearer tokens and TLS client certificate common names to the read or write role. If unset, every endpoint is open to anyone")
+	tlsCert            = flag.String("tls-cert", "", "Certificate to serve HTTPS with. Requires tls-key")
+	tlsKey             = flag.String("tls-key", "", "Key of tls-cert")
+	tlsClientCA        = flag.String("tls-client-ca", "", "CA certificates to verify TLS client certificates against, so that clients can authenticate with them")
+	alertmanagerURLs   = flag.String("alertmanager-url", "", "Comma-separated URLs of Alertmanagers to send alerts about detections and silences to, through their v2 API")
+	notifyConfig       = flag.String("notify-config", "", "Path of a YAML file describing webhooks to send detection, silence, unsilence, failure, drift, quarantine, release, escalation, breaker and proposal events to")
+	workloadEvents     = flag.Bool("workload-events", true, "Record a Kubernetes Event on the pods, ReplicaSets and Deployments behind every label the patrol silences, found from the namespace and pod labels of its series. Needs permission to get pods and replicasets, and to create events")
+	correlateRollouts  = flag.Bool("correlate-rollouts", true, "Look for the Deployment or StatefulSet revision rolled out shortly before each explosion, and record it with the silence. Needs permission to get pods, and to list replicasets and controllerrevisions")
+	quarantineAfter    = flag.Int("quarantine-after", 0, "Quarantine a scrape target when at least this many of the metrics found exploding in one patrol cycle come from it alone. 0 turns automatic quarantines off")
+	quarantineMode     = flag.String("quarantine-mode", config.QuarantineDrop, "How the patrol quarantines targets: 'drop' to drop the target with a relabel_configs rule, or 'sample_limit' to set quarantine-sample-limit on its jobs")
+	quarantineLimit    = flag.Uint("quarantine-sample-limit", 10000, "The sample_limit set on a quarantined target's jobs when quarantine-mode is 'sample_limit'. It applies to every target of those jobs")
+	quarantineTTL      = flag.Duration("quarantine-ttl", time.Duration(config.DefaultQuarantineTTL), "How long automatic quarantines last")
+	escalationCycles   = flag.Int("escalation-cycles", 0, "Check this many patrol cycles after the patrol silences a label, or escalates its silence, that the metric's card_count stopped growing, and escalate the silence if not. 0 turns checking off")
+	escalationLadder   = flag.String("escalation-ladder", strings.Join(patrol.DefaultLadder, ","), "Comma-separated steps a silence is escalated through while its metric keeps growing, from the mildest to the harshest: any of replace, labeldrop and drop, then quarantine to quarantine the single instance the metric comes from")
+	maxSilencesPerHour = flag.Int("max-silences-per-hour", 0, "Trip the circuit breaker, leaving the patrol to only report explosions until it is reset, rather than apply more than this many silences in an hour. 0 means no limit")
+	maxMetricsPerJob   = flag.Int("max-metrics-per-job", 0, "Trip the circuit breaker rather than have the patrol silence more than this many metrics of a single job. 0 means no limit")
+	requireApproval    = flag.Bool("require-approval", false, "Have the patrol propose silences, with the evidence for them, rather than apply them, until they're approved with 'bs approve' or the API")
+	autoApplyAfter     = flag.Duration("auto-apply-after", 0, "With require-approval, apply a proposed silence without approval this long after proposing it, if its label is still exploding. 0 waits for approval however long it takes")
+	impactPolicy       = flag.String("impact-policy", patrol.ImpactWarn, "What to do about silences that may break recording or alerting rules, found in the Prometheus config's rule_files and Prometheus' rules API: 'off' not to look, 'warn' to record the rules with the silence, or 'block' to refuse the silence unless it's forced or approved by hand")
+	ruleFilesDir       = flag.String("rule-files-dir", "", "Directory relative rule_files in the Prometheus config are read from when looking for the rules a silence may break. Defaults to the directory Bomb Squad runs in")
+	allowPurge         = flag.Bool("allow-purge", false, "Allow 'bs purge' and the API to delete the series a silenced label exploded into from Prometheus' TSDB, through its admin API. Prometheus must run with --web.enable-admin-api")
+	purgeSnapshot      = flag.Bool("purge-snapshot", true, "Take a TSDB snapshot before purging series, so that they can be restored")
+	purgeWindow        = flag.Duration("purge-window", time.Hour, "How long before a silence was applied the series purged for it start")
+	remoteWrite        = flag.String("remote-write-silences", "", "Comma-separated URLs of remote_write endpoints whose write_relabel_configs the patrol's silences are added to as well, or * for every endpoint, so that exploding series aren't forwarded")
+	predictCollisions  = flag.Bool("predict-collisions", false, "Have the patrol drop an exploding metric, rather than replace its label, when its other labels wouldn't keep its series apart, as Prometheus rejects the samples of series that collide")
+	collisionWatch     = flag.Duration("collision-watch", 5*time.Minute, "How long after the patrol applies a replace or labeldrop silence it watches prometheus_target_scrapes_sample_duplicate_timestamp_total, switching the silence to drop if its series collide. 0 turns watching off")
+	baselineWindow     = flag.Duration("baseline-window", 0, "Have the patrol's replace silences keep the values their label had over this long before it started exploding, learned from the series API, and rewrite only the others. 0 rewrites every value")
+	baselineMaxValues  = flag.Int("baseline-max-values", 100, "The most label values a baseline may keep. A label that had more before exploding is silenced without one. 0 means no limit")
 	getVersion         = flag.Bool("version", false, "return version information and exit")
 	versionGauge       = prometheus.NewGauge(
 		prometheus.GaugeOpts{
@@ -45,60 +94,199 @@ var (
 			},
 		},
 	)
-	k8sClientSet     kubernetes.Interface
-	promConfigurator config.Configurator
-	bsConfigurator   config.Configurator
+	k8sClientSet      kubernetes.Interface
+	promConfigurator  config.Configurator
+	bsConfigurator    config.Configurator
+	rulesConfigurator config.Configurator
 )
 
 func init() {
 	prometheus.MustRegister(versionGauge)
 	prometheus.MustRegister(patrol.ExplodingLabelGauge)
+	prometheus.MustRegister(patrol.DriftDetectedGauge)
+	prometheus.MustRegister(patrol.BreakerTrippedGauge)
 }
 
-func bootstrap(c config.Configurator) {
-	// TODO: Don't do this file write if the file already exists, but DO write the file
-	// if it's not present on disk but still present in the ConfigMap
-	b, err := ioutil.ReadFile("/etc/bomb-squad/rules.yaml")
+// readRules reads the recording rules Bomb Squad needs from rules-source
+func readRules() ([]byte, error) {
+	b, err := ioutil.ReadFile(*rulesSource)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("couldn't read bootstrap recording rules: %s", err)
 	}
-	err = ioutil.WriteFile("/etc/config/bomb-squad/rules.yaml", b, 0644)
+	return b, nil
+}
+
+func bootstrapOperator() error {
+	b, err := readRules()
 	if err != nil {
-		log.Fatalf("Error writing bootstrap recording rules: %s", err)
+		return err
+	}
+
+	labels := map[string]string{}
+	for _, kv := range strings.Split(*operatorRuleLabels, ",") {
+		if kv == "" {
+			continue
+		}
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) != 2 {
+			return fmt.Errorf("invalid operator rule label '%s', expected key=value", kv)
+		}
+		labels[pair[0]] = pair[1]
 	}
 
-	cfg, err := prom.AppendRuleFile("/etc/config/bomb-squad/rules.yaml", c)
+	err = operator.EnsurePrometheusRule(k8sClientSet.CoreV1().RESTClient(), *k8sNamespace, *operatorRuleName, labels, b)
 	if err != nil {
-		log.Fatalf("Error adding bootstrap recording rules to Prometheus config: %s", err)
+		return fmt.Errorf("error installing bootstrap recording rules as a PrometheusRule: %s", err)
 	}
+	return nil
+}
 
-	err = config.WritePromConfig(cfg, c)
+// bootstrap puts Bomb Squad's recording rules where Prometheus can load them,
+// either a file on a shared volume or a key of the Prometheus ConfigMap, and
+// adds them to the Prometheus config's rule files. Nothing is written that is
+// already in place.
+func bootstrap(c, rules config.Configurator) error {
+	b, err := readRules()
 	if err != nil {
-		log.Fatalf("Error adding bootstrap recording rules to Prometheus config: %s", err)
+		return err
 	}
 
-}
+	written, err := config.WriteIfChanged(b, rules)
+	if err != nil {
+		return fmt.Errorf("error writing bootstrap recording rules to %s: %s", rules.GetLocation(), err)
+	}
+	if written {
+		log.Printf("Wrote bootstrap recording rules to %s\n", rules.GetLocation())
+	}
 
-func main() {
-	flag.Parse()
-	if *getVersion {
-		out := fmt.Sprintf("version: %s\nprometheus: %s\nprometheus-rules: %s\n", version, promVersion, promRulesVersion)
-		log.Fatal(out)
+	written, err = prom.EnsureRuleFile(*rulesDest, c)
+	if err != nil {
+		return fmt.Errorf("error adding bootstrap recording rules to Prometheus config: %s", err)
 	}
+	if written {
+		log.Printf("Added %s to Prometheus rule files\n", *rulesDest)
+	}
+	return nil
+}
 
-	if *inK8s {
-		inClusterConfig, err := rest.InClusterConfig()
-		if err != nil {
-			log.Fatal(err)
+// bootstrapUntilLoaded retries bootstrap until it succeeds, then waits for
+// Prometheus to confirm that the card_count recording rule is being evaluated
+func bootstrapUntilLoaded(bootstrapFn func() error, promurl *url.URL, client *http.Client) {
+	for {
+		err := bootstrapFn()
+		if err == nil {
+			break
 		}
+		log.Printf("Bootstrap failed, retrying in %s: %s\n", *rulesCheckInterval, err)
+		time.Sleep(*rulesCheckInterval)
+	}
 
-		k8sClientSet, err = kubernetes.NewForConfig(inClusterConfig)
-		if err != nil {
-			log.Fatal(err)
+	for {
+		err := prom.CheckRuleGroup(prom.CardCounterRuleGroup, promurl, client)
+		if err == nil {
+			log.Printf("Prometheus is evaluating the %s rule group\n", prom.CardCounterRuleGroup)
+			return
 		}
-		cmClient := k8sClientSet.CoreV1().ConfigMaps(*k8sNamespace)
+		log.Printf("Waiting for Prometheus to evaluate bootstrap recording rules: %s\n", err)
+		time.Sleep(*rulesCheckInterval)
+	}
+}
+
+// setupConfigurators builds the configurators for the Prometheus config, the
+// Bomb Squad config and the recording rules from the flags
+func setupConfigurators() error {
+	rulesConfigurator = config.NewFileConfigurator(*rulesDest)
+	if !*inK8s {
+		promConfigurator = config.NewFileConfigurator(*promConfigLocation)
+		bsConfigurator = config.NewFileConfigurator(*bsConfigLocation)
+		return nil
+	}
+
+	inClusterConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return err
+	}
+
+	k8sClientSet, err = kubernetes.NewForConfig(inClusterConfig)
+	if err != nil {
+		return err
+	}
+	cmClient := k8sClientSet.CoreV1().ConfigMaps(*k8sNamespace)
+	switch *promBackend {
+	case "configmap":
 		promConfigurator = configmap.NewConfigMapWrapper(cmClient, *k8sNamespace, *k8sConfigMapName, *promConfigLocation)
+	case "operator":
+		promConfigurator = operator.NewMonitorWrapper(k8sClientSet.CoreV1().RESTClient(), *k8sNamespace)
+	default:
+		return fmt.Errorf("unknown prometheus backend '%s'", *promBackend)
+	}
+	if *rulesConfigMapKey != "" {
+		rulesConfigurator = configmap.NewConfigMapWrapper(cmClient, *k8sNamespace, *k8sConfigMapName, *rulesConfigMapKey)
+	}
+
+	switch *stateBackend {
+	case "configmap":
 		bsConfigurator = configmap.NewConfigMapWrapper(cmClient, *k8sNamespace, *k8sConfigMapName, *bsConfigLocation)
+	case "crd":
+		bsConfigurator = crd.NewSilenceWrapper(k8sClientSet.CoreV1().RESTClient(), *k8sNamespace)
+	default:
+		return fmt.Errorf("unknown state backend '%s'", *stateBackend)
+	}
+	return nil
+}
+
+// cliEnv sets up everything the bs subcommands need
+func cliEnv() (*cli.Env, error) {
+	err := setupConfigurators()
+	if err != nil {
+		return nil, err
+	}
+
+	promurl, err := url.Parse(*promURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse prometheus url: %s", err)
+	}
+
+	httpClient, err := util.HttpClient()
+	if err != nil {
+		return nil, fmt.Errorf("could not create http client: %s", err)
+	}
+
+	return &cli.Env{
+		PromConfigurator: promConfigurator,
+		BSConfigurator:   bsConfigurator,
+		PromURL:          promurl,
+		HTTPClient:       httpClient,
+		Impact:           patrol.ImpactPolicy{Mode: *impactPolicy, RuleFilesDir: *ruleFilesDir},
+		Purge:            patrol.PurgePolicy{Allowed: *allowPurge, Snapshot: *purgeSnapshot, Window: *purgeWindow},
+		Baseline:         patrol.BaselinePolicy{Window: *baselineWindow, MaxValues: *baselineMaxValues},
+	}, nil
+}
+
+func main() {
+	flag.Parse()
+	app := &cli.App{
+		Flags: flag.CommandLine,
+		Version: cli.VersionInfo{
+			Version:         version,
+			Prometheus:      promVersion,
+			PrometheusRules: promRulesVersion,
+		},
+		Setup:  cliEnv,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+	if *getVersion {
+		os.Exit(app.Run([]string{"version"}))
+	}
+	if flag.NArg() > 0 {
+		os.Exit(app.Run(flag.Args()))
+	}
+
+	err := setupConfigurators()
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	promurl, err := url.Parse(*promURL)
@@ -111,6 +299,19 @@ func main() {
 		log.Fatalf("could not create http client: %s", err)
 	}
 
+	alertmanagers, err := alertmanager.ParseURLs(*alertmanagerURLs, httpClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var notifier *notify.Notifier
+	if *notifyConfig != "" {
+		notifier, err = notify.Load(*notifyConfig, httpClient)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	p := patrol.Patrol{
 		PromURL:           promurl,
 		Interval:          5 * time.Second,
@@ -119,36 +320,85 @@ func main() {
 		HTTPClient:        httpClient,
 		PromConfigurator:  promConfigurator,
 		BSConfigurator:    bsConfigurator,
+		Alertmanagers:     alertmanagers,
+		Notifier:          notifier,
+		Quarantine: patrol.QuarantinePolicy{
+			After:       *quarantineAfter,
+			Mode:        *quarantineMode,
+			SampleLimit: *quarantineLimit,
+			TTL:         model.Duration(*quarantineTTL),
+		},
+	}
+	if *quarantineMode != config.QuarantineDrop && *quarantineMode != config.QuarantineSampleLimit {
+		log.Fatalf("unknown quarantine mode '%s'", *quarantineMode)
+	}
+	p.Escalation.Cycles = *escalationCycles
+	p.Breaker = patrol.BreakerPolicy{MaxSilencesPerHour: *maxSilencesPerHour, MaxMetricsPerJob: *maxMetricsPerJob}
+	p.Approval = patrol.ApprovalPolicy{Required: *requireApproval, AutoApplyAfter: *autoApplyAfter}
+	p.Impact = patrol.ImpactPolicy{Mode: *impactPolicy, RuleFilesDir: *ruleFilesDir}
+	p.Purge = patrol.PurgePolicy{Allowed: *allowPurge, Snapshot: *purgeSnapshot, Window: *purgeWindow}
+	p.Collision = patrol.CollisionPolicy{Predict: *predictCollisions, Watch: *collisionWatch}
+	p.Baseline = patrol.BaselinePolicy{Window: *baselineWindow, MaxValues: *baselineMaxValues}
+	if *remoteWrite != "" {
+		p.RemoteWrite = strings.Split(*remoteWrite, ",")
+	}
+	if *impactPolicy != patrol.ImpactOff && *impactPolicy != patrol.ImpactWarn && *impactPolicy != patrol.ImpactBlock {
+		log.Fatalf("unknown impact policy '%s'", *impactPolicy)
+	}
+	p.Escalation.Ladder, err = patrol.ParseLadder(*escalationLadder)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	if len(os.Args) > 1 {
-		cmd := os.Args[1]
-		if cmd == "list" {
-			fmt.Println("Suppressed Labels (metricName.labelName):")
-			config.ListSuppressedMetrics(p.BSConfigurator)
-			os.Exit(0)
-		}
+	if *inK8s && *workloadEvents {
+		p.Workloads = k8sevents.NewRecorder(k8sClientSet)
+	}
+	if *inK8s && *correlateRollouts {
+		p.Rollouts = rollouts.NewCorrelator(k8sClientSet)
+	}
 
-		if cmd == "unsilence" {
-			label := os.Args[2]
-			fmt.Printf("Removing silence rule for suppressed label: %s\n", label)
-			err := config.RemoveSilence(label, p.PromConfigurator, p.BSConfigurator)
-			if err != nil {
-				log.Fatalf("Could not remove silencing rule: %s\n", err)
-			}
+	if *inK8s {
+		bootstrapFn := func() error { return bootstrap(p.PromConfigurator, rulesConfigurator) }
+		if *promBackend == "operator" {
+			bootstrapFn = bootstrapOperator
+		}
+		go bootstrapUntilLoaded(bootstrapFn, promurl, httpClient)
 
-			os.Exit(0)
+		err = config.ReportOrphanedRelabelConfigs(p.PromConfigurator, p.BSConfigurator)
+		if err != nil {
+			log.Printf("Could not check for orphaned silence rules: %s\n", err)
 		}
 	}
-
+	go p.Run()
 	if *inK8s {
-		bootstrap(p.PromConfigurator)
+		var events <-chan struct{}
+		if cmw, ok := p.PromConfigurator.(*configmap.ConfigMapWrapper); ok {
+			events = cmw.Watch(make(chan struct{}))
+		} else {
+			log.Printf("The %s Prometheus backend isn't watched for changes, so drift is only reconciled every %s\n", *promBackend, *reconcileInterval)
+		}
+		go p.Reconcile(*reconcileInterval, events)
+	}
+
+	authz := auth.Open()
+	if *authConfig != "" {
+		authz, err = auth.Load(*authConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		log.Println("No auth-config given, so anyone who can reach Bomb Squad can change its silences")
 	}
-	go p.Run()
 
 	mux := http.DefaultServeMux
 	mux.Handle("/metrics", promhttp.Handler())
-	mux.Handle("/metrics/reset", patrol.MetricResetHandler())
+	mux.Handle("/metrics/reset", authz.Require(auth.RoleWrite, patrol.MetricResetHandler()))
+	apiServer := &api.Server{Patrol: &p}
+	mux.Handle(api.Prefix+"/", authz.ByMethod(apiServer.Handler()))
+	mux.Handle(api.HealthPath, apiServer.HealthHandler())
+	mux.Handle(api.ReadyPath, apiServer.ReadyHandler())
+	// The page carries no data, so it's open; its calls to the API aren't
+	mux.Handle(ui.Prefix, ui.Handler(api.Prefix, version))
 	versionGauge.Set(1.0)
 
 	server := &http.Server{
@@ -157,6 +407,17 @@ func main() {
 	}
 
 	fmt.Println("Welcome to bomb-squad")
-	log.Printf("serving prometheus endpoints on port %d\n",*metricsPort)
+	if *tlsCert != "" || *tlsKey != "" {
+		server.TLSConfig, err = auth.ServerTLSConfig(*tlsClientCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("serving prometheus endpoints with TLS on port %d\n", *metricsPort)
+		log.Fatal(server.ListenAndServeTLS(*tlsCert, *tlsKey))
+	}
+	if *tlsClientCA != "" {
+		log.Fatal("tls-client-ca needs tls-cert and tls-key to be set")
+	}
+	log.Printf("serving prometheus endpoints on port %d\n", *metricsPort)
 	log.Fatal(server.ListenAndServe())
 }
@@ -0,0 +1,275 @@
+// Package notify sends Bomb Squad's events to HTTP webhooks. Each webhook
+// renders events with its own Go template, so that one mechanism covers
+// Slack, Teams, PagerDuty and anything else that takes a POST. Deliveries
+// are retried, and those that still fail are written to a dead-letter log.
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Types of event
+const (
+	// EventDetection is sent when the patrol finds a label exploding
+	EventDetection = "detection"
+	// EventSilence is sent when a silence is applied
+	EventSilence = "silence"
+	// EventUnsilence is sent when a silence expires or is removed
+	EventUnsilence = "unsilence"
+	// EventFailure is sent when Bomb Squad fails to do something it should
+	EventFailure = "failure"
+	// EventDrift is sent when a silence rule was found out of sync with the
+	// silences recorded by Bomb Squad, and corrected
+	EventDrift = "drift"
+	// EventQuarantine is sent when a scrape target is quarantined
+	EventQuarantine = "quarantine"
+	// EventRelease is sent when a quarantine expires or is removed
+	EventRelease = "release"
+	// EventEscalation is sent when a silence didn't stop its metric from
+	// growing, and was escalated or ran out of steps to escalate to
+	EventEscalation = "escalation"
+	// EventBreaker is sent when the circuit breaker trips or is reset
+	EventBreaker = "breaker"
+	// EventProposal is sent when the patrol proposes a silence that awaits
+	// approval
+	EventProposal = "proposal"
+)
+
+// EventTypes are all the types of event, in the order they're documented
+var EventTypes = []string{EventDetection, EventSilence, EventUnsilence, EventFailure, EventDrift, EventQuarantine, EventRelease, EventEscalation, EventBreaker, EventProposal}
+
+// Event is something that happened, as passed to webhook templates
+type Event struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Metric string    `json:"metric,omitempty"`
+	Label  string    `json:"label,omitempty"`
+	Action string    `json:"action,omitempty"`
+	Jobs   []string  `json:"jobs,omitempty"`
+	// Target is the ID of the quarantine the event is about, if any
+	Target string `json:"target,omitempty"`
+	// Reason says why a silence went away, expired or removed, how an
+	// escalation ended, escalated, collision or exhausted, or what happened to the
+	// circuit breaker, tripped or reset
+	Reason string `json:"reason,omitempty"`
+	// Summary describes the event in a sentence or two, for people
+	Summary string `json:"summary"`
+}
+
+// Config is the contents of the notify config file
+type Config struct {
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+	// DeadLetterFile is where deliveries that failed for good are appended,
+	// one JSON object per line. Defaults to Bomb Squad's log.
+	DeadLetterFile string `yaml:"dead_letter_file,omitempty"`
+}
+
+// WebhookConfig describes one webhook
+type WebhookConfig struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Events are the types of event sent to the webhook. Defaults to all.
+	Events []string `yaml:"events,omitempty"`
+	// Template renders an event into the request body. Defaults to the event
+	// as JSON.
+	Template string `yaml:"template,omitempty"`
+	// ContentType defaults to application/json
+	ContentType string            `yaml:"content_type,omitempty"`
+	Headers     map[string]string `yaml:"headers,omitempty"`
+	// MaxRetries is how many times a failed delivery is retried. Defaults to
+	// 3; use -1 for none.
+	MaxRetries int `yaml:"max_retries,omitempty"`
+	// RetryBackoff is the wait before the first retry, doubled for each
+	// one after. Defaults to 1s.
+	RetryBackoff model.Duration `yaml:"retry_backoff,omitempty"`
+	// Timeout limits each delivery attempt. Defaults to 10s.
+	Timeout model.Duration `yaml:"timeout,omitempty"`
+}
+
+const (
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = time.Second
+	defaultTimeout      = 10 * time.Second
+	// queueSize is how many events may wait for each webhook before new
+	// ones go straight to the dead-letter log
+	queueSize = 100
+)
+
+// Notifier sends events to webhooks
+type Notifier struct {
+	webhooks []*webhook
+
+	mu         sync.Mutex
+	closed     bool
+	deadLetter func([]byte)
+	wg         sync.WaitGroup
+}
+
+// New validates cfg and starts delivering to its webhooks. Call Close to
+// stop.
+func New(cfg Config, client *http.Client) (*Notifier, error) {
+	n := &Notifier{deadLetter: func(b []byte) { log.Printf("Dead letter: %s\n", b) }}
+
+	names := map[string]bool{}
+	for i, wc := range cfg.Webhooks {
+		wh, err := newWebhook(wc, client)
+		if err != nil {
+			return nil, fmt.Errorf("webhooks[%d]: %s", i, err)
+		}
+		if names[wh.name] {
+			return nil, fmt.Errorf("webhooks[%d]: duplicate name '%s'", i, wh.name)
+		}
+		names[wh.name] = true
+		n.webhooks = append(n.webhooks, wh)
+	}
+
+	if cfg.DeadLetterFile != "" {
+		f, err := os.OpenFile(cfg.DeadLetterFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't open dead-letter file: %s", err)
+		}
+		n.deadLetter = func(b []byte) {
+			_, err := f.Write(append(b, '\n'))
+			if err != nil {
+				log.Printf("Couldn't write to dead-letter file, so here it is: %s\n", b)
+			}
+		}
+	}
+
+	for _, wh := range n.webhooks {
+		n.wg.Add(1)
+		go func(wh *webhook) {
+			defer n.wg.Done()
+			for e := range wh.queue {
+				n.deliver(wh, e)
+			}
+		}(wh)
+	}
+	return n, nil
+}
+
+// Load reads the notify config file at path and returns its Notifier
+func Load(path string, client *http.Client) (*Notifier, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read notify config: %s", err)
+	}
+
+	cfg := Config{}
+	err = yaml.UnmarshalStrict(b, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse notify config %s: %s", path, err)
+	}
+
+	n, err := New(cfg, client)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notify config %s: %s", path, err)
+	}
+	return n, nil
+}
+
+// Notify queues e for every webhook that wants it, without waiting for it to
+// be delivered
+func (n *Notifier) Notify(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return
+	}
+	for _, wh := range n.webhooks {
+		if !wh.events[e.Type] {
+			continue
+		}
+		select {
+		case wh.queue <- e:
+		default:
+			n.writeDeadLetter(wh, e, nil, fmt.Errorf("queue full"))
+		}
+	}
+}
+
+// Close stops taking events, and returns once those already queued have been
+// delivered or given up on
+func (n *Notifier) Close() {
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		for _, wh := range n.webhooks {
+			close(wh.queue)
+		}
+	}
+	n.mu.Unlock()
+	n.wg.Wait()
+}
+
+// deliver sends e to wh, retrying with exponential backoff, and dead-letters
+// it if every attempt fails
+func (n *Notifier) deliver(wh *webhook, e Event) {
+	body, err := wh.render(e)
+	if err != nil {
+		n.mu.Lock()
+		n.writeDeadLetter(wh, e, nil, err)
+		n.mu.Unlock()
+		return
+	}
+
+	backoff := wh.retryBackoff
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = wh.post(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= wh.maxRetries {
+			break
+		}
+		log.Printf("Couldn't notify webhook %s, retrying in %s: %s\n", wh.name, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	n.mu.Lock()
+	n.writeDeadLetter(wh, e, body, err)
+	n.mu.Unlock()
+}
+
+// deadLetter is a delivery that failed for good
+type deadLetter struct {
+	Time    time.Time `json:"time"`
+	Webhook string    `json:"webhook"`
+	Error   string    `json:"error"`
+	Event   Event     `json:"event"`
+	// Payload is the rendered body, if rendering got that far
+	Payload string `json:"payload,omitempty"`
+}
+
+// writeDeadLetter must be called with n.mu held
+func (n *Notifier) writeDeadLetter(wh *webhook, e Event, body []byte, err error) {
+	log.Printf("Giving up on notifying webhook %s of %s event: %s\n", wh.name, e.Type, err)
+	b, merr := json.Marshal(deadLetter{
+		Time:    time.Now(),
+		Webhook: wh.name,
+		Error:   err.Error(),
+		Event:   e,
+		Payload: string(body),
+	})
+	if merr != nil {
+		log.Printf("Couldn't marshal dead letter: %s\n", merr)
+		return
+	}
+	n.deadLetter(b)
+}
@@ -0,0 +1,136 @@
+package notify_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/notify"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+)
+
+// receiver records the bodies it's sent, answering with the given codes in
+// turn and 200 once they run out
+type receiver struct {
+	mu     sync.Mutex
+	codes  []int
+	bodies []string
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := ioutil.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.bodies = append(rc.bodies, string(b))
+	if len(rc.codes) > 0 {
+		code := rc.codes[0]
+		rc.codes = rc.codes[1:]
+		http.Error(w, "nope", code)
+	}
+}
+
+func TestWebhookTemplateAndRetries(t *testing.T) {
+	rc := &receiver{codes: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	s := httptest.NewServer(rc)
+	defer s.Close()
+
+	n, err := notify.New(notify.Config{Webhooks: []notify.WebhookConfig{{
+		Name:         "slack",
+		URL:          s.URL,
+		Events:       []string{notify.EventSilence},
+		Template:     `{"text": {{json (printf "%s.%s silenced (%s) on %s" .Metric .Label .Action (join .Jobs ", "))}}}`,
+		RetryBackoff: model.Duration(time.Millisecond),
+	}}}, http.DefaultClient)
+	require.NoError(t, err)
+
+	n.Notify(notify.Event{Type: notify.EventDetection, Metric: "foo", Label: "bar"})
+	n.Notify(notify.Event{Type: notify.EventSilence, Metric: "foo", Label: "bar", Action: "drop", Jobs: []string{"api", "web"}})
+	n.Close()
+
+	require.Len(t, rc.bodies, 3)
+	require.Equal(t, `{"text": "foo.bar silenced (drop) on api, web"}`, rc.bodies[2])
+}
+
+func TestDeadLetters(t *testing.T) {
+	rc := &receiver{codes: []int{500, 500, 400}}
+	s := httptest.NewServer(rc)
+	defer s.Close()
+
+	f, err := ioutil.TempFile("", "bomb-squad-dead-letters")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	defer os.Remove(f.Name())
+
+	n, err := notify.New(notify.Config{
+		DeadLetterFile: f.Name(),
+		Webhooks: []notify.WebhookConfig{{
+			Name:         "pager",
+			URL:          s.URL,
+			MaxRetries:   1,
+			RetryBackoff: model.Duration(time.Millisecond),
+		}},
+	}, http.DefaultClient)
+	require.NoError(t, err)
+
+	// The first gives up after a retry, the second isn't retried at all
+	n.Notify(notify.Event{Type: notify.EventFailure, Summary: "first"})
+	n.Notify(notify.Event{Type: notify.EventDrift, Summary: "second"})
+	n.Close()
+	require.Len(t, rc.bodies, 3)
+
+	sent := notify.Event{}
+	require.NoError(t, json.Unmarshal([]byte(rc.bodies[0]), &sent))
+	require.Equal(t, "first", sent.Summary)
+
+	b, err := ioutil.ReadFile(f.Name())
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Len(t, lines, 2)
+	for i, summary := range []string{"first", "second"} {
+		dl := struct {
+			Webhook string
+			Error   string
+			Event   notify.Event
+			Payload string
+		}{}
+		require.NoError(t, json.Unmarshal([]byte(lines[i]), &dl))
+		require.Equal(t, "pager", dl.Webhook)
+		require.Equal(t, summary, dl.Event.Summary)
+		require.NotEmpty(t, dl.Payload)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	f, err := ioutil.TempFile("", "bomb-squad-notify")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	defer os.Remove(f.Name())
+
+	for _, c := range []struct {
+		config string
+		valid  bool
+	}{
+		{"webhooks:\n- name: a\n  url: http://example.com/hook\n", true},
+		{"webhooks:\n- name: a\n  url: example.com/hook\n", false},
+		{"webhooks:\n- name: a\n  url: http://example.com\n  events: [explosion]\n", false},
+		{"webhooks:\n- name: a\n  url: http://example.com\n  template: '{{.Metric'\n", false},
+		{"webhooks:\n- name: a\n  url: http://example.com\n- name: a\n  url: http://example.com\n", false},
+		{"webhook: []\n", false},
+	} {
+		require.NoError(t, ioutil.WriteFile(f.Name(), []byte(c.config), 0600))
+		n, err := notify.Load(f.Name(), http.DefaultClient)
+		if c.valid {
+			require.NoError(t, err, c.config)
+			n.Close()
+		} else {
+			require.Error(t, err, c.config)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// defaultTemplate sends the event as it is
+const defaultTemplate = `{{json .}}`
+
+// templateFuncs are available to webhook templates, on top of text/template's
+// own
+var templateFuncs = template.FuncMap{
+	// json renders a value as JSON, which also quotes and escapes strings
+	// for use inside JSON payloads
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"time": func(t time.Time) string {
+		return t.UTC().Format(time.RFC3339)
+	},
+}
+
+type webhook struct {
+	name         string
+	url          string
+	events       map[string]bool
+	tmpl         *template.Template
+	contentType  string
+	headers      map[string]string
+	maxRetries   int
+	retryBackoff time.Duration
+	client       *http.Client
+
+	queue chan Event
+}
+
+func newWebhook(wc WebhookConfig, client *http.Client) (*webhook, error) {
+	if wc.Name == "" {
+		return nil, fmt.Errorf("name is empty")
+	}
+	u, err := url.Parse(wc.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("url '%s' must be an absolute http or https URL", wc.URL)
+	}
+
+	wh := &webhook{
+		name:         wc.Name,
+		url:          wc.URL,
+		events:       map[string]bool{},
+		contentType:  wc.ContentType,
+		headers:      wc.Headers,
+		maxRetries:   wc.MaxRetries,
+		retryBackoff: time.Duration(wc.RetryBackoff),
+		queue:        make(chan Event, queueSize),
+	}
+
+	events := wc.Events
+	if len(events) == 0 {
+		events = EventTypes
+	}
+	for _, e := range events {
+		known := false
+		for _, t := range EventTypes {
+			known = known || e == t
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown event '%s', expected one of %s", e, strings.Join(EventTypes, ", "))
+		}
+		wh.events[e] = true
+	}
+
+	text := wc.Template
+	if text == "" {
+		text = defaultTemplate
+	}
+	wh.tmpl, err = template.New(wc.Name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %s", err)
+	}
+
+	if wh.contentType == "" {
+		wh.contentType = "application/json"
+	}
+	switch {
+	case wh.maxRetries == 0:
+		wh.maxRetries = defaultMaxRetries
+	case wh.maxRetries < 0:
+		wh.maxRetries = 0
+	}
+	if wh.retryBackoff <= 0 {
+		wh.retryBackoff = defaultRetryBackoff
+	}
+	timeout := time.Duration(wc.Timeout)
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	c := *client
+	c.Timeout = timeout
+	wh.client = &c
+
+	return wh, nil
+}
+
+func (wh *webhook) render(e Event) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	err := wh.tmpl.Execute(buf, e)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't render template: %s", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// post sends body to the webhook once. If it fails, retry reports whether
+// trying again might help: network errors, 429s and 5xxs are retried, but
+// other 4xxs mean the payload is wrong.
+func (wh *webhook) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, wh.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", wh.contentType)
+	for k, v := range wh.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		// Webhook URLs often carry a secret, so keep them out of the error
+		if uerr, ok := err.(*url.Error); ok {
+			err = fmt.Errorf("%s request failed: %s", uerr.Op, uerr.Err)
+		}
+		return true, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = fmt.Errorf("webhook answered %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
@@ -0,0 +1,205 @@
+package patrol
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/alertmanager"
+	"github.com/open-fresh/bomb-squad/config"
+)
+
+// Names of the alerts Bomb Squad sends to Alertmanager
+const (
+	AlertExplosionDetected = "BombSquadExplosionDetected"
+	AlertSilenceApplied    = "BombSquadSilenceApplied"
+	AlertSilenceRemoved    = "BombSquadSilenceRemoved"
+)
+
+const (
+	// alertResendInterval is how often firing alerts are sent again when
+	// nothing has changed, so that Alertmanager doesn't resolve them
+	alertResendInterval = time.Minute
+	// alertLifetime is how long Alertmanager keeps a firing alert without
+	// hearing about it again
+	alertLifetime = 4 * alertResendInterval
+	// removedAlertLifetime is how long the alert for a removed silence fires
+	// before resolving itself
+	removedAlertLifetime = time.Hour
+)
+
+// alertState is what has been sent to Alertmanager
+type alertState struct {
+	// firing is every alert that hasn't been resolved, by fingerprint
+	firing   map[string]alertmanager.Alert
+	lastSent time.Time
+	// dirty is set when alerts have changed, or failed to send, since lastSent
+	dirty bool
+}
+
+// syncAlerts brings the alerts in Alertmanager into line with the current
+// detections and silences. Each silence in removed resolves its applied alert
+// and fires a removed alert that resolves itself after an hour.
+func (p *Patrol) syncAlerts(now time.Time, detections []Detection, silences, removed []config.SilenceSummary) {
+	if len(p.Alertmanagers) == 0 {
+		return
+	}
+	st := &p.alerts
+	if st.firing == nil {
+		st.firing = map[string]alertmanager.Alert{}
+	}
+
+	wanted := map[string]alertmanager.Alert{}
+	for _, d := range detections {
+		for _, a := range detectionAlerts(d) {
+			wanted[fingerprint(a.Labels)] = a
+		}
+	}
+	for _, s := range silences {
+		for _, a := range silenceAlerts(AlertSilenceApplied, s) {
+			wanted[fingerprint(a.Labels)] = a
+		}
+	}
+	for _, s := range removed {
+		for _, a := range silenceAlerts(AlertSilenceRemoved, s) {
+			a.Labels["reason"] = removalReason(s, now)
+			a.StartsAt = now
+			a.EndsAt = now.Add(removedAlertLifetime)
+			st.firing[fingerprint(a.Labels)] = a
+			st.dirty = true
+		}
+	}
+
+	batch := []alertmanager.Alert{}
+	for fp, a := range st.firing {
+		if a.Labels["alertname"] == AlertSilenceRemoved {
+			if !a.EndsAt.After(now) {
+				delete(st.firing, fp)
+			} else {
+				batch = append(batch, a)
+			}
+			continue
+		}
+		if _, ok := wanted[fp]; !ok {
+			a.EndsAt = now
+			batch = append(batch, a)
+			delete(st.firing, fp)
+			st.dirty = true
+		}
+	}
+	for fp, a := range wanted {
+		if prev, ok := st.firing[fp]; ok {
+			a.StartsAt = prev.StartsAt
+		} else {
+			a.StartsAt = now
+			st.dirty = true
+		}
+		a.EndsAt = now.Add(alertLifetime)
+		st.firing[fp] = a
+		batch = append(batch, a)
+	}
+
+	if !st.dirty && now.Sub(st.lastSent) < alertResendInterval {
+		return
+	}
+	if len(batch) == 0 {
+		st.dirty = false
+		return
+	}
+	sort.Slice(batch, func(i, j int) bool { return fingerprint(batch[i].Labels) < fingerprint(batch[j].Labels) })
+
+	failed := false
+	for _, am := range p.Alertmanagers {
+		err := am.Post(batch)
+		if err != nil {
+			log.Printf("Couldn't send alerts to Alertmanager: %s\n", err)
+			failed = true
+		}
+	}
+	// Resolved alerts have left firing, so a failed send is only retried for
+	// those still firing. Alertmanager resolves the others once their EndsAt
+	// passes anyway.
+	st.dirty = failed
+	st.lastSent = now
+}
+
+// detectionAlerts returns an explosion alert for each job d was seen in
+func detectionAlerts(d Detection) []alertmanager.Alert {
+	labels := map[string]string{
+		"alertname": AlertExplosionDetected,
+		"metric":    d.Metric,
+		"label":     d.Label,
+	}
+	annotations := map[string]string{
+		"summary": fmt.Sprintf("Cardinality explosion in %s, driven by label %s", d.Metric, d.Label),
+		"description": fmt.Sprintf("card_count of %s grew by %g in the last minute. %d series have %d distinct values of %s, such as %s.",
+			d.Metric, d.Growth, d.Series, d.DistinctValues, d.Label, strings.Join(d.SampleValues, ", ")),
+	}
+	return perJob(labels, annotations, d.Jobs)
+}
+
+// silenceAlerts returns an alert with the given name for each job s applies
+// to
+func silenceAlerts(name string, s config.SilenceSummary) []alertmanager.Alert {
+	labels := map[string]string{
+		"alertname": name,
+		"metric":    s.Metric,
+		"label":     s.Label,
+		"action":    s.Action,
+	}
+	source := "the patrol"
+	if s.Manual {
+		source = "hand"
+	}
+	expiry := "It doesn't expire."
+	if s.ExpiresAt != nil {
+		expiry = fmt.Sprintf("It expires at %s.", s.ExpiresAt.Format(time.RFC3339))
+	}
+	annotations := map[string]string{}
+	if name == AlertSilenceRemoved {
+		annotations["summary"] = fmt.Sprintf("Silence on label %s of %s removed", s.Label, s.Metric)
+		annotations["description"] = fmt.Sprintf("Bomb Squad no longer silences %s. If it's still exploding, the patrol will silence it again.", s.ID)
+	} else {
+		annotations["summary"] = fmt.Sprintf("Label %s of %s silenced", s.Label, s.Metric)
+		annotations["description"] = fmt.Sprintf("Bomb Squad %ss the values of %s, as requested by %s. %s", s.Action, s.ID, source, expiry)
+	}
+
+	jobs := s.AppliedJobs
+	if len(jobs) == 0 {
+		jobs = s.Jobs
+	}
+	return perJob(labels, annotations, jobs)
+}
+
+// perJob returns a copy of the alert for each job, labelled with it, or the
+// alert alone if there are no jobs
+func perJob(labels, annotations map[string]string, jobs []string) []alertmanager.Alert {
+	if len(jobs) == 0 {
+		return []alertmanager.Alert{{Labels: labels, Annotations: annotations}}
+	}
+	out := []alertmanager.Alert{}
+	for _, job := range jobs {
+		l := map[string]string{"job": job}
+		for k, v := range labels {
+			l[k] = v
+		}
+		out = append(out, alertmanager.Alert{Labels: l, Annotations: annotations})
+	}
+	return out
+}
+
+// fingerprint identifies an alert by its labels
+func fingerprint(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+labels[k])
+	}
+	return strings.Join(parts, ",")
+}
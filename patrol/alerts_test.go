@@ -0,0 +1,73 @@
+package patrol_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/alertmanager"
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/patrol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncAlerts(t *testing.T) {
+	var posts [][]alertmanager.Alert
+	am := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		alerts := []alertmanager.Alert{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&alerts))
+		posts = append(posts, alerts)
+	}))
+	defer am.Close()
+	amURL, _ := url.Parse(am.URL)
+
+	p := &patrol.Patrol{
+		PromConfigurator: bstesting.NewPromMemoryConfigurator(t),
+		BSConfigurator:   bstesting.NewMemoryConfigurator(t, []byte{}),
+		Alertmanagers:    []*alertmanager.Client{{URL: amURL, HTTPClient: http.DefaultClient}},
+	}
+
+	p.SyncNotifications()
+	require.Len(t, posts, 0)
+
+	_, err := p.CreateSilence(config.SilenceRequest{Metric: "foo", Label: "bar", Action: "drop", Jobs: []string{"prometheus"}})
+	require.NoError(t, err)
+	p.SyncNotifications()
+	require.Len(t, posts, 1)
+	require.Len(t, posts[0], 1)
+	applied := posts[0][0]
+	require.Equal(t, map[string]string{
+		"alertname": patrol.AlertSilenceApplied,
+		"metric":    "foo",
+		"label":     "bar",
+		"action":    "drop",
+		"job":       "prometheus",
+	}, applied.Labels)
+	require.True(t, applied.EndsAt.After(time.Now()))
+
+	// Nothing has changed, so nothing is resent until the resend interval
+	p.SyncNotifications()
+	require.Len(t, posts, 1)
+
+	_, err = p.RemoveSilence("foo.bar")
+	require.NoError(t, err)
+	p.SyncNotifications()
+	require.Len(t, posts, 2)
+	require.Len(t, posts[1], 2)
+	for _, a := range posts[1] {
+		switch a.Labels["alertname"] {
+		case patrol.AlertSilenceApplied:
+			require.False(t, a.EndsAt.After(time.Now()), "applied alert should be resolved")
+			require.Equal(t, applied.StartsAt.Unix(), a.StartsAt.Unix())
+		case patrol.AlertSilenceRemoved:
+			require.Equal(t, "removed", a.Labels["reason"])
+			require.True(t, a.EndsAt.After(time.Now().Add(30*time.Minute)))
+		default:
+			t.Fatalf("unexpected alert %v", a.Labels)
+		}
+	}
+}
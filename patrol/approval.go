@@ -0,0 +1,192 @@
+package patrol
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/notify"
+	"github.com/prometheus/common/model"
+)
+
+// ApprovalPolicy says whether the silences the patrol finds are applied
+// straight away, or proposed and left for a person to approve
+type ApprovalPolicy struct {
+	// Required makes the patrol propose silences rather than apply them.
+	// They're applied once approved with bs approve or the API.
+	Required bool
+	// AutoApplyAfter is how long after being proposed a silence is applied
+	// without approval, if its label is still exploding. Zero means never.
+	AutoApplyAfter time.Duration
+}
+
+// approvedByHand is the detail of the approval of a pending silence applied
+// with bs approve or the API
+const approvedByHand = "approved by hand"
+
+// evidence returns what d found, to be kept with a pending silence
+func (d Detection) evidence() config.Evidence {
+	return config.Evidence{
+		Growth:         d.Growth,
+		Series:         d.Series,
+		DistinctValues: d.DistinctValues,
+		SampleValues:   d.SampleValues,
+		Instances:      d.Instances,
+		DetectedAt:     d.DetectedAt,
+	}
+}
+
+// awaitingApproval proposes a silence for s, found exploding by d, unless one
+// is pending already or was rejected, and reports whether the pending silence
+// should still wait for approval. It shouldn't once its auto-apply time has
+// passed, as the label is evidently still exploding. The caller must hold the
+// patrol's lock.
+func (p *Patrol) awaitingApproval(s config.HighCardSeries, d Detection, now time.Time) bool {
+	id := s.MetricName + "." + string(s.HighCardLabelName)
+	pending, err := config.GetPending(id, p.BSConfigurator)
+	if _, ok := err.(config.PendingNotFoundError); ok {
+		rejected, err := config.IsRejected(id, p.BSConfigurator)
+		if err != nil {
+			log.Printf("Couldn't check whether the silence of %s was rejected: %s\n", id, err)
+			return true
+		}
+		if !rejected {
+			p.propose(s, d)
+		}
+		return true
+	}
+	if err != nil {
+		log.Printf("Couldn't read the pending silence of %s: %s\n", id, err)
+		return true
+	}
+	return pending.AutoApplyAt == nil || now.Before(*pending.AutoApplyAt)
+}
+
+// propose records a pending silence for s, with d as its evidence, and tells
+// the webhooks how to approve it. The caller must hold the patrol's lock.
+func (p *Patrol) propose(s config.HighCardSeries, d Detection) {
+	id := s.MetricName + "." + string(s.HighCardLabelName)
+	pending := config.PendingSilence{
+		Action:     p.silenceAction(s),
+		Jobs:       s.Jobs,
+		Culprit:    d.Culprit,
+		Evidence:   d.evidence(),
+		ProposedAt: time.Now().UTC(),
+	}
+	if p.Approval.AutoApplyAfter > 0 {
+		autoApplyAt := pending.ProposedAt.Add(p.Approval.AutoApplyAfter)
+		pending.AutoApplyAt = &autoApplyAt
+	}
+	pending.AffectedRules, _ = p.checkImpact(s, pending.Action, true)
+
+	err := config.ProposeSilence(s.MetricName, string(s.HighCardLabelName), pending, p.BSConfigurator)
+	if err != nil {
+		err = fmt.Errorf("Couldn't propose silencing %s: %s", id, err)
+		log.Println(err)
+		p.notify(failureEvent(s.MetricName, string(s.HighCardLabelName), err))
+		return
+	}
+	log.Printf("Proposed silencing %s, which awaits approval\n", id)
+	p.notify(proposalEvent(pending.Summarize(s.MetricName, string(s.HighCardLabelName))))
+}
+
+// proposalEvent returns the event telling people that s awaits approval, and
+// how to give it
+func proposalEvent(s config.PendingSummary) notify.Event {
+	summary := fmt.Sprintf("Label %s of %s is exploding, with card_count growing by %g in the last minute across %d series and %d values of the label. A %s silence awaits approval: run bs approve %s to apply it, or bs reject %s to dismiss it.",
+		s.Label, s.Metric, s.Evidence.Growth, s.Evidence.Series, s.Evidence.DistinctValues, s.Action, s.ID, s.ID)
+	if len(s.AffectedRules) > 0 {
+		summary += fmt.Sprintf(" It may break %d recording or alerting rules, listed by bs pending %s.", len(s.AffectedRules), s.ID)
+	}
+	if s.AutoApplyAt != nil {
+		summary += fmt.Sprintf(" It will be applied anyway at %s if the label is still exploding.", s.AutoApplyAt.Format(time.RFC3339))
+	}
+	return notify.Event{
+		Type:    notify.EventProposal,
+		Time:    s.ProposedAt,
+		Metric:  s.Metric,
+		Label:   s.Label,
+		Action:  s.Action,
+		Jobs:    s.Jobs,
+		Summary: summary,
+	}
+}
+
+// applyPending applies the pending silence with the given ID as it was
+// proposed, and removes it from the pending silences, recording detail as
+// how it was approved. force is passed on to checkImpact. The caller must
+// hold the patrol's lock.
+func (p *Patrol) applyPending(id, detail string, force bool) (config.SilenceSummary, error) {
+	pending, err := config.GetPending(id, p.BSConfigurator)
+	if err != nil {
+		return config.SilenceSummary{}, err
+	}
+
+	_, err = config.GetSilence(id, p.BSConfigurator)
+	if err == nil {
+		return config.SilenceSummary{}, config.SilenceExistsError{ID: id}
+	}
+	if _, ok := err.(config.SilenceNotFoundError); !ok {
+		return config.SilenceSummary{}, err
+	}
+
+	s := config.HighCardSeries{MetricName: pending.Metric, HighCardLabelName: model.LabelName(pending.Label), Jobs: pending.Jobs}
+	rules, err := p.checkImpact(s, pending.Action, force)
+	if err != nil {
+		return config.SilenceSummary{}, err
+	}
+	d := Detection{Metric: pending.Metric, Label: pending.Label, DetectedAt: pending.Evidence.DetectedAt}
+	silence := config.Silence{Action: pending.Action, Culprit: pending.Culprit, AffectedRules: rules, RemoteWrite: p.RemoteWrite, Baseline: p.baseline(s, pending.Action, d)}
+	err = ApplySilence(s, silence, p.PromConfigurator, p.BSConfigurator)
+	if err != nil {
+		return config.SilenceSummary{}, err
+	}
+	err = config.RemovePending(id, config.EventApproved, detail, p.BSConfigurator)
+	if err != nil {
+		log.Printf("Applied the pending silence of %s, but couldn't remove it from the pending silences: %s\n", id, err)
+	}
+	return config.GetSilence(id, p.BSConfigurator)
+}
+
+// ApproveSilence applies the pending silence with the given ID while holding
+// the patrol's lock. Approving a silence overrides the impact policy, as the
+// rules it may break were part of the proposal. The applied silence is
+// returned.
+func (p *Patrol) ApproveSilence(id string) (config.SilenceSummary, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.applyPending(id, approvedByHand, true)
+}
+
+// RejectSilence dismisses the pending silence with the given ID while holding
+// the patrol's lock. The patrol won't propose it again until its label has
+// stopped exploding. The rejected silence is returned.
+func (p *Patrol) RejectSilence(id string) (config.PendingSummary, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pending, err := config.GetPending(id, p.BSConfigurator)
+	if err != nil {
+		return config.PendingSummary{}, err
+	}
+	err = config.RemovePending(id, config.EventRejected, "rejected by hand", p.BSConfigurator)
+	if err != nil {
+		return config.PendingSummary{}, err
+	}
+	return pending, nil
+}
+
+// forgetRejections forgets the rejected silences of the labels that weren't
+// found exploding this cycle, so they're proposed again should they explode
+// again. The caller must hold the patrol's lock.
+func (p *Patrol) forgetRejections(highCardSeries []config.HighCardSeries) {
+	exploding := map[string]bool{}
+	for _, s := range highCardSeries {
+		exploding[s.MetricName+"."+string(s.HighCardLabelName)] = true
+	}
+	err := config.ForgetRejections(exploding, p.BSConfigurator)
+	if err != nil {
+		log.Printf("Couldn't forget rejected silences: %s\n", err)
+	}
+}
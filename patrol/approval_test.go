@@ -0,0 +1,93 @@
+package patrol_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/notify"
+	"github.com/open-fresh/bomb-squad/patrol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApprovalAutoApply(t *testing.T) {
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/query":
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"metric_name":"a"},"value":[0,"500"]}]}}`)
+		case "/api/v1/series":
+			fmt.Fprint(w, `{"status":"success","data":[{"__name__":"a","user":"1","instance":"x:80"},{"__name__":"a","user":"2","instance":"x:80"}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	// Run never returns, and exits if Prometheus goes away, so the server is
+	// left up for the rest of the tests
+	promURL, _ := url.Parse(prometheus.URL)
+
+	events := make(chan notify.Event, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := notify.Event{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+		events <- e
+	}))
+	defer hook.Close()
+	n, err := notify.New(notify.Config{Webhooks: []notify.WebhookConfig{{Name: "test", URL: hook.URL, Events: []string{notify.EventProposal}}}}, http.DefaultClient)
+	require.NoError(t, err)
+
+	bc := bstesting.NewMemoryConfigurator(t, []byte{})
+	p := &patrol.Patrol{
+		PromURL:           promURL,
+		Interval:          10 * time.Millisecond,
+		HighCardN:         5,
+		HighCardThreshold: 100,
+		HTTPClient:        http.DefaultClient,
+		PromConfigurator:  bstesting.NewPromMemoryConfigurator(t),
+		BSConfigurator:    bc,
+		Notifier:          n,
+		Approval:          patrol.ApprovalPolicy{Required: true, AutoApplyAfter: 500 * time.Millisecond},
+	}
+	go p.Run()
+
+	select {
+	case e := <-events:
+		require.Equal(t, "a", e.Metric)
+		require.Contains(t, e.Summary, "bs approve a.user")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no silence was proposed")
+	}
+
+	pending, err := config.GetPending("a.user", bc)
+	require.NoError(t, err)
+	require.Equal(t, 2, pending.Evidence.Series)
+	require.Equal(t, []string{"x:80"}, pending.Evidence.Instances)
+	require.NotNil(t, pending.AutoApplyAt)
+
+	// Nobody approves it, and the label keeps exploding, so it's applied
+	// once its auto-apply time has passed
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := config.GetPending("a.user", bc); err != nil {
+			require.IsType(t, config.PendingNotFoundError{}, err)
+			break
+		}
+		require.True(t, time.Now().Before(deadline), "the proposed silence wasn't applied")
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.False(t, time.Now().Before(*pending.AutoApplyAt))
+	silence, err := config.GetSilence("a.user", bc)
+	require.NoError(t, err)
+	require.False(t, silence.Manual)
+
+	history, err := config.History(0, bc)
+	require.NoError(t, err)
+	last := history[len(history)-1]
+	require.Equal(t, config.EventApproved, last.Type)
+	require.Contains(t, last.Detail, "without approval")
+}
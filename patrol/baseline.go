@@ -0,0 +1,125 @@
+package patrol
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/prom"
+	"github.com/prometheus/common/model"
+	promcfg "github.com/prometheus/prometheus/config"
+)
+
+// DefaultBaselineWindow is how long a window baselines are learned over when
+// asked for by hand, if the patrol learns none itself
+const DefaultBaselineWindow = time.Hour
+
+// BaselinePolicy says whether the patrol's replace silences keep the values
+// their label had before it exploded, and rewrite only the others
+type BaselinePolicy struct {
+	// Window is how long before an explosion started the label's values are
+	// learned over. Zero leaves the patrol's silences without a baseline.
+	Window time.Duration
+	// MaxValues is the most values a baseline may hold, as a label that had
+	// more than that was exploding already. Zero means no limit.
+	MaxValues int
+}
+
+// learnBaseline returns the values label of metric had over the window
+// ending at until, other than config.SilenceReplacement. A label that had no
+// values, or more than p.Baseline.MaxValues, gets a config.InvalidSilenceError.
+func (p *Patrol) learnBaseline(metric, label string, window time.Duration, until time.Time) (config.Baseline, error) {
+	b := config.Baseline{From: until.Add(-window).UTC(), Until: until.UTC(), LearnedAt: time.Now().UTC()}
+	series, err := prom.FetchSeriesBetween(metric, b.From, b.Until, p.PromURL, p.HTTPClient)
+	if err != nil {
+		return config.Baseline{}, err
+	}
+
+	values := map[string]bool{}
+	for _, s := range series.Data {
+		if v := s[label]; v != "" && v != config.SilenceReplacement {
+			values[v] = true
+		}
+	}
+	from, to := b.From.Format(time.RFC3339), b.Until.Format(time.RFC3339)
+	if len(values) == 0 {
+		return config.Baseline{}, config.InvalidSilenceError{Reason: fmt.Sprintf("label %s of %s had no values from %s to %s", label, metric, from, to)}
+	}
+	if p.Baseline.MaxValues > 0 && len(values) > p.Baseline.MaxValues {
+		return config.Baseline{}, config.InvalidSilenceError{Reason: fmt.Sprintf("label %s of %s had %d values from %s to %s, more than the %d a baseline may hold", label, metric, len(values), from, to, p.Baseline.MaxValues)}
+	}
+	for v := range values {
+		b.Values = append(b.Values, v)
+	}
+	sort.Strings(b.Values)
+	return b, nil
+}
+
+// baseline returns the baseline the patrol silences s with, found exploding
+// by d, learned over p.Baseline.Window before the explosion started. It's nil
+// if baselines are off, the action isn't replace, or none could be learned.
+func (p *Patrol) baseline(s config.HighCardSeries, action string, d Detection) *config.Baseline {
+	if p.Baseline.Window <= 0 || action != string(promcfg.RelabelReplace) {
+		return nil
+	}
+	b, err := p.learnBaseline(s.MetricName, string(s.HighCardLabelName), p.Baseline.Window, p.explosionStart(d))
+	if err != nil {
+		log.Printf("Silencing %s.%s without a baseline, as none could be learned: %s\n", s.MetricName, s.HighCardLabelName, err)
+		return nil
+	}
+	return &b
+}
+
+// RefreshBaseline learns the values the replace silence with the given ID
+// keeps again, over req's window, and swaps its rule for one keeping them
+// while holding the patrol's lock. The window defaults to p.Baseline.Window,
+// or DefaultBaselineWindow, and ends where the current baseline does, or
+// when the explosion that got the label silenced started. The refreshed
+// silence is returned.
+func (p *Patrol) RefreshBaseline(id string, req config.BaselineRequest) (config.SilenceSummary, error) {
+	s, err := config.GetSilence(id, p.BSConfigurator)
+	if err != nil {
+		return config.SilenceSummary{}, err
+	}
+	if s.Action != string(promcfg.RelabelReplace) {
+		return config.SilenceSummary{}, config.InvalidSilenceError{Reason: fmt.Sprintf("the silence of %s uses %s, and only replace silences keep a baseline's values", id, s.Action)}
+	}
+
+	window := p.Baseline.Window
+	if window <= 0 {
+		window = DefaultBaselineWindow
+	}
+	if req.Window != "" {
+		w, err := model.ParseDuration(req.Window)
+		if err != nil || w == 0 {
+			return config.SilenceSummary{}, config.InvalidSilenceError{Reason: fmt.Sprintf("invalid window '%s'", req.Window)}
+		}
+		window = time.Duration(w)
+	}
+	until := time.Now()
+	switch {
+	case req.Until != nil:
+		until = *req.Until
+	case s.Baseline != nil:
+		until = s.Baseline.Until
+	case s.AppliedAt != nil:
+		// The values the label exploded into would be learned otherwise
+		until = p.explosionStart(Detection{Metric: s.Metric, Label: s.Label, DetectedAt: *s.AppliedAt})
+	}
+
+	b, err := p.learnBaseline(s.Metric, s.Label, window, until)
+	if err != nil {
+		return config.SilenceSummary{}, err
+	}
+
+	p.mu.Lock()
+	err = config.SetBaseline(id, b, p.PromConfigurator, p.BSConfigurator)
+	p.mu.Unlock()
+	if err != nil {
+		return config.SilenceSummary{}, err
+	}
+	log.Printf("Learned the baseline of %s: %s\n", id, b)
+	return config.GetSilence(id, p.BSConfigurator)
+}
@@ -0,0 +1,91 @@
+package patrol_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/patrol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshBaseline(t *testing.T) {
+	var start, end string
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/query_range" {
+			// card_count started rising three minutes before the history ends
+			e, _ := strconv.ParseInt(r.URL.Query().Get("end"), 10, 64)
+			fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{},"values":[[%d,"10"],[%d,"10"],[%d,"20"],[%d,"40"]]}]}}`, e-240, e-180, e-120, e-60)
+			return
+		}
+		require.Equal(t, "/api/v1/series", r.URL.Path)
+		start, end = r.URL.Query().Get("start"), r.URL.Query().Get("end")
+		fmt.Fprint(w, `{"status":"success","data":[`+
+			`{"__name__":"requests_total","path":"/login","job":"api"},`+
+			`{"__name__":"requests_total","path":"/","job":"api"},`+
+			`{"__name__":"requests_total","path":"bs_silence","job":"api"},`+
+			`{"__name__":"requests_total","job":"api"}]}`)
+	}))
+	defer prometheus.Close()
+	promURL, _ := url.Parse(prometheus.URL)
+
+	p := &patrol.Patrol{
+		PromURL:          promURL,
+		HTTPClient:       http.DefaultClient,
+		PromConfigurator: bstesting.NewPromMemoryConfigurator(t),
+		BSConfigurator:   bstesting.NewMemoryConfigurator(t, []byte{}),
+	}
+	_, err := p.CreateSilence(config.SilenceRequest{Metric: "requests_total", Label: "path"})
+	require.NoError(t, err)
+
+	until := time.Date(2018, 10, 16, 10, 0, 0, 0, time.UTC)
+	s, err := p.RefreshBaseline("requests_total.path", config.BaselineRequest{Window: "30m", Until: &until})
+	require.NoError(t, err)
+	require.Equal(t, strconv.FormatInt(until.Add(-30*time.Minute).Unix(), 10), start)
+	require.Equal(t, strconv.FormatInt(until.Unix(), 10), end)
+	require.NotNil(t, s.Baseline)
+	require.Equal(t, []string{"/", "/login"}, s.Baseline.Values)
+	require.Equal(t, until, s.Baseline.Until)
+
+	// The applied rule now keeps the known values
+	pcfg, err := config.ReadPromConfig(p.PromConfigurator)
+	require.NoError(t, err)
+	found := false
+	for _, sc := range pcfg.ScrapeConfigs {
+		for _, rc := range sc.MetricRelabelConfigs {
+			if rc.Replacement == config.SilenceReplacement {
+				found = true
+				require.False(t, rc.Regex.MatchString("requests_total;/login"))
+				require.True(t, rc.Regex.MatchString("requests_total;/users/1234"))
+			}
+		}
+	}
+	require.True(t, found)
+
+	// Refreshing again learns over the same window by default
+	s, err = p.RefreshBaseline("requests_total.path", config.BaselineRequest{})
+	require.NoError(t, err)
+	require.Equal(t, strconv.FormatInt(until.Unix(), 10), end)
+
+	// Without a baseline, the window ends where the explosion started
+	s, err = p.CreateSilence(config.SilenceRequest{Metric: "sessions_total", Label: "path"})
+	require.NoError(t, err)
+	_, err = p.RefreshBaseline("sessions_total.path", config.BaselineRequest{})
+	require.NoError(t, err)
+	require.Equal(t, strconv.FormatInt(s.AppliedAt.Unix()-180, 10), end)
+
+	p.Baseline.MaxValues = 1
+	_, err = p.RefreshBaseline("requests_total.path", config.BaselineRequest{})
+	require.IsType(t, config.InvalidSilenceError{}, err)
+
+	_, err = p.CreateSilence(config.SilenceRequest{Metric: "latency_seconds", Label: "path", Action: "drop"})
+	require.NoError(t, err)
+	_, err = p.RefreshBaseline("latency_seconds.path", config.BaselineRequest{})
+	require.IsType(t, config.InvalidSilenceError{}, err)
+}
@@ -0,0 +1,220 @@
+package patrol
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/notify"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	BreakerTrippedGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "bomb_squad",
+			Name:      "circuit_breaker_tripped",
+			Help:      "Whether the circuit breaker has tripped, leaving the patrol to only report explosions until it is reset",
+		},
+	)
+)
+
+// BreakerPolicy limits how much the patrol changes by itself. Reaching a
+// limit trips the circuit breaker, after which the patrol only reports
+// explosions, without silencing, quarantining or escalating anything, until
+// an operator resets it.
+type BreakerPolicy struct {
+	// MaxSilencesPerHour limits how many silences the patrol applies in any
+	// hour. Zero means no limit.
+	MaxSilencesPerHour int
+	// MaxMetricsPerJob limits how many metrics of a single job the patrol
+	// has silenced at once. Zero means no limit.
+	MaxMetricsPerJob int
+}
+
+// breakerTripped reports whether the circuit breaker has tripped, and updates
+// its gauge. The saved state is trusted, so a reset made by another process
+// holds, unless the state backend couldn't record the trip. The caller must
+// hold the patrol's lock.
+func (p *Patrol) breakerTripped() bool {
+	tripped := p.tripped
+	st, err := config.GetBreaker(p.BSConfigurator)
+	if err != nil {
+		log.Printf("Couldn't read the circuit breaker: %s\n", err)
+	}
+	tripped = tripped || st.Tripped
+
+	if tripped {
+		BreakerTrippedGauge.Set(1)
+	} else {
+		BreakerTrippedGauge.Set(0)
+	}
+	return tripped
+}
+
+// recentSilences returns how many silences the patrol applied in the hour
+// before now, since the breaker was last reset. They're counted from the
+// history, so restarts don't forget them, or from the patrol's own record if
+// the state backend keeps no history. The caller must hold the patrol's lock.
+func (p *Patrol) recentSilences(now time.Time) int {
+	recent := []time.Time{}
+	for _, t := range p.silencedAt {
+		if now.Sub(t) < time.Hour {
+			recent = append(recent, t)
+		}
+	}
+	p.silencedAt = recent
+
+	events, err := config.History(0, p.BSConfigurator)
+	if err != nil {
+		if _, ok := err.(config.HistoryUnavailableError); !ok {
+			log.Printf("Couldn't read the history to count silences against the circuit breaker: %s\n", err)
+		}
+		return len(recent)
+	}
+
+	// counted holds the labels whose last silence was counted, in case it
+	// turns out to have been approved by hand
+	count, counted := 0, map[string]bool{}
+	for _, e := range events {
+		id := e.Metric + "." + e.Label
+		switch {
+		case e.Type == config.EventBreakerReset:
+			count, counted = 0, map[string]bool{}
+		case now.Sub(e.Time) >= time.Hour:
+		case e.Type == config.EventSilenced && e.Detail == "":
+			count++
+			counted[id] = true
+		case e.Type == config.EventApproved && e.Detail == approvedByHand && counted[id]:
+			count--
+			counted[id] = false
+		}
+	}
+	return count
+}
+
+// allowSilence reports whether silencing s stays within p.Breaker, tripping
+// the breaker if it doesn't. The caller must hold the patrol's lock.
+func (p *Patrol) allowSilence(s config.HighCardSeries, now time.Time) bool {
+	if p.Breaker.MaxSilencesPerHour > 0 {
+		if recent := p.recentSilences(now); recent >= p.Breaker.MaxSilencesPerHour {
+			p.trip(fmt.Sprintf("the patrol applied %d silences in the last hour, the most allowed", recent))
+			return false
+		}
+	}
+
+	if p.Breaker.MaxMetricsPerJob > 0 {
+		silences, err := config.ListSilences(p.BSConfigurator)
+		if err != nil {
+			log.Printf("Couldn't list silences to count them against the circuit breaker: %s\n", err)
+			return true
+		}
+
+		// Silences that apply to every job are counted under ""
+		perJob := map[string]map[string]bool{"": {}}
+		for _, t := range silences {
+			if t.Manual {
+				continue
+			}
+			jobs := t.Jobs
+			if len(jobs) == 0 {
+				jobs = []string{""}
+			}
+			for _, job := range jobs {
+				if perJob[job] == nil {
+					perJob[job] = map[string]bool{}
+				}
+				perJob[job][t.Metric] = true
+			}
+		}
+
+		jobs := s.Jobs
+		if len(jobs) == 0 {
+			for job := range perJob {
+				jobs = append(jobs, job)
+			}
+		}
+		for _, job := range jobs {
+			metrics := map[string]bool{}
+			for _, m := range []map[string]bool{perJob[job], perJob[""]} {
+				for metric := range m {
+					metrics[metric] = true
+				}
+			}
+			if !metrics[s.MetricName] && len(metrics) >= p.Breaker.MaxMetricsPerJob {
+				name := "job " + job
+				if job == "" {
+					name = "every job"
+				}
+				p.trip(fmt.Sprintf("the patrol has silenced %d metrics of %s, the most allowed", len(metrics), name))
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// trip trips the circuit breaker for the given reason. If that can't be
+// recorded, the patrol still holds back until it's reset through the API.
+// The caller must hold the patrol's lock.
+func (p *Patrol) trip(reason string) {
+	BreakerTrippedGauge.Set(1)
+	err := config.TripBreaker(reason, p.BSConfigurator)
+	if err != nil {
+		p.tripped = true
+		log.Printf("Couldn't record the circuit breaker tripping: %s\n", err)
+	}
+	log.Printf("Circuit breaker tripped, as %s. Only reporting explosions until it is reset\n", reason)
+	p.notify(notify.Event{
+		Type:    notify.EventBreaker,
+		Time:    time.Now(),
+		Reason:  "tripped",
+		Summary: fmt.Sprintf("Bomb Squad's circuit breaker tripped, as %s. It will only report explosions until it is reset with bs reset-breaker.", reason),
+	})
+}
+
+// BreakerState returns the state of the circuit breaker
+func (p *Patrol) BreakerState() (config.BreakerState, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	st, err := config.GetBreaker(p.BSConfigurator)
+	if err != nil {
+		return config.BreakerState{}, err
+	}
+	if p.tripped && !st.Tripped {
+		st = config.BreakerState{Tripped: true, Reason: "tripped, but the state backend couldn't record why"}
+	}
+	return st, nil
+}
+
+// ResetBreaker resets the circuit breaker while holding the patrol's lock,
+// and starts counting silences against the limits afresh. The state from
+// before the reset is returned.
+func (p *Patrol) ResetBreaker() (config.BreakerState, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	previous, err := config.ResetBreaker(p.BSConfigurator)
+	if err != nil {
+		return config.BreakerState{}, err
+	}
+	if p.tripped && !previous.Tripped {
+		previous = config.BreakerState{Tripped: true}
+	}
+	p.tripped = false
+	p.silencedAt = nil
+	BreakerTrippedGauge.Set(0)
+
+	if previous.Tripped {
+		log.Println("Circuit breaker reset")
+		p.notify(notify.Event{
+			Type:    notify.EventBreaker,
+			Time:    time.Now(),
+			Reason:  "reset",
+			Summary: "Bomb Squad's circuit breaker was reset, and the patrol acts on explosions again.",
+		})
+	}
+	return previous, nil
+}
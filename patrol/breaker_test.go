@@ -0,0 +1,157 @@
+package patrol_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/notify"
+	"github.com/open-fresh/bomb-squad/patrol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreakerTrips(t *testing.T) {
+	for _, c := range []struct {
+		policy   patrol.BreakerPolicy
+		silences int
+		reason   string
+	}{
+		{patrol.BreakerPolicy{MaxSilencesPerHour: 2}, 2, "2 silences in the last hour"},
+		{patrol.BreakerPolicy{MaxMetricsPerJob: 1}, 1, "1 metrics of every job"},
+	} {
+		testBreakerTrips(t, c.policy, c.silences, c.reason)
+	}
+}
+
+func testBreakerTrips(t *testing.T, policy patrol.BreakerPolicy, silences int, reason string) {
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/query":
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[`+
+				`{"metric":{"metric_name":"a"},"value":[0,"500"]},`+
+				`{"metric":{"metric_name":"b"},"value":[0,"500"]},`+
+				`{"metric":{"metric_name":"c"},"value":[0,"500"]}]}}`)
+		case "/api/v1/series":
+			m := r.URL.Query().Get("match[]")
+			fmt.Fprintf(w, `{"status":"success","data":[{"__name__":%q,"user":"1"},{"__name__":%q,"user":"2"}]}`, m, m)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	// Run never returns, and exits if Prometheus goes away, so the server is
+	// left up for the rest of the tests
+	promURL, _ := url.Parse(prometheus.URL)
+
+	events := make(chan notify.Event, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := notify.Event{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+		events <- e
+	}))
+	defer hook.Close()
+	n, err := notify.New(notify.Config{Webhooks: []notify.WebhookConfig{{Name: "test", URL: hook.URL, Events: []string{notify.EventBreaker}}}}, http.DefaultClient)
+	require.NoError(t, err)
+
+	bc := bstesting.NewMemoryConfigurator(t, []byte{})
+	p := &patrol.Patrol{
+		PromURL:           promURL,
+		Interval:          10 * time.Millisecond,
+		HighCardN:         5,
+		HighCardThreshold: 100,
+		HTTPClient:        http.DefaultClient,
+		PromConfigurator:  bstesting.NewPromMemoryConfigurator(t),
+		BSConfigurator:    bc,
+		Notifier:          n,
+		Breaker:           policy,
+	}
+	go p.Run()
+
+	select {
+	case e := <-events:
+		require.Equal(t, "tripped", e.Reason)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the circuit breaker didn't trip")
+	}
+
+	// The patrol doesn't touch the config while the breaker is tripped
+	applied, err := config.ListSilences(bc)
+	require.NoError(t, err)
+	require.Len(t, applied, silences)
+	st, err := p.BreakerState()
+	require.NoError(t, err)
+	require.True(t, st.Tripped)
+	require.Contains(t, st.Reason, reason)
+
+	previous, err := p.ResetBreaker()
+	require.NoError(t, err)
+	require.True(t, previous.Tripped)
+	select {
+	case e := <-events:
+		require.Equal(t, "reset", e.Reason)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event for the reset")
+	}
+}
+
+func TestBreakerResetByAnotherPatrol(t *testing.T) {
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/query":
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[`+
+				`{"metric":{"metric_name":"a"},"value":[0,"500"]},`+
+				`{"metric":{"metric_name":"b"},"value":[0,"500"]}]}}`)
+		case "/api/v1/series":
+			m := r.URL.Query().Get("match[]")
+			fmt.Fprintf(w, `{"status":"success","data":[{"__name__":%q,"user":"1"},{"__name__":%q,"user":"2"}]}`, m, m)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	promURL, _ := url.Parse(prometheus.URL)
+
+	bc := bstesting.NewMemoryConfigurator(t, []byte{})
+	p := &patrol.Patrol{
+		PromURL:           promURL,
+		Interval:          10 * time.Millisecond,
+		HighCardN:         5,
+		HighCardThreshold: 100,
+		HTTPClient:        http.DefaultClient,
+		PromConfigurator:  bstesting.NewPromMemoryConfigurator(t),
+		BSConfigurator:    bc,
+		Breaker:           patrol.BreakerPolicy{MaxSilencesPerHour: 1},
+	}
+	go p.Run()
+
+	waitFor := func(what string, done func() bool) {
+		for deadline := time.Now().Add(5 * time.Second); !done(); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatal(what)
+			}
+		}
+	}
+	silences := func() int {
+		applied, err := config.ListSilences(bc)
+		require.NoError(t, err)
+		return len(applied)
+	}
+	waitFor("the circuit breaker didn't trip", func() bool {
+		st, err := p.BreakerState()
+		require.NoError(t, err)
+		return st.Tripped
+	})
+	require.Equal(t, 1, silences())
+
+	// bs reset-breaker builds a patrol of its own on the same state backend
+	other := &patrol.Patrol{BSConfigurator: bc}
+	previous, err := other.ResetBreaker()
+	require.NoError(t, err)
+	require.True(t, previous.Tripped)
+
+	waitFor("the patrol didn't act again after the reset", func() bool { return silences() == 2 })
+}
@@ -5,14 +5,16 @@ import (
 	"fmt"
 	"log"
 	"net/url"
+	"sort"
 	"strconv"
+	"time"
 
 	"github.com/deckarep/golang-set"
 	"github.com/open-fresh/bomb-squad/config"
 	"github.com/open-fresh/bomb-squad/prom"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
-	yaml "gopkg.in/yaml.v2"
+	promcfg "github.com/prometheus/prometheus/config"
 )
 
 var (
@@ -56,54 +58,103 @@ func (p *Patrol) getTopCardinalities() error {
 		log.Fatal(err)
 	}
 
+	detections := []Detection{}
 	m := p.cardinalityTooHigh(iq)
 	if len(m) > 0 {
-		highCardSeries = p.findHighCardSeries(m)
+		highCardSeries, detections = p.findHighCardSeries(m)
 	}
+	p.findCulprits(detections)
+	p.setDetections(detections)
 
-	for _, s := range highCardSeries {
-		mrc, err := config.GenerateMetricRelabelConfig(s)
-		if err != nil {
-			log.Printf("Couldn't generate metric relabel config for metric %s: %s\n", s.MetricName, err)
-			continue
+	applied := p.applySilences(highCardSeries, detections)
+	if p.Workloads != nil {
+		for _, d := range applied {
+			action := string(promcfg.RelabelReplace)
+			if s, err := config.GetSilence(d.Metric+"."+d.Label, p.BSConfigurator); err == nil {
+				action = s.Action
+			}
+			p.Workloads.RecordSilence(d, action)
 		}
+	}
+	p.quarantineTargets(detections)
+	p.verifySilences()
+	p.watchCollisions()
 
-		err = prom.ReUnmarshal(&mrc)
-		if err != nil {
-			log.Println(err)
-			continue
-		}
+	return nil
+}
 
-		newPromConfig, err := config.InsertMetricRelabelConfigToPromConfig(mrc, p.PromConfigurator)
-		if err != nil {
-			log.Printf("Error inserting relabel config for metric %s: %s\n", s.MetricName, err)
+// applySilences silences each of highCardSeries, whose detections are at the
+// same index, while holding the patrol's lock. Labels that are already
+// silenced are left alone, so that silences made by hand keep their action
+// and TTL. When p.Approval requires it, silences are proposed instead, and
+// only applied once their auto-apply time has passed. Silences that p.Impact
+// blocks are reported once, and left for a person to apply. Nothing is
+// silenced while the circuit breaker is tripped, or once a silence would trip
+// it. The detections of the labels newly silenced are returned.
+func (p *Patrol) applySilences(highCardSeries []config.HighCardSeries, detections []Detection) []Detection {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	applied := []Detection{}
+	blocked := map[string]bool{}
+	defer func() { p.blocked = blocked }()
+	if p.Approval.Required {
+		p.forgetRejections(highCardSeries)
+	}
+	if len(highCardSeries) == 0 {
+		return applied
+	}
+	if p.breakerTripped() {
+		log.Printf("Circuit breaker is tripped, leaving %d exploding labels unsilenced\n", len(highCardSeries))
+		return applied
+	}
+	for i, s := range highCardSeries {
+		id := s.MetricName + "." + string(s.HighCardLabelName)
+		_, err := config.GetSilence(id, p.BSConfigurator)
+		if err == nil {
 			continue
 		}
 
-		newPromConfigBytes, err := yaml.Marshal(newPromConfig)
-		if err != nil {
-			log.Printf("Error marshalling Prometheus config: %s\n", err)
+		now := time.Now()
+		if p.Approval.Required && p.awaitingApproval(s, detections[i], now) {
 			continue
 		}
-
-		err = p.PromConfigurator.Write(newPromConfigBytes)
-		if err != nil {
-			log.Printf("Error writing Prometheus config: %s\n", err)
+		if !p.allowSilence(s, now) {
+			break
+		}
+		if p.Approval.Required {
+			_, err = p.applyPending(id, fmt.Sprintf("applied without approval, as the label was still exploding %s after being proposed", p.Approval.AutoApplyAfter), false)
+		} else {
+			var rules []config.AffectedRule
+			action := p.silenceAction(s)
+			rules, err = p.checkImpact(s, action, false)
+			if err == nil {
+				err = ApplySilence(s, config.Silence{Action: action, Culprit: detections[i].Culprit, AffectedRules: rules, RemoteWrite: p.RemoteWrite, Baseline: p.baseline(s, action, detections[i])}, p.PromConfigurator, p.BSConfigurator)
+			}
+		}
+		if _, ok := err.(config.RulesAffectedError); ok {
+			blocked[id] = true
+			if !p.blocked[id] {
+				log.Println(err)
+				p.notify(failureEvent(s.MetricName, string(s.HighCardLabelName), err))
+			}
 			continue
 		}
-
-		err = config.StoreMetricRelabelConfigBombSquad(s, mrc, p.BSConfigurator)
 		if err != nil {
-			log.Printf("Couldn't store metric relabel config for metric %s: %s\n", s.MetricName, err)
+			log.Println(err)
+			p.notify(failureEvent(s.MetricName, string(s.HighCardLabelName), err))
 			continue
 		}
+		p.silencedAt = append(p.silencedAt, now)
+		applied = append(applied, detections[i])
 	}
-
-	return nil
+	return applied
 }
 
-func (p *Patrol) cardinalityTooHigh(iq *prom.InstantQuery) []string {
-	out := []string{}
+// cardinalityTooHigh returns the growth in cardinality of every metric
+// growing faster than the threshold
+func (p *Patrol) cardinalityTooHigh(iq *prom.InstantQuery) map[string]float64 {
+	out := map[string]float64{}
 	for _, v := range iq.Data.Result {
 		m := v.Metric["metric_name"]
 		val := v.Value[1].(string)
@@ -114,7 +165,7 @@ func (p *Patrol) cardinalityTooHigh(iq *prom.InstantQuery) []string {
 		}
 
 		if f >= p.HighCardThreshold {
-			out = append(out, m)
+			out[m] = f
 		}
 	}
 	return out
@@ -131,13 +182,20 @@ func (p *Patrol) getDistinctLabelValuesInSeries(s map[string]string, tracker lab
 	}
 }
 
-func (p *Patrol) findHighCardSeries(metrics []string) []config.HighCardSeries {
+func (p *Patrol) findHighCardSeries(growth map[string]float64) ([]config.HighCardSeries, []Detection) {
 	hwmLabel := ""
 	var (
 		s      prom.Series
 		hwm, l int
 	)
 	res := []config.HighCardSeries{}
+	detections := []Detection{}
+
+	metrics := []string{}
+	for metricName := range growth {
+		metrics = append(metrics, metricName)
+	}
+	sort.Strings(metrics)
 
 	for _, metricName := range metrics {
 
@@ -165,6 +223,13 @@ func (p *Patrol) findHighCardSeries(metrics []string) []config.HighCardSeries {
 			p.getDistinctLabelValuesInSeries(series, tracker)
 		}
 
+		jobs := []string{}
+		if values, ok := tracker["job"]; ok {
+			for _, v := range values.ToSlice() {
+				jobs = append(jobs, v.(string))
+			}
+		}
+
 		// The label with the highest cardinality should be the exploding one,
 		// so we track a high water mark and continue with the "winner"
 		hwm = 0
@@ -177,15 +242,95 @@ func (p *Patrol) findHighCardSeries(metrics []string) []config.HighCardSeries {
 			}
 		}
 
-		res = append(res,
-			config.HighCardSeries{
-				MetricName:        metricName,
-				HighCardLabelName: model.LabelName(hwmLabel),
-			},
-		)
+		hcs := config.HighCardSeries{
+			MetricName:        metricName,
+			HighCardLabelName: model.LabelName(hwmLabel),
+			Jobs:              p.scrapeJobs(jobs),
+		}
+		res = append(res, hcs)
+
+		samples := []string{}
+		for _, v := range tracker[hwmLabel].ToSlice() {
+			samples = append(samples, v.(string))
+		}
+		sort.Strings(samples)
+		if len(samples) > maxSampleValues {
+			samples = samples[:maxSampleValues]
+		}
+		detections = append(detections, Detection{
+			Metric:         metricName,
+			Label:          hwmLabel,
+			Growth:         growth[metricName],
+			Series:         len(s.Data),
+			DistinctValues: hwm,
+			SampleValues:   samples,
+			Jobs:           hcs.Jobs,
+			Pods:           podsInSeries(s.Data),
+			Instances:      instancesInSeries(s.Data),
+			DetectedAt:     time.Now().UTC(),
+		})
+
 		fmt.Printf("Detected exploding label \"%s\" on metric \"%s\"\n", hwmLabel, metricName)
 		ExplodingLabelGauge.WithLabelValues(metricName, hwmLabel).Set(float64(hwm))
 	}
 
-	return res
+	return res, detections
+}
+
+// scrapeJobs maps the values of the job label seen on exploding series to the
+// scrape configs producing them. The two are the same unless the job label is
+// relabelled, as the Prometheus Operator does, in which case Prometheus' view
+// of its targets tells us which scrape pool they belong to. If the jobs can't
+// be worked out, nil is returned so that silences apply to every job.
+func (p *Patrol) scrapeJobs(jobLabels []string) []string {
+	if len(jobLabels) == 0 {
+		return nil
+	}
+
+	relativeURL, err := url.Parse("/api/v1/targets")
+	if err != nil {
+		return nil
+	}
+	queryURL := p.PromURL.ResolveReference(relativeURL)
+
+	b, err := prom.Fetch(queryURL.String(), p.HTTPClient)
+	if err != nil {
+		log.Printf("Couldn't fetch targets to scope silences, applying to all jobs: %s\n", err)
+		return nil
+	}
+
+	targets := prom.Targets{}
+	err = json.Unmarshal(b, &targets)
+	if err != nil {
+		log.Printf("Couldn't unmarshal targets to scope silences, applying to all jobs: %s\n", err)
+		return nil
+	}
+
+	wanted := mapset.NewSet()
+	for _, j := range jobLabels {
+		wanted.Add(j)
+	}
+
+	pools := mapset.NewSet()
+	for _, t := range targets.Data.ActiveTargets {
+		if !wanted.Contains(t.Labels["job"]) {
+			continue
+		}
+		if t.ScrapePool == "" {
+			// Older Prometheus, where we can only assume the job label wasn't relabelled
+			pools.Add(t.Labels["job"])
+			continue
+		}
+		pools.Add(t.ScrapePool)
+	}
+	if pools.Cardinality() == 0 {
+		return nil
+	}
+
+	out := []string{}
+	for _, pool := range pools.ToSlice() {
+		out = append(out, pool.(string))
+	}
+	sort.Strings(out)
+	return out
 }
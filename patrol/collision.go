@@ -0,0 +1,176 @@
+package patrol
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/notify"
+	"github.com/open-fresh/bomb-squad/prom"
+	"github.com/prometheus/common/model"
+	promcfg "github.com/prometheus/prometheus/config"
+)
+
+// CollisionPolicy says how the patrol keeps its silences from making series
+// collide. Replacing or dropping a label leaves series that only differed by
+// it with the same label set, and Prometheus rejects all but one of their
+// samples as duplicates.
+type CollisionPolicy struct {
+	// Predict makes the patrol drop the metric, rather than replace the
+	// label, when its other labels wouldn't keep its series apart
+	Predict bool
+	// Watch is how long after a replace or labeldrop silence is applied the
+	// patrol watches for duplicate samples, switching the silence to drop if
+	// its series turn out to collide. Zero disables watching.
+	Watch time.Duration
+}
+
+// seriesCollide reports whether any two of the series matching selector
+// that had samples within window would have the same label set once label is
+// removed from them. A zero window looks at the current series only.
+func (p *Patrol) seriesCollide(selector, label string, window time.Duration) (bool, error) {
+	groups, err := prom.CollidingSeries(selector, label, window, p.PromURL, p.HTTPClient)
+	if err != nil {
+		return false, err
+	}
+	return groups > 0, nil
+}
+
+// silenceAction returns the action the patrol silences s with: replace,
+// unless p.Collision predicts that its series would collide, in which case
+// the metric is dropped
+func (p *Patrol) silenceAction(s config.HighCardSeries) string {
+	action := string(promcfg.RelabelReplace)
+	if !p.Collision.Predict {
+		return action
+	}
+	collide, err := p.seriesCollide(s.MetricName, string(s.HighCardLabelName), 0)
+	if err != nil {
+		log.Printf("Couldn't check whether silencing %s.%s would make its series collide, will replace it: %s\n", s.MetricName, s.HighCardLabelName, err)
+		return action
+	}
+	if collide {
+		log.Printf("Series of %s would collide without label %s, will drop it\n", s.MetricName, s.HighCardLabelName)
+		return string(promcfg.RelabelDrop)
+	}
+	return action
+}
+
+// watchCollisions switches the patrol's replace and labeldrop silences
+// applied within p.Collision.Watch to drop, if Prometheus is rejecting
+// duplicate samples, samples scraped by the silence's jobs are going missing,
+// and the series the silence rewrote collide. Silences made by hand, and
+// those applied to remote_write endpoints alone, are left alone. It's only
+// used from the patrol's goroutine.
+func (p *Patrol) watchCollisions() {
+	blocked := map[string]bool{}
+	defer func() { p.blockedSwitches = blocked }()
+	if p.Collision.Watch <= 0 {
+		return
+	}
+	silences, err := config.ListSilences(p.BSConfigurator)
+	if err != nil {
+		log.Printf("Couldn't list silences to watch for collisions: %s\n", err)
+		return
+	}
+
+	now := time.Now()
+	watched := []config.SilenceSummary{}
+	for _, s := range silences {
+		if s.Manual || s.RemoteWriteOnly || s.AppliedAt == nil || now.Sub(*s.AppliedAt) > p.Collision.Watch {
+			continue
+		}
+		if step := currentStep(s); step == string(promcfg.RelabelReplace) || step == string(promcfg.RelabelLabelDrop) {
+			watched = append(watched, s)
+		}
+	}
+	if len(watched) == 0 {
+		return
+	}
+
+	duplicates, err := prom.DuplicateSamples(p.PromURL, p.HTTPClient)
+	if err != nil {
+		log.Printf("Couldn't check for duplicate samples: %s\n", err)
+		return
+	}
+	if duplicates == 0 {
+		return
+	}
+
+	for _, s := range watched {
+		// Duplicates are only counted for Prometheus as a whole, so they're
+		// put down to the silence if its own jobs are losing samples
+		rejected, err := prom.RejectedSamples(s.Jobs, p.PromURL, p.HTTPClient)
+		if err != nil {
+			log.Printf("Couldn't check whether the jobs of %s are losing samples: %s\n", s.ID, err)
+			continue
+		}
+		if rejected == 0 {
+			continue
+		}
+		// The series from before the silence still tell which ones collide,
+		// so the window reaches back to a minute before it was applied
+		window := now.Sub(*s.AppliedAt).Truncate(time.Second) + time.Minute
+		collide, err := p.seriesCollide(fmt.Sprintf("%s{%s!=%q}", s.Metric, s.Label, config.SilenceReplacement), s.Label, window)
+		if err != nil {
+			log.Printf("Couldn't check whether the series of %s collide: %s\n", s.ID, err)
+			continue
+		}
+		if !collide {
+			continue
+		}
+
+		err = p.switchToDrop(s, rejected)
+		if _, ok := err.(config.RulesAffectedError); ok {
+			blocked[s.ID] = true
+			if p.blockedSwitches[s.ID] {
+				continue
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("Couldn't switch the silence of %s to drop: %s", s.ID, err)
+			log.Println(err)
+			p.notify(failureEvent(s.Metric, s.Label, err))
+		}
+	}
+}
+
+// switchToDrop escalates s to drop, as its series collided and rejected
+// samples of its jobs weren't ingested at their last scrape, while holding
+// the patrol's lock. The switch is subject to p.Impact like any other
+// silence. Nothing is switched while the circuit breaker is tripped.
+func (p *Patrol) switchToDrop(s config.SilenceSummary, rejected float64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.breakerTripped() {
+		return nil
+	}
+
+	hcs := config.HighCardSeries{MetricName: s.Metric, HighCardLabelName: model.LabelName(s.Label), Jobs: s.Jobs}
+	if _, err := p.checkImpact(hcs, string(promcfg.RelabelDrop), false); err != nil {
+		return err
+	}
+
+	e := config.Escalation{
+		From:   currentStep(s),
+		To:     string(promcfg.RelabelDrop),
+		Reason: fmt.Sprintf("its series collided without label %s, and %g samples of its jobs weren't ingested at their last scrape", s.Label, rejected),
+	}
+	err := config.EscalateSilence(s.ID, e, p.PromConfigurator, p.BSConfigurator)
+	if err != nil {
+		return err
+	}
+	log.Printf("Switched the silence of %s from %s\n", s.ID, e)
+	p.notify(notify.Event{
+		Type:    notify.EventEscalation,
+		Time:    time.Now(),
+		Metric:  s.Metric,
+		Label:   s.Label,
+		Action:  e.To,
+		Jobs:    s.AppliedJobs,
+		Reason:  "collision",
+		Summary: fmt.Sprintf("The silence of label %s of %s made its series collide, and was switched from %s", s.Label, s.Metric, e),
+	})
+	return nil
+}
@@ -0,0 +1,91 @@
+package patrol_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/notify"
+	"github.com/open-fresh/bomb-squad/patrol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollisionPolicy(t *testing.T) {
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		switch {
+		case r.URL.Path == "/api/v1/series":
+			metric := strings.SplitN(r.URL.Query().Get("match[]"), "{", 2)[0]
+			fmt.Fprintf(w, `{"status":"success","data":[{"__name__":%q,"user":"1","instance":"x:80","job":"app"},{"__name__":%q,"user":"2","instance":"x:80","job":"app"}]}`, metric, metric)
+		case strings.HasPrefix(query, "count(count without (user)"):
+			// Without user, the series of both metrics have the same labels
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[0,"1"]}]}}`)
+		case strings.HasPrefix(query, "sum((sum by (job) (scrape_samples_post_metric_relabeling"):
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[0,"3"]}]}}`)
+		case strings.HasPrefix(query, "sum(increase(prometheus_target_scrapes_sample_duplicate_timestamp_total"):
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[0,"12"]}]}}`)
+		default:
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"metric_name":"a"},"value":[0,"500"]}]}}`)
+		}
+	}))
+	// Run never returns, and exits if Prometheus goes away, so the server is
+	// left up for the rest of the tests
+	promURL, _ := url.Parse(prometheus.URL)
+
+	events := make(chan notify.Event, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := notify.Event{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+		events <- e
+	}))
+	defer hook.Close()
+	n, err := notify.New(notify.Config{Webhooks: []notify.WebhookConfig{{Name: "test", URL: hook.URL, Events: []string{notify.EventEscalation}}}}, http.DefaultClient)
+	require.NoError(t, err)
+
+	pc := bstesting.NewPromMemoryConfigurator(t)
+	bc := bstesting.NewMemoryConfigurator(t, []byte{})
+	// b was silenced by replace before its collisions were noticed
+	s := config.HighCardSeries{MetricName: "b", HighCardLabelName: "user", Jobs: []string{"app"}}
+	require.NoError(t, patrol.ApplySilence(s, config.Silence{}, pc, bc))
+
+	p := &patrol.Patrol{
+		PromURL:           promURL,
+		Interval:          10 * time.Millisecond,
+		HighCardN:         5,
+		HighCardThreshold: 100,
+		HTTPClient:        http.DefaultClient,
+		PromConfigurator:  pc,
+		BSConfigurator:    bc,
+		Notifier:          n,
+		Collision:         patrol.CollisionPolicy{Predict: true, Watch: time.Minute},
+	}
+	go p.Run()
+
+	select {
+	case e := <-events:
+		require.Equal(t, "b", e.Metric)
+		require.Equal(t, "drop", e.Action)
+		require.Equal(t, "collision", e.Reason)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the colliding silence wasn't switched to drop")
+	}
+
+	silence, err := config.GetSilence("b.user", bc)
+	require.NoError(t, err)
+	require.Equal(t, "drop", silence.Action)
+	require.Len(t, silence.Escalations, 1)
+	require.Contains(t, silence.Escalations[0].Reason, "3 samples of its jobs")
+
+	// a was dropped from the start, as its series were seen to collide
+	silence, err = config.GetSilence("a.user", bc)
+	require.NoError(t, err)
+	require.Equal(t, "drop", silence.Action)
+	require.Empty(t, silence.Escalations)
+}
@@ -0,0 +1,50 @@
+package patrol
+
+import (
+	"time"
+
+	"github.com/open-fresh/bomb-squad/config"
+)
+
+// Detection is a label the patrol found exploding, along with the evidence
+// for it
+type Detection struct {
+	Metric string `json:"metric"`
+	Label  string `json:"label"`
+	// Growth is how much the metric's card_count grew over the last minute
+	Growth float64 `json:"growth"`
+	// Series is how many series of the metric Prometheus returned
+	Series int `json:"series"`
+	// DistinctValues is how many values of the label those series had
+	DistinctValues int `json:"distinctValues"`
+	// SampleValues are a few of those values
+	SampleValues []string `json:"sampleValues,omitempty"`
+	Jobs         []string `json:"jobs,omitempty"`
+	// Pods are the Kubernetes pods the series came from, as far as their
+	// labels tell
+	Pods []Pod `json:"pods,omitempty"`
+	// Instances are the scrape targets the series came from
+	Instances []string `json:"instances,omitempty"`
+	// Culprit is the rollout that most likely caused the explosion, looked
+	// for when the label is first silenced
+	Culprit    *config.Rollout `json:"culprit,omitempty"`
+	DetectedAt time.Time       `json:"detectedAt"`
+}
+
+// maxSampleValues is how many label values are kept as evidence
+const maxSampleValues = 5
+
+// Detections returns the exploding labels found by the last patrol cycle
+func (p *Patrol) Detections() []Detection {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	out := make([]Detection, len(p.detections))
+	copy(out, p.detections)
+	return out
+}
+
+func (p *Patrol) setDetections(d []Detection) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	p.detections = d
+}
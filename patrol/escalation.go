@@ -0,0 +1,227 @@
+package patrol
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/notify"
+	"github.com/open-fresh/bomb-squad/prom"
+	promcfg "github.com/prometheus/prometheus/config"
+)
+
+// EscalationPolicy says how the patrol checks that its silences work, and
+// what it tries next when they don't
+type EscalationPolicy struct {
+	// Cycles is how many patrol cycles after a silence is applied, or last
+	// escalated, its metric is checked for growth. Zero disables checking.
+	Cycles int
+	// Ladder holds the steps tried in turn while the metric keeps growing,
+	// from the mildest to the harshest
+	Ladder []string
+}
+
+// DefaultLadder tries every silence action, and then quarantines the target
+// the metric comes from
+var DefaultLadder = append(append([]string{}, config.SilenceActions...), config.EscalationQuarantine)
+
+// severity ranks escalation steps from the mildest to the harshest, or returns
+// -1 for an unknown step
+func severity(step string) int {
+	if step == config.EscalationQuarantine {
+		return len(config.SilenceActions)
+	}
+	for i, action := range config.SilenceActions {
+		if step == action {
+			return i
+		}
+	}
+	return -1
+}
+
+// ParseLadder parses a comma separated escalation ladder, such as
+// replace,drop,quarantine. Each step must be harsher than the one before.
+func ParseLadder(s string) ([]string, error) {
+	ladder := []string{}
+	for _, step := range strings.Split(s, ",") {
+		step = strings.TrimSpace(step)
+		if step == "" {
+			continue
+		}
+		if severity(step) < 0 {
+			return nil, fmt.Errorf("unknown escalation step '%s', expected one of %s", step, strings.Join(DefaultLadder, ", "))
+		}
+		if len(ladder) > 0 && severity(step) <= severity(ladder[len(ladder)-1]) {
+			return nil, fmt.Errorf("escalation step '%s' must come before '%s', expected steps in the order %s", step, ladder[len(ladder)-1], strings.Join(DefaultLadder, ", "))
+		}
+		ladder = append(ladder, step)
+	}
+	return ladder, nil
+}
+
+// currentStep returns the escalation step s is on
+func currentStep(s config.SilenceSummary) string {
+	if n := len(s.Escalations); n > 0 {
+		return s.Escalations[n-1].To
+	}
+	return s.Action
+}
+
+// next returns the first step of the ladder that is harsher than the one s is
+// on, or "" if there is none. Silences applied to remote_write endpoints skip
+// labeldrop.
+func (e EscalationPolicy) next(s config.SilenceSummary) string {
+	current := severity(currentStep(s))
+	for _, step := range e.Ladder {
+		if step == string(promcfg.RelabelLabelDrop) && len(s.RemoteWrite) > 0 {
+			continue
+		}
+		if severity(step) > current {
+			return step
+		}
+	}
+	return ""
+}
+
+// verifySilences checks that the metric of each silence the patrol made has
+// stopped growing, once the silence has been in place for
+// p.Escalation.Cycles cycles, and escalates it if not. Silences made by hand
+// are left alone. It's only used from the patrol's goroutine.
+func (p *Patrol) verifySilences() {
+	if p.Escalation.Cycles <= 0 {
+		return
+	}
+	silences, err := config.ListSilences(p.BSConfigurator)
+	if err != nil {
+		log.Printf("Couldn't list silences to verify: %s\n", err)
+		return
+	}
+
+	counted := map[string]int{}
+	for _, s := range silences {
+		if s.Manual || s.Verification != "" {
+			continue
+		}
+		// Counting starts over after each escalation
+		key := fmt.Sprintf("%s/%d", s.ID, len(s.Escalations))
+		counted[key] = p.verifying[key] + 1
+		if counted[key] < p.Escalation.Cycles {
+			continue
+		}
+
+		growth, err := prom.CardinalityGrowth(s.Metric, p.PromURL, p.HTTPClient)
+		if err != nil {
+			log.Printf("Couldn't check whether the silence of %s worked, will try again: %s\n", s.ID, err)
+			continue
+		}
+		p.settle(s, growth)
+	}
+	p.verifying = counted
+}
+
+// settle passes the silence s if its metric's growth has fallen below the
+// threshold, and escalates it otherwise, while holding the patrol's lock.
+// Nothing is escalated while the circuit breaker is tripped.
+func (p *Patrol) settle(s config.SilenceSummary, growth float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.breakerTripped() {
+		return
+	}
+
+	if growth < p.HighCardThreshold {
+		p.setVerification(s, config.VerificationPassed, fmt.Sprintf("card_count grew by %g in the last minute, with the silence at %s", growth, currentStep(s)))
+		return
+	}
+
+	e := config.Escalation{From: currentStep(s), To: p.Escalation.next(s), Growth: growth}
+	if e.To == "" {
+		p.setVerification(s, config.VerificationExhausted, fmt.Sprintf("card_count still grew by %g in the last minute, and %s was the last step of the escalation ladder", growth, e.From))
+		return
+	}
+	if e.To == config.EscalationQuarantine {
+		id, err := p.quarantineSource(s, growth)
+		if err != nil {
+			p.setVerification(s, config.VerificationExhausted, fmt.Sprintf("card_count still grew by %g in the last minute, and its target couldn't be quarantined: %s", growth, err))
+			return
+		}
+		e.Target = id
+	}
+
+	err := config.EscalateSilence(s.ID, e, p.PromConfigurator, p.BSConfigurator)
+	if err != nil {
+		err = fmt.Errorf("Couldn't escalate the silence of %s to %s: %s", s.ID, e.To, err)
+		log.Println(err)
+		p.notify(failureEvent(s.Metric, s.Label, err))
+		return
+	}
+	log.Printf("Escalated the silence of %s from %s\n", s.ID, e)
+	p.notify(notify.Event{
+		Type:    notify.EventEscalation,
+		Time:    time.Now(),
+		Metric:  s.Metric,
+		Label:   s.Label,
+		Action:  e.To,
+		Jobs:    s.AppliedJobs,
+		Target:  e.Target,
+		Reason:  "escalated",
+		Summary: fmt.Sprintf("The silence of label %s of %s didn't stop it growing, and was escalated from %s", s.Label, s.Metric, e),
+	})
+}
+
+// setVerification records the outcome of verifying s, telling the webhooks
+// if the escalation ladder ran out
+func (p *Patrol) setVerification(s config.SilenceSummary, verification, detail string) {
+	err := config.SetVerification(s.ID, verification, detail, p.BSConfigurator)
+	if err != nil {
+		err = fmt.Errorf("Couldn't record the verification of the silence of %s: %s", s.ID, err)
+		log.Println(err)
+		p.notify(failureEvent(s.Metric, s.Label, err))
+		return
+	}
+	log.Printf("Verification of the silence of %s %s: %s\n", s.ID, verification, detail)
+	if verification != config.VerificationExhausted {
+		return
+	}
+	p.notify(notify.Event{
+		Type:    notify.EventEscalation,
+		Time:    time.Now(),
+		Metric:  s.Metric,
+		Label:   s.Label,
+		Action:  s.Action,
+		Jobs:    s.AppliedJobs,
+		Reason:  verification,
+		Summary: fmt.Sprintf("Label %s of %s is still exploding despite its silence: %s", s.Label, s.Metric, detail),
+	})
+}
+
+// quarantineSource quarantines the instance the series of s's metric come
+// from, and returns the quarantine's ID. Metrics coming from more than one
+// instance aren't quarantined, as there's no telling which is to blame.
+func (p *Patrol) quarantineSource(s config.SilenceSummary, growth float64) (string, error) {
+	instances, err := prom.Instances(s.Metric, p.PromURL, p.HTTPClient)
+	if err != nil {
+		return "", err
+	}
+	if len(instances) != 1 {
+		return "", fmt.Errorf("its series don't come from a single instance")
+	}
+
+	id := config.TargetInstance + ":" + instances[0]
+	if _, err := config.GetQuarantine(id, p.BSConfigurator); err == nil {
+		return id, nil
+	}
+
+	q := p.Quarantine.quarantine()
+	q.Jobs = s.Jobs
+	q.Reason = fmt.Sprintf("%s kept growing by %g a minute with label %s silenced by %s", s.Metric, growth, s.Label, currentStep(s))
+	err = config.ApplyQuarantine(id, q, p.PromConfigurator, p.BSConfigurator)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Quarantined %s, as %s\n", id, q.Reason)
+	p.notifyQuarantine(id)
+	return id, nil
+}
@@ -0,0 +1,95 @@
+package patrol_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/notify"
+	"github.com/open-fresh/bomb-squad/patrol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEscalationLadder(t *testing.T) {
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/series":
+			fmt.Fprint(w, `{"status":"success","data":[{"__name__":"foo","bar":"1","instance":"localhost:9090","job":"prometheus"}]}`)
+		case strings.HasPrefix(r.URL.Query().Get("query"), "count by (instance)"):
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"instance":"localhost:9090"},"value":[0,"1"]}]}}`)
+		case strings.HasPrefix(r.URL.Query().Get("query"), "topk"):
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+		default:
+			// foo never stops growing, whatever is done to it
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"metric_name":"foo"},"value":[0,"500"]}]}}`)
+		}
+	}))
+	// Run never returns, and exits if Prometheus goes away, so the server is
+	// left up for the rest of the tests
+	promURL, _ := url.Parse(prometheus.URL)
+
+	events := make(chan notify.Event, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := notify.Event{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+		events <- e
+	}))
+	defer hook.Close()
+	n, err := notify.New(notify.Config{Webhooks: []notify.WebhookConfig{{Name: "test", URL: hook.URL, Events: []string{notify.EventEscalation}}}}, http.DefaultClient)
+	require.NoError(t, err)
+
+	pc := bstesting.NewPromMemoryConfigurator(t)
+	bc := bstesting.NewMemoryConfigurator(t, []byte{})
+	s := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar", Jobs: []string{"prometheus"}}
+	require.NoError(t, patrol.ApplySilence(s, config.Silence{}, pc, bc))
+
+	p := &patrol.Patrol{
+		PromURL:           promURL,
+		Interval:          10 * time.Millisecond,
+		HighCardThreshold: 100,
+		HTTPClient:        http.DefaultClient,
+		PromConfigurator:  pc,
+		BSConfigurator:    bc,
+		Notifier:          n,
+		Escalation:        patrol.EscalationPolicy{Cycles: 2, Ladder: patrol.DefaultLadder},
+	}
+	go p.Run()
+
+	for _, want := range []string{"labeldrop", "drop", config.EscalationQuarantine, "drop"} {
+		select {
+		case e := <-events:
+			require.Equal(t, want, e.Action)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no escalation to %s", want)
+		}
+	}
+
+	silence, err := config.GetSilence("foo.bar", bc)
+	require.NoError(t, err)
+	require.Equal(t, "drop", silence.Action)
+	require.Equal(t, config.VerificationExhausted, silence.Verification)
+	require.Len(t, silence.Escalations, 3)
+	require.Equal(t, "instance:localhost:9090", silence.Escalations[2].Target)
+
+	q, err := config.GetQuarantine("instance:localhost:9090", bc)
+	require.NoError(t, err)
+	require.Equal(t, []string{"prometheus"}, q.AppliedJobs)
+}
+
+func TestParseLadder(t *testing.T) {
+	ladder, err := patrol.ParseLadder("replace, drop,quarantine")
+	require.NoError(t, err)
+	require.Equal(t, []string{"replace", "drop", "quarantine"}, ladder)
+
+	for _, bad := range []string{"replace,keep", "drop,replace", "quarantine,drop", "drop,drop"} {
+		_, err := patrol.ParseLadder(bad)
+		require.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,130 @@
+package patrol
+
+import (
+	"log"
+
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/prom"
+	promcfg "github.com/prometheus/prometheus/config"
+)
+
+// What the patrol does about a silence that may break recording or alerting
+// rules. off doesn't look for such rules, warn records them with the silence,
+// and block refuses the silence unless it's forced or approved by hand.
+const (
+	ImpactOff   = "off"
+	ImpactWarn  = "warn"
+	ImpactBlock = "block"
+)
+
+// ImpactPolicy says how the patrol looks for the rules a silence may break,
+// and what it does about them
+type ImpactPolicy struct {
+	// Mode is ImpactOff, ImpactWarn or ImpactBlock. Empty means off.
+	Mode string
+	// RuleFilesDir is the directory the relative rule_files of the
+	// Prometheus config are read from
+	RuleFilesDir string
+}
+
+// impactReason says how a rule whose expression refers to refs may be broken
+// by silencing label of metric with action, or returns "" if it won't be.
+// replace breaks selectors matching on the label and aggregations and joins
+// using it, labeldrop does the same for every metric, and drop breaks
+// everything selecting the metric.
+func impactReason(refs prom.Refs, metric, label, action string) string {
+	switch action {
+	case string(promcfg.RelabelDrop):
+		if refs.Metrics[metric] {
+			return "selects " + metric
+		}
+		return ""
+	case string(promcfg.RelabelLabelDrop):
+		for _, labels := range refs.Matchers {
+			if labels[label] {
+				return "matches on " + label
+			}
+		}
+	default:
+		if !refs.Metrics[metric] {
+			return ""
+		}
+		if refs.Matchers[metric][label] {
+			return "matches on " + label + " of " + metric
+		}
+	}
+	if refs.Grouping[label] {
+		return "groups or joins on " + label
+	}
+	return ""
+}
+
+// affectedRules returns the rules that silencing s with action may break,
+// looking at the rule files of the Prometheus config and the rules
+// Prometheus has loaded. Rules that can't be read are logged and skipped.
+func (p *Patrol) affectedRules(s config.HighCardSeries, action string) []config.AffectedRule {
+	id := s.MetricName + "." + string(s.HighCardLabelName)
+	rules := []prom.Rule{}
+	promConfig, err := config.ReadPromConfig(p.PromConfigurator)
+	if err != nil {
+		log.Printf("Couldn't read rule files to find what silencing %s may break: %s\n", id, err)
+	} else {
+		fromFiles, errs := prom.ReadRuleFiles(promConfig.RuleFiles, p.Impact.RuleFilesDir)
+		for _, err := range errs {
+			log.Printf("Skipping rules when finding what silencing %s may break: %s\n", id, err)
+		}
+		rules = append(rules, fromFiles...)
+	}
+	loaded, err := prom.FetchRules(p.PromURL, p.HTTPClient)
+	if err != nil {
+		log.Printf("Couldn't fetch rules to find what silencing %s may break: %s\n", id, err)
+	} else {
+		rules = append(rules, loaded...)
+	}
+
+	// Rules loaded by Prometheus usually come from the rule files too
+	seen := map[string]bool{}
+	out := []config.AffectedRule{}
+	for _, r := range rules {
+		key := r.Group + "/" + r.Name + "/" + r.Query
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		refs, err := prom.ExprRefs(r.Query)
+		if err != nil {
+			log.Printf("Skipping rule %s in group %s when finding what silencing %s may break: %s\n", r.Name, r.Group, id, err)
+			continue
+		}
+		reason := impactReason(refs, s.MetricName, string(s.HighCardLabelName), action)
+		if reason == "" {
+			continue
+		}
+		out = append(out, config.AffectedRule{Group: r.Group, Name: r.Name, Type: r.Type, File: r.File, Reason: reason})
+	}
+	return out
+}
+
+// checkImpact returns the rules that silencing s with action may break, or a
+// config.RulesAffectedError if there are some and p.Impact blocks such
+// silences. Forced silences are never blocked.
+func (p *Patrol) checkImpact(s config.HighCardSeries, action string, force bool) ([]config.AffectedRule, error) {
+	if p.Impact.Mode == "" || p.Impact.Mode == ImpactOff {
+		return nil, nil
+	}
+	if action == "" {
+		action = string(promcfg.RelabelReplace)
+	}
+
+	rules := p.affectedRules(s, action)
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	id := s.MetricName + "." + string(s.HighCardLabelName)
+	if p.Impact.Mode == ImpactBlock && !force {
+		return nil, config.RulesAffectedError{ID: id, Rules: rules}
+	}
+	log.Printf("Silencing %s may break %d rules\n", id, len(rules))
+	return rules, nil
+}
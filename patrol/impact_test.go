@@ -0,0 +1,56 @@
+package patrol_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/patrol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImpactPolicy(t *testing.T) {
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v1/rules", r.URL.Path)
+		fmt.Fprint(w, `{"status":"success","data":{"groups":[{"name":"api","file":"api.yml","rules":[`+
+			`{"name":"job:requests:rate5m","type":"recording","query":"sum by (job) (rate(requests_total[5m]))"},`+
+			`{"name":"SlowPath","type":"alerting","query":"latency_seconds{path=\"/login\"} > 1"}]}]}}`)
+	}))
+	defer prometheus.Close()
+	promURL, _ := url.Parse(prometheus.URL)
+
+	p := &patrol.Patrol{
+		PromURL:          promURL,
+		HTTPClient:       http.DefaultClient,
+		PromConfigurator: bstesting.NewPromMemoryConfigurator(t),
+		BSConfigurator:   bstesting.NewMemoryConfigurator(t, []byte{}),
+		Impact:           patrol.ImpactPolicy{Mode: patrol.ImpactBlock},
+	}
+
+	// Neither rule refers to the user label of requests_total
+	s, err := p.CreateSilence(config.SilenceRequest{Metric: "requests_total", Label: "user"})
+	require.NoError(t, err)
+	require.Empty(t, s.AffectedRules)
+
+	_, err = p.CreateSilence(config.SilenceRequest{Metric: "latency_seconds", Label: "path"})
+	require.IsType(t, config.RulesAffectedError{}, err)
+	_, err = config.GetSilence("latency_seconds.path", p.BSConfigurator)
+	require.IsType(t, config.SilenceNotFoundError{}, err)
+
+	s, err = p.CreateSilence(config.SilenceRequest{Metric: "latency_seconds", Label: "path", Force: true})
+	require.NoError(t, err)
+	require.Equal(t, []config.AffectedRule{
+		{Group: "api", Name: "SlowPath", Type: "alerting", File: "api.yml", Reason: "matches on path of latency_seconds"},
+	}, s.AffectedRules)
+
+	// Dropping the label breaks every rule grouping by it, whatever the metric
+	p.Impact.Mode = patrol.ImpactWarn
+	s, err = p.CreateSilence(config.SilenceRequest{Metric: "other", Label: "job", Action: "labeldrop"})
+	require.NoError(t, err)
+	require.Len(t, s.AffectedRules, 1)
+	require.Equal(t, "job:requests:rate5m", s.AffectedRules[0].Name)
+}
@@ -0,0 +1,135 @@
+package patrol
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/notify"
+)
+
+// observed is what SyncNotifications saw last time. It's only used from the
+// patrol's goroutine.
+type observed struct {
+	// silences is nil until the first sync, so that silences already in
+	// place at start-up aren't announced as new
+	silences   map[string]config.SilenceSummary
+	detections map[string]bool
+}
+
+// SyncNotifications works out what has changed since it was last called,
+// and tells the webhooks and Alertmanagers about it. Silences are compared
+// with the Bomb Squad config rather than tracked as they're made, so those
+// added or removed by the CLI are noticed too. Run calls it after every
+// cycle.
+func (p *Patrol) SyncNotifications() {
+	if p.Notifier == nil && len(p.Alertmanagers) == 0 {
+		return
+	}
+	silences, err := config.ListSilences(p.BSConfigurator)
+	if err != nil {
+		log.Printf("Couldn't list silences to notify about: %s\n", err)
+		p.notify(notify.Event{Type: notify.EventFailure, Summary: fmt.Sprintf("Couldn't list silences: %s", err)})
+		return
+	}
+	now := time.Now()
+	detections := p.Detections()
+
+	detected := map[string]bool{}
+	for _, d := range detections {
+		id := d.Metric + "." + d.Label
+		detected[id] = true
+		if !p.seen.detections[id] {
+			p.notify(detectionEvent(d))
+		}
+	}
+
+	current := map[string]config.SilenceSummary{}
+	for _, s := range silences {
+		current[s.ID] = s
+		if _, ok := p.seen.silences[s.ID]; !ok && p.seen.silences != nil {
+			p.notify(silenceEvent(s))
+		}
+	}
+	removed := []config.SilenceSummary{}
+	for id, s := range p.seen.silences {
+		if _, ok := current[id]; !ok {
+			removed = append(removed, s)
+			p.notify(unsilenceEvent(s, now))
+		}
+	}
+	p.seen = observed{silences: current, detections: detected}
+
+	p.syncAlerts(now, detections, silences, removed)
+}
+
+// notify passes e to the Notifier, if there is one
+func (p *Patrol) notify(e notify.Event) {
+	if p.Notifier != nil {
+		p.Notifier.Notify(e)
+	}
+}
+
+// removalReason says whether a silence that has gone away expired or was
+// removed
+func removalReason(s config.SilenceSummary, now time.Time) string {
+	if s.ExpiresAt != nil && !s.ExpiresAt.After(now) {
+		return "expired"
+	}
+	return "removed"
+}
+
+func detectionEvent(d Detection) notify.Event {
+	return notify.Event{
+		Type:   notify.EventDetection,
+		Time:   d.DetectedAt,
+		Metric: d.Metric,
+		Label:  d.Label,
+		Jobs:   d.Jobs,
+		Summary: fmt.Sprintf("Label %s of %s is exploding: card_count grew by %g in the last minute, and %d series have %d distinct values of it",
+			d.Label, d.Metric, d.Growth, d.Series, d.DistinctValues),
+	}
+}
+
+func silenceEvent(s config.SilenceSummary) notify.Event {
+	source := "the patrol"
+	if s.Manual {
+		source = "hand"
+	}
+	expiry := "doesn't expire"
+	if s.ExpiresAt != nil {
+		expiry = "expires at " + s.ExpiresAt.Format(time.RFC3339)
+	}
+	e := notify.Event{
+		Type:    notify.EventSilence,
+		Metric:  s.Metric,
+		Label:   s.Label,
+		Action:  s.Action,
+		Jobs:    s.AppliedJobs,
+		Summary: fmt.Sprintf("Label %s of %s silenced with action %s, as requested by %s. It %s.", s.Label, s.Metric, s.Action, source, expiry),
+	}
+	if s.AppliedAt != nil {
+		e.Time = *s.AppliedAt
+	}
+	return e
+}
+
+func unsilenceEvent(s config.SilenceSummary, now time.Time) notify.Event {
+	reason := removalReason(s, now)
+	return notify.Event{
+		Type:    notify.EventUnsilence,
+		Time:    now,
+		Metric:  s.Metric,
+		Label:   s.Label,
+		Action:  s.Action,
+		Jobs:    s.AppliedJobs,
+		Reason:  reason,
+		Summary: fmt.Sprintf("Silence on label %s of %s %s", s.Label, s.Metric, reason),
+	}
+}
+
+// failureEvent describes something the patrol or the reconciler failed to do
+func failureEvent(metricName, labelName string, err error) notify.Event {
+	return notify.Event{Type: notify.EventFailure, Metric: metricName, Label: labelName, Summary: err.Error()}
+}
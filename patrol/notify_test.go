@@ -0,0 +1,59 @@
+package patrol_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/notify"
+	"github.com/open-fresh/bomb-squad/patrol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncNotificationsWebhooks(t *testing.T) {
+	mu := sync.Mutex{}
+	events := []notify.Event{}
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := notify.Event{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}))
+	defer hook.Close()
+
+	n, err := notify.New(notify.Config{Webhooks: []notify.WebhookConfig{{Name: "test", URL: hook.URL}}}, http.DefaultClient)
+	require.NoError(t, err)
+
+	bc := bstesting.NewMemoryConfigurator(t, []byte{})
+	p := &patrol.Patrol{
+		PromConfigurator: bstesting.NewPromMemoryConfigurator(t),
+		BSConfigurator:   bc,
+		Notifier:         n,
+	}
+
+	// Silences in place before the first sync aren't announced
+	_, err = p.CreateSilence(config.SilenceRequest{Metric: "old", Label: "bar"})
+	require.NoError(t, err)
+	p.SyncNotifications()
+
+	_, err = p.CreateSilence(config.SilenceRequest{Metric: "foo", Label: "bar", Action: "drop"})
+	require.NoError(t, err)
+	p.SyncNotifications()
+
+	// Removed behind the patrol's back, as the CLI does
+	require.NoError(t, config.RemoveSilence("foo.bar", p.PromConfigurator, bc))
+	p.SyncNotifications()
+	n.Close()
+
+	require.Len(t, events, 2)
+	require.Equal(t, notify.EventSilence, events[0].Type)
+	require.Equal(t, "drop", events[0].Action)
+	require.Equal(t, notify.EventUnsilence, events[1].Type)
+	require.Equal(t, "foo", events[1].Metric)
+	require.Equal(t, "removed", events[1].Reason)
+}
@@ -5,9 +5,12 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
+	"github.com/open-fresh/bomb-squad/alertmanager"
 	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/notify"
 	"github.com/open-fresh/bomb-squad/prom"
 )
 
@@ -23,15 +26,83 @@ type Patrol struct {
 	HTTPClient        *http.Client
 	PromConfigurator  config.Configurator
 	BSConfigurator    config.Configurator
+	// Alertmanagers are told about detections and silences. May be empty.
+	Alertmanagers []*alertmanager.Client
+	// Notifier sends events to webhooks. May be nil.
+	Notifier *notify.Notifier
+	// Workloads is told about the labels the patrol silences. May be nil.
+	Workloads WorkloadRecorder
+	// Rollouts finds the rollouts behind explosions. May be nil.
+	Rollouts RolloutCorrelator
+	// Quarantine says when the patrol quarantines a target on its own
+	Quarantine QuarantinePolicy
+	// Escalation says how the patrol checks that its silences work
+	Escalation EscalationPolicy
+	// Breaker limits how much the patrol changes by itself
+	Breaker BreakerPolicy
+	// Approval says whether the patrol's silences need approving
+	Approval ApprovalPolicy
+	// Impact says what happens to silences that may break rules
+	Impact ImpactPolicy
+	// Purge says whether silenced series may be deleted from the TSDB
+	Purge PurgePolicy
+	// Collision says how the patrol keeps its silences from making series
+	// collide
+	Collision CollisionPolicy
+	// Baseline says whether the patrol's silences keep the label values
+	// seen before an explosion
+	Baseline BaselinePolicy
+	// RemoteWrite holds the URLs of the remote_write endpoints the patrol's
+	// silences are applied to as well, or config.AllRemoteWrites
+	RemoteWrite []string
+
+	// mu serialises changes to the Prometheus and Bomb Squad configs between
+	// the patrol, the reconciler and the API
+	mu sync.Mutex
+	// silenced holds the silences seen by the last reconciliation
+	silenced map[string]config.SilenceSummary
+	// silencedAt holds when the patrol applied its silences of the last
+	// hour, for state backends that keep no history
+	silencedAt []time.Time
+	// tripped is set when the circuit breaker trips and the state backend
+	// can't record it
+	tripped bool
+
+	// stateMu guards what the patrol reports about itself
+	stateMu     sync.Mutex
+	detections  []Detection
+	startedAt   time.Time
+	lastCycleAt time.Time
+
+	seen   observed
+	alerts alertState
+	// verifying counts the cycles each silence has been waiting to be
+	// verified. It's only used from the patrol's goroutine.
+	verifying map[string]int
+	// blocked holds the silences the impact policy blocked in the last
+	// cycle, so that each is only reported once. It's only used from the
+	// patrol's goroutine.
+	blocked map[string]bool
+	// blockedSwitches holds the silences the impact policy kept from being
+	// switched to drop at the last watch for collisions, so that each is
+	// only reported once. It's only used from the patrol's goroutine.
+	blockedSwitches map[string]bool
 }
 
 func (p *Patrol) Run() {
+	p.setStarted()
 	ticker := time.NewTicker(p.Interval)
 	for range ticker.C {
 		err := p.getTopCardinalities()
 		if err != nil {
+			if p.Notifier != nil {
+				p.Notifier.Notify(failureEvent("", "", fmt.Errorf("Bomb Squad is exiting, as it couldn't retrieve top cardinalities: %s", err)))
+				p.Notifier.Close()
+			}
 			log.Fatalf("Couldn't retrieve top cardinalities: %s\n", err)
 		}
+		p.setCycleFinished()
+		p.SyncNotifications()
 	}
 }
 
@@ -0,0 +1,105 @@
+package patrol
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/prom"
+)
+
+// PurgePolicy says whether the series a silenced label exploded into may be
+// deleted from Prometheus' TSDB, and how. The patrol never purges series by
+// itself; they're only purged when asked to with bs purge or the API.
+type PurgePolicy struct {
+	// Allowed lets silences be purged. It needs Prometheus' admin API.
+	Allowed bool
+	// Snapshot takes a TSDB snapshot before deleting anything, so that the
+	// series can be restored
+	Snapshot bool
+	// Window is how long before the silence was applied the deleted samples
+	// start
+	Window time.Duration
+}
+
+// purgeMatcher returns the selector of the series s's label exploded into.
+// The series a replace silence rewrote to config.SilenceReplacement are kept,
+// as are those with the values of its baseline.
+func purgeMatcher(s config.SilenceSummary) string {
+	matchers := []string{
+		fmt.Sprintf("__name__=%q", s.Metric),
+		fmt.Sprintf("%s!=%q", s.Label, ""),
+		fmt.Sprintf("%s!=%q", s.Label, config.SilenceReplacement),
+	}
+	if s.Baseline != nil && len(s.Baseline.Values) > 0 {
+		values := []string{}
+		for _, v := range s.Baseline.Values {
+			values = append(values, regexp.QuoteMeta(v))
+		}
+		matchers = append(matchers, fmt.Sprintf("%s!~%q", s.Label, strings.Join(values, "|")))
+	}
+	if len(s.Jobs) > 0 {
+		jobs := []string{}
+		for _, job := range s.Jobs {
+			jobs = append(jobs, regexp.QuoteMeta(job))
+		}
+		matchers = append(matchers, fmt.Sprintf("job=~%q", strings.Join(jobs, "|")))
+	}
+	return "{" + strings.Join(matchers, ",") + "}"
+}
+
+// PurgeSilence deletes the series the label of the silence with the given ID
+// exploded into from Prometheus' TSDB, from p.Purge.Window before the silence
+// was applied until now, and then cleans the tombstones left behind. A
+// snapshot is taken first if p.Purge asks for one. The request must confirm
+// the silence's ID. Silences applied to remote_write endpoints alone can't be
+// purged. The purged silence is returned.
+func (p *Patrol) PurgeSilence(id string, req config.PurgeRequest) (config.SilenceSummary, error) {
+	if !p.Purge.Allowed {
+		return config.SilenceSummary{}, config.PurgeNotAllowedError{Reason: "purging series is turned off, start Bomb Squad with -allow-purge to turn it on"}
+	}
+	s, err := config.GetSilence(id, p.BSConfigurator)
+	if err != nil {
+		return config.SilenceSummary{}, err
+	}
+	if s.RemoteWriteOnly {
+		return config.SilenceSummary{}, config.InvalidSilenceError{Reason: fmt.Sprintf("the silence of %s applies to remote_write endpoints alone, so the series it silences are still being ingested and purging them would only lose data", id)}
+	}
+	if req.Confirm != id {
+		return config.SilenceSummary{}, config.InvalidSilenceError{Reason: fmt.Sprintf("purging deletes series for good, confirm it by giving the silence's ID, %s", id)}
+	}
+
+	purge := config.Purge{Matcher: purgeMatcher(s), End: time.Now().UTC()}
+	purge.Start = purge.End.Add(-p.Purge.Window)
+	if s.AppliedAt != nil {
+		purge.Start = s.AppliedAt.Add(-p.Purge.Window)
+	}
+	if p.Purge.Snapshot {
+		purge.Snapshot, err = prom.Snapshot(p.PromURL, p.HTTPClient)
+		if err != nil {
+			return config.SilenceSummary{}, fmt.Errorf("Couldn't take a TSDB snapshot before purging %s, so nothing was deleted: %s", id, err)
+		}
+		log.Printf("Took TSDB snapshot %s before purging %s\n", purge.Snapshot, id)
+	}
+	err = prom.DeleteSeries(purge.Matcher, purge.Start, purge.End, p.PromURL, p.HTTPClient)
+	if err != nil {
+		return config.SilenceSummary{}, err
+	}
+	cleanErr := prom.CleanTombstones(p.PromURL, p.HTTPClient)
+
+	// The series are gone whether or not the tombstones could be cleaned
+	p.mu.Lock()
+	err = config.RecordPurge(id, purge, p.BSConfigurator)
+	p.mu.Unlock()
+	if err != nil {
+		log.Printf("Purged %s, but couldn't record it: %s\n", purge, err)
+	}
+	if cleanErr != nil {
+		return config.SilenceSummary{}, fmt.Errorf("Deleted %s, but couldn't clean the tombstones, which Prometheus will do at its next compaction: %s", purge, cleanErr)
+	}
+	log.Printf("Purged %s\n", purge)
+	return config.GetSilence(id, p.BSConfigurator)
+}
@@ -0,0 +1,83 @@
+package patrol_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/patrol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurgeSilence(t *testing.T) {
+	calls := []string{}
+	deleted := url.Values{}
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		calls = append(calls, r.URL.Path)
+		switch r.URL.Path {
+		case "/api/v1/admin/tsdb/snapshot":
+			fmt.Fprint(w, `{"status":"success","data":{"name":"20181016T100000Z-1234"}}`)
+		case "/api/v1/admin/tsdb/delete_series":
+			deleted = r.URL.Query()
+			w.WriteHeader(http.StatusNoContent)
+		case "/api/v1/admin/tsdb/clean_tombstones":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer prometheus.Close()
+	promURL, _ := url.Parse(prometheus.URL)
+
+	p := &patrol.Patrol{
+		PromURL:          promURL,
+		HTTPClient:       http.DefaultClient,
+		PromConfigurator: bstesting.NewPromMemoryConfigurator(t),
+		BSConfigurator:   bstesting.NewMemoryConfigurator(t, []byte{}),
+	}
+	s, err := p.CreateSilence(config.SilenceRequest{Metric: "foo", Label: "bar", Jobs: []string{"prometheus"}})
+	require.NoError(t, err)
+
+	_, err = p.PurgeSilence("foo.bar", config.PurgeRequest{Confirm: "foo.bar"})
+	require.IsType(t, config.PurgeNotAllowedError{}, err)
+
+	p.Purge = patrol.PurgePolicy{Allowed: true, Snapshot: true, Window: time.Hour}
+	_, err = p.PurgeSilence("foo.bar", config.PurgeRequest{})
+	require.IsType(t, config.InvalidSilenceError{}, err)
+	_, err = p.PurgeSilence("foo.baz", config.PurgeRequest{Confirm: "foo.baz"})
+	require.IsType(t, config.SilenceNotFoundError{}, err)
+	require.Empty(t, calls)
+
+	s, err = p.PurgeSilence("foo.bar", config.PurgeRequest{Confirm: "foo.bar"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"/api/v1/admin/tsdb/snapshot", "/api/v1/admin/tsdb/delete_series", "/api/v1/admin/tsdb/clean_tombstones"}, calls)
+	require.Equal(t, `{__name__="foo",bar!="",bar!="bs_silence",job=~"prometheus"}`, deleted.Get("match[]"))
+	require.Equal(t, fmt.Sprint(s.AppliedAt.Add(-time.Hour).Unix()), deleted.Get("start"))
+
+	require.Len(t, s.Purges, 1)
+	require.Equal(t, "20181016T100000Z-1234", s.Purges[0].Snapshot)
+	require.Equal(t, deleted.Get("match[]"), s.Purges[0].Matcher)
+	events, err := config.History(0, p.BSConfigurator)
+	require.NoError(t, err)
+	require.Equal(t, config.EventPurged, events[len(events)-1].Type)
+
+	// The values a baseline keeps were never part of the explosion
+	require.NoError(t, config.SetBaseline("foo.bar", config.Baseline{Values: []string{"a.b", "c"}}, p.PromConfigurator, p.BSConfigurator))
+	_, err = p.PurgeSilence("foo.bar", config.PurgeRequest{Confirm: "foo.bar"})
+	require.NoError(t, err)
+	require.Equal(t, `{__name__="foo",bar!="",bar!="bs_silence",bar!~"a\\.b|c",job=~"prometheus"}`, deleted.Get("match[]"))
+
+	// The series of remote_write-only silences are still in the TSDB
+	calls = nil
+	_, err = p.CreateSilence(config.SilenceRequest{Metric: "foo", Label: "baz", RemoteWrite: []string{config.AllRemoteWrites}, RemoteWriteOnly: true})
+	require.NoError(t, err)
+	_, err = p.PurgeSilence("foo.baz", config.PurgeRequest{Confirm: "foo.baz"})
+	require.IsType(t, config.InvalidSilenceError{}, err)
+	require.Empty(t, calls)
+}
@@ -0,0 +1,221 @@
+package patrol
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/notify"
+	"github.com/prometheus/common/model"
+)
+
+// QuarantinePolicy says when the patrol quarantines a scrape target on its
+// own, rather than only silencing the labels exploding in it
+type QuarantinePolicy struct {
+	// After is how many of the metrics found exploding in one cycle must
+	// come from a single instance for it to be quarantined. Zero disables
+	// automatic quarantines.
+	After int
+	// Mode is one of config.QuarantineModes. Empty means drop.
+	Mode string
+	// SampleLimit is the limit set in sample_limit mode
+	SampleLimit uint
+	// TTL is how long automatic quarantines last. Zero means
+	// config.DefaultQuarantineTTL.
+	TTL model.Duration
+}
+
+// quarantine returns a quarantine made by the patrol under policy q, with
+// neither jobs nor a reason
+func (q QuarantinePolicy) quarantine() config.Quarantine {
+	out := config.Quarantine{Mode: q.Mode, SampleLimit: q.SampleLimit, TTL: q.TTL}
+	if out.Mode == "" {
+		out.Mode = config.QuarantineDrop
+	}
+	if out.TTL == 0 {
+		out.TTL = config.DefaultQuarantineTTL
+	}
+	return out
+}
+
+// maxInstances limits how many instances are kept for each detection
+const maxInstances = 10
+
+// instancesInSeries returns the distinct instances that series came from,
+// sorted
+func instancesInSeries(series []map[string]string) []string {
+	seen := map[string]bool{}
+	for _, labels := range series {
+		if instance := labels["instance"]; instance != "" {
+			seen[instance] = true
+		}
+	}
+
+	instances := []string{}
+	for instance := range seen {
+		instances = append(instances, instance)
+	}
+	sort.Strings(instances)
+	if len(instances) > maxInstances {
+		instances = instances[:maxInstances]
+	}
+	return instances
+}
+
+// quarantineTargets quarantines every instance that was alone behind at least
+// p.Quarantine.After of detections, while holding the patrol's lock, unless
+// the circuit breaker is tripped
+func (p *Patrol) quarantineTargets(detections []Detection) {
+	if p.Quarantine.After <= 0 {
+		return
+	}
+
+	byInstance := map[string][]Detection{}
+	for _, d := range detections {
+		if len(d.Instances) == 1 {
+			byInstance[d.Instances[0]] = append(byInstance[d.Instances[0]], d)
+		}
+	}
+	instances := []string{}
+	for instance, ds := range byInstance {
+		if len(ds) >= p.Quarantine.After {
+			instances = append(instances, instance)
+		}
+	}
+	if len(instances) == 0 {
+		return
+	}
+	sort.Strings(instances)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.breakerTripped() {
+		return
+	}
+
+	for _, instance := range instances {
+		id := config.TargetInstance + ":" + instance
+		if _, err := config.GetQuarantine(id, p.BSConfigurator); err == nil {
+			continue
+		}
+
+		q := p.Quarantine.quarantine()
+		metrics := []string{}
+		everyJob := false
+		for _, d := range byInstance[instance] {
+			metrics = append(metrics, d.Metric)
+			everyJob = everyJob || len(d.Jobs) == 0
+			q.Jobs = appendMissing(q.Jobs, d.Jobs...)
+		}
+		if everyJob {
+			q.Jobs = nil
+		}
+		sort.Strings(q.Jobs)
+		q.Reason = fmt.Sprintf("%d exploding metrics came from it alone: %s", len(metrics), strings.Join(metrics, ", "))
+
+		err := config.ApplyQuarantine(id, q, p.PromConfigurator, p.BSConfigurator)
+		if err != nil {
+			err = fmt.Errorf("Couldn't quarantine %s: %s", id, err)
+			log.Println(err)
+			p.notify(failureEvent("", "", err))
+			continue
+		}
+		log.Printf("Quarantined %s, as %s\n", id, q.Reason)
+		p.notifyQuarantine(id)
+	}
+}
+
+func appendMissing(to []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, t := range to {
+			found = found || t == v
+		}
+		if !found {
+			to = append(to, v)
+		}
+	}
+	return to
+}
+
+// CreateQuarantine validates and applies a quarantine requested by hand,
+// while holding the patrol's lock. The new quarantine is returned.
+func (p *Patrol) CreateQuarantine(req config.QuarantineRequest) (config.QuarantineSummary, error) {
+	q, err := req.Parse()
+	if err != nil {
+		return config.QuarantineSummary{}, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	err = p.checkJobs(q.Jobs)
+	if err != nil {
+		return config.QuarantineSummary{}, err
+	}
+	err = config.ApplyQuarantine(req.Target, q, p.PromConfigurator, p.BSConfigurator)
+	if err != nil {
+		return config.QuarantineSummary{}, err
+	}
+	p.notifyQuarantine(req.Target)
+	return config.GetQuarantine(req.Target, p.BSConfigurator)
+}
+
+// RemoveQuarantine lifts the quarantine with the given ID while holding the
+// patrol's lock. The removed quarantine is returned.
+func (p *Patrol) RemoveQuarantine(id string) (config.QuarantineSummary, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	summary, err := config.GetQuarantine(id, p.BSConfigurator)
+	if err != nil {
+		return config.QuarantineSummary{}, err
+	}
+
+	err = config.RemoveQuarantine(id, p.PromConfigurator, p.BSConfigurator)
+	if err != nil {
+		return config.QuarantineSummary{}, err
+	}
+	p.notify(releaseEvent(id, "removed", time.Now()))
+	return summary, nil
+}
+
+// notifyQuarantine tells the webhooks about the quarantine with the given ID,
+// which has just been applied
+func (p *Patrol) notifyQuarantine(id string) {
+	q, err := config.GetQuarantine(id, p.BSConfigurator)
+	if err != nil {
+		return
+	}
+	source := "the patrol"
+	if q.Manual {
+		source = "hand"
+	}
+	expiry := "It doesn't expire."
+	if q.ExpiresAt != nil {
+		expiry = "It expires at " + q.ExpiresAt.Format(time.RFC3339) + "."
+	}
+	summary := fmt.Sprintf("Target %s quarantined in %s mode, as requested by %s. %s", id, q.Mode, source, expiry)
+	if q.Reason != "" {
+		summary += " Reason: " + q.Reason
+	}
+
+	e := notify.Event{Type: notify.EventQuarantine, Target: id, Action: q.Mode, Jobs: q.AppliedJobs, Summary: summary}
+	if q.AppliedAt != nil {
+		e.Time = *q.AppliedAt
+	}
+	p.notify(e)
+}
+
+func releaseEvent(id, reason string, now time.Time) notify.Event {
+	return notify.Event{
+		Type:    notify.EventRelease,
+		Time:    now,
+		Target:  id,
+		Reason:  reason,
+		Summary: fmt.Sprintf("Quarantine of %s %s", id, reason),
+	}
+}
@@ -0,0 +1,105 @@
+package patrol
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/prom"
+)
+
+// minPatrolStaleness is the least time without a finished patrol cycle before
+// Bomb Squad stops being ready, however short the interval
+const minPatrolStaleness = 30 * time.Second
+
+// Check is the result of one readiness check
+type Check struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Readiness is the result of checking everything Bomb Squad depends on
+type Readiness struct {
+	Ready bool `json:"ready"`
+	// Failed names the checks that failed
+	Failed []string `json:"failed,omitempty"`
+	Checks []Check  `json:"checks"`
+}
+
+// Ready checks that Prometheus answers, that it has loaded the card_count
+// rule, that both configs can be read, and that the patrol is running
+func (p *Patrol) Ready() Readiness {
+	checks := []struct {
+		name string
+		fn   func() error
+	}{
+		{"prometheus", func() error {
+			_, err := prom.FetchBuildInfo(p.PromURL, p.HTTPClient)
+			return err
+		}},
+		{"rules", func() error {
+			return prom.CheckRuleGroup(prom.CardCounterRuleGroup, p.PromURL, p.HTTPClient)
+		}},
+		{"prometheus_config", func() error {
+			_, err := config.ReadPromConfig(p.PromConfigurator)
+			return err
+		}},
+		{"bomb_squad_config", func() error {
+			_, err := config.ReadBombSquadConfig(p.BSConfigurator)
+			return err
+		}},
+		{"patrol", p.checkPatrol},
+	}
+
+	r := Readiness{Ready: true, Checks: []Check{}}
+	for _, c := range checks {
+		check := Check{Name: c.name, OK: true}
+		if err := c.fn(); err != nil {
+			check.OK = false
+			check.Error = err.Error()
+			r.Ready = false
+			r.Failed = append(r.Failed, c.name)
+		}
+		r.Checks = append(r.Checks, check)
+	}
+	return r
+}
+
+// checkPatrol returns an error unless a patrol cycle finished recently. Until
+// the first cycle finishes, the time the patrol started stands in for it.
+func (p *Patrol) checkPatrol() error {
+	p.stateMu.Lock()
+	started, last := p.startedAt, p.lastCycleAt
+	p.stateMu.Unlock()
+
+	if started.IsZero() {
+		return fmt.Errorf("patrol hasn't started")
+	}
+	staleAfter := 3 * p.Interval
+	if staleAfter < minPatrolStaleness {
+		staleAfter = minPatrolStaleness
+	}
+	if last.IsZero() {
+		if time.Since(started) > staleAfter {
+			return fmt.Errorf("no patrol cycle has finished since the patrol started at %s", started.Format(time.RFC3339))
+		}
+		return nil
+	}
+	if age := time.Since(last); age > staleAfter {
+		return fmt.Errorf("last patrol cycle finished %s ago, at %s", age.Round(time.Second), last.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (p *Patrol) setStarted() {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	p.startedAt = time.Now()
+}
+
+func (p *Patrol) setCycleFinished() {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	p.lastCycleAt = time.Now()
+}
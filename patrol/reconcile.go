@@ -0,0 +1,152 @@
+package patrol
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/notify"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	DriftDetectedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "bomb_squad",
+			Name:      "drift_detected",
+			Help:      "Number of silence and quarantine rules found out of sync with the Bomb Squad config during the last reconciliation",
+		},
+		[]string{"kind"},
+	)
+)
+
+// Reconcile periodically compares the silences recorded in the Bomb Squad
+// config with the rules present in the Prometheus config, and corrects any
+// drift between the two. Silences and quarantines whose TTL has run out are
+// removed first. A value received on events triggers an immediate
+// reconciliation; events may be nil.
+func (p *Patrol) Reconcile(interval time.Duration, events <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	p.reconcileOnce()
+	for {
+		select {
+		case <-ticker.C:
+		case <-events:
+		}
+		p.reconcileOnce()
+	}
+}
+
+func (p *Patrol) reconcileOnce() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	expired, err := config.ExpireSilences(time.Now(), p.PromConfigurator, p.BSConfigurator)
+	if err != nil {
+		log.Printf("Couldn't expire silences: %s\n", err)
+		p.notify(failureEvent("", "", fmt.Errorf("Couldn't expire silences: %s", err)))
+	}
+	for _, s := range expired {
+		log.Printf("Silence for %s.%s has expired and was removed\n", s.MetricName, s.HighCardLabelName)
+	}
+
+	released, err := config.ExpireQuarantines(time.Now(), p.PromConfigurator, p.BSConfigurator)
+	if err != nil {
+		log.Printf("Couldn't expire quarantines: %s\n", err)
+		p.notify(failureEvent("", "", fmt.Errorf("Couldn't expire quarantines: %s", err)))
+	}
+	for _, id := range released {
+		log.Printf("Quarantine of %s has expired and was lifted\n", id)
+		p.notify(releaseEvent(id, "expired", time.Now()))
+	}
+
+	d, err := config.Reconcile(p.PromConfigurator, p.BSConfigurator)
+	if err != nil {
+		log.Printf("Couldn't reconcile Prometheus config with Bomb Squad config: %s\n", err)
+		p.notify(failureEvent("", "", fmt.Errorf("Couldn't reconcile Prometheus config with Bomb Squad config: %s", err)))
+		return
+	}
+	p.resetRemovedSilences()
+
+	DriftDetectedGauge.WithLabelValues("missing").Set(float64(len(d.Missing) + len(d.MissingQuarantines)))
+	DriftDetectedGauge.WithLabelValues("orphaned").Set(float64(len(d.Orphaned) + len(d.OrphanedQuarantines)))
+	if !d.Empty() {
+		log.Printf("Detected and corrected drift: %d missing and %d orphaned silence rules, %d missing and %d orphaned quarantine rules\n", len(d.Missing), len(d.Orphaned), len(d.MissingQuarantines), len(d.OrphanedQuarantines))
+		for _, e := range driftEvents(d) {
+			p.notify(e)
+		}
+	}
+}
+
+// resetRemovedSilences resets the exploding label gauge of every silence that
+// has disappeared since the last reconciliation, however it was removed
+func (p *Patrol) resetRemovedSilences() {
+	silences, err := config.ListSilences(p.BSConfigurator)
+	if err != nil {
+		log.Printf("Couldn't list silences: %s\n", err)
+		return
+	}
+
+	current := map[string]config.SilenceSummary{}
+	for _, s := range silences {
+		current[s.ID] = s
+	}
+	for id, s := range p.silenced {
+		if _, ok := current[id]; !ok {
+			ExplodingLabelGauge.WithLabelValues(s.Metric, s.Label).Set(float64(0.))
+		}
+	}
+	p.silenced = current
+}
+
+// driftEvents returns an event for each silence or quarantine rule that was
+// found missing or orphaned
+func driftEvents(d config.Drift) []notify.Event {
+	events := []notify.Event{}
+	for _, m := range d.Missing {
+		where, jobs := driftLocation(m.JobName, m.RemoteWrite)
+		events = append(events, notify.Event{
+			Type:    notify.EventDrift,
+			Metric:  m.MetricName,
+			Label:   m.LabelName,
+			Jobs:    jobs,
+			Summary: fmt.Sprintf("The silence rule for %s.%s was missing from %s and has been restored", m.MetricName, m.LabelName, where),
+		})
+	}
+	for _, o := range d.Orphaned {
+		where, jobs := driftLocation(o.JobName, o.RemoteWrite)
+		events = append(events, notify.Event{
+			Type:    notify.EventDrift,
+			Jobs:    jobs,
+			Summary: fmt.Sprintf("A silence rule on %v in %s had no recorded silence and has been removed", o.RelabelConfig.SourceLabels, where),
+		})
+	}
+	for _, m := range d.MissingQuarantines {
+		events = append(events, notify.Event{
+			Type:    notify.EventDrift,
+			Jobs:    []string{m.JobName},
+			Target:  m.ID,
+			Summary: fmt.Sprintf("The %s rule of the quarantine of %s was missing from job %s and has been restored", m.Mode, m.ID, m.JobName),
+		})
+	}
+	for _, o := range d.OrphanedQuarantines {
+		events = append(events, notify.Event{
+			Type:    notify.EventDrift,
+			Jobs:    []string{o.JobName},
+			Summary: fmt.Sprintf("A quarantine rule on %v in job %s had no recorded quarantine and has been removed", o.RelabelConfig.SourceLabels, o.JobName),
+		})
+	}
+	return events
+}
+
+// driftLocation names where drift was found, the scrape job or the
+// remote_write endpoint with the given URL, and returns the jobs for its event
+func driftLocation(job, remoteWrite string) (string, []string) {
+	if remoteWrite != "" {
+		return "remote_write endpoint " + remoteWrite, nil
+	}
+	return "job " + job, []string{job}
+}
@@ -0,0 +1,61 @@
+package patrol_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/notify"
+	"github.com/open-fresh/bomb-squad/patrol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteWriteDriftEvents(t *testing.T) {
+	events := make(chan notify.Event, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := notify.Event{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+		events <- e
+	}))
+	defer hook.Close()
+	n, err := notify.New(notify.Config{Webhooks: []notify.WebhookConfig{{Name: "test", URL: hook.URL, Events: []string{notify.EventDrift}}}}, http.DefaultClient)
+	require.NoError(t, err)
+
+	pc := bstesting.NewPromMemoryConfigurator(t)
+	pc.Data = bytes.Replace(pc.Data, []byte("remote_write: []"), []byte("remote_write:\n- url: http://a/write\n- url: http://b/write"), 1)
+	bc := bstesting.NewMemoryConfigurator(t, []byte{})
+
+	// foo.bar is missing from one endpoint, and the rule for baz.qux in the
+	// other has no silence
+	hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar"}
+	mrc, err := config.GenerateMetricRelabelConfig(hcs)
+	require.NoError(t, err)
+	require.NoError(t, config.StoreSilence(hcs, mrc, config.Silence{RemoteWrite: []string{"http://b/write"}, RemoteWriteOnly: true}, bc))
+	orphan, err := config.GenerateMetricRelabelConfig(config.HighCardSeries{MetricName: "baz", HighCardLabelName: "qux"})
+	require.NoError(t, err)
+	pcfg, err := config.ReadPromConfig(pc)
+	require.NoError(t, err)
+	pcfg.RemoteWriteConfigs[0].WriteRelabelConfigs = append(pcfg.RemoteWriteConfigs[0].WriteRelabelConfigs, &orphan)
+	require.NoError(t, config.WritePromConfig(pcfg, pc))
+
+	p := &patrol.Patrol{PromConfigurator: pc, BSConfigurator: bc, Notifier: n}
+	go p.Reconcile(time.Hour, nil)
+
+	summaries := []string{}
+	for len(summaries) < 2 {
+		select {
+		case e := <-events:
+			require.Empty(t, e.Jobs)
+			summaries = append(summaries, e.Summary)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no drift events")
+		}
+	}
+	require.Contains(t, summaries, "The silence rule for foo.bar was missing from remote_write endpoint http://b/write and has been restored")
+	require.Contains(t, summaries, "A silence rule on __name__, qux in remote_write endpoint http://a/write had no recorded silence and has been removed")
+}
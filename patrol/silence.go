@@ -0,0 +1,188 @@
+package patrol
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/prom"
+	promcfg "github.com/prometheus/prometheus/config"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ApplySilence generates the silencing rule for s, inserts it into the scrape
+// configs of s.Jobs, or of every job if there are none, unless the silence is
+// remote write only, and into the write_relabel_configs of the silence's
+// remote_write endpoints, and records the silence in the Bomb Squad config.
+// The rule uses silence's action, replace if it has none, keeping the values
+// of its baseline if it has one, and its other fields, such as its TTL, are
+// stored along with it. The Bomb Squad config is written first, and restored
+// if the Prometheus config can't be written. Both the patrol and silences
+// requested by hand go through here.
+func ApplySilence(s config.HighCardSeries, silence config.Silence, pc, bc config.Configurator) error {
+	mrc, err := silence.Rule(s.MetricName, string(s.HighCardLabelName))
+	if err != nil {
+		return fmt.Errorf("Couldn't generate metric relabel config for metric %s: %s", s.MetricName, err)
+	}
+
+	err = prom.ReUnmarshal(&mrc)
+	if err != nil {
+		return err
+	}
+
+	var newPromConfig promcfg.Config
+	if silence.RemoteWriteOnly {
+		newPromConfig, err = config.ReadPromConfig(pc)
+	} else {
+		newPromConfig, err = config.InsertMetricRelabelConfigToJobs(mrc, s.Jobs, pc)
+	}
+	if err != nil {
+		return fmt.Errorf("Error inserting relabel config for metric %s: %s", s.MetricName, err)
+	}
+	config.InsertWriteRelabelConfig(mrc, silence.RemoteWrite, &newPromConfig)
+
+	newPromConfigBytes, err := yaml.Marshal(newPromConfig)
+	if err != nil {
+		return fmt.Errorf("Error marshalling Prometheus config: %s", err)
+	}
+
+	previous, err := config.ReadBombSquadConfig(bc)
+	if err != nil {
+		return err
+	}
+	err = config.StoreSilence(s, mrc, silence, bc)
+	if err != nil {
+		return fmt.Errorf("Couldn't store metric relabel config for metric %s: %s", s.MetricName, err)
+	}
+
+	err = pc.Write(newPromConfigBytes)
+	if err != nil {
+		// Put the Bomb Squad config back, so that it doesn't claim a rule
+		// that isn't in place
+		if werr := config.WriteBombSquadConfig(previous, bc); werr != nil {
+			log.Printf("Couldn't undo the silence of %s.%s, which wasn't applied: %s\n", s.MetricName, s.HighCardLabelName, werr)
+		}
+		return fmt.Errorf("Error writing Prometheus config: %s", err)
+	}
+	return nil
+}
+
+// CreateSilence validates and applies a silence requested by hand, while
+// holding the patrol's lock so that it doesn't race with the patrol or the
+// reconciler. Unless the request is forced, it's refused with a
+// config.RulesAffectedError if the impact policy blocks it. Any silence the
+// patrol proposed for the same label is dropped. The new silence is returned.
+func (p *Patrol) CreateSilence(req config.SilenceRequest) (config.SilenceSummary, error) {
+	s, silence, err := req.Parse()
+	if err != nil {
+		return config.SilenceSummary{}, err
+	}
+	id := s.MetricName + "." + string(s.HighCardLabelName)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, err = config.GetSilence(id, p.BSConfigurator)
+	if err == nil {
+		return config.SilenceSummary{}, config.SilenceExistsError{ID: id}
+	}
+	if _, ok := err.(config.SilenceNotFoundError); !ok {
+		return config.SilenceSummary{}, err
+	}
+
+	err = p.checkJobs(s.Jobs)
+	if err != nil {
+		return config.SilenceSummary{}, err
+	}
+	err = p.checkRemoteWrite(silence.RemoteWrite)
+	if err != nil {
+		return config.SilenceSummary{}, err
+	}
+	silence.AffectedRules, err = p.checkImpact(s, silence.Action, req.Force)
+	if err != nil {
+		return config.SilenceSummary{}, err
+	}
+
+	err = ApplySilence(s, silence, p.PromConfigurator, p.BSConfigurator)
+	if err != nil {
+		return config.SilenceSummary{}, err
+	}
+
+	// A silence the patrol proposed for the label is no longer needed
+	err = config.RemovePending(id, config.EventApproved, "superseded by a silence requested by hand", p.BSConfigurator)
+	if _, ok := err.(config.PendingNotFoundError); err != nil && !ok {
+		log.Printf("Couldn't remove the pending silence of %s: %s\n", id, err)
+	}
+	return config.GetSilence(id, p.BSConfigurator)
+}
+
+// checkJobs returns an InvalidSilenceError if any of jobs has no scrape config
+func (p *Patrol) checkJobs(jobs []string) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	promConfig, err := config.ReadPromConfig(p.PromConfigurator)
+	if err != nil {
+		return err
+	}
+	known := map[string]bool{}
+	for _, sc := range promConfig.ScrapeConfigs {
+		known[sc.JobName] = true
+	}
+	for _, job := range jobs {
+		if !known[job] {
+			return config.InvalidSilenceError{Reason: fmt.Sprintf("no scrape config for job '%s'", job)}
+		}
+	}
+	return nil
+}
+
+// checkRemoteWrite returns an InvalidSilenceError if any of urls, other than
+// config.AllRemoteWrites, isn't the URL of a remote_write endpoint
+func (p *Patrol) checkRemoteWrite(urls []string) error {
+	if len(urls) == 0 {
+		return nil
+	}
+	promConfig, err := config.ReadPromConfig(p.PromConfigurator)
+	if err != nil {
+		return err
+	}
+	known := map[string]bool{config.AllRemoteWrites: true}
+	for _, rw := range promConfig.RemoteWriteConfigs {
+		known[config.RemoteWriteURL(*rw)] = true
+	}
+	for _, u := range urls {
+		if !known[u] {
+			return config.InvalidSilenceError{Reason: fmt.Sprintf("no remote_write endpoint with URL '%s'", u)}
+		}
+	}
+	return nil
+}
+
+// RemoveSilence removes the silence with the given ID, in metricName.labelName
+// form, while holding the patrol's lock, and resets its exploding label gauge.
+// The removed silence is returned.
+func (p *Patrol) RemoveSilence(id string) (config.SilenceSummary, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	summary, err := config.GetSilence(id, p.BSConfigurator)
+	if err != nil {
+		return config.SilenceSummary{}, err
+	}
+
+	err = config.RemoveSilence(id, p.PromConfigurator, p.BSConfigurator)
+	if err != nil {
+		return config.SilenceSummary{}, err
+	}
+	ExplodingLabelGauge.WithLabelValues(summary.Metric, summary.Label).Set(float64(0.))
+	return summary, nil
+}
+
+// UpdateSilence changes the silence with the given ID while holding the
+// patrol's lock. The updated silence is returned.
+func (p *Patrol) UpdateSilence(id string, upd config.SilenceUpdate) (config.SilenceSummary, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return config.UpdateSilence(id, upd, p.BSConfigurator)
+}
@@ -0,0 +1,33 @@
+package patrol_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/open-fresh/bomb-squad/bstesting"
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/patrol"
+	"github.com/stretchr/testify/require"
+)
+
+// readOnlyConfigurator fails every write
+type readOnlyConfigurator struct {
+	*bstesting.MemoryConfigurator
+}
+
+func (c readOnlyConfigurator) Write([]byte) error {
+	return errors.New("read-only file system")
+}
+
+func TestApplySilenceRollsBack(t *testing.T) {
+	pc := readOnlyConfigurator{bstesting.NewPromMemoryConfigurator(t)}
+	bc := bstesting.NewMemoryConfigurator(t, []byte{})
+	s := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar"}
+
+	require.Error(t, patrol.ApplySilence(s, config.Silence{}, pc, bc))
+	_, err := config.GetSilence("foo.bar", bc)
+	require.IsType(t, config.SilenceNotFoundError{}, err)
+	events, err := config.History(0, bc)
+	require.NoError(t, err)
+	require.Empty(t, events)
+}
@@ -0,0 +1,69 @@
+package patrol
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/prom"
+)
+
+// Status is the result of checking that Bomb Squad is working
+type Status struct {
+	Healthy bool `json:"healthy"`
+	// RulesLoaded is whether Prometheus is evaluating Bomb Squad's recording rules
+	RulesLoaded bool `json:"rulesLoaded"`
+	Silences    int  `json:"silences"`
+	Quarantines int  `json:"quarantines"`
+	// Pending is how many silences await approval
+	Pending int `json:"pending"`
+	// BreakerTripped is whether the circuit breaker has stopped the patrol
+	// from acting by itself
+	BreakerTripped bool     `json:"breakerTripped"`
+	MissingRules   int      `json:"missingRules"`
+	OrphanedRules  int      `json:"orphanedRules"`
+	Problems       []string `json:"problems,omitempty"`
+}
+
+// Status checks that Prometheus is evaluating Bomb Squad's recording rules,
+// and that the Prometheus config carries the rules of every recorded silence
+// and no others
+func (p *Patrol) Status() Status {
+	st := Status{}
+
+	err := prom.CheckRuleGroup(prom.CardCounterRuleGroup, p.PromURL, p.HTTPClient)
+	if err != nil {
+		st.Problems = append(st.Problems, fmt.Sprintf("recording rules: %s", err))
+	} else {
+		st.RulesLoaded = true
+	}
+
+	bsCfg, bsErr := config.ReadBombSquadConfig(p.BSConfigurator)
+	if bsErr != nil {
+		st.Problems = append(st.Problems, fmt.Sprintf("bomb squad config: %s", bsErr))
+	}
+	for _, labels := range bsCfg.SuppressedMetrics {
+		st.Silences += len(labels)
+	}
+	st.Quarantines = len(bsCfg.Quarantines)
+	st.Pending = len(bsCfg.Pending)
+	if bsCfg.Breaker != nil {
+		st.BreakerTripped = true
+		st.Problems = append(st.Problems, fmt.Sprintf("circuit breaker tripped at %s, as %s", bsCfg.Breaker.TrippedAt.Format(time.RFC3339), bsCfg.Breaker.Reason))
+	}
+
+	promConfig, promErr := config.ReadPromConfig(p.PromConfigurator)
+	if promErr != nil {
+		st.Problems = append(st.Problems, fmt.Sprintf("prometheus config: %s", promErr))
+	}
+	if bsErr == nil && promErr == nil {
+		d := config.DetectDrift(promConfig, bsCfg)
+		st.MissingRules = len(d.Missing) + len(d.MissingQuarantines)
+		st.OrphanedRules = len(d.Orphaned) + len(d.OrphanedQuarantines)
+		if !d.Empty() {
+			st.Problems = append(st.Problems, fmt.Sprintf("drift: %d missing and %d orphaned silence and quarantine rules", st.MissingRules, st.OrphanedRules))
+		}
+	}
+	st.Healthy = len(st.Problems) == 0
+	return st
+}
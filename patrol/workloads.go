@@ -0,0 +1,126 @@
+package patrol
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/open-fresh/bomb-squad/config"
+	"github.com/open-fresh/bomb-squad/prom"
+)
+
+// Pod identifies a Kubernetes pod
+type Pod struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// WorkloadRecorder tells the owners of the workloads behind an exploding
+// label that Bomb Squad has silenced it
+type WorkloadRecorder interface {
+	// RecordSilence is called once for each label the patrol silences, with
+	// the action it took
+	RecordSilence(d Detection, action string)
+}
+
+// RolloutCorrelator finds the rollout that most likely caused an explosion
+type RolloutCorrelator interface {
+	// Culprit returns the revision of the Deployments and StatefulSets
+	// behind pods that was rolled out most recently before start, or nil if
+	// none was rolled out shortly before it
+	Culprit(pods []Pod, start time.Time) (*config.Rollout, error)
+}
+
+// Labels that name the namespace and pod a series was scraped from, in order
+// of preference. The first are what the Prometheus Operator and kube-prometheus
+// use, and the others what the Prometheus example Kubernetes config uses.
+var (
+	namespaceLabels = []string{"namespace", "kubernetes_namespace"}
+	podLabels       = []string{"pod", "pod_name", "kubernetes_pod_name"}
+)
+
+// maxPods limits how many pods are kept for each detection
+const maxPods = 10
+
+// podsInSeries returns the distinct pods that series came from, sorted
+func podsInSeries(series []map[string]string) []Pod {
+	seen := map[Pod]bool{}
+	for _, labels := range series {
+		pod := Pod{Namespace: firstLabel(labels, namespaceLabels), Name: firstLabel(labels, podLabels)}
+		if pod.Namespace != "" && pod.Name != "" {
+			seen[pod] = true
+		}
+	}
+
+	pods := []Pod{}
+	for pod := range seen {
+		pods = append(pods, pod)
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Namespace != pods[j].Namespace {
+			return pods[i].Namespace < pods[j].Namespace
+		}
+		return pods[i].Name < pods[j].Name
+	})
+	if len(pods) > maxPods {
+		pods = pods[:maxPods]
+	}
+	return pods
+}
+
+func firstLabel(labels map[string]string, names []string) string {
+	for _, name := range names {
+		if v := labels[name]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// findCulprits looks for the rollout behind each detection whose label isn't
+// silenced yet
+func (p *Patrol) findCulprits(detections []Detection) {
+	if p.Rollouts == nil {
+		return
+	}
+	for i, d := range detections {
+		if len(d.Pods) == 0 {
+			continue
+		}
+		if _, err := config.GetSilence(d.Metric+"."+d.Label, p.BSConfigurator); err == nil {
+			continue
+		}
+
+		culprit, err := p.Rollouts.Culprit(d.Pods, p.explosionStart(d))
+		if err != nil {
+			log.Printf("Couldn't look for the rollout behind %s.%s: %s\n", d.Metric, d.Label, err)
+			continue
+		}
+		detections[i].Culprit = culprit
+	}
+}
+
+// explosionStart works out when d's metric started growing, by walking back
+// through the hour of its card_count before the detection for as long as it
+// was rising. If that can't be had, it's taken to be a minute before the
+// detection, the window the patrol measures growth over.
+func (p *Patrol) explosionStart(d Detection) time.Time {
+	fallback := d.DetectedAt.Add(-time.Minute)
+	samples, err := prom.CardinalityHistoryUntil(d.Metric, time.Hour, time.Minute, d.DetectedAt, p.PromURL, p.HTTPClient)
+	if err != nil {
+		log.Printf("Couldn't get card_count history of %s: %s\n", d.Metric, err)
+		return fallback
+	}
+	if len(samples) < 2 {
+		return fallback
+	}
+
+	i := len(samples) - 1
+	for i > 0 && samples[i-1].Value < samples[i].Value {
+		i--
+	}
+	if i == len(samples)-1 {
+		return fallback
+	}
+	return samples[i].Time
+}
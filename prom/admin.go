@@ -0,0 +1,80 @@
+package prom
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// adminPrefix is where Prometheus serves its TSDB admin API, which it only
+// enables when started with --web.enable-admin-api
+const adminPrefix = "/api/v1/admin/tsdb/"
+
+// admin POSTs query to the TSDB admin endpoint named by path, and returns the
+// response body. Prometheus answers 204 to most of them.
+func admin(path string, query url.Values, promURL *url.URL, client *http.Client) ([]byte, error) {
+	relativeURL, err := url.Parse(adminPrefix + path)
+	if err != nil {
+		return nil, err
+	}
+	relativeURL.RawQuery = query.Encode()
+
+	resp, err := client.Post(promURL.ResolveReference(relativeURL).String(), "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call the TSDB admin API %s: %s", path, err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("the TSDB admin API %s failed with status %d, is Prometheus running with --web.enable-admin-api? %s", path, resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+// DeleteSeries deletes the samples of the series matching matcher between
+// start and end. They're only marked as deleted, with tombstones, until
+// CleanTombstones is called.
+func DeleteSeries(matcher string, start, end time.Time, promURL *url.URL, client *http.Client) error {
+	query := url.Values{}
+	query.Set("match[]", matcher)
+	query.Set("start", strconv.FormatInt(start.Unix(), 10))
+	query.Set("end", strconv.FormatInt(end.Unix(), 10))
+	_, err := admin("delete_series", query, promURL, client)
+	return err
+}
+
+// CleanTombstones removes the samples deleted by DeleteSeries from disk
+func CleanTombstones(promURL *url.URL, client *http.Client) error {
+	_, err := admin("clean_tombstones", url.Values{}, promURL, client)
+	return err
+}
+
+// Snapshot takes a snapshot of the TSDB, including its head block, and
+// returns the snapshot's name. Prometheus keeps it in the snapshots directory
+// of its data directory.
+func Snapshot(promURL *url.URL, client *http.Client) (string, error) {
+	b, err := admin("snapshot", url.Values{}, promURL, client)
+	if err != nil {
+		return "", err
+	}
+
+	resp := struct {
+		Status string `json:"status"`
+		Data   struct {
+			Name string `json:"name"`
+		} `json:"data"`
+	}{}
+	err = json.Unmarshal(b, &resp)
+	if err != nil {
+		return "", fmt.Errorf("couldn't unmarshal the TSDB snapshot from prometheus: %s", err)
+	}
+	if resp.Status != "success" || resp.Data.Name == "" {
+		return "", fmt.Errorf("prometheus didn't name the TSDB snapshot it took: %s", b)
+	}
+	return resp.Data.Name, nil
+}
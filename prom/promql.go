@@ -0,0 +1,95 @@
+package prom
+
+import (
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// Refs is what a PromQL expression refers to. It errs on the side of finding
+// too much: a label grouped by anywhere in the expression counts as grouped
+// by for every metric in it.
+type Refs struct {
+	// Metrics are the metric names the expression selects
+	Metrics map[string]bool
+	// Matchers holds the labels each metric's selectors match on. Selectors
+	// without a metric name are kept under "".
+	Matchers map[string]map[string]bool
+	// Grouping holds the labels named by by, without, on, ignoring,
+	// group_left and group_right, and read or written by label_replace and
+	// label_join
+	Grouping map[string]bool
+}
+
+// labelArgs returns the arguments of call that name labels: the
+// destination and source labels of label_replace and label_join
+func labelArgs(call *promql.Call) promql.Expressions {
+	switch call.Func.Name {
+	case "label_replace":
+		// label_replace(v, dst, replacement, src, regex)
+		return promql.Expressions{call.Args[1], call.Args[3]}
+	case "label_join":
+		// label_join(v, dst, separator, src...)
+		return append(promql.Expressions{call.Args[1]}, call.Args[3:]...)
+	}
+	return nil
+}
+
+func (r Refs) addSelector(name string, matchers []*labels.Matcher) {
+	metric := name
+	for _, m := range matchers {
+		if m.Name == labels.MetricName && m.Type == labels.MatchEqual && metric == "" {
+			metric = m.Value
+		}
+	}
+	if metric != "" {
+		r.Metrics[metric] = true
+	}
+	for _, m := range matchers {
+		if m.Name == labels.MetricName {
+			continue
+		}
+		if r.Matchers[metric] == nil {
+			r.Matchers[metric] = map[string]bool{}
+		}
+		r.Matchers[metric][m.Name] = true
+	}
+}
+
+func (r Refs) addGrouping(names []string) {
+	for _, name := range names {
+		r.Grouping[name] = true
+	}
+}
+
+// ExprRefs returns the metrics and labels the PromQL expression expr refers to
+func ExprRefs(expr string) (Refs, error) {
+	refs := Refs{Metrics: map[string]bool{}, Matchers: map[string]map[string]bool{}, Grouping: map[string]bool{}}
+	parsed, err := promql.ParseExpr(expr)
+	if err != nil {
+		return refs, err
+	}
+
+	promql.Inspect(parsed, func(node promql.Node, _ []promql.Node) error {
+		switch n := node.(type) {
+		case *promql.VectorSelector:
+			refs.addSelector(n.Name, n.LabelMatchers)
+		case *promql.MatrixSelector:
+			refs.addSelector(n.Name, n.LabelMatchers)
+		case *promql.AggregateExpr:
+			refs.addGrouping(n.Grouping)
+		case *promql.BinaryExpr:
+			if n.VectorMatching != nil {
+				refs.addGrouping(n.VectorMatching.MatchingLabels)
+				refs.addGrouping(n.VectorMatching.Include)
+			}
+		case *promql.Call:
+			for _, arg := range labelArgs(n) {
+				if s, ok := arg.(*promql.StringLiteral); ok {
+					refs.Grouping[s.Val] = true
+				}
+			}
+		}
+		return nil
+	})
+	return refs, nil
+}
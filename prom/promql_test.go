@@ -0,0 +1,82 @@
+package prom_test
+
+import (
+	"testing"
+
+	"github.com/open-fresh/bomb-squad/prom"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExprRefs(t *testing.T) {
+	for _, c := range []struct {
+		expr     string
+		metrics  []string
+		matchers map[string][]string
+		grouping []string
+	}{
+		{
+			expr:    `up`,
+			metrics: []string{"up"},
+		},
+		{
+			expr:     `sum by (path) (rate(http_requests_total{job="api", code=~"5.."}[5m]))`,
+			metrics:  []string{"http_requests_total"},
+			matchers: map[string][]string{"http_requests_total": {"job", "code"}},
+			grouping: []string{"path"},
+		},
+		{
+			expr:     `a / on(instance) group_left(version) b offset 5m > bool 1`,
+			metrics:  []string{"a", "b"},
+			grouping: []string{"instance", "version"},
+		},
+		{
+			expr:     `count without (user) ({__name__="logins", region!=""})`,
+			metrics:  []string{"logins"},
+			matchers: map[string][]string{"logins": {"region"}},
+			grouping: []string{"user"},
+		},
+		{
+			expr:     `label_replace(up{job="x"}, "host", "$1", "instance", "(.*):.*")`,
+			metrics:  []string{"up"},
+			matchers: map[string][]string{"up": {"job"}},
+			grouping: []string{"host", "instance"},
+		},
+		{
+			expr:     `SUM BY (bar) (foo) / IGNORING (baz) Sum Without (qux) (rate(foo[5m] OFFSET 1h))`,
+			metrics:  []string{"foo"},
+			grouping: []string{"bar", "baz", "qux"},
+		},
+		{
+			expr:     `label_join(up, "id", "-", "job", "instance") # joined`,
+			metrics:  []string{"up"},
+			grouping: []string{"id", "job", "instance"},
+		},
+	} {
+		refs, err := prom.ExprRefs(c.expr)
+		require.NoError(t, err, c.expr)
+
+		metrics := map[string]bool{}
+		for _, m := range c.metrics {
+			metrics[m] = true
+		}
+		require.Equal(t, metrics, refs.Metrics, c.expr)
+
+		matchers := map[string]map[string]bool{}
+		for m, labels := range c.matchers {
+			matchers[m] = map[string]bool{}
+			for _, l := range labels {
+				matchers[m][l] = true
+			}
+		}
+		require.Equal(t, matchers, refs.Matchers, c.expr)
+
+		grouping := map[string]bool{}
+		for _, l := range c.grouping {
+			grouping[l] = true
+		}
+		require.Equal(t, grouping, refs.Grouping, c.expr)
+	}
+
+	_, err := prom.ExprRefs(`up{job="x}`)
+	require.Error(t, err)
+}
@@ -1,9 +1,19 @@
 package prom
 
 import (
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
 )
 
 // InstantQuery represents the full result of a Prometheus instant query
@@ -44,3 +54,297 @@ func Fetch(endpt string, client *http.Client) ([]byte, error) {
 
 	return body, nil
 }
+
+// Targets represents the result of a Prometheus targets query
+type Targets struct {
+	Status string `json:"status"`
+	Data   struct {
+		ActiveTargets []ActiveTarget `json:"activeTargets"`
+	} `json:"data"`
+}
+
+// ActiveTarget represents a single target Prometheus is currently scraping
+type ActiveTarget struct {
+	DiscoveredLabels map[string]string `json:"discoveredLabels"`
+	Labels           map[string]string `json:"labels"`
+	// ScrapePool is the job_name of the scrape config the target belongs to.
+	// Older Prometheus versions don't report it.
+	ScrapePool string `json:"scrapePool"`
+	ScrapeURL  string `json:"scrapeUrl"`
+	Health     string `json:"health"`
+}
+
+// RangeQuery represents the full result of a Prometheus range query
+type RangeQuery struct {
+	Status string `json:"status"`
+	Data   struct {
+		ResultType string        `json:"resultType"`
+		Result     []RangeResult `json:"result"`
+	} `json:"data"`
+}
+
+// RangeResult represents a single series returned in a RangeQuery
+type RangeResult struct {
+	Metric map[string]string `json:"metric"`
+	Values [][]interface{}   `json:"values"`
+}
+
+// Sample is a single value of a series
+type Sample struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// CardinalityHistory returns the card_count of metricName over the window
+// ending now, one sample every step
+func CardinalityHistory(metricName string, window, step time.Duration, promURL *url.URL, client *http.Client) ([]Sample, error) {
+	return CardinalityHistoryUntil(metricName, window, step, time.Now(), promURL, client)
+}
+
+// CardinalityHistoryUntil returns the card_count of metricName over the
+// window ending at end, one sample every step
+func CardinalityHistoryUntil(metricName string, window, step time.Duration, end time.Time, promURL *url.URL, client *http.Client) ([]Sample, error) {
+	relativeURL, err := url.Parse("/api/v1/query_range")
+	if err != nil {
+		return nil, err
+	}
+
+	query := promURL.Query()
+	query.Set("query", fmt.Sprintf("card_count{metric_name=%q}", metricName))
+	query.Set("start", strconv.FormatInt(end.Add(-window).Unix(), 10))
+	query.Set("end", strconv.FormatInt(end.Unix(), 10))
+	query.Set("step", strconv.FormatFloat(step.Seconds(), 'f', -1, 64))
+	relativeURL.RawQuery = query.Encode()
+
+	b, err := Fetch(promURL.ResolveReference(relativeURL).String(), client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch card_count from prometheus: %s", err)
+	}
+
+	rq := RangeQuery{}
+	err = json.Unmarshal(b, &rq)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal card_count from prometheus: %s", err)
+	}
+
+	samples := []Sample{}
+	if len(rq.Data.Result) == 0 {
+		return samples, nil
+	}
+	for _, v := range rq.Data.Result[0].Values {
+		if len(v) != 2 {
+			continue
+		}
+		ts, ok := v[0].(float64)
+		if !ok {
+			continue
+		}
+		s, ok := v[1].(string)
+		if !ok {
+			continue
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			continue
+		}
+		samples = append(samples, Sample{Time: time.Unix(0, int64(ts*float64(time.Second))).UTC(), Value: f})
+	}
+	return samples, nil
+}
+
+// CardinalityGrowth returns how much the card_count of metricName grew by in
+// the last minute. A metric without a card_count hasn't grown.
+func CardinalityGrowth(metricName string, promURL *url.URL, client *http.Client) (float64, error) {
+	relativeURL, err := url.Parse("/api/v1/query")
+	if err != nil {
+		return 0, err
+	}
+
+	query := promURL.Query()
+	query.Set("query", fmt.Sprintf("delta(card_count{metric_name=%q}[1m])", metricName))
+	relativeURL.RawQuery = query.Encode()
+
+	b, err := Fetch(promURL.ResolveReference(relativeURL).String(), client)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch card_count growth from prometheus: %s", err)
+	}
+
+	iq := InstantQuery{}
+	err = json.Unmarshal(b, &iq)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't unmarshal card_count growth from prometheus: %s", err)
+	}
+	if len(iq.Data.Result) == 0 || len(iq.Data.Result[0].Value) != 2 {
+		return 0, nil
+	}
+	s, ok := iq.Data.Result[0].Value[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("unexpected card_count growth value %v", iq.Data.Result[0].Value[1])
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// instantQuery runs the PromQL query q and returns its result, describing
+// what was queried as what in errors
+func instantQuery(q, what string, promURL *url.URL, client *http.Client) ([]InstantResult, error) {
+	relativeURL, err := url.Parse("/api/v1/query")
+	if err != nil {
+		return nil, err
+	}
+
+	query := promURL.Query()
+	query.Set("query", q)
+	relativeURL.RawQuery = query.Encode()
+
+	b, err := Fetch(promURL.ResolveReference(relativeURL).String(), client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s from prometheus: %s", what, err)
+	}
+
+	iq := InstantQuery{}
+	err = json.Unmarshal(b, &iq)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal %s from prometheus: %s", what, err)
+	}
+	return iq.Data.Result, nil
+}
+
+// scalarQuery runs the PromQL query q and returns the value of its first
+// result. A query without results is 0.
+func scalarQuery(q, what string, promURL *url.URL, client *http.Client) (float64, error) {
+	result, err := instantQuery(q, what, promURL, client)
+	if err != nil {
+		return 0, err
+	}
+	if len(result) == 0 || len(result[0].Value) != 2 {
+		return 0, nil
+	}
+	s, ok := result[0].Value[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("unexpected %s value %v", what, result[0].Value[1])
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// DuplicateSamples returns how many samples Prometheus rejected over the
+// last minute for having the same timestamp as another sample of the same
+// series, as happens when relabelling makes series' label sets collide
+func DuplicateSamples(promURL *url.URL, client *http.Client) (float64, error) {
+	return scalarQuery("sum(increase(prometheus_target_scrapes_sample_duplicate_timestamp_total[1m]))", "duplicate samples", promURL, client)
+}
+
+// RejectedSamples returns how many of the samples the targets of jobs had
+// left after metric relabelling at their last scrape weren't ingested, as
+// happens when samples are rejected as duplicates. It compares each job's
+// scrape_samples_post_metric_relabeling with the series it has, other than
+// those Prometheus adds itself. Empty jobs means every job.
+func RejectedSamples(jobs []string, promURL *url.URL, client *http.Client) (float64, error) {
+	job := ".+"
+	if len(jobs) > 0 {
+		quoted := []string{}
+		for _, j := range jobs {
+			quoted = append(quoted, regexp.QuoteMeta(j))
+		}
+		job = strings.Join(quoted, "|")
+	}
+	q := fmt.Sprintf("sum((sum by (job) (scrape_samples_post_metric_relabeling{job=~%q}) - count by (job) ({job=~%q,__name__!~\"up|scrape_.+\"})) > 0)", job, job)
+	return scalarQuery(q, "rejected samples", promURL, client)
+}
+
+// CollidingSeries returns how many groups of the series matching selector
+// would have the same label set once label is removed from them. A window
+// counts the series that had samples within it, rather than only the current
+// ones. Prometheus does the counting, so however many series match, only a
+// number comes back.
+func CollidingSeries(selector, label string, window time.Duration, promURL *url.URL, client *http.Client) (float64, error) {
+	if window > 0 {
+		selector = fmt.Sprintf("count_over_time(%s[%s])", selector, model.Duration(window))
+	}
+	return scalarQuery(fmt.Sprintf("count(count without (%s) (%s) > 1)", label, selector), "colliding series", promURL, client)
+}
+
+// Instances returns the distinct instances the current series of metricName
+// come from, sorted
+func Instances(metricName string, promURL *url.URL, client *http.Client) ([]string, error) {
+	result, err := instantQuery(fmt.Sprintf("count by (instance) (%s)", metricName), "instances", promURL, client)
+	if err != nil {
+		return nil, err
+	}
+	instances := []string{}
+	for _, r := range result {
+		if instance := r.Metric["instance"]; instance != "" {
+			instances = append(instances, instance)
+		}
+	}
+	sort.Strings(instances)
+	return instances, nil
+}
+
+// FetchSeries returns the label sets of the series matching match
+func FetchSeries(match string, promURL *url.URL, client *http.Client) (Series, error) {
+	return FetchSeriesBetween(match, time.Time{}, time.Time{}, promURL, client)
+}
+
+// FetchSeriesBetween returns the label sets of the series matching match that
+// had samples between start and end. Zero times leave it to Prometheus.
+func FetchSeriesBetween(match string, start, end time.Time, promURL *url.URL, client *http.Client) (Series, error) {
+	relativeURL, err := url.Parse("/api/v1/series")
+	if err != nil {
+		return Series{}, err
+	}
+
+	query := promURL.Query()
+	query.Set("match[]", match)
+	if !start.IsZero() {
+		query.Set("start", strconv.FormatInt(start.Unix(), 10))
+	}
+	if !end.IsZero() {
+		query.Set("end", strconv.FormatInt(end.Unix(), 10))
+	}
+	relativeURL.RawQuery = query.Encode()
+
+	b, err := Fetch(promURL.ResolveReference(relativeURL).String(), client)
+	if err != nil {
+		return Series{}, fmt.Errorf("failed to fetch series from prometheus: %s", err)
+	}
+
+	s := Series{}
+	err = json.Unmarshal(b, &s)
+	if err != nil {
+		return Series{}, fmt.Errorf("couldn't unmarshal series from prometheus: %s", err)
+	}
+	return s, nil
+}
+
+// BuildInfo represents the result of a Prometheus buildinfo query
+type BuildInfo struct {
+	Status string `json:"status"`
+	Data   struct {
+		Version  string `json:"version"`
+		Revision string `json:"revision"`
+	} `json:"data"`
+}
+
+// FetchBuildInfo asks Prometheus what version it is, which shows that it's up
+// and answering API calls
+func FetchBuildInfo(promURL *url.URL, client *http.Client) (BuildInfo, error) {
+	relativeURL, err := url.Parse("/api/v1/status/buildinfo")
+	if err != nil {
+		return BuildInfo{}, err
+	}
+
+	b, err := Fetch(promURL.ResolveReference(relativeURL).String(), client)
+	if err != nil {
+		return BuildInfo{}, fmt.Errorf("failed to fetch buildinfo from prometheus: %s", err)
+	}
+
+	bi := BuildInfo{}
+	err = json.Unmarshal(b, &bi)
+	if err != nil {
+		return BuildInfo{}, fmt.Errorf("couldn't unmarshal buildinfo from prometheus: %s", err)
+	}
+	if bi.Status != "success" {
+		return BuildInfo{}, fmt.Errorf("prometheus buildinfo returned status %q", bi.Status)
+	}
+	return bi, nil
+}
@@ -1,13 +1,22 @@
 package prom
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
 
 	"github.com/open-fresh/bomb-squad/config"
 	promcfg "github.com/prometheus/prometheus/config"
 	yaml "gopkg.in/yaml.v2"
 )
 
+// CardCounterRuleGroup is the name of the rule group, shipped with Bomb Squad,
+// that records the cardinality of every metric
+const CardCounterRuleGroup = "bomb_squad_card_counter"
+
 // AppendRuleFile Appends a static rule file that Bomb Squad needs into the
 // array of rule files that may exist in the current Prometheus config
 func AppendRuleFile(filename string, c config.Configurator) (promcfg.Config, error) {
@@ -32,6 +41,178 @@ func AppendRuleFile(filename string, c config.Configurator) (promcfg.Config, err
 	return cfg, nil
 }
 
+// EnsureRuleFile adds filename to the Prometheus config's rule files, writing
+// the config back only if it wasn't already there. It reports whether the
+// config was written.
+func EnsureRuleFile(filename string, c config.Configurator) (bool, error) {
+	before, err := config.ReadPromConfig(c)
+	if err != nil {
+		return false, err
+	}
+
+	cfg, err := AppendRuleFile(filename, c)
+	if err != nil {
+		return false, err
+	}
+	if len(cfg.RuleFiles) == len(before.RuleFiles) {
+		return false, nil
+	}
+
+	return true, config.WritePromConfig(cfg, c)
+}
+
+// RuleGroups represents the result of a Prometheus rules query
+type RuleGroups struct {
+	Status string `json:"status"`
+	Data   struct {
+		Groups []RuleGroup `json:"groups"`
+	} `json:"data"`
+}
+
+// RuleGroup represents a single group of rules loaded by Prometheus
+type RuleGroup struct {
+	Name  string `json:"name"`
+	File  string `json:"file"`
+	Rules []struct {
+		Name      string `json:"name"`
+		Query     string `json:"query"`
+		Health    string `json:"health"`
+		LastError string `json:"lastError"`
+		Type      string `json:"type"`
+	} `json:"rules"`
+}
+
+// FetchRuleGroups returns the rule groups Prometheus currently has loaded
+func FetchRuleGroups(promURL *url.URL, client *http.Client) ([]RuleGroup, error) {
+	relativeURL, err := url.Parse("/api/v1/rules")
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := Fetch(promURL.ResolveReference(relativeURL).String(), client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch rules from prometheus: %s", err)
+	}
+
+	groups := RuleGroups{}
+	err = json.Unmarshal(b, &groups)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal rules from prometheus: %s", err)
+	}
+	return groups.Data.Groups, nil
+}
+
+// Rule is a recording or alerting rule, as loaded by Prometheus or read from
+// one of its rule files
+type Rule struct {
+	Group string
+	// File is the rule file the rule comes from, if known
+	File string
+	Name string
+	// Type is recording or alerting
+	Type  string
+	Query string
+}
+
+// FetchRules returns every rule Prometheus currently has loaded
+func FetchRules(promURL *url.URL, client *http.Client) ([]Rule, error) {
+	groups, err := FetchRuleGroups(promURL, client)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []Rule{}
+	for _, g := range groups {
+		for _, r := range g.Rules {
+			rules = append(rules, Rule{Group: g.Name, File: g.File, Name: r.Name, Type: r.Type, Query: r.Query})
+		}
+	}
+	return rules, nil
+}
+
+// ruleFile is the format of a Prometheus rule file
+type ruleFile struct {
+	Groups []struct {
+		Name  string `yaml:"name"`
+		Rules []struct {
+			Record string `yaml:"record"`
+			Alert  string `yaml:"alert"`
+			Expr   string `yaml:"expr"`
+		} `yaml:"rules"`
+	} `yaml:"groups"`
+}
+
+// ReadRuleFiles returns the rules in the files matching each of patterns, the
+// rule_files of a Prometheus config. Relative patterns are taken from dir, as
+// Prometheus takes them from its config file's directory. Files that can't be
+// read or parsed are skipped, and an error returned for each of them.
+func ReadRuleFiles(patterns []string, dir string) ([]Rule, []error) {
+	rules := []Rule{}
+	errs := []error{}
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid rule file pattern %s: %s", pattern, err))
+			continue
+		}
+
+		for _, file := range files {
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("couldn't read rule file %s: %s", file, err))
+				continue
+			}
+			rf := ruleFile{}
+			err = yaml.Unmarshal(b, &rf)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("couldn't parse rule file %s: %s", file, err))
+				continue
+			}
+
+			for _, g := range rf.Groups {
+				for _, r := range g.Rules {
+					rule := Rule{Group: g.Name, File: file, Name: r.Record, Type: "recording", Query: r.Expr}
+					if r.Alert != "" {
+						rule.Name = r.Alert
+						rule.Type = "alerting"
+					}
+					rules = append(rules, rule)
+				}
+			}
+		}
+	}
+	return rules, errs
+}
+
+// CheckRuleGroup returns nil if Prometheus has loaded the named rule group and
+// every rule in it has been evaluated successfully, and an error saying what
+// is wrong otherwise
+func CheckRuleGroup(name string, promURL *url.URL, client *http.Client) error {
+	groups, err := FetchRuleGroups(promURL, client)
+	if err != nil {
+		return err
+	}
+
+	for _, g := range groups {
+		if g.Name != name {
+			continue
+		}
+		if len(g.Rules) == 0 {
+			return fmt.Errorf("rule group %s is loaded but empty", name)
+		}
+		for _, r := range g.Rules {
+			if r.Health != "ok" {
+				return fmt.Errorf("rule %s in group %s is not evaluating: health %q %s", r.Name, name, r.Health, r.LastError)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("rule group %s is not loaded", name)
+}
+
 // ReUnmarshal simply marshals a RelabelConfig and unmarshals it again back into place.
 // This is needed to accomodate an "expansion", if you will, of the prometheus.config
 // Regexp struct's string representation that happens only upon unmarshalling it.
@@ -1,11 +1,18 @@
 package prom_test
 
 import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/open-fresh/bomb-squad/bstesting"
 	"github.com/open-fresh/bomb-squad/config"
 	"github.com/open-fresh/bomb-squad/prom"
+	"github.com/open-fresh/bomb-squad/util"
 	"github.com/stretchr/testify/require"
 )
 
@@ -16,3 +23,61 @@ func TestCanAppendRulesFile(t *testing.T) {
 	promcfg, err = prom.AppendRuleFile("/test/rules/file.yaml", c)
 	require.Equal(t, "/test/rules/file.yaml", promcfg.RuleFiles[1])
 }
+
+func TestEnsureRuleFileOnlyWritesOnce(t *testing.T) {
+	c := bstesting.NewPromMemoryConfigurator(t)
+
+	written, err := prom.EnsureRuleFile("/test/rules/file.yaml", c)
+	require.NoError(t, err)
+	require.True(t, written)
+
+	written, err = prom.EnsureRuleFile("/test/rules/file.yaml", c)
+	require.NoError(t, err)
+	require.False(t, written)
+	require.Equal(t, 1, c.Writes)
+}
+
+func TestCheckRuleGroup(t *testing.T) {
+	body := ""
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v1/rules", r.URL.Path)
+		w.Write([]byte(body))
+	}))
+	defer s.Close()
+	promurl, _ := url.Parse(s.URL)
+	client, _ := util.HttpClient()
+
+	body = `{"status":"success","data":{"groups":[]}}`
+	require.EqualError(t, prom.CheckRuleGroup("bomb_squad_card_counter", promurl, client), "rule group bomb_squad_card_counter is not loaded")
+
+	body = `{"status":"success","data":{"groups":[{"name":"bomb_squad_card_counter","rules":[{"name":"card_count","health":"unknown"}]}]}}`
+	require.Error(t, prom.CheckRuleGroup("bomb_squad_card_counter", promurl, client))
+
+	body = `{"status":"success","data":{"groups":[{"name":"bomb_squad_card_counter","rules":[{"name":"card_count","health":"ok"}]}]}}`
+	require.NoError(t, prom.CheckRuleGroup("bomb_squad_card_counter", promurl, client))
+}
+
+func TestReadRuleFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	rules := `groups:
+- name: api
+  rules:
+  - record: job:requests:rate5m
+    expr: sum by (job) (rate(requests_total[5m]))
+  - alert: APIDown
+    expr: up{job="api"} == 0
+`
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "api.rules.yml"), []byte(rules), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "broken.rules.yml"), []byte("groups: ["), 0644))
+
+	read, errs := prom.ReadRuleFiles([]string{"*.rules.yml"}, dir)
+	require.Len(t, errs, 1)
+	file := filepath.Join(dir, "api.rules.yml")
+	require.Equal(t, []prom.Rule{
+		{Group: "api", File: file, Name: "job:requests:rate5m", Type: "recording", Query: "sum by (job) (rate(requests_total[5m]))"},
+		{Group: "api", File: file, Name: "APIDown", Type: "alerting", Query: `up{job="api"} == 0`},
+	}, read)
+}
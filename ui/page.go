@@ -0,0 +1,238 @@
+package ui
+
+// pageTemplate is the whole of the UI: markup, styles and the script that
+// drives the API
+const pageTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Bomb Squad</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; color: #222; }
+h1 { font-size: 1.4em; }
+h1 small { font-weight: normal; color: #888; font-size: 0.6em; }
+h2 { font-size: 1.1em; margin-top: 1.5em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 4px 8px; border-bottom: 1px solid #ddd; vertical-align: middle; }
+th { background: #f4f4f4; }
+td.num { text-align: right; }
+.muted { color: #888; }
+.error { color: #b00; }
+svg.spark { width: 120px; height: 24px; }
+svg.spark polyline { fill: none; stroke: #c33; stroke-width: 1.5; }
+form.inline input, form.inline select { margin-right: 0.5em; }
+#auth { float: right; }
+</style>
+</head>
+<body>
+<form id="auth" class="inline">
+<input id="token" type="password" placeholder="API token" autocomplete="off">
+<button type="submit">Save token</button>
+</form>
+<h1>Bomb Squad <small>{{.Version}}</small></h1>
+<div id="message" class="error"></div>
+
+<h2>Candidates</h2>
+<p class="muted">Exploding labels found by the last patrol cycle.</p>
+<table>
+<thead><tr><th>Metric</th><th>Label</th><th>Growth/min</th><th>Series</th><th>Values</th><th>Sample values</th><th>Jobs</th><th>Detected</th><th>card_count (1h)</th><th></th></tr></thead>
+<tbody id="detections"></tbody>
+</table>
+
+<h2>Active silences</h2>
+<table>
+<thead><tr><th>Silence</th><th>Source</th><th>Action</th><th>Jobs</th><th>Applied</th><th>Expires</th><th>card_count (1h)</th><th></th></tr></thead>
+<tbody id="silences"></tbody>
+</table>
+
+<h2>New silence</h2>
+<form id="create" class="inline">
+<input name="metric" placeholder="metric" required>
+<input name="label" placeholder="label" required>
+<select name="action"><option>replace</option><option>labeldrop</option><option>drop</option></select>
+<input name="ttl" placeholder="ttl, such as 6h">
+<input name="jobs" placeholder="jobs, comma-separated">
+<button type="submit">Silence</button>
+</form>
+
+<script>
+"use strict";
+const apiPrefix = {{.APIPrefix}};
+const tokenKey = "bombSquadToken";
+
+function headers(json) {
+  const h = {};
+  const token = localStorage.getItem(tokenKey);
+  if (token) { h["Authorization"] = "Bearer " + token; }
+  if (json) { h["Content-Type"] = "application/json"; }
+  return h;
+}
+
+async function api(method, path, body) {
+  const opts = {method: method, headers: headers(body !== undefined), credentials: "same-origin"};
+  if (body !== undefined) { opts.body = JSON.stringify(body); }
+  const resp = await fetch(apiPrefix + path, opts);
+  const data = await resp.json().catch(() => ({}));
+  if (!resp.ok) { throw new Error(data.error || resp.statusText); }
+  return data;
+}
+
+function showError(err) {
+  document.getElementById("message").textContent = err ? String(err.message || err) : "";
+}
+
+function age(iso) {
+  if (!iso) { return "-"; }
+  let s = Math.round((Date.now() - new Date(iso).getTime()) / 1000);
+  const future = s < 0;
+  s = Math.abs(s);
+  let out;
+  if (s < 60) { out = s + "s"; }
+  else if (s < 3600) { out = Math.round(s / 60) + "m"; }
+  else if (s < 86400) { out = (s / 3600).toFixed(1) + "h"; }
+  else { out = (s / 86400).toFixed(1) + "d"; }
+  return future ? "in " + out : out + " ago";
+}
+
+function parseSeconds(ttl) {
+  const units = {ms: 0.001, s: 1, m: 60, h: 3600, d: 86400, w: 604800, y: 31536000};
+  let total = 0;
+  const re = /(\d+)(ms|s|m|h|d|w|y)/g;
+  let m;
+  while ((m = re.exec(ttl)) !== null) { total += Number(m[1]) * units[m[2]]; }
+  return total;
+}
+
+function cell(row, text, cls) {
+  const td = document.createElement("td");
+  td.textContent = text === undefined || text === null || text === "" ? "-" : text;
+  if (cls) { td.className = cls; }
+  row.appendChild(td);
+  return td;
+}
+
+function button(td, label, fn) {
+  const b = document.createElement("button");
+  b.textContent = label;
+  b.addEventListener("click", async () => {
+    b.disabled = true;
+    try { await fn(); showError(null); await refresh(); }
+    catch (err) { showError(err); b.disabled = false; }
+  });
+  td.appendChild(b);
+}
+
+async function sparkline(td, metric) {
+  const ns = "http://www.w3.org/2000/svg";
+  try {
+    const samples = await api("GET", "/cardinality/" + encodeURIComponent(metric) + "?window=1h");
+    if (samples.length < 2) { td.textContent = "-"; return; }
+    const values = samples.map(s => s.value);
+    const min = Math.min.apply(null, values), max = Math.max.apply(null, values);
+    const points = values.map((v, i) => {
+      const x = i * 120 / (values.length - 1);
+      const y = max === min ? 12 : 22 - (v - min) * 20 / (max - min);
+      return x.toFixed(1) + "," + y.toFixed(1);
+    }).join(" ");
+    const svg = document.createElementNS(ns, "svg");
+    svg.setAttribute("class", "spark");
+    svg.setAttribute("viewBox", "0 0 120 24");
+    const line = document.createElementNS(ns, "polyline");
+    line.setAttribute("points", points);
+    svg.appendChild(line);
+    const title = document.createElementNS(ns, "title");
+    title.textContent = "min " + min + ", max " + max;
+    svg.appendChild(title);
+    td.textContent = "";
+    td.appendChild(svg);
+  } catch (err) {
+    td.textContent = "?";
+    td.title = err.message;
+  }
+}
+
+async function refresh() {
+  let detections, silences;
+  try {
+    [detections, silences] = await Promise.all([api("GET", "/detections"), api("GET", "/silences")]);
+  } catch (err) {
+    showError(err);
+    return;
+  }
+  const silenced = new Set(silences.map(s => s.id));
+
+  const dt = document.getElementById("detections");
+  dt.textContent = "";
+  if (detections.length === 0) {
+    cell(dt.insertRow(), "Nothing is exploding right now.", "muted").colSpan = 10;
+  }
+  for (const d of detections) {
+    const row = dt.insertRow();
+    cell(row, d.metric);
+    cell(row, d.label);
+    cell(row, d.growth, "num");
+    cell(row, d.series, "num");
+    cell(row, d.distinctValues, "num");
+    cell(row, (d.sampleValues || []).join(", "));
+    cell(row, (d.jobs || []).join(", ") || "all");
+    cell(row, age(d.detectedAt));
+    sparkline(cell(row, ""), d.metric);
+    const actions = cell(row, "");
+    if (silenced.has(d.metric + "." + d.label)) {
+      actions.textContent = "silenced";
+    } else {
+      actions.textContent = "";
+      button(actions, "Silence", () => api("POST", "/silences", {metric: d.metric, label: d.label, jobs: d.jobs}));
+    }
+  }
+
+  const st = document.getElementById("silences");
+  st.textContent = "";
+  if (silences.length === 0) {
+    cell(st.insertRow(), "No silences.", "muted").colSpan = 8;
+  }
+  for (const s of silences) {
+    const row = st.insertRow();
+    cell(row, s.id);
+    cell(row, s.manual ? "manual" : "patrol");
+    cell(row, s.action);
+    cell(row, (s.jobs || []).join(", ") || "all");
+    cell(row, age(s.appliedAt));
+    cell(row, s.expiresAt ? age(s.expiresAt) : "never");
+    sparkline(cell(row, ""), s.metric);
+    const actions = cell(row, "");
+    actions.textContent = "";
+    if (s.ttl) {
+      button(actions, "Extend 1h", () => api("PATCH", "/silences/" + s.id, {ttl: (parseSeconds(s.ttl) + 3600) + "s"}));
+    }
+    button(actions, "Unsilence", () => {
+      if (!confirm("Remove the silence on " + s.id + "?")) { return Promise.resolve(); }
+      return api("DELETE", "/silences/" + s.id);
+    });
+  }
+}
+
+document.getElementById("token").value = localStorage.getItem(tokenKey) || "";
+document.getElementById("auth").addEventListener("submit", (e) => {
+  e.preventDefault();
+  localStorage.setItem(tokenKey, document.getElementById("token").value);
+  refresh();
+});
+
+document.getElementById("create").addEventListener("submit", async (e) => {
+  e.preventDefault();
+  const f = e.target;
+  const req = {metric: f.metric.value.trim(), label: f.label.value.trim(), action: f.action.value};
+  if (f.ttl.value.trim()) { req.ttl = f.ttl.value.trim(); }
+  const jobs = f.jobs.value.split(",").map(j => j.trim()).filter(j => j);
+  if (jobs.length) { req.jobs = jobs; }
+  try { await api("POST", "/silences", req); showError(null); f.reset(); await refresh(); }
+  catch (err) { showError(err); }
+});
+
+refresh();
+setInterval(refresh, 15000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,46 @@
+// Package ui serves Bomb Squad's web page. The page itself holds no data; it
+// fetches everything from the API, so that the API's auth applies to it.
+package ui
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// Prefix is the path the UI is served at
+const Prefix = "/ui/"
+
+var page = template.Must(template.New("ui").Parse(pageTemplate))
+
+// Handler returns the handler for the UI, to be mounted at Prefix. apiPrefix
+// is where the page finds the API.
+func Handler(apiPrefix, version string) http.Handler {
+	buf := &bytes.Buffer{}
+	err := page.Execute(buf, struct {
+		APIPrefix string
+		Version   string
+	}{apiPrefix, version})
+	if err != nil {
+		// The template and its data are fixed, so this can only be a bug
+		log.Fatalf("Couldn't render UI: %s", err)
+	}
+	body := buf.Bytes()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != Prefix {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+		_, _ = w.Write(body)
+	})
+}
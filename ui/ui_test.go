@@ -0,0 +1,31 @@
+package ui_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/open-fresh/bomb-squad/ui"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	h := ui.Handler("/api/v1", "v1.2.3")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ui/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	body, _ := ioutil.ReadAll(w.Body)
+	require.Contains(t, string(body), "v1.2.3")
+	require.Contains(t, string(body), `const apiPrefix = "/api/v1";`)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ui/other", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ui/", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}